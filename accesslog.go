@@ -0,0 +1,119 @@
+package kite
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// AccessLogEntry describes a method call served by the kite.
+type AccessLogEntry struct {
+	Time       time.Time     // start time of the call
+	Username   string        // username of the caller
+	RemoteAddr string        // remote address of the caller, if known
+	Kite       string        // caller kite
+	Method     string        // called method
+	Duration   time.Duration // time spent in the handler
+	Status     string        // "ok" or the type of the returned error
+	Error      string        // message of the returned error, if any
+}
+
+// String returns the entry in a single line.
+func (e *AccessLogEntry) String() string {
+	s := fmt.Sprintf("%s %s %q %q %s %s %s",
+		e.Time.Format(time.RFC3339), e.RemoteAddr, e.Username, e.Kite,
+		e.Method, e.Status, e.Duration)
+
+	if e.Error != "" {
+		s += fmt.Sprintf(" %q", e.Error)
+	}
+
+	return s
+}
+
+// AccessLogSink receives the entries of the access log.
+type AccessLogSink interface {
+	LogAccess(e *AccessLogEntry)
+}
+
+// AccessLogSinkFunc is a type adapter to allow the use of ordinary functions
+// as AccessLogSink.
+type AccessLogSinkFunc func(e *AccessLogEntry)
+
+// LogAccess calls f(e)
+func (f AccessLogSinkFunc) LogAccess(e *AccessLogEntry) {
+	f(e)
+}
+
+// NewWriterSink returns an AccessLogSink that writes every entry to w in a
+// single line. It's safe to use with files, and also with syslog through a
+// *syslog.Writer.
+func NewWriterSink(w io.Writer) AccessLogSink {
+	return &writerSink{w: w}
+}
+
+type writerSink struct {
+	w  io.Writer
+	mu sync.Mutex // serializes writes
+}
+
+func (s *writerSink) LogAccess(e *AccessLogEntry) {
+	s.mu.Lock()
+	fmt.Fprintln(s.w, e.String())
+	s.mu.Unlock()
+}
+
+// NewLoggerSink returns an AccessLogSink that logs every entry to l in the
+// INFO level, with the fields of the entry attached.
+func NewLoggerSink(l Logger) AccessLogSink {
+	return AccessLogSinkFunc(func(e *AccessLogEntry) {
+		WithFields(l, Fields{
+			"username":   e.Username,
+			"remoteAddr": e.RemoteAddr,
+			"kite":       e.Kite,
+			"duration":   e.Duration,
+			"status":     e.Status,
+		}).Info("%s", e.Method)
+	})
+}
+
+// AccessLog returns a Middleware that sends an entry to sink for every method
+// call. Register it with Kite.Use:
+//
+//	k.Use(kite.AccessLog(kite.NewWriterSink(f)))
+//
+// Requests rejected before the handlers are run, like the ones that fail
+// authentication, don't reach the middlewares and are not logged.
+func AccessLog(sink AccessLogSink) Middleware {
+	return func(r *Request, next HandlerFunc) (result interface{}, err error) {
+		e := &AccessLogEntry{
+			Time:       time.Now(),
+			Username:   r.Username,
+			RemoteAddr: r.Client.RemoteAddr(),
+			Kite:       r.Client.Kite.String(),
+			Method:     r.Method,
+		}
+
+		defer func() {
+			e.Duration = time.Since(e.Time)
+
+			if rec := recover(); rec != nil {
+				e.Status = "panic"
+				e.Error = fmt.Sprint(rec)
+				sink.LogAccess(e)
+				panic(rec)
+			}
+
+			e.Status = "ok"
+			if kiteErr := createError(err); kiteErr != nil {
+				e.Status = kiteErr.Type
+				e.Error = kiteErr.Message
+			}
+
+			sink.LogAccess(e)
+		}()
+
+		return next(r)
+	}
+}
@@ -0,0 +1,41 @@
+package kite
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+// activationListener returns the listener of the socket passed by systemd
+// with socket activation, or nil if the process is not socket activated. Only
+// the first socket is used. The environment variables of the activation are
+// unset, so they are not inherited by the child processes.
+func activationListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(listenFDsStart, "LISTEN_FD_"+strconv.Itoa(listenFDsStart))
+	defer f.Close()
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot use the socket passed by systemd: %s", err)
+	}
+
+	return l, nil
+}
@@ -0,0 +1,51 @@
+package kite
+
+import (
+	"crypto/tls"
+	"path/filepath"
+
+	"github.com/koding/kite/kitekey"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// UseAutocert makes the kite serve TLS with certificates obtained and renewed
+// automatically from Let's Encrypt for the public hostname. Certificates are
+// cached in cacheDir, or in the "autocert" directory in kite home if it's
+// empty. By calling it, you agree to the terms of service of Let's Encrypt.
+//
+// Let's Encrypt validates the hostname by connecting to it. The kite must be
+// listening on port 443 for the TLS-ALPN challenge, otherwise the HTTP
+// challenge must be served on port 80 with the returned Manager:
+//
+//	m := k.UseAutocert("kite.example.com", "")
+//	go http.ListenAndServe(":80", m.HTTPHandler(nil))
+//
+// RegisterURL returns a URL with the hostname instead of the public IP, so the
+// URL registered to kontrol matches the certificate.
+func (k *Kite) UseAutocert(hostname, cacheDir string) *autocert.Manager {
+	if cacheDir == "" {
+		kiteHome, err := kitekey.KiteHome()
+		if err != nil {
+			k.Log.Fatal("Cannot find kite home: %s", err.Error())
+		}
+
+		cacheDir = filepath.Join(kiteHome, "autocert")
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hostname),
+		Cache:      autocert.DirCache(cacheDir),
+	}
+
+	if k.TLSConfig == nil {
+		k.TLSConfig = &tls.Config{}
+	}
+
+	k.TLSConfig.GetCertificate = m.GetCertificate
+	k.TLSConfig.NextProtos = append(k.TLSConfig.NextProtos, "http/1.1", acme.ALPNProto)
+	k.publicHostname = hostname
+
+	return m
+}
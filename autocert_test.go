@@ -0,0 +1,45 @@
+package kite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUseAutocert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kite-autocert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a cached certificate is served without contacting Let's Encrypt
+	certPEM, keyPEM := newTestCert(t, "kite.example.com", 3)
+	cached := append(keyPEM, certPEM...)
+	if err := ioutil.WriteFile(filepath.Join(dir, "kite.example.com"), cached, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10043
+	k.UseAutocert("kite.example.com", dir)
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	if serial := servedCertSerial(t, "127.0.0.1:10043", "kite.example.com"); serial != 3 {
+		t.Errorf("got certificate %d, want: 3", serial)
+	}
+
+	u := k.RegisterURL(false)
+	if u == nil {
+		t.Fatal("register URL is nil")
+	}
+
+	if u.Scheme != "https" || u.Host != "kite.example.com:10043" {
+		t.Errorf("got register URL %s, want: https://kite.example.com:10043", u)
+	}
+}
@@ -0,0 +1,109 @@
+package kite
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/koding/kite/dnode"
+)
+
+// batchKey is the context key of the function that queues the messages of
+// the calls in a Batch instead of sending them.
+type batchKey struct{}
+
+// Batch queues method calls to send them to the remote kite in a single
+// frame, which saves the overhead of sending many small messages. Create one
+// with Client.Batch:
+//
+//	b := c.Batch()
+//	square := b.Go("square", 2)
+//	cube := b.Go("cube", 2)
+//	b.Flush()
+//	resp := <-square
+//
+// A Batch is safe for concurrent use and it can be reused after Flush.
+type Batch struct {
+	// Timeout is the timeout for waiting reply of each call, starting with
+	// Flush. Zero means no timeout.
+	Timeout time.Duration
+
+	c     *Client
+	calls []*batchCall
+	mu    sync.Mutex // protects calls
+}
+
+type batchCall struct {
+	method       string
+	args         []interface{}
+	responseChan chan *response
+}
+
+// Batch returns a new Batch for sending calls to the remote kite.
+func (c *Client) Batch() *Batch {
+	return &Batch{c: c}
+}
+
+// Go queues a method call. It returns a channel that receives the response
+// after the batch is flushed, like Client.Go.
+func (b *Batch) Go(method string, args ...interface{}) chan *response {
+	call := &batchCall{
+		method:       method,
+		args:         args,
+		responseChan: make(chan *response, 1),
+	}
+
+	b.mu.Lock()
+	b.calls = append(b.calls, call)
+	b.mu.Unlock()
+
+	return call.responseChan
+}
+
+// Len returns the number of the queued calls.
+func (b *Batch) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.calls)
+}
+
+// Flush sends the queued calls in a single frame. The calls that can't be
+// sent receive their errors from their response channels, like Client.Go.
+func (b *Batch) Flush() {
+	b.mu.Lock()
+	calls := b.calls
+	b.calls = nil
+	b.mu.Unlock()
+
+	if len(calls) == 0 {
+		return
+	}
+
+	var batch [][]byte
+	queue := func(data []byte) error {
+		if err := b.c.canSend(); err != nil {
+			return err
+		}
+
+		batch = append(batch, data)
+		return nil
+	}
+
+	ctx := context.WithValue(context.Background(), batchKey{}, queue)
+
+	for _, call := range calls {
+		b.c.LocalKite.Log.Debug("Telling method [%s] on kite [%s] in batch", call.method, b.c.Name)
+		b.c.sendMethod(ctx, call.method, call.args, b.Timeout, call.responseChan, dnode.Function{})
+	}
+
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := b.c.queueFrame(frame{batch: batch}); err != nil {
+		// the client is closed or the send queue is filled after the
+		// messages are queued, the calls receive the disconnect or timeout
+		// errors
+		b.c.LocalKite.Log.Debug("Cannot send batch: %s", err)
+	}
+}
@@ -0,0 +1,416 @@
+package kite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/koding/kite/dnode"
+)
+
+// DefaultMaxBinarySize is the maximum size of a binary payload uploaded to
+// the kite if Config.MaxMessageSize is zero.
+const DefaultMaxBinarySize = 32 << 20
+
+// DefaultMaxBinaryStoreSize is the total size of the binary payloads kept by
+// the kite if Config.MaxBinaryStoreSize is zero.
+const DefaultMaxBinaryStoreSize = 256 << 20
+
+// binaryTTL is the duration a binary payload is kept until it's claimed by
+// the call it's sent with.
+const binaryTTL = time.Minute
+
+// Binary is a binary payload. A handler that returns a Binary sends it back
+// to the caller over the binary side channel if it's called with
+// Client.TellBinary, instead of encoding it in the dnode message. For the
+// other callers it's encoded as a base64 string like any []byte.
+type Binary []byte
+
+// binaryKey is the context key of the binaryCall of a call made with
+// TellBinary.
+type binaryKey struct{}
+
+// binaryCall is the binary side channel of a call made with TellBinary.
+type binaryCall struct {
+	id string // id of the uploaded payload, empty if there is no payload
+}
+
+// binaryRef is sent as the result in place of a Binary returned by the
+// handler.
+type binaryRef struct {
+	BinaryID string `json:"kiteBinaryId"`
+}
+
+// binaryStore keeps the binary payloads until they are claimed or expired.
+type binaryStore struct {
+	payloads map[string][]byte
+	size     int64 // total size of the payloads and the reserved uploads
+	mu       sync.Mutex
+}
+
+// reserve reserves n bytes for an upload. It returns false if the total size
+// would exceed max.
+func (b *binaryStore) reserve(n, max int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.size+n > max {
+		return false
+	}
+
+	b.size += n
+	return true
+}
+
+// release releases n bytes reserved for an upload.
+func (b *binaryStore) release(n int64) {
+	b.mu.Lock()
+	b.size -= n
+	b.mu.Unlock()
+}
+
+// put saves data and returns its id.
+func (b *binaryStore) put(data []byte) string {
+	return b.store(data, 0)
+}
+
+// store saves data that has reserved bytes of the total size reserved and
+// returns its id.
+func (b *binaryStore) store(data []byte, reserved int64) string {
+	id := randomStringLength(24)
+
+	b.mu.Lock()
+	if b.payloads == nil {
+		b.payloads = make(map[string][]byte)
+	}
+	b.payloads[id] = data
+	b.size += int64(len(data)) - reserved
+	b.mu.Unlock()
+
+	time.AfterFunc(binaryTTL, func() { b.take(id) })
+
+	return id
+}
+
+// take removes the payload with the id and returns it.
+func (b *binaryStore) take(id string) ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data, ok := b.payloads[id]
+	if ok {
+		delete(b.payloads, id)
+		b.size -= int64(len(data))
+	}
+	return data, ok
+}
+
+// HandleBinary serves the binary side channel of the calls made with
+// Client.TellBinary at "/kite/binary". Without it the binary payloads can't be
+// sent to the kite. It panics if the pattern is already registered with
+// HandleHTTP.
+func (k *Kite) HandleBinary() {
+	k.HandleHTTPFunc("/kite/binary", k.handleBinary)
+}
+
+// handleBinary serves the binary side channel of the kite. The payloads of
+// the calls are uploaded with POST and the returned payloads are downloaded
+// with GET, each payload can be claimed once. The callers are authenticated
+// with their kite token or kite key.
+func (k *Kite) handleBinary(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" && req.Method != "GET" {
+		http.Error(w, "method is not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := k.authenticateBinary(req); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	switch req.Method {
+	case "POST":
+		limit := int64(k.Config.MaxMessageSize)
+		if limit <= 0 {
+			limit = DefaultMaxBinarySize
+		}
+
+		if req.ContentLength > limit {
+			http.Error(w, "payload is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// the whole limit is reserved if the size is not known beforehand
+		reserved := limit
+		if req.ContentLength >= 0 {
+			reserved = req.ContentLength
+		}
+
+		storeSize := int64(k.Config.MaxBinaryStoreSize)
+		if storeSize <= 0 {
+			storeSize = DefaultMaxBinaryStoreSize
+		}
+
+		if !k.binaries.reserve(reserved, storeSize) {
+			http.Error(w, "too many binary payloads", http.StatusServiceUnavailable)
+			return
+		}
+
+		data, err := ioutil.ReadAll(io.LimitReader(req.Body, reserved+1))
+		if err != nil {
+			k.binaries.release(reserved)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if int64(len(data)) > reserved {
+			k.binaries.release(reserved)
+			http.Error(w, "payload is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		fmt.Fprint(w, k.binaries.store(data, reserved))
+	case "GET":
+		data, ok := k.binaries.take(req.URL.Query().Get("id"))
+		if !ok {
+			http.Error(w, "payload is not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+	}
+}
+
+// authenticateBinary authenticates the caller of the binary side channel
+// with the kite token or kite key in the Authorization header, which is
+// formatted as "<auth type> <key>".
+func (k *Kite) authenticateBinary(req *http.Request) error {
+	if k.Config.DisableAuthentication {
+		return nil
+	}
+
+	typ, key := "", ""
+	if fields := strings.SplitN(req.Header.Get("Authorization"), " ", 2); len(fields) == 2 {
+		typ, key = fields[0], fields[1]
+	}
+
+	if typ != "token" && typ != "kiteKey" {
+		return errors.New("token or kite key is required")
+	}
+
+	authenticate, ok := k.Authenticators[typ]
+	if !ok {
+		return fmt.Errorf("unknown authentication type: %s", typ)
+	}
+
+	return authenticate(&Request{
+		LocalKite: k,
+		Auth:      &Auth{Type: typ, Key: key},
+	})
+}
+
+// claimBinary sets the Binary of the request to the payload uploaded with
+// it, if there is any.
+func (r *Request) claimBinary() *Error {
+	if r.binaryID == "" {
+		return nil
+	}
+
+	data, ok := r.LocalKite.binaries.take(r.binaryID)
+	if !ok {
+		return &Error{
+			Type:    "binaryError",
+			Message: "Binary payload is not found or expired",
+		}
+	}
+
+	r.Binary = data
+	return nil
+}
+
+// binaryResult replaces a Binary result with a reference to it if the
+// caller accepts binary payloads.
+func (r *Request) binaryResult(result interface{}) interface{} {
+	b, ok := result.(Binary)
+	if !ok || !r.acceptBinary {
+		return result
+	}
+
+	return binaryRef{BinaryID: r.LocalKite.binaries.put(b)}
+}
+
+// withBinary adds the binary side channel options to the wrapped args.
+func withBinary(args []interface{}, call *binaryCall) []interface{} {
+	options := args[0].(callOptionsOut)
+	options.BinaryID = call.id
+	options.AcceptBinary = true
+	return []interface{}{options}
+}
+
+// TellBinary makes a blocking method call like Tell and passes data to the
+// handler in Request.Binary. data is uploaded to the remote kite over HTTP
+// instead of being encoded in the dnode message. If the handler returns a
+// Binary, it's downloaded the same way and returned in binary with a nil
+// result. The remote kite must be dialed by its URL and serve the binary side
+// channel with Kite.HandleBinary, the payloads are sent with the token or kite
+// key in Auth.
+func (c *Client) TellBinary(method string, data []byte, args ...interface{}) (result *dnode.Partial, binary []byte, err error) {
+	call := &binaryCall{}
+
+	if data != nil {
+		if call.id, err = c.uploadBinary(data); err != nil {
+			return nil, nil, binaryError(err)
+		}
+	}
+
+	ctx := context.WithValue(context.Background(), binaryKey{}, call)
+	resp := <-c.GoWithContext(ctx, method, args...)
+	if resp.Err != nil {
+		return nil, nil, resp.Err
+	}
+
+	var ref binaryRef
+	if resp.Result == nil || resp.Result.Unmarshal(&ref) != nil || ref.BinaryID == "" {
+		return resp.Result, nil, nil
+	}
+
+	if binary, err = c.downloadBinary(ref.BinaryID); err != nil {
+		return nil, nil, binaryError(err)
+	}
+
+	return nil, binary, nil
+}
+
+// binaryError converts an error of the binary side channel into a *Error.
+func binaryError(err error) *Error {
+	return &Error{
+		Type:    "binaryError",
+		Message: err.Error(),
+	}
+}
+
+// binaryURL returns the URL of the binary side channel of the remote kite.
+func (c *Client) binaryURL() (string, error) {
+	if c.URL == "" {
+		return "", fmt.Errorf("URL of the remote kite is not known")
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return "", err
+	}
+
+	u.Path = u.Path + "/binary"
+	return u.String(), nil
+}
+
+// binaryClient returns the HTTP client of the binary side channel.
+func (c *Client) binaryClient() (*http.Client, error) {
+	proxy, err := c.proxy()
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:             proxy,
+			TLSClientConfig:   c.TLSClientConfig,
+			DisableKeepAlives: true,
+		},
+	}, nil
+}
+
+// binaryRequest returns a request to the binary side channel with the
+// credentials of the client.
+func (c *Client) binaryRequest(method, u string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if auth := c.auth(); auth != nil {
+		req.Header.Set("Authorization", auth.Type+" "+auth.Key)
+	}
+
+	return req, nil
+}
+
+// uploadBinary uploads data to the remote kite and returns its id.
+func (c *Client) uploadBinary(data []byte) (string, error) {
+	u, err := c.binaryURL()
+	if err != nil {
+		return "", err
+	}
+
+	client, err := c.binaryClient()
+	if err != nil {
+		return "", err
+	}
+
+	req, err := c.binaryRequest("POST", u, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("uploading binary payload failed: %s", bytes.TrimSpace(body))
+	}
+
+	return string(body), nil
+}
+
+// downloadBinary downloads the payload with the id from the remote kite.
+func (c *Client) downloadBinary(id string) ([]byte, error) {
+	u, err := c.binaryURL()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := c.binaryClient()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := c.binaryRequest("GET", u+"?id="+url.QueryEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading binary payload failed: %s", bytes.TrimSpace(body))
+	}
+
+	return body, nil
+}
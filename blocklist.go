@@ -0,0 +1,157 @@
+package kite
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/koding/kite/protocol"
+)
+
+// ErrBlocked is returned when the remote kite or its user is blocked in
+// kontrol.
+var ErrBlocked = errors.New("kite is blocked")
+
+// IsBlocked returns true if the kite with the id or the user is in the
+// blocklist of the kite. The kite ids are chosen by the kites, use
+// IsKeyBlocked to check the kite keys.
+func (k *Kite) IsBlocked(kiteID, username string) bool {
+	k.blockedMu.RLock()
+	defer k.blockedMu.RUnlock()
+
+	if _, ok := k.blockedKites[kiteID]; ok && kiteID != "" {
+		return true
+	}
+
+	_, ok := k.blockedUsers[username]
+	return ok && username != ""
+}
+
+// IsKeyBlocked returns true if the kite key with the id is in the blocklist
+// of the kite.
+func (k *Kite) IsKeyBlocked(keyID string) bool {
+	k.blockedMu.RLock()
+	defer k.blockedMu.RUnlock()
+
+	_, ok := k.blockedKeys[keyID]
+	return ok && keyID != ""
+}
+
+// Blocklist returns the kite ids, the kite key ids and the usernames in the
+// blocklist of the kite, sorted.
+func (k *Kite) Blocklist() *protocol.Blocklist {
+	k.blockedMu.RLock()
+	defer k.blockedMu.RUnlock()
+
+	b := &protocol.Blocklist{
+		Kites: make([]string, 0, len(k.blockedKites)),
+		Keys:  make([]string, 0, len(k.blockedKeys)),
+		Users: make([]string, 0, len(k.blockedUsers)),
+	}
+
+	for id := range k.blockedKites {
+		b.Kites = append(b.Kites, id)
+	}
+
+	for id := range k.blockedKeys {
+		b.Keys = append(b.Keys, id)
+	}
+
+	for username := range k.blockedUsers {
+		b.Users = append(b.Users, username)
+	}
+
+	sort.Strings(b.Kites)
+	sort.Strings(b.Keys)
+	sort.Strings(b.Users)
+
+	return b
+}
+
+// SetBlocklist replaces the blocklist of the kite. The requests of the
+// blocked kite keys and users are rejected, the ones that are connected
+// already are disconnected.
+func (k *Kite) SetBlocklist(b *protocol.Blocklist) {
+	kites := make(map[string]struct{}, len(b.Kites))
+	for _, id := range b.Kites {
+		kites[id] = struct{}{}
+	}
+
+	keys := make(map[string]struct{}, len(b.Keys))
+	for _, id := range b.Keys {
+		keys[id] = struct{}{}
+	}
+
+	users := make(map[string]struct{}, len(b.Users))
+	for _, username := range b.Users {
+		users[username] = struct{}{}
+	}
+
+	k.blockedMu.Lock()
+	k.blockedKites = kites
+	k.blockedKeys = keys
+	k.blockedUsers = users
+	k.blockedMu.Unlock()
+
+	for _, c := range k.Clients() {
+		if k.isClientBlocked(c) {
+			k.Log.Info("Disconnecting blocked kite from %s", c.RemoteAddr())
+			c.session.Close(3000, ErrBlocked.Error())
+		}
+	}
+}
+
+// isClientBlocked returns true if the kite key the client is authenticated
+// with or its user is blocked. The kite id the client has sent is not
+// checked, any kite can choose any id.
+func (k *Kite) isClientBlocked(c *Client) bool {
+	c.muProt.Lock()
+	keyID, username := c.kiteKeyID, c.Kite.Username
+	c.muProt.Unlock()
+
+	return k.IsKeyBlocked(keyID) || k.IsBlocked("", username)
+}
+
+// UpdateBlocklist fetches the blocklist from kontrol and replaces the
+// blocklist of the kite with it.
+func (k *Kite) UpdateBlocklist() error {
+	if err := k.SetupKontrolClient(); err != nil {
+		return err
+	}
+
+	<-k.kontrol.readyConnected
+
+	result, err := k.kontrol.TellWithTimeout("getBlocklist", 4*time.Second)
+	if err != nil {
+		return err
+	}
+
+	var b protocol.Blocklist
+	if err := result.Unmarshal(&b); err != nil {
+		return err
+	}
+
+	k.SetBlocklist(&b)
+	return nil
+}
+
+// handleSetBlocklist replaces the blocklist with the one pushed by kontrol
+// when it changes. Calls over the connections the kite has initiated are not
+// authenticated, so only the kontrol connection is accepted.
+func (k *Kite) handleSetBlocklist(r *Request) (interface{}, error) {
+	k.kontrol.Lock()
+	fromKontrol := r.Client == k.kontrol.Client
+	k.kontrol.Unlock()
+
+	if !fromKontrol {
+		return nil, errors.New("blocklist is accepted only from kontrol")
+	}
+
+	var b protocol.Blocklist
+	if err := r.Args.One().Unmarshal(&b); err != nil {
+		return nil, errors.New("invalid blocklist")
+	}
+
+	k.SetBlocklist(&b)
+	return nil, nil
+}
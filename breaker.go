@@ -0,0 +1,103 @@
+package kite
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitBreaker stops sending requests to a remote kite that keeps failing.
+// After Threshold consecutive failures the circuit opens and the calls fail
+// immediately with a "circuitOpen" error without being sent. After Cooldown a
+// single trial call is let through; the circuit is closed again if it
+// succeeds, otherwise it stays open for another Cooldown.
+//
+// Only timeouts, send errors and disconnects are counted as failures. Errors
+// returned from the remote handler mean that the remote kite is alive. Calls
+// canceled by the caller are not counted either way.
+type CircuitBreaker struct {
+	// Threshold is the number of consecutive failures to open the circuit.
+	Threshold int
+
+	// Cooldown is the duration the circuit stays open.
+	Cooldown time.Duration
+
+	mu       sync.Mutex // protects fields below
+	failures int
+	openedAt time.Time
+	trial    bool // a trial call is in flight in half-open state
+}
+
+// NewCircuitBreaker returns a new CircuitBreaker that opens after threshold
+// consecutive failures and stays open for cooldown.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+	}
+}
+
+// Open returns true if the calls are currently rejected.
+func (b *CircuitBreaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.isOpen() && (b.trial || time.Since(b.openedAt) < b.Cooldown)
+}
+
+func (b *CircuitBreaker) isOpen() bool {
+	return b.Threshold > 0 && b.failures >= b.Threshold
+}
+
+// allow returns true if a call can be made. trial is true if the call is the
+// trial call of the half-open state, it must be passed to report with the
+// result of the call.
+func (b *CircuitBreaker) allow() (trial, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.isOpen() {
+		return false, true
+	}
+
+	// half-open, let only a single call to pass
+	if !b.trial && time.Since(b.openedAt) >= b.Cooldown {
+		b.trial = true
+		return true, true
+	}
+
+	return false, false
+}
+
+// report records the result of a call. While the circuit is open only the
+// result of the trial call is recorded, the calls made before it has opened
+// may finish at any time.
+func (b *CircuitBreaker) report(err error, trial bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if trial {
+		b.trial = false
+	} else if b.isOpen() {
+		return
+	}
+
+	// the call has not finished, it tells nothing about the remote kite
+	if isCanceled(err) {
+		return
+	}
+
+	if !IsDeadPeer(err) {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.isOpen() {
+		b.openedAt = time.Now()
+	}
+}
+
+// isCanceled returns true if the call is canceled by the caller.
+func isCanceled(err error) bool {
+	kiteErr, ok := err.(*Error)
+	return ok && kiteErr.Type == "canceled"
+}
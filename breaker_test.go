@@ -0,0 +1,116 @@
+package kite
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := NewCircuitBreaker(2, 100*time.Millisecond)
+
+	timeout := &Error{Type: "timeout"}
+	remote := &Error{Type: "genericError"}
+
+	b.report(timeout, false)
+	if _, ok := b.allow(); !ok {
+		t.Fatal("circuit should be closed before reaching the threshold")
+	}
+
+	b.report(remote, false)
+	b.report(timeout, false)
+	if _, ok := b.allow(); !ok {
+		t.Fatal("handler errors must reset the failures")
+	}
+
+	b.report(timeout, false)
+	if _, ok := b.allow(); ok {
+		t.Fatal("circuit should be open after reaching the threshold")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if trial, ok := b.allow(); !ok || !trial {
+		t.Fatal("a trial call should be allowed after cooldown")
+	}
+
+	if _, ok := b.allow(); ok {
+		t.Fatal("only a single trial call should be allowed")
+	}
+
+	b.report(nil, true)
+	if _, ok := b.allow(); !ok || b.Open() {
+		t.Fatal("circuit should be closed after a successful trial")
+	}
+}
+
+func TestCircuitBreakerCanceled(t *testing.T) {
+	b := NewCircuitBreaker(2, 100*time.Millisecond)
+
+	timeout := &Error{Type: "timeout"}
+	canceled := &Error{Type: "canceled"}
+
+	b.report(timeout, false)
+	b.report(canceled, false)
+	b.report(timeout, false)
+	if _, ok := b.allow(); ok {
+		t.Fatal("canceled calls must not reset the failures")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, ok := b.allow(); !ok {
+		t.Fatal("a trial call should be allowed after cooldown")
+	}
+
+	// the canceled trial neither closes the circuit nor starts another
+	// cooldown
+	b.report(canceled, true)
+	if _, ok := b.allow(); !ok {
+		t.Fatal("another trial call should be allowed after a canceled trial")
+	}
+
+	if !b.Open() {
+		t.Fatal("circuit should stay open after a canceled trial")
+	}
+}
+
+func TestCircuitBreakerTrial(t *testing.T) {
+	b := NewCircuitBreaker(1, 100*time.Millisecond)
+
+	timeout := &Error{Type: "timeout"}
+
+	// a call made before the circuit has opened
+	if trial, ok := b.allow(); !ok || trial {
+		t.Fatal("circuit should be closed")
+	}
+
+	b.report(timeout, false)
+	time.Sleep(100 * time.Millisecond)
+
+	if trial, ok := b.allow(); !ok || !trial {
+		t.Fatal("a trial call should be allowed after cooldown")
+	}
+
+	// the late result of the earlier call doesn't close the circuit or let
+	// another trial call pass
+	b.report(nil, false)
+	if _, ok := b.allow(); ok {
+		t.Fatal("only the trial call should close the circuit")
+	}
+
+	b.report(timeout, true)
+	if _, ok := b.allow(); ok || !b.Open() {
+		t.Fatal("circuit should stay open after a failed trial")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if trial, ok := b.allow(); !ok || !trial {
+		t.Fatal("a trial call should be allowed after another cooldown")
+	}
+
+	b.report(nil, true)
+	if b.Open() {
+		t.Fatal("circuit should be closed after a successful trial")
+	}
+}
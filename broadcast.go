@@ -0,0 +1,54 @@
+package kite
+
+import "time"
+
+// broadcastTimeout is the duration the responses of the broadcast calls are
+// waited for, after which their callbacks are removed.
+const broadcastTimeout = time.Minute
+
+// Clients returns the remote kites that are connected to this kite.
+func (k *Kite) Clients() []*Client {
+	k.clientsMu.Lock()
+	defer k.clientsMu.Unlock()
+
+	clients := make([]*Client, 0, len(k.clients))
+	for c := range k.clients {
+		clients = append(clients, c)
+	}
+
+	return clients
+}
+
+// ClientByID returns the connected remote kite with the given kite ID, or nil
+// if it's not connected. The remote kite is known after it makes its first
+// request, so kites that are connected but have not called any methods yet
+// are not found.
+func (k *Kite) ClientByID(kiteID string) *Client {
+	if kiteID == "" {
+		return nil
+	}
+
+	k.clientsMu.Lock()
+	defer k.clientsMu.Unlock()
+
+	for c := range k.clients {
+		c.muProt.Lock()
+		id := c.Kite.ID
+		c.muProt.Unlock()
+
+		if id == kiteID {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// Broadcast calls the method with the given arguments on every remote kite
+// that is connected to this kite. It does not wait for the responses, use
+// Clients() instead if the results are needed.
+func (k *Kite) Broadcast(method string, args ...interface{}) {
+	for _, c := range k.Clients() {
+		c.goWithTimeout(method, broadcastTimeout, args...)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/koding/kite/kitekey"
 )
@@ -28,6 +30,57 @@ type Config struct {
 	KontrolURL  string
 	KontrolKey  string
 	KontrolUser string
+
+	// HMACSecret is the shared secret for the "hmac" authentication type.
+	HMACSecret string
+
+	// AllowedOrigins restricts the origins of the browsers that can connect
+	// to the kite, like "https://example.com" or "https://*.example.com".
+	// Connections without an Origin header and from the same origin are
+	// always allowed. Empty means any origin is allowed.
+	AllowedOrigins []string
+
+	// AllowedHosts restricts the Host header of the incoming requests.
+	// Empty means any host is allowed.
+	AllowedHosts []string
+
+	// MaxMessageSize is the maximum size in bytes of a single incoming
+	// dnode message. Larger messages are rejected and the connection is
+	// closed. Zero means no limit.
+	MaxMessageSize int
+
+	// MaxBinaryStoreSize is the total size in bytes of the binary payloads
+	// that the kite keeps for the calls made with TellBinary. Uploads are
+	// rejected while it's exceeded. Zero means kite.DefaultMaxBinaryStoreSize.
+	MaxBinaryStoreSize int
+
+	// SendQueueSize is the number of messages that can be queued for
+	// sending to a remote kite. Sending fails with kite.ErrSendQueueFull
+	// instead of blocking when the queue is full. Zero means
+	// kite.DefaultSendQueueSize.
+	SendQueueSize int
+
+	// CompressionThreshold enables the websocket permessage-deflate
+	// compression if it's positive. Clients compress the messages of at
+	// least CompressionThreshold bytes, the server compresses all messages
+	// of the clients that have negotiated the compression. Zero disables it.
+	CompressionThreshold int
+
+	// HandlerTimeout is the time the method handlers have to return. The
+	// caller gets a "handlerTimeout" error from a handler that doesn't return
+	// in time and the handler is abandoned. Zero means no timeout. It can be
+	// overridden for each method with Method.Timeout.
+	HandlerTimeout time.Duration
+
+	// Labels are free-form key/value pairs registered to kontrol with the
+	// kite, like "gpu=true" or "tier=canary". Kites can be queried by them.
+	Labels map[string]string
+
+	// URLs are the additional URLs of the kite registered to kontrol by
+	// network names, like {"private": "http://10.0.0.5:3000/kite"}. The
+	// kites querying kontrol for a network get these URLs instead of the
+	// registered one.
+	URLs map[string]string
 }
 
 // DefaultConfig contains the default settings.
@@ -77,6 +130,60 @@ func (c *Config) ReadEnvironmentVariables() error {
 		c.KontrolURL = kontrolURL
 	}
 
+	if hmacSecret := os.Getenv("KITE_HMAC_SECRET"); hmacSecret != "" {
+		c.HMACSecret = hmacSecret
+	}
+
+	if origins := os.Getenv("KITE_ALLOWED_ORIGINS"); origins != "" {
+		c.AllowedOrigins = strings.Split(origins, ",")
+	}
+
+	if hosts := os.Getenv("KITE_ALLOWED_HOSTS"); hosts != "" {
+		c.AllowedHosts = strings.Split(hosts, ",")
+	}
+
+	if size := os.Getenv("KITE_MAX_MESSAGE_SIZE"); size != "" {
+		c.MaxMessageSize, err = strconv.Atoi(size)
+		if err != nil {
+			return err
+		}
+	}
+
+	if size := os.Getenv("KITE_MAX_BINARY_STORE_SIZE"); size != "" {
+		c.MaxBinaryStoreSize, err = strconv.Atoi(size)
+		if err != nil {
+			return err
+		}
+	}
+
+	if size := os.Getenv("KITE_SEND_QUEUE_SIZE"); size != "" {
+		c.SendQueueSize, err = strconv.Atoi(size)
+		if err != nil {
+			return err
+		}
+	}
+
+	if size := os.Getenv("KITE_COMPRESSION_THRESHOLD"); size != "" {
+		c.CompressionThreshold, err = strconv.Atoi(size)
+		if err != nil {
+			return err
+		}
+	}
+
+	if timeout := os.Getenv("KITE_HANDLER_TIMEOUT"); timeout != "" {
+		c.HandlerTimeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			return err
+		}
+	}
+
+	if labels := os.Getenv("KITE_LABELS"); labels != "" {
+		c.Labels, err = ParseLabels(labels)
+		if err != nil {
+			return err
+		}
+	}
+
 	if transportName := os.Getenv("KITE_TRANSPORT"); transportName != "" {
 		transport, ok := Transports[transportName]
 		if !ok {
@@ -89,9 +196,17 @@ func (c *Config) ReadEnvironmentVariables() error {
 	return nil
 }
 
-// ReadKiteKey parsed the user's kite key and returns a new Config.
+// ReadKiteKey parsed the user's kite key and returns a new Config. The kite
+// key of the profile in KITE_PROFILE environment variable is read if it's
+// set.
 func (c *Config) ReadKiteKey() error {
-	key, err := kitekey.Parse()
+	return c.ReadProfile(kitekey.Profile())
+}
+
+// ReadProfile is like ReadKiteKey but reads the kite key of the named
+// profile, see kitekey.ProfilePath.
+func (c *Config) ReadProfile(name string) error {
+	key, err := kitekey.ParseProfile(name)
 	if err != nil {
 		return err
 	}
@@ -126,9 +241,44 @@ func (c *Config) ReadKiteKey() error {
 func (c *Config) Copy() *Config {
 	cloned := new(Config)
 	*cloned = *c
+	cloned.AllowedOrigins = append([]string(nil), c.AllowedOrigins...)
+	cloned.AllowedHosts = append([]string(nil), c.AllowedHosts...)
+
+	cloned.Labels = copyMap(c.Labels)
+	cloned.URLs = copyMap(c.URLs)
+	return cloned
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+
+	cloned := make(map[string]string, len(m))
+	for k, v := range m {
+		cloned[k] = v
+	}
+
 	return cloned
 }
 
+// ParseLabels parses the labels in the form of "key=value" separated by
+// commas, like "gpu=true,tier=canary".
+func ParseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+
+	for _, label := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(label), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid label %q", label)
+		}
+
+		labels[kv[0]] = kv[1]
+	}
+
+	return labels, nil
+}
+
 func Get() (*Config, error) {
 	c := New()
 	if err := c.ReadKiteKey(); err != nil {
@@ -140,6 +290,19 @@ func Get() (*Config, error) {
 	return c, nil
 }
 
+// GetProfile is like Get but reads the kite key of the named profile instead
+// of the one selected with KITE_PROFILE, like "staging" or "production".
+func GetProfile(name string) (*Config, error) {
+	c := New()
+	if err := c.ReadProfile(name); err != nil {
+		return nil, err
+	}
+	if err := c.ReadEnvironmentVariables(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
 func MustGet() *Config {
 	c, err := Get()
 	if err != nil {
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/koding/multiconfig"
+)
+
+// fileConfig is the structure of the config files read by FromFile. It's
+// separate from Config because the transport is given by its name and the
+// durations are given as strings, like "30s".
+type fileConfig struct {
+	Username              string            `json:"username" toml:"username" yaml:"username"`
+	Environment           string            `json:"environment" toml:"environment" yaml:"environment"`
+	Region                string            `json:"region" toml:"region" yaml:"region"`
+	Id                    string            `json:"id" toml:"id" yaml:"id"`
+	KiteKey               string            `json:"kiteKey" toml:"kiteKey" yaml:"kiteKey"`
+	DisableAuthentication bool              `json:"disableAuthentication" toml:"disableAuthentication" yaml:"disableAuthentication"`
+	DisableConcurrency    bool              `json:"disableConcurrency" toml:"disableConcurrency" yaml:"disableConcurrency"`
+	Transport             string            `json:"transport" toml:"transport" yaml:"transport"`
+	IP                    string            `json:"ip" toml:"ip" yaml:"ip"`
+	Port                  int               `json:"port" toml:"port" yaml:"port"`
+	KontrolURL            string            `json:"kontrolURL" toml:"kontrolURL" yaml:"kontrolURL"`
+	KontrolKey            string            `json:"kontrolKey" toml:"kontrolKey" yaml:"kontrolKey"`
+	KontrolUser           string            `json:"kontrolUser" toml:"kontrolUser" yaml:"kontrolUser"`
+	HMACSecret            string            `json:"hmacSecret" toml:"hmacSecret" yaml:"hmacSecret"`
+	AllowedOrigins        []string          `json:"allowedOrigins" toml:"allowedOrigins" yaml:"allowedOrigins"`
+	AllowedHosts          []string          `json:"allowedHosts" toml:"allowedHosts" yaml:"allowedHosts"`
+	MaxMessageSize        int               `json:"maxMessageSize" toml:"maxMessageSize" yaml:"maxMessageSize"`
+	MaxBinaryStoreSize    int               `json:"maxBinaryStoreSize" toml:"maxBinaryStoreSize" yaml:"maxBinaryStoreSize"`
+	SendQueueSize         int               `json:"sendQueueSize" toml:"sendQueueSize" yaml:"sendQueueSize"`
+	CompressionThreshold  int               `json:"compressionThreshold" toml:"compressionThreshold" yaml:"compressionThreshold"`
+	HandlerTimeout        string            `json:"handlerTimeout" toml:"handlerTimeout" yaml:"handlerTimeout"`
+	Labels                map[string]string `json:"labels" toml:"labels" yaml:"labels"`
+	URLs                  map[string]string `json:"urls" toml:"urls" yaml:"urls"`
+}
+
+// FromFile returns a new Config read from the file at path. The format of the
+// file is selected by its extension: ".json", ".toml", ".yaml" or ".yml".
+// Keys are the field names of Config in camel case, like "kontrolURL",
+// and the transport is given by its name, like "XHRPolling".
+//
+// Settings are applied in the following order, a later one overrides the
+// earlier ones:
+//
+//  1. Defaults in DefaultConfig.
+//  2. The kite.key file, if it exists.
+//  3. The config file. Empty values in the file are ignored.
+//  4. Environment variables, see ReadEnvironmentVariables.
+func FromFile(path string) (*Config, error) {
+	c := New()
+
+	if err := c.ReadKiteKey(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := c.ReadFile(path); err != nil {
+		return nil, err
+	}
+
+	if err := c.ReadEnvironmentVariables(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ReadFile reads the config file at path and overrides the fields of c that
+// have a non-empty value in the file. See FromFile for the file format.
+func (c *Config) ReadFile(path string) error {
+	var loader multiconfig.Loader
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		loader = &multiconfig.JSONLoader{Path: path}
+	case ".toml":
+		loader = &multiconfig.TOMLLoader{Path: path}
+	case ".yaml", ".yml":
+		loader = &multiconfig.YAMLLoader{Path: path}
+	default:
+		return fmt.Errorf("unknown config file extension: %q", ext)
+	}
+
+	var f fileConfig
+	if err := loader.Load(&f); err != nil {
+		return err
+	}
+
+	return c.apply(&f)
+}
+
+// apply copies the non-empty fields of f to c.
+func (c *Config) apply(f *fileConfig) error {
+	setString := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+
+	setString(&c.Username, f.Username)
+	setString(&c.Environment, f.Environment)
+	setString(&c.Region, f.Region)
+	setString(&c.Id, f.Id)
+	setString(&c.KiteKey, f.KiteKey)
+	setString(&c.IP, f.IP)
+	setString(&c.KontrolURL, f.KontrolURL)
+	setString(&c.KontrolKey, f.KontrolKey)
+	setString(&c.KontrolUser, f.KontrolUser)
+	setString(&c.HMACSecret, f.HMACSecret)
+
+	if len(f.AllowedOrigins) != 0 {
+		c.AllowedOrigins = f.AllowedOrigins
+	}
+
+	if len(f.AllowedHosts) != 0 {
+		c.AllowedHosts = f.AllowedHosts
+	}
+
+	if f.Port != 0 {
+		c.Port = f.Port
+	}
+
+	if len(f.Labels) != 0 {
+		c.Labels = f.Labels
+	}
+
+	if len(f.URLs) != 0 {
+		c.URLs = f.URLs
+	}
+
+	if f.MaxMessageSize != 0 {
+		c.MaxMessageSize = f.MaxMessageSize
+	}
+
+	if f.MaxBinaryStoreSize != 0 {
+		c.MaxBinaryStoreSize = f.MaxBinaryStoreSize
+	}
+
+	if f.SendQueueSize != 0 {
+		c.SendQueueSize = f.SendQueueSize
+	}
+
+	if f.CompressionThreshold != 0 {
+		c.CompressionThreshold = f.CompressionThreshold
+	}
+
+	if f.HandlerTimeout != "" {
+		timeout, err := time.ParseDuration(f.HandlerTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid handler timeout: %s", err)
+		}
+
+		c.HandlerTimeout = timeout
+	}
+
+	if f.DisableAuthentication {
+		c.DisableAuthentication = true
+	}
+
+	if f.DisableConcurrency {
+		c.DisableConcurrency = true
+	}
+
+	if f.Transport != "" {
+		transport, ok := Transports[f.Transport]
+		if !ok {
+			return fmt.Errorf("transport '%s' doesn't exists", f.Transport)
+		}
+
+		c.Transport = transport
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kite-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// do not read the kite.key of the user
+	os.Setenv("KITE_HOME", dir)
+	defer os.Unsetenv("KITE_HOME")
+
+	path := filepath.Join(dir, "kite.json")
+	data := `{
+		"username": "alice",
+		"environment": "production",
+		"port": 5000,
+		"transport": "XHRPolling"
+	}`
+
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("KITE_ENVIRONMENT", "staging")
+	defer os.Unsetenv("KITE_ENVIRONMENT")
+
+	c, err := FromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Username != "alice" {
+		t.Errorf("got username %q, want: alice", c.Username)
+	}
+
+	if c.Port != 5000 {
+		t.Errorf("got port %d, want: 5000", c.Port)
+	}
+
+	if c.Transport != XHRPolling {
+		t.Errorf("got transport %s, want: XHRPolling", c.Transport)
+	}
+
+	// environment variables override the file
+	if c.Environment != "staging" {
+		t.Errorf("got environment %q, want: staging", c.Environment)
+	}
+
+	// defaults are kept for the missing values
+	if c.Region != DefaultConfig.Region {
+		t.Errorf("got region %q, want: %q", c.Region, DefaultConfig.Region)
+	}
+}
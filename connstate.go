@@ -0,0 +1,98 @@
+package kite
+
+// ConnState is the state of the connection of a Client to the remote kite.
+type ConnState int
+
+const (
+	// Disconnected means the client is not connected and it's not trying to
+	// connect. It's the state of a new client.
+	Disconnected ConnState = iota
+
+	// Connecting means the client is dialing the remote kite. A client that
+	// keeps redialing stays in this state until it connects or gives up.
+	Connecting
+
+	// Connected means the client is connected to the remote kite.
+	Connected
+)
+
+// stateChangesBuffer is the number of state changes that are kept for a
+// reader of a StateChanges channel that falls behind.
+const stateChangesBuffer = 16
+
+func (s ConnState) String() string {
+	switch s {
+	case Disconnected:
+		return "disconnected"
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	default:
+		return "unknown"
+	}
+}
+
+// State returns the current state of the connection.
+func (c *Client) State() ConnState {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.state
+}
+
+// StateChanges returns a channel that receives the new state of the
+// connection every time it changes. Each call returns a new channel. The
+// changes are dropped if the reader falls behind. The channel is closed when
+// the client is closed.
+func (c *Client) StateChanges() <-chan ConnState {
+	ch := make(chan ConnState, stateChangesBuffer)
+
+	c.stateMu.Lock()
+	if c.stateClosed {
+		close(ch)
+	} else {
+		c.stateChans = append(c.stateChans, ch)
+	}
+	c.stateMu.Unlock()
+
+	return ch
+}
+
+// setState changes the state of the connection and notifies the
+// StateChanges channels.
+func (c *Client) setState(state ConnState) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if c.state == state || c.stateClosed {
+		return
+	}
+
+	c.state = state
+
+	for _, ch := range c.stateChans {
+		select {
+		case ch <- state:
+		default:
+		}
+	}
+}
+
+// closeState sets the state to Disconnected for the last time and closes the
+// StateChanges channels.
+func (c *Client) closeState() {
+	c.setState(Disconnected)
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if c.stateClosed {
+		return
+	}
+	c.stateClosed = true
+
+	for _, ch := range c.stateChans {
+		close(ch)
+	}
+	c.stateChans = nil
+}
@@ -0,0 +1,82 @@
+package kite
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// handlerDeadlines keeps the deadlines of the requests whose handlers are
+// running, keyed by the id of the goroutine that runs the handler. The calls
+// made by a handler in its goroutine don't wait longer than its caller.
+type handlerDeadlines struct {
+	count int32    // number of the saved deadlines, accessed atomically
+	m     sync.Map // goroutine id -> time.Time
+}
+
+// enter saves the deadline for the calling goroutine. The returned function
+// must be called by the same goroutine when the handler returns.
+func (h *handlerDeadlines) enter(deadline time.Time) (leave func()) {
+	id := goroutineID()
+	h.m.Store(id, deadline)
+	atomic.AddInt32(&h.count, 1)
+
+	return func() {
+		h.m.Delete(id)
+		atomic.AddInt32(&h.count, -1)
+	}
+}
+
+// get returns the deadline of the handler running in the calling goroutine.
+func (h *handlerDeadlines) get() (time.Time, bool) {
+	// the goroutine id is looked up only while there are handlers with
+	// deadlines
+	if atomic.LoadInt32(&h.count) == 0 {
+		return time.Time{}, false
+	}
+
+	deadline, ok := h.m.Load(goroutineID())
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return deadline.(time.Time), true
+}
+
+// timeout returns the shorter of timeout and the time left until the deadline
+// of the handler running in the calling goroutine. Zero means no timeout.
+func (h *handlerDeadlines) timeout(timeout time.Duration) time.Duration {
+	deadline, ok := h.get()
+	if !ok {
+		return timeout
+	}
+
+	left := time.Until(deadline)
+	if left <= 0 {
+		left = time.Nanosecond // the call times out immediately
+	}
+
+	if timeout <= 0 || left < timeout {
+		return left
+	}
+
+	return timeout
+}
+
+// goroutineID returns the id of the current goroutine, parsed from the
+// header of its stack trace: "goroutine 18 [running]:".
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		buf = buf[:i]
+	}
+
+	id, _ := strconv.ParseUint(string(buf), 10, 64)
+	return id
+}
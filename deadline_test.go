@@ -0,0 +1,73 @@
+package kite
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeadlinePropagation(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10030
+
+	self := k.NewClient("http://127.0.0.1:10030/kite")
+
+	deadlines := make(chan time.Time, 2)
+	k.HandleFunc("inner", func(r *Request) (interface{}, error) {
+		deadlines <- r.Deadline
+		return nil, nil
+	})
+	k.HandleFunc("outer", func(r *Request) (interface{}, error) {
+		deadlines <- r.Deadline
+		return self.TellWithContext(r.Ctx, "inner")
+	})
+
+	// the deadline is propagated without passing the context
+	k.HandleFunc("outerTell", func(r *Request) (interface{}, error) {
+		deadlines <- r.Deadline
+		return self.Tell("inner")
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	if err := self.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer self.Close()
+
+	e := New("exp", "0.0.1")
+	c := e.NewClient("http://127.0.0.1:10030/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, method := range []string{"outer", "outerTell"} {
+		start := time.Now()
+		if _, err := c.TellWithTimeout(method, 2*time.Second); err != nil {
+			t.Fatal(err)
+		}
+		end := time.Now()
+
+		// the deadline is set when the call is received
+		outer, inner := <-deadlines, <-deadlines
+		if outer.Before(start.Add(2*time.Second)) || outer.After(end.Add(2*time.Second)) {
+			t.Errorf("%s: wrong deadline of the outer call: %s", method, outer)
+		}
+
+		// the remaining time is sent, so allow for the time it takes to send it
+		if inner.IsZero() || inner.After(outer.Add(100*time.Millisecond)) {
+			t.Errorf("%s: deadline of the inner call %s is not before %s", method, inner, outer)
+		}
+	}
+
+	if _, err := c.Tell("inner"); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := <-deadlines; !d.IsZero() {
+		t.Errorf("call without a timeout has the deadline: %s", d)
+	}
+}
@@ -0,0 +1,199 @@
+package kite
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/koding/kite/protocol"
+)
+
+// Description is the result of the "kite.describe" method.
+type Description struct {
+	Kite    protocol.Kite `json:"kite"`
+	Methods []MethodInfo  `json:"methods"`
+}
+
+// MethodInfo describes a method of a kite.
+type MethodInfo struct {
+	Name string `json:"name"`
+
+	// Version is the version of the method, zero if it's not versioned.
+	// Deprecated is the deprecation message of the method.
+	Version    int    `json:"version,omitempty"`
+	Deprecated string `json:"deprecated,omitempty"`
+
+	// Authenticate is true if the method requires the caller to be
+	// authenticated.
+	Authenticate bool `json:"authenticate"`
+
+	// AuthTypes are the authentication types accepted by the method. Empty
+	// means any type the kite supports.
+	AuthTypes []string `json:"authTypes,omitempty"`
+
+	// Authorized is true if the method has additional authorization checks,
+	// like AllowUsers.
+	Authorized bool `json:"authorized,omitempty"`
+
+	// Args and Result are the JSON schemas of the argument and the result of
+	// the method. They are only known for the typed handlers.
+	Args   map[string]interface{} `json:"args,omitempty"`
+	Result map[string]interface{} `json:"result,omitempty"`
+}
+
+// Describe returns the methods of the kite sorted by their names and versions.
+func (k *Kite) Describe() *Description {
+	d := &Description{
+		Kite:    *k.Kite(),
+		Methods: make([]MethodInfo, 0, len(k.handlers)),
+	}
+
+	for _, versions := range k.versions {
+		for _, m := range versions {
+			d.Methods = append(d.Methods, describeMethod(m))
+		}
+	}
+
+	sort.Slice(d.Methods, func(i, j int) bool {
+		a, b := d.Methods[i], d.Methods[j]
+		return a.Name < b.Name || a.Name == b.Name && a.Version < b.Version
+	})
+
+	return d
+}
+
+// describeMethod returns the description of the method.
+func describeMethod(m *Method) MethodInfo {
+	info := MethodInfo{
+		Name:         m.name,
+		Version:      m.version,
+		Deprecated:   m.deprecated,
+		Authenticate: m.authenticate,
+		AuthTypes:    m.authTypes,
+		Authorized:   len(m.authorizers) != 0,
+	}
+
+	if m.argsType != nil {
+		info.Args = jsonSchema(m.argsType)
+	}
+
+	if m.resultType != nil {
+		info.Result = jsonSchema(m.resultType)
+	}
+
+	return info
+}
+
+// handleDescribe returns the list of methods of the kite, so clients can
+// discover the API of the kite at runtime.
+func (k *Kite) handleDescribe(r *Request) (interface{}, error) {
+	return k.Describe(), nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// jsonSchema returns the JSON schema of the values of type t as they are
+// marshaled by the json package.
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	return schemaOf(t, make(map[reflect.Type]bool))
+}
+
+func schemaOf(t reflect.Type, seen map[reflect.Type]bool) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), seen)}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": schemaOf(t.Elem(), seen),
+		}
+	case reflect.Struct:
+		// recursive types are not expanded again
+		if seen[t] {
+			return map[string]interface{}{"type": "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		properties := make(map[string]interface{})
+		var required []string
+
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+
+			name, omitempty := f.Name, false
+			if tag != "" {
+				parts := strings.Split(tag, ",")
+				if parts[0] != "" {
+					name = parts[0]
+				}
+
+				for _, opt := range parts[1:] {
+					omitempty = omitempty || opt == "omitempty"
+				}
+			}
+
+			// the fields of embedded structs are promoted
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+
+			if f.Anonymous && ft.Kind() == reflect.Struct && name == f.Name {
+				embedded := schemaOf(ft, seen)
+				if props, ok := embedded["properties"].(map[string]interface{}); ok {
+					for n, p := range props {
+						properties[n] = p
+					}
+				}
+				if req, ok := embedded["required"].([]string); ok {
+					required = append(required, req...)
+				}
+				continue
+			}
+
+			if f.PkgPath != "" { // unexported
+				continue
+			}
+
+			properties[name] = schemaOf(f.Type, seen)
+			if !omitempty && f.Type.Kind() != reflect.Ptr {
+				required = append(required, name)
+			}
+		}
+
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) != 0 {
+			schema["required"] = required
+		}
+		return schema
+	default:
+		// interfaces and other types may hold any value
+		return map[string]interface{}{}
+	}
+}
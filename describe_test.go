@@ -0,0 +1,93 @@
+package kite
+
+import (
+	"reflect"
+	"testing"
+)
+
+type describeBase struct {
+	ID string `json:"id"`
+}
+
+type describeArgs struct {
+	describeBase
+	Path    string            `json:"path"`
+	Offset  int64             `json:"offset,omitempty"`
+	Data    []byte            `json:"data"`
+	Tags    map[string]string `json:"tags"`
+	Next    *describeArgs     `json:"next"`
+	Ignored string            `json:"-"`
+	private int
+}
+
+func TestJSONSchema(t *testing.T) {
+	schema := jsonSchema(reflect.TypeOf(describeArgs{}))
+
+	properties := schema["properties"].(map[string]interface{})
+	for _, name := range []string{"id", "path", "offset", "data", "tags", "next"} {
+		if _, ok := properties[name]; !ok {
+			t.Errorf("property %q is missing", name)
+		}
+	}
+
+	if len(properties) != 6 {
+		t.Errorf("got %d properties, want: 6", len(properties))
+	}
+
+	if typ := properties["offset"].(map[string]interface{})["type"]; typ != "integer" {
+		t.Errorf("offset type is %v, want: integer", typ)
+	}
+
+	if typ := properties["data"].(map[string]interface{})["type"]; typ != "string" {
+		t.Errorf("data type is %v, want: string", typ)
+	}
+
+	required := schema["required"].([]string)
+	if want := []string{"id", "path", "data", "tags"}; !reflect.DeepEqual(required, want) {
+		t.Errorf("got required %v, want: %v", required, want)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	k := New("testkite", "0.0.1")
+
+	k.HandleTyped("fs.readFile", func(r *Request, args describeArgs) ([]byte, error) {
+		return nil, nil
+	}).AuthTypes("token").AllowUsers("alice")
+
+	k.HandleFunc("fs.remove", func(r *Request) (interface{}, error) {
+		return nil, nil
+	})
+
+	var info, untyped *MethodInfo
+	d := k.Describe()
+	for i := range d.Methods {
+		if i > 0 && d.Methods[i-1].Name > d.Methods[i].Name {
+			t.Fatal("methods are not sorted")
+		}
+
+		switch d.Methods[i].Name {
+		case "fs.readFile":
+			info = &d.Methods[i]
+		case "fs.remove":
+			untyped = &d.Methods[i]
+		}
+	}
+
+	if info == nil || untyped == nil {
+		t.Fatal("methods are not described")
+	}
+
+	if !info.Authenticate || !info.Authorized || !reflect.DeepEqual(info.AuthTypes, []string{"token"}) {
+		t.Errorf("wrong auth requirements: %+v", info)
+	}
+
+	if info.Args == nil || info.Result["type"] != "string" {
+		t.Errorf("wrong schemas: %+v", info)
+	}
+
+	// the schemas are known for the typed handlers only
+	if untyped.Args != nil || untyped.Result != nil {
+		t.Errorf("got schemas of an untyped method: %+v", untyped)
+	}
+}
@@ -2,6 +2,7 @@ package dnode
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
 )
 
@@ -29,10 +30,12 @@ func (f Function) IsValid() bool {
 }
 
 func (f Function) MarshalJSON() ([]byte, error) {
-	if _, ok := f.Caller.(callback); !ok {
+	switch f.Caller.(type) {
+	case callback, persistentCallback:
+		return []byte(`"[Function]"`), nil
+	default:
 		return []byte(`null`), nil
 	}
-	return []byte(`"[Function]"`), nil
 }
 
 func (*Function) UnmarshalJSON(data []byte) error {
@@ -46,6 +49,15 @@ func Callback(f func(*Partial)) Function {
 	}
 }
 
+// PersistentCallback is like Callback, but the callback is not expired by
+// Scrubber.TTL. It's for the callbacks that are removed explicitly with
+// Scrubber.RemoveCallback when they are done, like the response callbacks.
+func PersistentCallback(f func(*Partial)) Function {
+	return Function{
+		Caller: persistentCallback(f),
+	}
+}
+
 type callback func(*Partial)
 
 func (f callback) Call(args ...interface{}) error {
@@ -53,6 +65,12 @@ func (f callback) Call(args ...interface{}) error {
 	panic("you cannot call your own callback method")
 }
 
+type persistentCallback func(*Partial)
+
+func (f persistentCallback) Call(args ...interface{}) error {
+	panic("you cannot call your own callback method")
+}
+
 // functionReceived is a type implementing caller interface.
 // It is used to set the Function when a callback function is received.
 type functionReceived func(...interface{}) error
@@ -76,8 +94,31 @@ type Path []interface{}
 // parseCallbacks parses the message's "callbacks" field and prepares
 // callback functions in "arguments" field.
 func ParseCallbacks(msg *Message, sender func(id uint64, args []interface{}) error) error {
+	return parseCallbacks(msg, sender, 0, 0)
+}
+
+// ParseCallbacks is like the ParseCallbacks function but it rejects the
+// messages that exceed the MaxReceivedCallbacks and MaxPathDepth limits of
+// the Scrubber.
+func (s *Scrubber) ParseCallbacks(msg *Message, sender func(id uint64, args []interface{}) error) error {
+	s.Lock()
+	maxCallbacks, maxDepth := s.MaxReceivedCallbacks, s.MaxPathDepth
+	s.Unlock()
+
+	return parseCallbacks(msg, sender, maxCallbacks, maxDepth)
+}
+
+func parseCallbacks(msg *Message, sender func(id uint64, args []interface{}) error, maxCallbacks, maxDepth int) error {
+	if maxCallbacks > 0 && len(msg.Callbacks) > maxCallbacks {
+		return ErrTooManyCallbacks
+	}
+
 	// Parse callbacks field and create callback functions.
 	for methodID, path := range msg.Callbacks {
+		if maxDepth > 0 && len(path) > maxDepth {
+			return fmt.Errorf("callback path is too deep: %d elements", len(path))
+		}
+
 		id, err := strconv.ParseUint(methodID, 10, 64)
 		if err != nil {
 			return err
@@ -1,9 +1,14 @@
 package dnode
 
 import (
+	"errors"
 	"fmt"
 )
 
+// ErrTooManyCallbacks is returned when the number of callbacks exceeds the
+// limits of the Scrubber.
+var ErrTooManyCallbacks = errors.New("too many callbacks")
+
 // MethodNotFoundError is returned when there is no registered handler for
 // received method.
 type MethodNotFoundError struct {
@@ -0,0 +1,695 @@
+package dnode
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Marshaler is implemented by types that control their own representation
+// in dnode messages. MarshalDnode returns the value that is sent in place of
+// the receiver. The returned value may contain callbacks.
+type Marshaler interface {
+	MarshalDnode() (interface{}, error)
+}
+
+// Unmarshaler is implemented by types that decode their own representation
+// in dnode messages. The Partial holds the raw JSON of the value and the
+// callbacks in it, with their paths relative to the value.
+type Unmarshaler interface {
+	UnmarshalDnode(p *Partial) error
+}
+
+var (
+	marshalerType       = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	jsonMarshalerType   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// MarshalArgs returns a copy of the arguments in which the values
+// implementing Marshaler are replaced by the results of their MarshalDnode
+// methods. Structs that contain such values are replaced by maps keyed by
+// their JSON field names. Arguments without any Marshaler are returned as
+// they are.
+func MarshalArgs(args []interface{}) ([]interface{}, error) {
+	var out []interface{}
+
+	for i, arg := range args {
+		v, changed, err := marshalValue(reflect.ValueOf(arg))
+		if err != nil {
+			return nil, err
+		}
+
+		if !changed {
+			continue
+		}
+
+		if out == nil {
+			out = make([]interface{}, len(args))
+			copy(out, args)
+		}
+
+		out[i] = v
+	}
+
+	if out == nil {
+		return args, nil
+	}
+
+	return out, nil
+}
+
+// marshalValue returns the value to encode in place of v. It returns false
+// if v can be encoded as it is.
+func marshalValue(v reflect.Value) (interface{}, bool, error) {
+	if !v.IsValid() || !mayContain(v.Type(), marshalerType, jsonMarshalerType) {
+		return nil, false, nil
+	}
+
+	if v.CanAddr() && v.Kind() != reflect.Ptr && reflect.PtrTo(v.Type()).Implements(marshalerType) {
+		v = v.Addr()
+	}
+
+	if v.Type().Implements(marshalerType) {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return nil, false, nil
+		}
+
+		m, err := v.Interface().(Marshaler).MarshalDnode()
+		if err != nil {
+			return nil, false, err
+		}
+
+		// the result may contain other Marshalers
+		if r, changed, err := marshalValue(reflect.ValueOf(m)); err != nil || changed {
+			return r, changed, err
+		}
+
+		return m, true, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, false, nil
+		}
+
+		return marshalValue(v.Elem())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, false, nil
+		}
+
+		var out []interface{}
+		for i := 0; i < v.Len(); i++ {
+			e, changed, err := marshalValue(v.Index(i))
+			if err != nil {
+				return nil, false, err
+			}
+
+			if !changed {
+				if out != nil {
+					out[i] = v.Index(i).Interface()
+				}
+				continue
+			}
+
+			if out == nil {
+				out = make([]interface{}, v.Len())
+				for j := 0; j < i; j++ {
+					out[j] = v.Index(j).Interface()
+				}
+			}
+
+			out[i] = e
+		}
+
+		return out, out != nil, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, false, nil
+		}
+
+		var out map[string]interface{}
+		for _, key := range v.MapKeys() {
+			e, changed, err := marshalValue(v.MapIndex(key))
+			if err != nil {
+				return nil, false, err
+			}
+
+			if !changed {
+				continue
+			}
+
+			if out == nil {
+				out = make(map[string]interface{}, v.Len())
+			}
+
+			name, err := mapKeyString(key)
+			if err != nil {
+				return nil, false, err
+			}
+
+			out[name] = e
+		}
+
+		if out == nil {
+			return nil, false, nil
+		}
+
+		// copy the values that are not changed
+		for _, key := range v.MapKeys() {
+			name, err := mapKeyString(key)
+			if err != nil {
+				return nil, false, err
+			}
+
+			if _, ok := out[name]; !ok {
+				out[name] = v.MapIndex(key).Interface()
+			}
+		}
+
+		return out, true, nil
+	case reflect.Struct:
+		// the map is built only if a field is changed, most structs
+		// with interface fields hold no Marshalers
+		fields, changed, err := marshalStruct(v, nil)
+		if err != nil || !changed {
+			return nil, false, err
+		}
+
+		out := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			if out[f.name], err = f.result(); err != nil {
+				return nil, false, err
+			}
+		}
+
+		return out, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// structField is a field of a struct marshaled by marshalStruct.
+type structField struct {
+	name    string
+	value   reflect.Value
+	quoted  bool        // has the ",string" option
+	changed bool        // replaced by marshalValue
+	out     interface{} // the result of marshalValue
+}
+
+// result returns the value to encode for the field.
+func (f *structField) result() (interface{}, error) {
+	if f.changed {
+		return f.out, nil
+	}
+
+	if !f.quoted {
+		return f.value.Interface(), nil
+	}
+
+	// encoding/json encodes the values with the ",string" option in JSON
+	// strings, nil pointers are still null
+	if f.value.Kind() == reflect.Ptr && f.value.IsNil() {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(f.value.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+// marshalStruct appends the exported fields of v to fields and reports
+// whether any of them is changed by marshalValue. Fields of embedded structs
+// are appended like encoding/json does, a later field with the same name
+// replaces the previous one.
+func marshalStruct(v reflect.Value, fields []structField) ([]structField, bool, error) {
+	var changed bool
+
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" && !f.Anonymous { // unexported
+			continue
+		}
+
+		name, opts, ok := jsonField(f)
+		if !ok {
+			continue
+		}
+
+		fv := v.Field(i)
+
+		if f.Anonymous && name == "" {
+			ev := fv
+			if ev.Kind() == reflect.Ptr {
+				if ev.IsNil() {
+					continue
+				}
+				ev = ev.Elem()
+			}
+
+			if ev.Kind() == reflect.Struct && !ev.Type().Implements(jsonMarshalerType) {
+				var c bool
+				var err error
+				if fields, c, err = marshalStruct(ev, fields); err != nil {
+					return nil, false, err
+				}
+
+				changed = changed || c
+				continue
+			}
+		}
+
+		if f.PkgPath != "" { // unexported embedded non-struct
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		if opts.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+
+		e, c, err := marshalValue(fv)
+		if err != nil {
+			return nil, false, err
+		}
+
+		changed = changed || c
+		fields = append(fields, structField{
+			name:    name,
+			value:   fv,
+			quoted:  opts.quoted && isQuotable(f.Type),
+			changed: c,
+			out:     e,
+		})
+	}
+
+	return fields, changed, nil
+}
+
+// mapKeyString returns the key of a map in JSON, like encoding/json does.
+func mapKeyString(key reflect.Value) (string, error) {
+	if key.Kind() == reflect.String {
+		return key.String(), nil
+	}
+
+	if tm, ok := key.Interface().(encoding.TextMarshaler); ok {
+		if key.Kind() == reflect.Ptr && key.IsNil() {
+			return "", nil
+		}
+
+		text, err := tm.MarshalText()
+		return string(text), err
+	}
+
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), nil
+	}
+
+	return "", fmt.Errorf("dnode: unsupported map key type %s", key.Type())
+}
+
+// mapKeyValue parses the key of a map in JSON into a value of type t, like
+// encoding/json does.
+func mapKeyValue(name string, t reflect.Type) (reflect.Value, error) {
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		key := reflect.New(t)
+		err := key.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(name))
+		return key.Elem(), err
+	}
+
+	if t.Kind() == reflect.String {
+		return reflect.ValueOf(name).Convert(t), nil
+	}
+
+	key := reflect.New(t).Elem()
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(name, 10, t.Bits())
+		if err != nil {
+			return key, err
+		}
+		key.SetInt(n)
+		return key, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(name, 10, t.Bits())
+		if err != nil {
+			return key, err
+		}
+		key.SetUint(n)
+		return key, nil
+	}
+
+	return key, fmt.Errorf("dnode: unsupported map key type %s", t)
+}
+
+// isQuotable reports whether the ",string" option applies to the type, as in
+// encoding/json. It's ignored for the types encoding themselves.
+func isQuotable(t reflect.Type) bool {
+	if t.Name() == "" && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) {
+		return false
+	}
+
+	switch t.Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+
+	return false
+}
+
+// unmarshalValue decodes the raw JSON into v, calling UnmarshalDnode of the
+// values implementing Unmarshaler. specs are the callbacks in raw, their
+// paths are relative to v.
+func unmarshalValue(raw []byte, v reflect.Value, specs []CallbackSpec) error {
+	if isNull(raw) {
+		return nil
+	}
+
+	if isUnmarshaler(v) {
+		p := &Partial{Raw: raw, CallbackSpecs: specs}
+		return v.Addr().Interface().(Unmarshaler).UnmarshalDnode(p)
+	}
+
+	if !mayContain(v.Type(), unmarshalerType, jsonUnmarshalerType) {
+		return json.Unmarshal(raw, v.Addr().Interface())
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+
+		return unmarshalValue(raw, v.Elem(), specs)
+	case reflect.Slice, reflect.Array:
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return err
+		}
+
+		if v.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(v.Type(), len(items), len(items)))
+		}
+
+		for i, item := range items {
+			if i >= v.Len() {
+				break
+			}
+
+			if err := unmarshalValue(item, v.Index(i), childSpecs(specs, strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case reflect.Map:
+		var items map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return err
+		}
+
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+
+		for name, item := range items {
+			key, err := mapKeyValue(name, v.Type().Key())
+			if err != nil {
+				return err
+			}
+
+			e := reflect.New(v.Type().Elem()).Elem()
+			if err := unmarshalValue(item, e, childSpecs(specs, name)); err != nil {
+				return err
+			}
+
+			v.SetMapIndex(key, e)
+		}
+
+		return nil
+	case reflect.Struct:
+		var items map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return err
+		}
+
+		return unmarshalStruct(items, v, specs)
+	}
+
+	return json.Unmarshal(raw, v.Addr().Interface())
+}
+
+// isUnmarshaler reports whether v is decoded by its UnmarshalDnode method.
+func isUnmarshaler(v reflect.Value) bool {
+	return v.CanAddr() && v.Kind() != reflect.Ptr && reflect.PtrTo(v.Type()).Implements(unmarshalerType)
+}
+
+// unmarshalStruct decodes the items into the fields of v that have the same
+// JSON names.
+func unmarshalStruct(items map[string]json.RawMessage, v reflect.Value, specs []CallbackSpec) error {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" && !f.Anonymous { // unexported
+			continue
+		}
+
+		name, opts, ok := jsonField(f)
+		if !ok {
+			continue
+		}
+
+		fv := v.Field(i)
+
+		if f.Anonymous && name == "" {
+			t := f.Type
+			if t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+
+			if t.Kind() == reflect.Struct && !reflect.PtrTo(t).Implements(jsonUnmarshalerType) {
+				if fv.Kind() == reflect.Ptr {
+					if !fv.CanSet() {
+						continue
+					}
+					if fv.IsNil() {
+						fv.Set(reflect.New(t))
+					}
+					fv = fv.Elem()
+				}
+
+				if err := unmarshalStruct(items, fv, specs); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if f.PkgPath != "" { // unexported embedded non-struct
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		item, ok := items[name]
+		if !ok {
+			// encoding/json matches the keys case-insensitively
+			for key, raw := range items {
+				if strings.EqualFold(key, name) {
+					name, item, ok = key, raw, true
+					break
+				}
+			}
+		}
+
+		if !ok {
+			continue
+		}
+
+		// the values with the ",string" option are in JSON strings
+		if opts.quoted && isQuotable(f.Type) && !isNull(item) {
+			var s string
+			if err := json.Unmarshal(item, &s); err != nil {
+				return err
+			}
+			item = json.RawMessage(s)
+		}
+
+		if err := unmarshalValue(item, fv, childSpecs(specs, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// childSpecs returns the specs under the key with their paths relative to
+// the key.
+func childSpecs(specs []CallbackSpec, key string) []CallbackSpec {
+	var children []CallbackSpec
+
+	for _, spec := range specs {
+		if len(spec.Path) == 0 || pathKey(spec.Path[0]) != key {
+			continue
+		}
+
+		children = append(children, CallbackSpec{spec.Path[1:], spec.Function})
+	}
+
+	return children
+}
+
+// pathKey returns the string form of a path component, which may be a string
+// or an integer.
+func pathKey(v interface{}) string {
+	switch k := v.(type) {
+	case string:
+		return k
+	case float64:
+		return strconv.FormatFloat(k, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(k)
+	}
+
+	return ""
+}
+
+// tagOptions are the options in the json tag of a field.
+type tagOptions struct {
+	omitEmpty bool
+	quoted    bool // the "string" option
+}
+
+// jsonField returns the name and the options in the json tag of the field.
+// It returns false if the field is skipped by encoding/json.
+func jsonField(f reflect.StructField) (name string, opts tagOptions, ok bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", opts, false
+	}
+
+	parts := strings.Split(tag, ",")
+	for _, opt := range parts[1:] {
+		switch opt {
+		case "omitempty":
+			opts.omitEmpty = true
+		case "string":
+			opts.quoted = true
+		}
+	}
+
+	return parts[0], opts, true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+
+	return false
+}
+
+func isNull(raw []byte) bool {
+	return strings.TrimSpace(string(raw)) == "null"
+}
+
+// mayContainCache caches the results of mayContain.
+var mayContainCache = struct {
+	sync.RWMutex
+	m map[[2]reflect.Type]map[reflect.Type]bool
+}{m: make(map[[2]reflect.Type]map[reflect.Type]bool)}
+
+// mayContain reports whether a value of type t may contain a value that
+// implements iface. Types implementing stop are not looked into, because
+// encoding/json doesn't look into them either. Interface types may hold any
+// value, so they may contain it when marshaling.
+func mayContain(t, iface, stop reflect.Type) bool {
+	key := [2]reflect.Type{iface, stop}
+
+	mayContainCache.RLock()
+	result, ok := mayContainCache.m[key][t]
+	mayContainCache.RUnlock()
+	if ok {
+		return result
+	}
+
+	result = mayContainType(t, iface, stop, make(map[reflect.Type]bool))
+
+	mayContainCache.Lock()
+	if mayContainCache.m[key] == nil {
+		mayContainCache.m[key] = make(map[reflect.Type]bool)
+	}
+	mayContainCache.m[key][t] = result
+	mayContainCache.Unlock()
+
+	return result
+}
+
+func mayContainType(t, iface, stop reflect.Type, seen map[reflect.Type]bool) bool {
+	if t.Implements(iface) || reflect.PtrTo(t).Implements(iface) {
+		return true
+	}
+
+	if t.Implements(stop) || reflect.PtrTo(t).Implements(stop) {
+		return false
+	}
+
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Interface:
+		// only the marshaled values are known at runtime
+		return iface == marshalerType
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return mayContainType(t.Elem(), iface, stop, seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if mayContainType(t.Field(i).Type, iface, stop, seen) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,189 @@
+package dnode
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Blob is sent as a base64 string.
+type Blob []byte
+
+func (b Blob) MarshalDnode() (interface{}, error) {
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func (b *Blob) UnmarshalDnode(p *Partial) error {
+	var s string
+	if err := p.Unmarshal(&s); err != nil {
+		return err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+
+	*b = data
+	return nil
+}
+
+type File struct {
+	Name    string `json:"name"`
+	Content Blob   `json:"content"`
+}
+
+func TestMarshalArgs(t *testing.T) {
+	args := []interface{}{"foo", File{Name: "a.txt", Content: Blob("hello")}}
+
+	marshaled, err := MarshalArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(marshaled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `["foo",{"content":"aGVsbG8=","name":"a.txt"}]`
+	if string(data) != want {
+		t.Errorf("got %s, want: %s", data, want)
+	}
+
+	// arguments without any Marshaler are not copied
+	plain := []interface{}{"foo", 1}
+	if marshaled, _ := MarshalArgs(plain); &marshaled[0] != &plain[0] {
+		t.Error("plain arguments are copied")
+	}
+}
+
+func TestUnmarshaler(t *testing.T) {
+	p := &Partial{Raw: []byte(`[{"name":"a.txt","content":"aGVsbG8="}]`)}
+
+	var files []File
+	if err := p.Unmarshal(&files); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 || files[0].Name != "a.txt" || string(files[0].Content) != "hello" {
+		t.Errorf("got %+v", files)
+	}
+}
+
+// Upper is sent in upper case, in both dnode and JSON.
+type Upper string
+
+func (u Upper) MarshalDnode() (interface{}, error) {
+	return strings.ToUpper(string(u)), nil
+}
+
+func (u Upper) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strings.ToUpper(string(u)))
+}
+
+func (u *Upper) UnmarshalDnode(p *Partial) error {
+	var s string
+	if err := p.Unmarshal(&s); err != nil {
+		return err
+	}
+
+	*u = Upper(strings.ToLower(s))
+	return nil
+}
+
+type Embedded struct {
+	Shadowed string `json:"name"`
+	Level    Upper  `json:"level"`
+}
+
+type Record struct {
+	Embedded
+	Name     string            `json:"name"`
+	Count    int               `json:"count,string"`
+	Ratio    *float64          `json:"ratio,string"`
+	Flag     bool              `json:"flag,string,omitempty"`
+	Skipped  Upper             `json:"-"`
+	ByID     map[int]Upper     `json:"byId"`
+	ByUint   map[uint8]Upper   `json:"byUint"`
+	Any      interface{}       `json:"any"`
+	Plain    map[string]string `json:"plain"`
+	Optional *Upper            `json:"optional,omitempty"`
+}
+
+type Holder struct {
+	Any interface{} `json:"any"`
+}
+
+func TestMarshalArgsJSON(t *testing.T) {
+	ratio := 0.5
+	args := []interface{}{
+		Record{
+			Embedded: Embedded{Shadowed: "hidden", Level: "debug"},
+			Name:     "record",
+			Count:    42,
+			Ratio:    &ratio,
+			Flag:     true,
+			Skipped:  "skipped",
+			ByID:     map[int]Upper{1: "one", -2: "two"},
+			ByUint:   map[uint8]Upper{3: "three"},
+			Any:      []interface{}{Upper("nested"), map[string]interface{}{"a": Upper("b")}},
+			Plain:    map[string]string{"k": "v"},
+		},
+		Record{Name: "empty", Count: 1},
+		Holder{Any: map[string]interface{}{"a": 1}},
+		map[int]interface{}{1: Upper("key")},
+		[]interface{}{1, "two", nil},
+	}
+
+	marshaled, err := MarshalArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := json.Marshal(marshaled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// MarshalJSON of Upper gives the same result as MarshalDnode
+	want, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// compare the decoded values, structs are converted to maps
+	var gotValue, wantValue interface{}
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal(want, &wantValue); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	// interfaces holding no Marshalers are not converted
+	if _, ok := marshaled[2].(Holder); !ok {
+		t.Errorf("got %T, want: Holder", marshaled[2])
+	}
+}
+
+func TestUnmarshalerMapKeys(t *testing.T) {
+	p := &Partial{Raw: []byte(`{"count":"42","byId":{"1":"ONE","-2":"TWO"}}`)}
+
+	var r Record
+	if err := p.Unmarshal(&r); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[int]Upper{1: "one", -2: "two"}
+	if r.Count != 42 || !reflect.DeepEqual(r.ByID, want) {
+		t.Errorf("got %+v", r)
+	}
+}
@@ -3,7 +3,18 @@
 // https://github.com/substack/dnode-protocol/blob/master/doc/protocol.markdown
 package dnode
 
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
 // Message is the JSON object to call a method at the other side.
+//
+// Messages are always encoded as JSON. Partial keeps the raw JSON of the
+// arguments until they are unmarshaled by the handler, and SockJS only carries
+// text frames, so a binary encoding like MessagePack can't be negotiated
+// without replacing both.
 type Message struct {
 	// Method can be an integer or string.
 	Method interface{} `json:"method"`
@@ -14,3 +25,76 @@ type Message struct {
 	// Integer map of callback paths in arguments
 	Callbacks map[string]Path `json:"callbacks"`
 }
+
+// bufferPool keeps the buffers used for encoding messages.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// maxPooledBuffer is the capacity of the largest buffer that is put back to
+// the pool, so a few huge messages don't keep memory forever.
+const maxPooledBuffer = 64 << 10
+
+// MarshalMessage returns the JSON encoding of a message with the method,
+// arguments and callbacks. It encodes into a pooled buffer and allocates
+// only the returned slice.
+func MarshalMessage(method interface{}, arguments []interface{}, callbacks map[string]Path) ([]byte, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			bufferPool.Put(buf)
+		}
+	}()
+
+	if err := WriteMessage(buf, method, arguments, callbacks); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, buf.Len())
+	copy(data, buf.Bytes())
+	return data, nil
+}
+
+// WriteMessage encodes a message with the method, arguments and callbacks
+// into buf. The output is the same as marshaling a Message, but the
+// arguments are encoded in place instead of being marshaled into a Partial
+// first.
+func WriteMessage(buf *bytes.Buffer, method interface{}, arguments []interface{}, callbacks map[string]Path) error {
+	enc := json.NewEncoder(buf)
+
+	buf.WriteString(`{"method":`)
+	if err := encode(enc, buf, method); err != nil {
+		return err
+	}
+
+	// Do not encode empty arguments as "null", make it "[]".
+	if arguments == nil {
+		arguments = []interface{}{}
+	}
+
+	buf.WriteString(`,"arguments":`)
+	if err := encode(enc, buf, arguments); err != nil {
+		return err
+	}
+
+	buf.WriteString(`,"callbacks":`)
+	if err := encode(enc, buf, callbacks); err != nil {
+		return err
+	}
+
+	buf.WriteByte('}')
+	return nil
+}
+
+// encode encodes v into buf with enc, without the newline that is appended
+// by the Encoder.
+func encode(enc *json.Encoder, buf *bytes.Buffer, v interface{}) error {
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
@@ -0,0 +1,87 @@
+package dnode
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestWriteMessage(t *testing.T) {
+	cases := []struct {
+		method    interface{}
+		arguments []interface{}
+		callbacks map[string]Path
+	}{
+		{"foo", nil, nil},
+		{"foo", []interface{}{"<bar>", 1, map[string]interface{}{"a": true}}, nil},
+		{float64(3), []interface{}{"[Function]"}, map[string]Path{"0": {0}}},
+	}
+
+	for i, c := range cases {
+		var buf bytes.Buffer
+		if err := WriteMessage(&buf, c.method, c.arguments, c.callbacks); err != nil {
+			t.Fatal(err)
+		}
+
+		arguments := c.arguments
+		if arguments == nil {
+			arguments = []interface{}{}
+		}
+
+		raw, err := json.Marshal(arguments)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, err := json.Marshal(Message{
+			Method:    c.method,
+			Arguments: &Partial{Raw: raw},
+			Callbacks: c.callbacks,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("test case %d: got %s, want: %s", i, buf.Bytes(), want)
+		}
+	}
+}
+
+var benchArguments = []interface{}{
+	map[string]interface{}{
+		"kite":           map[string]interface{}{"username": "testuser", "name": "mathworker"},
+		"authentication": map[string]interface{}{"type": "token", "key": "eyJhbGciOiJSUzI1NiIsInR5cCI6IkpXVCJ9"},
+		"withArgs":       []interface{}{"hello", 42, true},
+	},
+}
+
+// BenchmarkMarshalMessage measures the single pass encoding with the pooled
+// buffer.
+func BenchmarkMarshalMessage(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := MarshalMessage("square", benchArguments, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkMarshalMessagePartial measures marshaling the arguments into a
+// Partial and then the message, for comparison.
+func BenchmarkMarshalMessagePartial(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		raw, err := json.Marshal(benchArguments)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		msg := Message{Method: "square", Arguments: &Partial{Raw: raw}}
+		if _, err := json.Marshal(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
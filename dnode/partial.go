@@ -36,12 +36,19 @@ func (p *Partial) Unmarshal(v interface{}) error {
 		return fmt.Errorf("Cannot unmarshal nil argument")
 	}
 
-	if err := json.Unmarshal(p.Raw, &v); err != nil {
+	value := reflect.ValueOf(v)
+
+	// Types implementing Unmarshaler are decoded by themselves, together
+	// with the callbacks in them.
+	if value.Kind() == reflect.Ptr && !value.IsNil() &&
+		mayContain(value.Type(), unmarshalerType, jsonUnmarshalerType) {
+		if err := unmarshalValue(p.Raw, value.Elem(), p.CallbackSpecs); err != nil {
+			return fmt.Errorf("%s. Data: %s", err.Error(), string(p.Raw))
+		}
+	} else if err := json.Unmarshal(p.Raw, &v); err != nil {
 		return fmt.Errorf("%s. Data: %s", err.Error(), string(p.Raw))
 	}
 
-	value := reflect.ValueOf(v)
-
 	for _, spec := range p.CallbackSpecs {
 		if err := setCallback(value, spec.Path, spec.Function.Caller.(functionReceived)); err != nil {
 			return err
@@ -13,6 +13,29 @@ func (s *Scrubber) Scrub(obj interface{}) (callbacks map[string]Path) {
 	return callbacks
 }
 
+// ScrubLimit is like Scrub but it returns ErrTooManyCallbacks and registers
+// none of the callbacks in obj if the number of kept callbacks would exceed
+// MaxCallbacks.
+func (s *Scrubber) ScrubLimit(obj interface{}) (callbacks map[string]Path, err error) {
+	callbacks = s.Scrub(obj)
+
+	s.Lock()
+	max := s.MaxCallbacks
+	s.Unlock()
+
+	if max <= 0 || len(callbacks) == 0 || s.Len() <= max {
+		return callbacks, nil
+	}
+
+	for sid := range callbacks {
+		if id, err := strconv.ParseUint(sid, 10, 64); err == nil {
+			s.RemoveCallback(id)
+		}
+	}
+
+	return nil, ErrTooManyCallbacks
+}
+
 // collectCallbacks walks over the rawObj and populates callbackMap
 // with callbacks. This is a recursive function. The top level send must
 // sends arguments as rawObj, an empty path and empty callbackMap parameter.
@@ -79,6 +102,13 @@ func (s *Scrubber) collectFields(v reflect.Value, path Path, callbackMap map[str
 	for i := 0; i < v.NumField(); i++ {
 		f := v.Type().Field(i)
 
+		// The exported fields of the embedded unexported structs are
+		// promoted, so they are collected like encoding/json does.
+		if f.PkgPath != "" && f.Anonymous && f.Type.Kind() == reflect.Struct {
+			s.collectFields(v.Field(i), path, callbackMap)
+			continue
+		}
+
 		if f.PkgPath != "" { // unexported
 			continue
 		}
@@ -126,6 +156,7 @@ func (s *Scrubber) registerCallback(val reflect.Value, path Path, callbackMap ma
 	}
 
 	var cb func(*Partial) // We are going to save this in scubber
+	var persistent bool   // not expired by TTL
 
 	// Save in client callbacks so we can call it when we receive a call.
 	switch f := val.Interface().(type) {
@@ -133,7 +164,11 @@ func (s *Scrubber) registerCallback(val reflect.Value, path Path, callbackMap ma
 		if f.Caller == nil {
 			return
 		}
-		cb = f.Caller.(callback)
+		if p, ok := f.Caller.(persistentCallback); ok {
+			cb, persistent = p, true
+		} else {
+			cb = f.Caller.(callback)
+		}
 	case func(*Partial):
 		cb = f
 	default:
@@ -149,9 +184,7 @@ func (s *Scrubber) registerCallback(val reflect.Value, path Path, callbackMap ma
 	seq := strconv.FormatUint(next, 10)
 
 	// Save in scubber callbacks
-	s.Lock()
-	s.callbacks[next] = cb
-	s.Unlock()
+	s.addCallback(next, cb, persistent)
 
 	// Add to callback map to be sent to remote.
 	// Make a copy of path because it is reused in caller.
@@ -33,6 +33,10 @@ func TestScrub(t *testing.T) {
 			"3": {"E", "f3"},
 			"4": {"f1"},
 		}},
+		{U{embedded{cb}, cb}, map[string]Path{
+			"0": {"c"},
+			"1": {"d"},
+		}},
 	}
 
 	for i, c := range cases {
@@ -61,3 +65,13 @@ func (t T) F1(p *Partial)  {}
 func (t T) f2(p *Partial)  {}
 func (t *T) F3(p *Partial) {}
 func (t *T) f4(p *Partial) {}
+
+// U embeds an unexported struct, whose exported fields are promoted.
+type U struct {
+	embedded
+	D Function `json:"d"`
+}
+
+type embedded struct {
+	C Function `json:"c"`
+}
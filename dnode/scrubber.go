@@ -1,21 +1,55 @@
 package dnode
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 type Scrubber struct {
 	// Reference to sent callbacks are saved in this map.
-	callbacks  map[uint64]func(*Partial)
+	callbacks  map[uint64]*callbackEntry
 	sync.Mutex // protects
 
 	// Next callback number.
 	// Incremented atomically by registerCallback().
 	seq uint64
+
+	// TTL is the duration a callback is kept after it is registered or
+	// last called. Expired callbacks are removed by Sweep. Zero value means
+	// callbacks are kept until they are removed with RemoveCallback. It
+	// doesn't apply to the callbacks created with PersistentCallback.
+	TTL time.Duration
+
+	// MaxCallbacks is the maximum number of callbacks that are kept at the
+	// same time. ScrubLimit fails if it's exceeded. Zero means no limit.
+	MaxCallbacks int
+
+	// MaxReceivedCallbacks is the maximum number of callbacks in a message
+	// received from the remote side. Zero means no limit.
+	MaxReceivedCallbacks int
+
+	// MaxPathDepth is the maximum length of the callback paths in a message
+	// received from the remote side. Zero means no limit.
+	MaxPathDepth int
+}
+
+// Default limits of the new Scrubbers for the received messages.
+var (
+	DefaultMaxReceivedCallbacks = 1024
+	DefaultMaxPathDepth         = 64
+)
+
+type callbackEntry struct {
+	fn      func(*Partial)
+	expires time.Time // zero value means never
 }
 
 // New returns a pointer to a new Scrubber.
 func NewScrubber() *Scrubber {
 	return &Scrubber{
-		callbacks: make(map[uint64]func(*Partial)),
+		callbacks:            make(map[uint64]*callbackEntry),
+		MaxReceivedCallbacks: DefaultMaxReceivedCallbacks,
+		MaxPathDepth:         DefaultMaxPathDepth,
 	}
 }
 
@@ -27,9 +61,84 @@ func (s *Scrubber) RemoveCallback(id uint64) {
 	s.Unlock()
 }
 
+// GetCallback returns the callback with id. It returns nil if there is no
+// such callback or it has expired. Getting a callback extends its lifetime
+// by TTL.
 func (s *Scrubber) GetCallback(id uint64) func(*Partial) {
 	s.Lock()
-	fn := s.callbacks[id]
+	defer s.Unlock()
+
+	e, ok := s.callbacks[id]
+	if !ok {
+		return nil
+	}
+
+	if !e.expires.IsZero() {
+		now := time.Now()
+		if now.After(e.expires) {
+			delete(s.callbacks, id)
+			return nil
+		}
+
+		if s.TTL > 0 {
+			e.expires = now.Add(s.TTL)
+		}
+	}
+
+	return e.fn
+}
+
+// Len returns the number of callbacks that are kept in the Scrubber.
+func (s *Scrubber) Len() int {
+	s.Lock()
+	n := len(s.callbacks)
+	s.Unlock()
+	return n
+}
+
+// Sweep removes the expired callbacks and returns the number of removed
+// callbacks.
+func (s *Scrubber) Sweep() int {
+	now := time.Now()
+
+	s.Lock()
+	defer s.Unlock()
+
+	n := 0
+	for id, e := range s.callbacks {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(s.callbacks, id)
+			n++
+		}
+	}
+
+	return n
+}
+
+// RunSweeper calls Sweep every interval until stop is closed.
+func (s *Scrubber) RunSweeper(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Sweep()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// addCallback saves the callback with id, setting its expiry from TTL unless
+// it's persistent.
+func (s *Scrubber) addCallback(id uint64, fn func(*Partial), persistent bool) {
+	e := &callbackEntry{fn: fn}
+
+	s.Lock()
+	if s.TTL > 0 && !persistent {
+		e.expires = time.Now().Add(s.TTL)
+	}
+	s.callbacks[id] = e
 	s.Unlock()
-	return fn
 }
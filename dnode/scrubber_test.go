@@ -1,6 +1,9 @@
 package dnode
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestScrubUnscrub(t *testing.T) {
 	scrubber := NewScrubber()
@@ -48,3 +51,106 @@ func TestScrubUnscrub(t *testing.T) {
 		t.Error("callback is not called")
 	}
 }
+
+func TestScrubberTTL(t *testing.T) {
+	scrubber := NewScrubber()
+	scrubber.TTL = 50 * time.Millisecond
+
+	type Args struct {
+		A Function
+		B Function
+	}
+
+	scrubber.Scrub(Args{
+		A: Callback(func(*Partial) {}),
+		B: Callback(func(*Partial) {}),
+	})
+
+	if n := scrubber.Len(); n != 2 {
+		t.Fatalf("got %d callbacks, want: 2", n)
+	}
+
+	if n := scrubber.Sweep(); n != 0 {
+		t.Fatalf("swept %d callbacks before they expire", n)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	// Using a callback extends its lifetime.
+	if scrubber.GetCallback(0) == nil {
+		t.Fatal("callback is not found")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if n := scrubber.Sweep(); n != 1 {
+		t.Fatalf("swept %d callbacks, want: 1", n)
+	}
+
+	if scrubber.GetCallback(0) == nil {
+		t.Fatal("used callback is swept")
+	}
+
+	if n := scrubber.Len(); n != 1 {
+		t.Fatalf("got %d callbacks, want: 1", n)
+	}
+}
+
+func TestScrubberPersistentCallback(t *testing.T) {
+	scrubber := NewScrubber()
+	scrubber.TTL = 10 * time.Millisecond
+
+	callbacks := scrubber.Scrub([]interface{}{PersistentCallback(func(*Partial) {})})
+	if len(callbacks) != 1 {
+		t.Fatalf("got %d callbacks, want: 1", len(callbacks))
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if n := scrubber.Sweep(); n != 0 {
+		t.Errorf("swept %d persistent callbacks", n)
+	}
+
+	if scrubber.GetCallback(0) == nil {
+		t.Error("persistent callback is expired")
+	}
+}
+
+func TestScrubberLimits(t *testing.T) {
+	scrubber := NewScrubber()
+	scrubber.MaxCallbacks = 2
+
+	cb := Callback(func(*Partial) {})
+
+	if _, err := scrubber.ScrubLimit([]interface{}{cb, cb}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := scrubber.ScrubLimit([]interface{}{cb}); err != ErrTooManyCallbacks {
+		t.Errorf("got error %v, want: %v", err, ErrTooManyCallbacks)
+	}
+
+	if n := scrubber.Len(); n != 2 {
+		t.Errorf("got %d callbacks, want: 2", n)
+	}
+
+	sender := func(id uint64, args []interface{}) error { return nil }
+
+	scrubber.MaxReceivedCallbacks = 1
+	msg := &Message{
+		Arguments: &Partial{Raw: []byte(`[null, null]`)},
+		Callbacks: map[string]Path{"0": {0}, "1": {1}},
+	}
+	if err := scrubber.ParseCallbacks(msg, sender); err != ErrTooManyCallbacks {
+		t.Errorf("got error %v, want: %v", err, ErrTooManyCallbacks)
+	}
+
+	scrubber.MaxPathDepth = 2
+	msg = &Message{
+		Arguments: &Partial{Raw: []byte(`[null]`)},
+		Callbacks: map[string]Path{"0": {0, "a", "b"}},
+	}
+	if err := scrubber.ParseCallbacks(msg, sender); err == nil {
+		t.Error("deep callback path is accepted")
+	}
+}
@@ -31,6 +31,11 @@ func (s *Scrubber) Unscrub(arguments interface{}, callbacks map[string]Path, f f
 func setCallback(value reflect.Value, path Path, cb functionReceived) error {
 	i := 0
 	for {
+		// the callbacks are passed to UnmarshalDnode
+		if isUnmarshaler(value) {
+			return nil
+		}
+
 		switch value.Kind() {
 		case reflect.Slice:
 			if i == len(path) {
@@ -49,7 +54,11 @@ func setCallback(value reflect.Value, path Path, cb functionReceived) error {
 			case float64:
 				index = int(v)
 			default:
-				panic(fmt.Errorf("unknown type: %#v", path[i]))
+				return fmt.Errorf("invalid callback path element: %#v", path[i])
+			}
+
+			if index < 0 || index >= value.Len() {
+				return fmt.Errorf("index out of range in callback path: %d", index)
 			}
 
 			value = value.Index(index)
@@ -58,11 +67,14 @@ func setCallback(value reflect.Value, path Path, cb functionReceived) error {
 			if i == len(path) {
 				return fmt.Errorf("callback path too short: %v", path)
 			}
+			if _, ok := path[i].(string); !ok || value.Type().Key().Kind() != reflect.String {
+				return fmt.Errorf("invalid callback path element: %#v", path[i])
+			}
 			if i == len(path)-1 && value.Type().Elem().Kind() == reflect.Interface {
-				value.SetMapIndex(reflect.ValueOf(path[i]), reflect.ValueOf(cb))
+				value.SetMapIndex(reflect.ValueOf(path[i]).Convert(value.Type().Key()), reflect.ValueOf(cb))
 				return nil
 			}
-			value = value.MapIndex(reflect.ValueOf(path[i]))
+			value = value.MapIndex(reflect.ValueOf(path[i]).Convert(value.Type().Key()))
 			i++
 		case reflect.Ptr:
 			value = value.Elem()
@@ -87,7 +99,7 @@ func setCallback(value reflect.Value, path Path, cb functionReceived) error {
 
 			// Path component may be a string or an integer.
 			name, ok := path[i].(string)
-			if !ok {
+			if !ok || name == "" {
 				return fmt.Errorf("Invalid path: %#v", path[i])
 			}
 
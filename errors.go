@@ -1,7 +1,9 @@
 package kite
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/koding/kite/dnode"
 )
@@ -11,6 +13,17 @@ type Error struct {
 	Type    string `json:"type"`
 	Message string `json:"message"`
 	CodeVal string `json:"code"`
+
+	// Status is the numeric code of the error, like the HTTP status codes.
+	// If it's zero, the code registered for the Type with RegisterError is
+	// sent to the caller.
+	Status int `json:"status,omitempty"`
+
+	// Details contains additional information about the error, like the
+	// invalid fields of a "validationError".
+	Details map[string]interface{} `json:"details,omitempty"`
+
+	err error // wrapped error, set with Wrap
 }
 
 func (e Error) Code() string {
@@ -25,7 +38,160 @@ func (e Error) Error() string {
 	return fmt.Sprintf("%s: %s", e.Type, e.Message)
 }
 
-// createError creates a new kite.Error for the given r variable
+// Is reports whether the error matches target for errors.Is. Errors match if
+// target is a *Error of the same Type. The Status and CodeVal of target are
+// compared too, if they are set. The Message is not compared, so for example
+// errors.Is(err, kite.ErrNotFound("")) tells whether err is a "notFound"
+// error.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+
+	return e.Type == t.Type &&
+		(t.Status == 0 || e.status() == t.status()) &&
+		(t.CodeVal == "" || e.CodeVal == t.CodeVal)
+}
+
+// Unwrap returns the error wrapped with Wrap. The wrapped error is not sent
+// to the caller.
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Wrap sets the underlying error of e, which can be inspected by errors.Is
+// and errors.As on the side that has created e. If the message of e is
+// empty, the message of err is used. It returns e.
+func (e *Error) Wrap(err error) *Error {
+	e.err = err
+	if e.Message == "" && err != nil {
+		e.Message = err.Error()
+	}
+	return e
+}
+
+// WithDetail adds the key and value to the details of e. It returns e.
+func (e *Error) WithDetail(key string, value interface{}) *Error {
+	if e.Details == nil {
+		e.Details = make(map[string]interface{})
+	}
+	e.Details[key] = value
+	return e
+}
+
+// status returns the numeric code of the error, which is Status, or the code
+// registered for its type.
+func (e *Error) status() int {
+	if e.Status != 0 {
+		return e.Status
+	}
+	return errorStatus(e.Type)
+}
+
+var (
+	errorStatusesMu sync.RWMutex
+	errorStatuses   = map[string]int{
+		"argumentError":       400,
+		"validationError":     400,
+		"authenticationError": 401,
+		"authorizationError":  403,
+		"notFound":            404,
+		"methodNotFound":      404,
+		"alreadyExists":       409,
+		"rateLimited":         429,
+		"requestLimitError":   429,
+		"genericError":        500,
+		"unavailable":         503,
+		"shutdown":            503,
+		"circuitOpen":         503,
+		"timeout":             504,
+	}
+)
+
+// RegisterError registers the numeric code of the errors of the given type.
+// Errors of the type that have no Status are sent with this code. Kites
+// should register their own error types at init, so they are reported
+// consistently.
+func RegisterError(errType string, status int) {
+	errorStatusesMu.Lock()
+	errorStatuses[errType] = status
+	errorStatusesMu.Unlock()
+}
+
+// errorStatus returns the code registered for the error type, zero if there
+// is none.
+func errorStatus(errType string) int {
+	errorStatusesMu.RLock()
+	defer errorStatusesMu.RUnlock()
+	return errorStatuses[errType]
+}
+
+// NewError returns a new error with the given type and the formatted message.
+// The Status of the error is the code registered for the type.
+func NewError(errType string, format string, args ...interface{}) *Error {
+	return &Error{
+		Type:    errType,
+		Message: fmt.Sprintf(format, args...),
+		Status:  errorStatus(errType),
+	}
+}
+
+// ErrInvalidArgument returns an "argumentError".
+func ErrInvalidArgument(format string, args ...interface{}) *Error {
+	return NewError("argumentError", format, args...)
+}
+
+// ErrUnauthenticated returns an "authenticationError".
+func ErrUnauthenticated(format string, args ...interface{}) *Error {
+	return NewError("authenticationError", format, args...)
+}
+
+// ErrPermissionDenied returns an "authorizationError".
+func ErrPermissionDenied(format string, args ...interface{}) *Error {
+	return NewError("authorizationError", format, args...)
+}
+
+// ErrNotFound returns a "notFound" error.
+func ErrNotFound(format string, args ...interface{}) *Error {
+	return NewError("notFound", format, args...)
+}
+
+// ErrAlreadyExists returns an "alreadyExists" error.
+func ErrAlreadyExists(format string, args ...interface{}) *Error {
+	return NewError("alreadyExists", format, args...)
+}
+
+// ErrUnavailable returns an "unavailable" error.
+func ErrUnavailable(format string, args ...interface{}) *Error {
+	return NewError("unavailable", format, args...)
+}
+
+// ErrInternal returns a "genericError".
+func ErrInternal(format string, args ...interface{}) *Error {
+	return NewError("genericError", format, args...)
+}
+
+// IsDeadPeer returns true if the error of a call means the remote kite is not
+// reachable. Other errors mean the remote kite is alive but the call failed.
+// A full send queue means the calls are sent slower than they are made, not
+// that the remote kite is dead.
+func IsDeadPeer(err error) bool {
+	kiteErr, ok := err.(*Error)
+	if !ok {
+		return false
+	}
+
+	switch kiteErr.Type {
+	case "timeout", "sendError", "disconnect":
+		return true
+	default:
+		return false
+	}
+}
+
+// createError creates a new kite.Error for the given r variable. Errors that
+// wrap a *Error are sent as the wrapped one.
 func createError(r interface{}) *Error {
 	if r == nil {
 		return nil
@@ -40,6 +206,13 @@ func createError(r interface{}) *Error {
 			Type:    "argumentError",
 			Message: err.Error(),
 		}
+	case error:
+		if !errors.As(err, &kiteErr) {
+			kiteErr = &Error{
+				Type:    "genericError",
+				Message: err.Error(),
+			}
+		}
 	default:
 		kiteErr = &Error{
 			Type:    "genericError",
@@ -47,5 +220,13 @@ func createError(r interface{}) *Error {
 		}
 	}
 
+	if kiteErr != nil && kiteErr.Status == 0 {
+		if status := errorStatus(kiteErr.Type); status != 0 {
+			e := *kiteErr
+			e.Status = status
+			kiteErr = &e
+		}
+	}
+
 	return kiteErr
 }
@@ -0,0 +1,57 @@
+package kite
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestError_Is(t *testing.T) {
+	err := ErrNotFound("user %q", "alice").Wrap(io.EOF)
+
+	if err.Status != 404 || err.Message != `user "alice"` {
+		t.Errorf("wrong error: %+v", err)
+	}
+
+	wrapped := fmt.Errorf("get user: %w", err)
+	if !errors.Is(wrapped, ErrNotFound("")) {
+		t.Error("error should match notFound")
+	}
+
+	if errors.Is(wrapped, ErrAlreadyExists("")) {
+		t.Error("error shouldn't match alreadyExists")
+	}
+
+	if !errors.Is(wrapped, io.EOF) {
+		t.Error("wrapped error should match")
+	}
+
+	var kiteErr *Error
+	if !errors.As(wrapped, &kiteErr) || kiteErr != err {
+		t.Error("errors.As should find the error")
+	}
+}
+
+func TestCreateError(t *testing.T) {
+	RegisterError("quotaExceeded", 429)
+
+	tests := []struct {
+		err    error
+		typ    string
+		status int
+	}{
+		{errors.New("oops"), "genericError", 500},
+		{fmt.Errorf("wrapped: %w", ErrPermissionDenied("no")), "authorizationError", 403},
+		{&Error{Type: "quotaExceeded"}, "quotaExceeded", 429},
+		{&Error{Type: "notFound", Status: 410}, "notFound", 410},
+		{&Error{Type: "unknown"}, "unknown", 0},
+	}
+
+	for _, test := range tests {
+		kiteErr := createError(test.err)
+		if kiteErr.Type != test.typ || kiteErr.Status != test.status {
+			t.Errorf("%v: got %s/%d, want: %s/%d", test.err, kiteErr.Type, kiteErr.Status, test.typ, test.status)
+		}
+	}
+}
@@ -20,17 +20,12 @@ func main() {
 	k := kite.New("exp2", "1.0.0")
 	k.Config = config.MustGet()
 
-	onEvent := func(e *kite.Event, err *kite.Error) {
-		fmt.Printf("e %+v\n", e)
-		fmt.Printf("err %+v\n", err)
-	}
-
-	_, err := k.WatchKites(protocol.KontrolQuery{
+	w, err := k.WatchKites(&protocol.KontrolQuery{
 		Username:    k.Config.Username,
 		Environment: k.Config.Environment,
 		Name:        "math",
 		// ID: "48bb002b-79f6-4a4e-6bba-a40567a08b6c",
-	}, onEvent)
+	})
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -38,5 +33,7 @@ func main() {
 	// This is a bad example, it's just for testing the watch functionality :)
 	fmt.Println("listening to events")
 
-	select {}
+	for e := range w.Events() {
+		fmt.Printf("%s %s %s\n", e.Type, e.Kite, e.URL)
+	}
 }
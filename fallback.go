@@ -0,0 +1,112 @@
+package kite
+
+import (
+	"sort"
+	"time"
+
+	"github.com/koding/kite/protocol"
+)
+
+// dialResult is the result of a connection attempt to the URL at index i.
+type dialResult struct {
+	session Transport
+	i       int
+	err     error
+}
+
+// fallbackURLs returns the network URLs of the kite other than its URL,
+// sorted by the network names.
+func fallbackURLs(k *protocol.KiteWithToken) []string {
+	networks := make([]string, 0, len(k.URLs))
+	for network := range k.URLs {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+
+	var urls []string
+	for _, network := range networks {
+		if u := k.URLs[network]; u != k.URL {
+			urls = append(urls, u)
+		}
+	}
+
+	return urls
+}
+
+// dialURLs returns the URLs the client tries to connect in order.
+func (c *Client) dialURLs() []string {
+	return append([]string{c.URL}, c.FallbackURLs...)
+}
+
+// useURL makes the URL at index i of dialURLs the primary URL of the client,
+// so it's tried first on the next dial.
+func (c *Client) useURL(i int) {
+	if i == 0 {
+		return
+	}
+
+	c.URL, c.FallbackURLs[i-1] = c.FallbackURLs[i-1], c.URL
+}
+
+// dialFirst connects to the first URL that can be connected. The next URL is
+// tried when the previous one fails. If delay is positive, it's also tried
+// when the previous one is not connected in delay, without canceling the
+// pending attempts, like the "happy eyeballs" algorithm. It returns the
+// session and the index of the connected URL, or the error of the first URL
+// if none of them can be connected.
+func dialFirst(urls []string, delay time.Duration, dial func(url string) (Transport, error)) (Transport, int, error) {
+	if len(urls) == 1 {
+		session, err := dial(urls[0])
+		return session, 0, err
+	}
+
+	results := make(chan dialResult, len(urls))
+	start := func(i int) {
+		go func() {
+			session, err := dial(urls[i])
+			results <- dialResult{session: session, i: i, err: err}
+		}()
+	}
+
+	start(0)
+	next, pending := 1, 1
+
+	var firstErr error
+	for pending > 0 {
+		var fallback <-chan time.Time
+		if delay > 0 && next < len(urls) {
+			fallback = time.After(delay)
+		}
+
+		select {
+		case r := <-results:
+			pending--
+
+			if r.err == nil {
+				// close the sessions of the slower attempts
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.err == nil {
+							r.session.Close(3000, "Go away!")
+						}
+					}
+				}(pending)
+
+				return r.session, r.i, nil
+			}
+
+			if firstErr == nil || r.i == 0 {
+				firstErr = r.err
+			}
+		case <-fallback:
+		}
+
+		if next < len(urls) {
+			start(next)
+			next++
+			pending++
+		}
+	}
+
+	return nil, -1, firstErr
+}
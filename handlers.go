@@ -20,11 +20,15 @@ func (k *Kite) addDefaultHandlers() {
 	k.HandleFunc("kite.systemInfo", handleSystemInfo)
 	k.HandleFunc("kite.heartbeat", k.handleHeartbeat)
 	k.HandleFunc("kite.ping", handlePing).DisableAuthentication()
+	k.HandleFunc("kite.health", k.handleHealth)
+	k.HandleFunc("kite.status", k.handleStatus)
+	k.HandleFunc("kite.describe", k.handleDescribe)
 	k.HandleFunc("kite.tunnel", handleTunnel)
 	k.HandleFunc("kite.log", k.handleLog)
 	k.HandleFunc("kite.print", handlePrint)
 	k.HandleFunc("kite.prompt", handlePrompt)
 	k.HandleFunc("kite.getPass", handleGetPass)
+	k.HandleFunc("kite.setBlocklist", k.handleSetBlocklist)
 	if runtime.GOOS == "darwin" {
 		k.HandleFunc("kite.notify", handleNotifyDarwin)
 	}
@@ -60,7 +64,7 @@ loop:
 		case <-done:
 			break loop
 		case <-heartbeat.C:
-			if err := ping.Call(); err != nil {
+			if err := ping.Call(k.Load()); err != nil {
 				k.Log.Error(err.Error())
 			}
 		}
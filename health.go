@@ -0,0 +1,202 @@
+package kite
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HealthCheckTimeout is the time a health check has to return. The checks
+// that don't return in time are reported as unhealthy.
+var HealthCheckTimeout = 5 * time.Second
+
+// HealthCacheTTL is the time the results of the health checks are reused
+// for, so frequent health requests don't run the checks every time.
+var HealthCacheTTL = time.Second
+
+// Health is the result of the "kite.health" method and the /healthz endpoint.
+type Health struct {
+	// Healthy is true if all checks have passed.
+	Healthy bool          `json:"healthy"`
+	Checks  []CheckResult `json:"checks,omitempty"`
+}
+
+// CheckResult is the result of a health check.
+type CheckResult struct {
+	Name     string  `json:"name"`
+	Healthy  bool    `json:"healthy"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration"` // in seconds
+}
+
+// healthChecks are the checks added with AddHealthCheck.
+type healthChecks struct {
+	mu     sync.Mutex // protects the fields below
+	checks map[string]func() error
+	gen    int // incremented when the checks are changed
+
+	// last is the result of the last run of the checks with the generation
+	// gen, it's reused until HealthCacheTTL has passed since lastAt.
+	last    *Health
+	lastGen int
+	lastAt  time.Time
+
+	// runMu serializes the runs, so the concurrent requests wait for the
+	// same run
+	runMu sync.Mutex
+}
+
+// AddHealthCheck adds a check of a dependency of the kite, like a database
+// connection, to its health. The kite is reported unhealthy while the check
+// returns an error. The checks are run concurrently by Health, which is served
+// by the "kite.health" method and the /healthz HTTP endpoint, see
+// HandleHealthz. A check with the same name replaces the previous one.
+func (k *Kite) AddHealthCheck(name string, check func() error) {
+	k.health.mu.Lock()
+	defer k.health.mu.Unlock()
+
+	if k.health.checks == nil {
+		k.health.checks = make(map[string]func() error)
+	}
+	k.health.checks[name] = check
+	k.health.gen++
+}
+
+// RemoveHealthCheck removes the check added with AddHealthCheck.
+func (k *Kite) RemoveHealthCheck(name string) {
+	k.health.mu.Lock()
+	delete(k.health.checks, name)
+	k.health.gen++
+	k.health.mu.Unlock()
+}
+
+// Health runs the health checks and returns their results sorted by name.
+// The results are reused for HealthCacheTTL, unless the checks are changed.
+func (k *Kite) Health() *Health {
+	k.health.runMu.Lock()
+	defer k.health.runMu.Unlock()
+
+	k.health.mu.Lock()
+	gen := k.health.gen
+	if last := k.health.last; last != nil && k.health.lastGen == gen && time.Since(k.health.lastAt) < HealthCacheTTL {
+		k.health.mu.Unlock()
+		return last.copy()
+	}
+
+	checks := make(map[string]func() error, len(k.health.checks))
+	for name, check := range k.health.checks {
+		checks[name] = check
+	}
+	k.health.mu.Unlock()
+
+	h := runHealthChecks(checks)
+
+	k.health.mu.Lock()
+	k.health.last, k.health.lastGen, k.health.lastAt = h, gen, time.Now()
+	k.health.mu.Unlock()
+
+	return h.copy()
+}
+
+// copy returns a copy of h, the cached results are not modified by the
+// callers.
+func (h *Health) copy() *Health {
+	c := *h
+	c.Checks = append([]CheckResult(nil), h.Checks...)
+	return &c
+}
+
+// runHealthChecks runs the checks concurrently and returns their results
+// sorted by name.
+func runHealthChecks(checks map[string]func() error) *Health {
+	results := make(chan CheckResult, len(checks))
+	for name, check := range checks {
+		go func(name string, check func() error) {
+			results <- runHealthCheck(name, check)
+		}(name, check)
+	}
+
+	h := &Health{
+		Healthy: true,
+		Checks:  make([]CheckResult, 0, len(checks)),
+	}
+
+	for range checks {
+		res := <-results
+		h.Healthy = h.Healthy && res.Healthy
+		h.Checks = append(h.Checks, res)
+	}
+
+	sort.Slice(h.Checks, func(i, j int) bool {
+		return h.Checks[i].Name < h.Checks[j].Name
+	})
+
+	return h
+}
+
+// runHealthCheck runs the check with HealthCheckTimeout.
+func runHealthCheck(name string, check func() error) CheckResult {
+	start := time.Now()
+	done := make(chan error, 1)
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+
+		done <- check()
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(HealthCheckTimeout):
+		err = fmt.Errorf("check has not returned in %s", HealthCheckTimeout)
+	}
+
+	res := CheckResult{
+		Name:     name,
+		Healthy:  err == nil,
+		Duration: time.Since(start).Seconds(),
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	return res
+}
+
+// handleHealth returns the health of the kite.
+func (k *Kite) handleHealth(r *Request) (interface{}, error) {
+	return k.Health(), nil
+}
+
+// HandleHealthz serves the health of the kite at "/healthz" over HTTP for the
+// orchestrators. The status code is 200 if the kite is healthy and 503
+// otherwise. The endpoint is not authenticated, so the errors of the checks
+// are not included, they are returned by the "kite.health" method to the
+// authenticated callers. It panics if the pattern is already registered with
+// HandleHTTP.
+func (k *Kite) HandleHealthz() {
+	k.HandleHTTPFunc("/healthz", k.handleHealthz)
+}
+
+// handleHealthz serves the health of the kite over HTTP.
+func (k *Kite) handleHealthz(w http.ResponseWriter, req *http.Request) {
+	h := k.Health()
+	for i := range h.Checks {
+		h.Checks[i].Error = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !h.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(h)
+}
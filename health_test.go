@@ -0,0 +1,70 @@
+package kite
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	k := New("testkite", "0.0.1")
+
+	healthz := func() (int, *Health) {
+		w := httptest.NewRecorder()
+		k.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+
+		var h Health
+		if err := json.NewDecoder(w.Body).Decode(&h); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, &h
+	}
+
+	// the endpoint is opt-in
+	w := httptest.NewRecorder()
+	k.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d without HandleHealthz, want: %d", w.Code, http.StatusNotFound)
+	}
+
+	k.HandleHealthz()
+
+	if code, h := healthz(); code != http.StatusOK || !h.Healthy {
+		t.Errorf("kite without checks is not healthy: %d %+v", code, h)
+	}
+
+	var runs int32
+	k.AddHealthCheck("db", func() error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	})
+	k.AddHealthCheck("cache", func() error { return errors.New("connection refused") })
+
+	code, h := healthz()
+	if code != http.StatusServiceUnavailable || h.Healthy {
+		t.Errorf("kite with a failing check is healthy: %d %+v", code, h)
+	}
+
+	// the errors are not shown to the unauthenticated callers
+	if len(h.Checks) != 2 || h.Checks[0].Name != "cache" || h.Checks[0].Healthy || h.Checks[0].Error != "" ||
+		h.Checks[1].Name != "db" || !h.Checks[1].Healthy {
+		t.Errorf("wrong check results: %+v", h.Checks)
+	}
+
+	if h := k.Health(); h.Checks[0].Error != "connection refused" {
+		t.Errorf("got error %q, want: connection refused", h.Checks[0].Error)
+	}
+
+	// the results are reused
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Errorf("check is run %d times, want: 1", n)
+	}
+
+	k.RemoveHealthCheck("cache")
+	if code, h := healthz(); code != http.StatusOK || !h.Healthy {
+		t.Errorf("kite is not healthy after removing the failing check: %d %+v", code, h)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -102,6 +103,7 @@ func (k *Kite) RegisterHTTP(kiteURL *url.URL) (*registerResult, error) {
 			Type: "kiteKey",
 			Key:  k.Config.KiteKey,
 		},
+		URLs: k.Config.URLs,
 	}
 
 	data, err := json.Marshal(&args)
@@ -138,6 +140,7 @@ func (k *Kite) RegisterHTTP(kiteURL *url.URL) (*registerResult, error) {
 	k.Log.Info("Registered (via HTTP) with URL: '%s' and HeartBeat interval: '%s'",
 		rr.URL, heartbeat)
 
+	k.kontrol.setRegistered(true)
 	go k.sendHeartbeats(heartbeat, kiteURL)
 
 	return &registerResult{parsed}, nil
@@ -157,14 +160,27 @@ func (k *Kite) sendHeartbeats(interval time.Duration, kiteURL *url.URL) {
 
 	q := u.Query()
 	q.Set("id", k.Id)
-	u.RawQuery = q.Encode()
 
 	errRegisterAgain := errors.New("register again")
 
 	heartbeatFunc := func() error {
+		// the load of the kite is reported with the heartbeats
+		load := k.Load()
+		q.Set("inflight", strconv.Itoa(load.InFlight))
+		q.Set("cpu", strconv.FormatFloat(load.CPU, 'f', -1, 64))
+		u.RawQuery = q.Encode()
+
 		k.Log.Debug("Sending heartbeat to %s", u.String())
 
-		resp, err := defaultClient.Get(u.String())
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return err
+		}
+
+		// kontrol accepts the load only from the kite itself
+		req.Header.Set("Authorization", "Bearer "+k.Config.KiteKey)
+
+		resp, err := defaultClient.Do(req)
 		if err != nil {
 			return err
 		}
@@ -196,6 +212,11 @@ func (k *Kite) sendHeartbeats(interval time.Duration, kiteURL *url.URL) {
 	}
 
 	for _ = range tick.C {
+		if !k.kontrol.isRegistered() {
+			tick.Stop()
+			return // deregistered
+		}
+
 		err := heartbeatFunc()
 		if err == errRegisterAgain {
 			return // return so we don't run forever
@@ -0,0 +1,176 @@
+// Package jwtkeys converts the PEM encoded keys of kontrol to the forms used
+// by jwt-go for signing and verifying the tokens. The keys can be RSA, ECDSA
+// or Ed25519 keys, the tokens are signed with RS256, ES256 (ES384 and ES512
+// for the P-384 and P-521 curves) and EdDSA respectively. The package
+// registers the EdDSA signing method with jwt-go.
+package jwtkeys
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodEdDSA signs the tokens with Ed25519 keys, as "EdDSA" in the
+// "alg" header defined in RFC 8037.
+var SigningMethodEdDSA jwt.SigningMethod = signingMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+type signingMethodEdDSA struct{}
+
+func (signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	k, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKey
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(k, []byte(signingString))), nil
+}
+
+func (signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	k, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKey
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(k, []byte(signingString), sig) {
+		return errors.New("EdDSA verification failed")
+	}
+
+	return nil
+}
+
+// SigningKey returns the signing method for the PEM encoded private key and
+// the key to pass to Token.SignedString.
+func SigningKey(privateKey string) (jwt.SigningMethod, interface{}, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return nil, nil, errors.New("private key is not PEM encoded")
+	}
+
+	var key interface{}
+	var err error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, nil, fmt.Errorf("unsupported private key type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		// jwt-go parses the PEM encoded RSA keys itself
+		return jwt.SigningMethodRS256, []byte(privateKey), nil
+	case *ecdsa.PrivateKey:
+		method, err := ecdsaMethod(&k.PublicKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		return method, k, nil
+	case ed25519.PrivateKey:
+		return SigningMethodEdDSA, k, nil
+	}
+
+	return nil, nil, fmt.Errorf("unsupported private key: %T", key)
+}
+
+// SigningKeyMethod is like SigningKey, but the tokens are signed with the
+// method named alg, like "RS512", instead of the default method of the key.
+// The RSA keys can be used with RS256, RS384 and RS512, the ECDSA and Ed25519
+// keys only with their default methods. It returns an error if the key can't
+// be used with the method. Empty alg selects the default method.
+func SigningKeyMethod(privateKey, alg string) (jwt.SigningMethod, interface{}, error) {
+	method, key, err := SigningKey(privateKey)
+	if err != nil || alg == "" || alg == method.Alg() {
+		return method, key, err
+	}
+
+	m := jwt.GetSigningMethod(alg)
+	if m == nil {
+		return nil, nil, fmt.Errorf("unknown signing method: %s", alg)
+	}
+
+	if _, ok := method.(*jwt.SigningMethodRSA); ok {
+		if _, ok := m.(*jwt.SigningMethodRSA); ok {
+			return m, key, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("signing method %s cannot be used with the key, use %s", alg, method.Alg())
+}
+
+// VerificationKey returns the key to verify the token signed with the method
+// with the PEM encoded public key. It returns an error if the key can't
+// verify the method, so the tokens signed with other algorithms, like HS256
+// with the public key as the secret, are not accepted.
+func VerificationKey(method jwt.SigningMethod, publicKey string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil {
+		return nil, errors.New("public key is not PEM encoded")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if _, ok := method.(*jwt.SigningMethodRSA); ok {
+			// jwt-go parses the PEM encoded RSA keys itself
+			return []byte(publicKey), nil
+		}
+	case *ecdsa.PublicKey:
+		if m, err := ecdsaMethod(k); err == nil && m == method {
+			return k, nil
+		}
+	case ed25519.PublicKey:
+		if method == SigningMethodEdDSA {
+			return k, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected signing method: %s", method.Alg())
+}
+
+// ecdsaMethod returns the signing method for the curve of the key.
+func ecdsaMethod(key *ecdsa.PublicKey) (jwt.SigningMethod, error) {
+	switch key.Curve.Params().BitSize {
+	case 256:
+		return jwt.SigningMethodES256, nil
+	case 384:
+		return jwt.SigningMethodES384, nil
+	case 521:
+		return jwt.SigningMethodES512, nil
+	}
+
+	return nil, fmt.Errorf("unsupported curve: %s", key.Curve.Params().Name)
+}
@@ -0,0 +1,134 @@
+package jwtkeys
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/koding/kite/testkeys"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		private, public string
+		alg             string
+	}{
+		{testkeys.Private, testkeys.Public, "RS256"},
+		{encodePrivate(t, ecKey), encodePublic(t, &ecKey.PublicKey), "ES256"},
+		{encodePrivate(t, edKey), encodePublic(t, edKey.Public()), "EdDSA"},
+	}
+
+	for _, test := range tests {
+		method, key, err := SigningKey(test.private)
+		if err != nil {
+			t.Fatalf("%s: %s", test.alg, err)
+		}
+
+		if method.Alg() != test.alg {
+			t.Errorf("got signing method %s, want: %s", method.Alg(), test.alg)
+		}
+
+		token := jwt.New(method)
+		token.Claims["sub"] = "testuser"
+
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("%s: %s", test.alg, err)
+		}
+
+		parsed, err := jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+			return VerificationKey(token.Method, test.public)
+		})
+		if err != nil || !parsed.Valid {
+			t.Errorf("%s: cannot verify token: %v", test.alg, err)
+		}
+	}
+
+	// a key can't verify the tokens of other algorithms
+	if _, err := VerificationKey(jwt.SigningMethodHS256, testkeys.Public); err == nil {
+		t.Error("RSA key is accepted for HS256")
+	}
+}
+
+func TestSigningKeyMethod(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		private, alg string
+		valid        bool
+	}{
+		{testkeys.Private, "", true},
+		{testkeys.Private, "RS512", true},
+		{testkeys.Private, "ES256", false},
+		{testkeys.Private, "unknown", false},
+		{encodePrivate(t, ecKey), "ES256", true},
+		{encodePrivate(t, ecKey), "ES384", false},
+		{encodePrivate(t, ecKey), "RS256", false},
+		{encodePrivate(t, edKey), "EdDSA", true},
+		{encodePrivate(t, edKey), "ES256", false},
+	}
+
+	for _, test := range tests {
+		method, key, err := SigningKeyMethod(test.private, test.alg)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: method %s is accepted for the key", test.alg, method.Alg())
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %s", test.alg, err)
+		}
+
+		if test.alg != "" && method.Alg() != test.alg {
+			t.Errorf("got signing method %s, want: %s", method.Alg(), test.alg)
+		}
+
+		token := jwt.New(method)
+		if _, err := token.SignedString(key); err != nil {
+			t.Errorf("%s: %s", test.alg, err)
+		}
+	}
+}
+
+func encodePrivate(t *testing.T, key interface{}) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func encodePublic(t *testing.T, key interface{}) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
@@ -0,0 +1,71 @@
+package kite
+
+import "time"
+
+// SetKeepAlive enables sending a ping to the remote kite every interval. If
+// the remote kite does not answer the ping within timeout, the connection is
+// assumed to be dead and it's closed, which triggers a reconnect if
+// Client.Reconnect is true. A zero interval disables the keepalive.
+func (c *Client) SetKeepAlive(interval, timeout time.Duration) {
+	c.keepAliveMu.Lock()
+	c.keepAliveInterval = interval
+	c.keepAliveTimeout = timeout
+	c.keepAliveMu.Unlock()
+
+	c.keepAliveOnce.Do(func() {
+		c.OnConnect(func() { go c.keepAlive() })
+
+		// already connected, OnConnect handlers will not be called until the
+		// next connection.
+		if c.session != nil {
+			go c.keepAlive()
+		}
+	})
+}
+
+func (c *Client) keepAliveSettings() (interval, timeout time.Duration) {
+	c.keepAliveMu.Lock()
+	defer c.keepAliveMu.Unlock()
+	return c.keepAliveInterval, c.keepAliveTimeout
+}
+
+// keepAlive pings the remote kite until the current session is closed or
+// replaced with a new one after a reconnect.
+func (c *Client) keepAlive() {
+	session := c.session
+	if session == nil {
+		return
+	}
+
+	for {
+		interval, timeout := c.keepAliveSettings()
+		if interval <= 0 {
+			return
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-c.closeChan:
+			return
+		}
+
+		if c.session != session {
+			return // reconnected, a new loop is started for the new session
+		}
+
+		// Only a ping that is not answered means the connection is dead.
+		// The others are not sent, like when the send queue is full.
+		_, err := c.TellWithTimeout("kite.ping", timeout)
+		if kiteErr, ok := err.(*Error); !ok || kiteErr.Type != "timeout" {
+			continue
+		}
+
+		if c.session != session {
+			return
+		}
+
+		c.LocalKite.Log.Warning("No keepalive response from kite %q: %s, closing connection", c.Kite.Name, err)
+		session.Close(3000, "keepalive timeout")
+		return
+	}
+}
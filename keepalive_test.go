@@ -0,0 +1,61 @@
+package kite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/koding/kite/sockjsclient"
+)
+
+func TestKeepAlive(t *testing.T) {
+	k := New("keepalive", "0.0.1")
+	k.Config.DisableAuthentication = true
+
+	dial := func(dead bool) *Client {
+		c := New("exp", "0.0.1").NewClient("http://keepalive/kite")
+		c.Dialer = func(*sockjsclient.DialOptions) (Transport, error) {
+			if dead {
+				return &blackholeTransport{closed: make(chan struct{})}, nil
+			}
+
+			client, server := newPipe()
+			go k.ServeTransport(server)
+			return client, nil
+		}
+
+		c.SetKeepAlive(50*time.Millisecond, 100*time.Millisecond)
+
+		if err := c.Dial(); err != nil {
+			t.Fatal(err)
+		}
+
+		return c
+	}
+
+	disconnected := func(c *Client) chan struct{} {
+		ch := make(chan struct{})
+		c.OnDisconnect(func() { close(ch) })
+		return ch
+	}
+
+	alive := dial(false)
+	defer alive.Close()
+	aliveDisconnected := disconnected(alive)
+
+	dead := dial(true)
+	defer dead.Close()
+	deadDisconnected := disconnected(dead)
+
+	select {
+	case <-deadDisconnected:
+	case <-time.After(4 * time.Second):
+		t.Fatal("connection to the dead kite is not closed")
+	}
+
+	// the pings of the other kite are answered
+	select {
+	case <-aliveDisconnected:
+		t.Fatal("connection to the alive kite is closed")
+	default:
+	}
+}
@@ -0,0 +1,76 @@
+package kite
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// KeyID returns the id of a PEM encoded public key. Kontrol puts the id of
+// the key that signs a token into the "kid" header of the token.
+func KeyID(publicKey string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(publicKey)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// AddKontrolKey adds a PEM encoded kontrol public key to the keys used for
+// validating tokens and returns its key id. Tokens with a "kid" header are
+// validated with the key that has the same id, which lets kontrol rotate its
+// key without invalidating the tokens signed with the previous one.
+func (k *Kite) AddKontrolKey(publicKey string) string {
+	kid := KeyID(publicKey)
+
+	k.kontrolKeysMu.Lock()
+	k.kontrolKeys[kid] = publicKey
+	delete(k.retiredKeys, kid)
+	k.kontrolKeysMu.Unlock()
+
+	return kid
+}
+
+// RemoveKontrolKey removes a key added with AddKontrolKey. Tokens signed with
+// the key are rejected after it's removed, even if it's the key in the config,
+// until it's added again.
+func (k *Kite) RemoveKontrolKey(kid string) {
+	k.kontrolKeysMu.Lock()
+	delete(k.kontrolKeys, kid)
+	k.retiredKeys[kid] = struct{}{}
+	k.kontrolKeysMu.Unlock()
+}
+
+// KontrolKeys returns the keys added with AddKontrolKey by their key ids.
+func (k *Kite) KontrolKeys() map[string]string {
+	k.kontrolKeysMu.RLock()
+	defer k.kontrolKeysMu.RUnlock()
+
+	keys := make(map[string]string, len(k.kontrolKeys))
+	for kid, key := range k.kontrolKeys {
+		keys[kid] = key
+	}
+
+	return keys
+}
+
+// kontrolKey returns the kontrol public key with the key id. The key in the
+// config is used if it's not added with AddKontrolKey, unless it's removed.
+func (k *Kite) kontrolKey(kid string) (string, error) {
+	k.kontrolKeysMu.RLock()
+	key, ok := k.kontrolKeys[kid]
+	_, retired := k.retiredKeys[kid]
+	k.kontrolKeysMu.RUnlock()
+
+	if ok {
+		return key, nil
+	}
+
+	if retired {
+		return "", fmt.Errorf("key is retired: %s", kid)
+	}
+
+	if KeyID(k.Config.KontrolKey) == kid {
+		return k.Config.KontrolKey, nil
+	}
+
+	return "", fmt.Errorf("unknown key id: %s", kid)
+}
@@ -12,9 +12,13 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/websocket"
 	"github.com/koding/kite/config"
+	"github.com/koding/kite/jwtkeys"
 	"github.com/koding/kite/protocol"
 	"github.com/nu7hatch/gouuid"
 	"gopkg.in/igm/sockjs-go.v2/sockjs"
@@ -39,6 +43,11 @@ func init() {
 // with HandleFunc mehtod, then call Run method to start the inbuilt server (or
 // pass it to any http.Handler compatible server)
 type Kite struct {
+	// inflightCount is the number of running handlers and callbacks, for
+	// Load(). It's accessed atomically, so it's the first word to be 64-bit
+	// aligned on 32-bit platforms.
+	inflightCount int64
+
 	Config *config.Config
 
 	// Log logs with the given Logger interface
@@ -56,15 +65,50 @@ type Kite struct {
 	// Key data must be PEM encoded.
 	trustedKontrolKeys map[string]string
 
+	// Additional kontrol public keys added with AddKontrolKey. Keys are the
+	// key ids in the "kid" header of the tokens.
+	kontrolKeys   map[string]string
+	retiredKeys   map[string]struct{} // removed with RemoveKontrolKey
+	kontrolKeysMu sync.RWMutex
+
+	// Ids of the revoked tokens, see SetRevokedTokens.
+	revoked   map[string]struct{}
+	revokedMu sync.RWMutex
+
+	// Kite ids, kite key ids and usernames blocked in kontrol, see
+	// SetBlocklist.
+	blockedKites map[string]struct{}
+	blockedKeys  map[string]struct{}
+	blockedUsers map[string]struct{}
+	blockedMu    sync.RWMutex
+
 	// Handlers added with Kite.HandleFunc().
-	handlers     map[string]*Method // method map for exported methods
-	preHandlers  []Handler          // a list of handlers that are executed before any handler
-	postHandlers []Handler          // a list of handlers that are executed after any handler
+	handlers     map[string]*Method         // method map for exported methods
+	versions     map[string]map[int]*Method // all versions of the methods
+	preHandlers  []Handler                  // a list of handlers that are executed before any handler
+	postHandlers []Handler                  // a list of handlers that are executed after any handler
+	middlewares  []Middleware               // wraps every handler, added with Kite.Use()
+	rateLimiter  *RateLimiter               // limits the requests of each caller, set with Kite.RateLimit()
 
 	// MethodHandling defines how the kite is returning the response for
 	// multiple handlers
 	MethodHandling MethodHandling
 
+	// PanicHandler, if set, is called when a handler panics, with the
+	// recovered value and the stack trace of the panic. The returned error
+	// is sent to the caller, nil sends the default "genericError". The
+	// request is nil if the panic has happened before it's created. The
+	// kite can be crashed for fatal conditions by panicking again in it. If
+	// PanicHandler is nil the stack trace is printed to stderr.
+	PanicHandler func(r *Request, recovered interface{}, stack []byte) *Error
+
+	// ValidateArgs, if set, is called with a pointer to the decoded
+	// argument of the typed handlers before they are called, like
+	// Validator.Validate. It's the integration point for struct tag based
+	// validators. A non-nil error is sent to the caller as a
+	// "validationError".
+	ValidateArgs func(args interface{}) error
+
 	// HTTP muxer
 	httpHandler *http.ServeMux
 
@@ -83,20 +127,57 @@ type Kite struct {
 
 	// server fields, are initialized and used when
 	// TODO: move them to their own struct, just like KontrolClient
-	listener  net.Listener
-	TLSConfig *tls.Config
-	readyC    chan bool // To signal when kite is ready to accept connections
-	closeC    chan bool // To signal when kite is closed with Close()
+	listener       net.Listener
+	rawListener    net.Listener // listener without TLS, used by Upgrade
+	TLSConfig      *tls.Config
+	certReloader   *certReloader // reloads the certificate of RunTLS
+	publicHostname string        // set by UseAutocert, used in RegisterURL
+	readyC         chan bool     // To signal when kite is ready to accept connections
+	closeC         chan bool     // To signal when kite is closed with Close()
+
+	// clients contains the connected clients that are served by this kite.
+	clients   map[*Client]struct{}
+	clientsMu sync.Mutex
+
+	// idempotent keeps the calls sent by resuming clients, so they are run
+	// only once.
+	idempotent idempotentCalls
+
+	// binaries keeps the binary payloads of the calls, see TellBinary.
+	binaries binaryStore
+
+	// handlerDeadlines are the deadlines of the running handlers, the calls
+	// they make with Tell and Go are limited by them.
+	handlerDeadlines handlerDeadlines
+
+	// pendingCalls contains the cancel functions of the calls made with Go()
+	// that are not answered yet, keyed by their PendingCall.
+	pendingCalls sync.Map
+
+	// health contains the checks added with AddHealthCheck.
+	health healthChecks
+
+	// load measures the CPU usage reported by Load.
+	load loadMeter
+
+	// inflight counts the running handlers and callbacks, used by Shutdown()
+	// to drain them.
+	inflight     sync.WaitGroup
+	shuttingDown bool
+	shutdownMu   sync.RWMutex // protects shuttingDown
 
 	name    string
 	version string
 	Id      string // Unique kite instance id
+
+	startedAt time.Time // creation time of the kite, for uptime
 }
 
 // New creates, initialize and then returns a new Kite instance. Version must
 // be in 3-digit semantic form. Name is important that it's also used to be
-// searched by others.
-func New(name, version string) *Kite {
+// searched by others. Options are applied after the kite is initialized with
+// the defaults.
+func New(name, version string, opts ...Option) *Kite {
 	if name == "" {
 		panic("kite: name cannot be empty")
 	}
@@ -124,7 +205,10 @@ func New(name, version string) *Kite {
 		SetLogLevel:        setlevel,
 		Authenticators:     make(map[string]func(*Request) error),
 		trustedKontrolKeys: make(map[string]string),
+		kontrolKeys:        make(map[string]string),
+		retiredKeys:        make(map[string]struct{}),
 		handlers:           make(map[string]*Method),
+		versions:           make(map[string]map[int]*Method),
 		preHandlers:        make([]Handler, 0),
 		postHandlers:       make([]Handler, 0),
 		kontrol:            kClient,
@@ -132,12 +216,14 @@ func New(name, version string) *Kite {
 		version:            version,
 		Id:                 kiteID.String(),
 		readyC:             make(chan bool),
+		startedAt:          time.Now().UTC(),
 		closeC:             make(chan bool),
+		clients:            make(map[*Client]struct{}),
 		httpHandler:        http.NewServeMux(),
 	}
 
 	// All websocket communication is done through this endpoint.
-	k.HandleHTTP("/", sockjs.NewHandler("/kite", sockjs.DefaultOptions, k.sockjsHandler))
+	k.HandleHTTP("/", k.newSockJSHandler("/kite"))
 
 	// Add useful debug logs
 	k.OnConnect(func(c *Client) { k.Log.Debug("New session: %s", c.session.ID()) })
@@ -151,6 +237,21 @@ func New(name, version string) *Kite {
 	// A kite accepts requests with the same username.
 	k.Authenticators["kiteKey"] = k.AuthenticateFromKiteKey
 
+	// Kites sharing a secret can authenticate each other without kontrol.
+	k.Authenticators["hmac"] = k.AuthenticateFromHMAC
+
+	// Kites connected with a client certificate, see RequireClientCerts.
+	k.Authenticators["tls"] = k.AuthenticateFromTLS
+
+	// The kite started by Upgrade continues as the old one.
+	if id := upgradeID(); id != "" {
+		k.Id = id
+	}
+
+	for _, opt := range opts {
+		opt(k)
+	}
+
 	// Register default methods and handlers.
 	k.addDefaultHandlers()
 
@@ -167,6 +268,7 @@ func (k *Kite) Kite() *protocol.Kite {
 		Region:      k.Config.Region,
 		Hostname:    hostname,
 		ID:          k.Id,
+		Labels:      k.Config.Labels,
 	}
 }
 
@@ -190,27 +292,94 @@ func (k *Kite) HandleHTTPFunc(pattern string, handler func(http.ResponseWriter,
 // ServeHTTP helps Kite to satisfy the http.Handler interface. So kite can be
 // used as a standard http server.
 func (k *Kite) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !k.allowRequest(w, req) {
+		return
+	}
+
 	k.httpHandler.ServeHTTP(w, req)
 }
 
+// HandlerFunc returns the handler of the kite endpoint for mounting the kite
+// on the given path of an existing HTTP server, next to its other routes:
+//
+//	mux.Handle("/rpc/kite/", k.HandlerFunc("/rpc/kite"))
+//
+// The path must be registered with a trailing slash, the handler serves the
+// path and everything below it. Clients connect to the URL of the path, like
+// "http://example.com/rpc/kite", and the kite doesn't need to be run with
+// Run. The routes added with HandleHTTP are not served by the handler.
+func (k *Kite) HandlerFunc(path string) http.HandlerFunc {
+	path = "/" + strings.Trim(path, "/")
+	sockjsHandler := k.newSockJSHandler(path)
+	binaryPath := path + "/binary"
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !k.allowRequest(w, req) {
+			return
+		}
+
+		if req.URL.Path == binaryPath {
+			k.handleBinary(w, req)
+			return
+		}
+
+		sockjsHandler.ServeHTTP(w, req)
+	}
+}
+
+// allowRequest checks the origin and host of the request and responds with
+// an error if they are not allowed.
+func (k *Kite) allowRequest(w http.ResponseWriter, req *http.Request) bool {
+	if !k.checkRequest(req) {
+		k.Log.Warning("Rejected request from %s: origin %q host %q is not allowed",
+			req.RemoteAddr, req.Header.Get("Origin"), req.Host)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
 func (k *Kite) sockjsHandler(session sockjs.Session) {
-	defer session.Close(0, "")
+	k.ServeTransport(session)
+}
 
-	// This Client also handles the connected client.
-	// Since both sides can send/receive messages the client code is reused here.
-	c := k.NewClient("")
-	c.session = session
+// newSockJSHandler returns the handler of the kite endpoint at the prefix. The
+// websocket compression is negotiated with the clients that connect while
+// Config.CompressionThreshold is positive.
+func (k *Kite) newSockJSHandler(prefix string) http.Handler {
+	newHandler := func(compression bool) http.Handler {
+		opts := sockjs.DefaultOptions
+		opts.WebsocketUpgrader = &websocket.Upgrader{
+			// with a buffer size, the connection returned from
+			// limitHijacker is read instead of the buffer of the server
+			ReadBufferSize:    4096,
+			WriteBufferSize:   4096,
+			EnableCompression: compression,
+			// origins are checked by checkRequest before the upgrade
+			CheckOrigin: func(*http.Request) bool { return true },
+		}
+
+		return sockjs.NewHandler(prefix, opts, k.sockjsHandler)
+	}
 
-	go c.sendHub()
-	c.wg.Add(1) // with sendHub we added a new listener
+	plain := newHandler(false)
+	compressed := newHandler(true)
 
-	k.callOnConnectHandlers(c)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// websocket messages are limited while they are read, the messages
+		// of the other transports are checked after they are received
+		if limit := k.Config.MaxMessageSize; limit > 0 && websocket.IsWebSocketUpgrade(req) {
+			w = &limitHijacker{ResponseWriter: w, limit: frameLimit(limit)}
+		}
 
-	// Run after methods are registered and delegate is set
-	c.readLoop()
+		if k.Config.CompressionThreshold > 0 {
+			compressed.ServeHTTP(w, req)
+			return
+		}
 
-	c.callOnDisconnectHandlers()
-	k.callOnDisconnectHandlers(c)
+		plain.ServeHTTP(w, req)
+	})
 }
 
 func (k *Kite) OnConnect(handler func(*Client)) {
@@ -247,6 +416,9 @@ func (k *Kite) callOnDisconnectHandlers(c *Client) {
 
 // RSAKey returns the corresponding public key for the issuer of the token.
 // It is called by jwt-go package when validating the signature in the token.
+// If the token has a "kid" header, the key is selected by it from the keys
+// added with AddKontrolKey and the key in the config. Despite its name, the
+// kontrol keys can be ECDSA or Ed25519 keys too, see package jwtkeys.
 func (k *Kite) RSAKey(token *jwt.Token) (interface{}, error) {
 	if k.Config.KontrolKey == "" {
 		panic("kontrol key is not set in config")
@@ -261,5 +433,16 @@ func (k *Kite) RSAKey(token *jwt.Token) (interface{}, error) {
 		return nil, fmt.Errorf("issuer is not trusted: %s", issuer)
 	}
 
-	return []byte(k.Config.KontrolKey), nil
+	// tokens without a kid are signed with the key in the config
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = KeyID(k.Config.KontrolKey)
+	}
+
+	key, err := k.kontrolKey(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	return jwtkeys.VerificationKey(token.Method, key)
 }
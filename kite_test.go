@@ -1,17 +1,31 @@
 package kite
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"reflect"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/koding/kite/config"
 	"github.com/koding/kite/dnode"
+	"github.com/koding/kite/protocol"
+	"github.com/koding/kite/sockjsclient"
 	_ "github.com/koding/kite/testutil"
 )
 
@@ -198,6 +212,22 @@ func TestKite(t *testing.T) {
 		t.Fatal("Did not get the message")
 	}
 
+	var square float64
+	if err := remote.Call("square", 3, &square); err != nil {
+		t.Fatal(err)
+	}
+
+	if square != 9 {
+		t.Fatalf("Invalid result: %f", square)
+	}
+
+	// consume the reverse call of square
+	select {
+	case <-fooChan:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Did not get the message")
+	}
+
 	resultChan := make(chan float64, 1)
 	resultCallback := func(args *dnode.Partial) {
 		n := args.One().MustFloat64()
@@ -223,6 +253,13 @@ func TestKite(t *testing.T) {
 		t.Fatal("Did get message in 1 seconds, however the sleep method takes 2 seconds to response")
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	_, err = remote.TellWithContext(ctx, "sleep")
+	cancel()
+	if kiteErr, ok := err.(*Error); !ok || kiteErr.Type != "timeout" {
+		t.Fatalf("Want timeout error when the context deadline is exceeded, got: %v", err)
+	}
+
 	result, err = remote.Tell("sleep")
 	if err != nil {
 		t.Fatal(err)
@@ -277,3 +314,1132 @@ func newXhrKite(name, version string) *Kite {
 	k.Config.Transport = config.XHRPolling
 	return k
 }
+
+func TestBroadcast(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10004
+
+	connected := make(chan struct{}, 2)
+	k.OnConnect(func(c *Client) { connected <- struct{}{} })
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	notified := make(chan string, 2)
+
+	for i := 0; i < 2; i++ {
+		e := New("exp"+strconv.Itoa(i), "0.0.1")
+		e.HandleFunc("notify", func(r *Request) (interface{}, error) {
+			notified <- r.Args.One().MustString()
+			return nil, nil
+		})
+
+		c := e.NewClient("http://127.0.0.1:10004/kite")
+		if err := c.Dial(); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-connected:
+		case <-time.After(4 * time.Second):
+			t.Fatal("clients are not connected")
+		}
+	}
+
+	if n := len(k.Clients()); n != 2 {
+		t.Fatalf("got %d clients, want: 2", n)
+	}
+
+	k.Broadcast("notify", "hello")
+
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-notified:
+			if msg != "hello" {
+				t.Fatalf("got %q, want: hello", msg)
+			}
+		case <-time.After(4 * time.Second):
+			t.Fatal("broadcast is not received")
+		}
+	}
+}
+
+func TestClientByID(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10041
+	k.HandleFunc("hello", func(r *Request) (interface{}, error) { return nil, nil })
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := New("exp", "0.0.1")
+	e.HandleFunc("whoami", func(r *Request) (interface{}, error) {
+		return e.Kite().Name, nil
+	})
+
+	c := e.NewClient("http://127.0.0.1:10041/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// the remote kite is known after its first request
+	if _, err := c.Tell("hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	if k.ClientByID("unknown") != nil {
+		t.Error("got a client for an unknown kite ID")
+	}
+
+	remote := k.ClientByID(e.Id)
+	if remote == nil {
+		t.Fatalf("client %s is not found", e.Id)
+	}
+
+	result, err := remote.TellWithTimeout("whoami", 4*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := result.MustString(); name != "exp" {
+		t.Errorf("got %q, want: exp", name)
+	}
+}
+
+func TestHMACAuthentication(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.Port = 10005
+	k.Config.HMACSecret = "secret"
+	k.HandleFunc("whoami", func(r *Request) (interface{}, error) {
+		return r.Username, nil
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	token, err := NewHMACToken("secret", "alice", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	badToken, err := NewHMACToken("wrong", "alice", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := New("exp", "0.0.1")
+
+	c := e.NewClient("http://127.0.0.1:10005/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.Auth = &Auth{Type: "hmac", Key: token}
+
+	result, err := c.Tell("whoami")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if username := result.MustString(); username != "alice" {
+		t.Errorf("got username %q, want: alice", username)
+	}
+
+	for _, key := range []string{badToken, "wrong"} {
+		c.Auth = &Auth{Type: "hmac", Key: key}
+
+		if _, err := c.Tell("whoami"); err == nil {
+			t.Errorf("key %q is accepted", key)
+		}
+	}
+}
+
+func TestHMACSecretLikeToken(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.HMACSecret = "part1.part2.part3"
+
+	// the username of the remote kite is trusted with the secret
+	r := &Request{
+		Client: &Client{Kite: protocol.Kite{Username: "bob"}},
+		Auth:   &Auth{Type: "hmac", Key: k.Config.HMACSecret},
+	}
+
+	if err := k.AuthenticateFromHMAC(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Username != "bob" {
+		t.Errorf("got username %q, want: bob", r.Username)
+	}
+
+	token, err := NewHMACToken(k.Config.HMACSecret, "alice", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Auth.Key = token
+	if err := k.AuthenticateFromHMAC(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Username != "alice" {
+		t.Errorf("got username %q, want: alice", r.Username)
+	}
+
+	r.Auth.Key = "part1.part2.part4"
+	if err := k.AuthenticateFromHMAC(r); err == nil {
+		t.Error("wrong secret is accepted")
+	}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	conf := config.New()
+	conf.Username = "alice"
+
+	k := New("testkite", "0.0.1",
+		WithConfig(conf),
+		WithPort(10008),
+		WithTransport(config.XHRPolling),
+	)
+
+	if k.Config.Username != "alice" {
+		t.Errorf("got username %q, want: alice", k.Config.Username)
+	}
+
+	if k.Config.Port != 10008 {
+		t.Errorf("got port %d, want: 10008", k.Config.Port)
+	}
+
+	if k.Config.Transport != config.XHRPolling {
+		t.Errorf("got transport %s, want: XHRPolling", k.Config.Transport)
+	}
+
+	// a nil config must not make the following options panic
+	k = New("testkite", "0.0.1", WithConfig(nil), WithPort(10008))
+
+	if k.Config == nil || k.Config.Port != 10008 {
+		t.Errorf("got config %+v, want: default config with port 10008", k.Config)
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.AllowedOrigins = []string{"https://example.com", "https://*.koding.com", "https://*.kite.io:8443"}
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"", true},
+		{"http://127.0.0.1:3636", true}, // same origin
+		{"https://example.com", true},
+		{"https://test.koding.com", true},
+		{"https://test.koding.com:8443", true},
+		{"https://test.kite.io:8443", true},
+		{"https://test.kite.io", false},
+		{"https://test.kite.io:9443", false},
+		{"http://test.koding.com", false},
+		{"https://evil.com", false},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", "http://127.0.0.1:3636/kite/websocket", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+
+		if allowed := k.checkRequest(req); allowed != test.allowed {
+			t.Errorf("origin %q: got allowed %t, want: %t", test.origin, allowed, test.allowed)
+		}
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.Port = 10009
+	k.Config.DisableAuthentication = true
+	k.Config.MaxMessageSize = 1024
+	k.HandleFunc("echo", func(r *Request) (interface{}, error) {
+		return r.Args.One().MustString(), nil
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := New("exp", "0.0.1")
+
+	c := e.NewClient("http://127.0.0.1:10009/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.TellWithTimeout("echo", 4*time.Second, "small"); err != nil {
+		t.Fatal(err)
+	}
+
+	large := strings.Repeat("a", 2048)
+
+	if _, err := c.TellWithTimeout("echo", 4*time.Second, large); err == nil {
+		t.Error("large message is accepted")
+	}
+
+	// the connection must be closed before the whole message is received
+	ws, _, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:10009/kite/000/limit/websocket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	if _, _, err := ws.ReadMessage(); err != nil { // open frame
+		t.Fatal(err)
+	}
+
+	w, err := ws.NextWriter(websocket.TextMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the message is never finished
+	if _, err := w.Write([]byte(strings.Repeat("a", 64*1024))); err != nil {
+		t.Fatal(err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(4 * time.Second))
+	for {
+		_, _, err := ws.ReadMessage()
+		if err == nil {
+			continue
+		}
+
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			t.Error("connection is not closed while receiving a large message")
+		}
+		break
+	}
+}
+
+func TestDialFallback(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.Port = 10012
+	k.Config.DisableAuthentication = true
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := New("exp", "0.0.1")
+
+	// nothing is listening on port 10013
+	c := e.NewClient("http://127.0.0.1:10013/kite")
+	c.FallbackURLs = []string{"http://127.0.0.1:10012/kite"}
+	if err := c.DialTimeout(4 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.TellWithTimeout("kite.ping", 4*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.URL != "http://127.0.0.1:10012/kite" {
+		t.Errorf("got url %q, want the fallback url", c.URL)
+	}
+}
+
+func TestReconnectGiveUp(t *testing.T) {
+	e := New("exp", "0.0.1")
+
+	// nothing is listening on port 10017
+	c := e.NewClient("http://127.0.0.1:10017/kite")
+	c.MaxReconnectAttempts = 3
+	c.SetReconnectBackoff(10*time.Millisecond, 50*time.Millisecond, 2, 0)
+
+	gaveUp := make(chan struct{})
+	c.OnGiveUp(func() { close(gaveUp) })
+
+	connected, err := c.DialForever()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-gaveUp:
+	case <-connected:
+		t.Fatal("connected to a closed port")
+	case <-time.After(10 * time.Second):
+		t.Fatal("did not give up dialing")
+	}
+
+	if c.reconnect() {
+		t.Error("Reconnect is still enabled after giving up")
+	}
+}
+
+func TestConnState(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.Port = 10018
+	k.Config.DisableAuthentication = true
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := New("exp", "0.0.1")
+	c := e.NewClient("http://127.0.0.1:10018/kite")
+
+	if s := c.State(); s != Disconnected {
+		t.Fatalf("got state %s before dialing, want %s", s, Disconnected)
+	}
+
+	changes := c.StateChanges()
+
+	if err := c.DialTimeout(4 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := c.State(); s != Connected {
+		t.Errorf("got state %s after dialing, want %s", s, Connected)
+	}
+
+	c.Close()
+
+	var got []ConnState
+	for s := range changes {
+		got = append(got, s)
+	}
+
+	want := []ConnState{Connecting, Connected, Disconnected}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got state changes %v, want %v", got, want)
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.Port = 10019
+	k.Config.DisableAuthentication = true
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := New("exp", "0.0.1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := e.NewClient("http://127.0.0.1:10019/kite")
+	if err := c.DialContext(ctx); err != context.Canceled {
+		t.Fatalf("got error %v with canceled context, want %v", err, context.Canceled)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	c = e.NewClient("http://127.0.0.1:10019/kite")
+	if err := c.DialContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.TellWithTimeout("kite.ping", 4*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDialProxy(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.Port = 10020
+	k.Config.DisableAuthentication = true
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	var tunnels int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "CONNECT" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))
+		if r.Header.Get("Proxy-Authorization") != auth {
+			http.Error(w, "bad credentials", http.StatusProxyAuthRequired)
+			return
+		}
+
+		remote, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			remote.Close()
+			return
+		}
+
+		atomic.AddInt32(&tunnels, 1)
+
+		go func() {
+			io.Copy(remote, conn)
+			remote.Close()
+		}()
+		io.Copy(conn, remote)
+		conn.Close()
+	}))
+	defer proxy.Close()
+
+	e := New("exp", "0.0.1")
+
+	c := e.NewClient("http://127.0.0.1:10020/kite")
+	c.Proxy = strings.Replace(proxy.URL, "http://", "http://user:wrong@", 1)
+	if err := c.DialTimeout(4 * time.Second); err == nil {
+		c.Close()
+		t.Fatal("connected through the proxy with wrong credentials")
+	}
+
+	c = e.NewClient("http://127.0.0.1:10020/kite")
+	c.Proxy = strings.Replace(proxy.URL, "http://", "http://user:pass@", 1)
+	if err := c.DialTimeout(4 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.TellWithTimeout("kite.ping", 4*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadInt32(&tunnels); n != 1 {
+		t.Errorf("got %d tunnels, want 1", n)
+	}
+}
+
+func TestDialFirst(t *testing.T) {
+	slow := errors.New("slow")
+	urls := []string{"slow", "fast"}
+
+	dial := func(url string) (Transport, error) {
+		if url == "slow" {
+			time.Sleep(time.Second)
+			return nil, slow
+		}
+		return nil, nil
+	}
+
+	if _, i, err := dialFirst(urls, 50*time.Millisecond, dial); err != nil || i != 1 {
+		t.Errorf("got %d, %v; want the fast url", i, err)
+	}
+
+	start := time.Now()
+	if _, i, err := dialFirst(urls, 0, dial); err != nil || i != 1 {
+		t.Errorf("got %d, %v; want the fast url", i, err)
+	}
+
+	if time.Since(start) < time.Second {
+		t.Error("urls are not tried one by one without a delay")
+	}
+}
+
+func TestNotify(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.Port = 10014
+	k.Config.DisableAuthentication = true
+
+	events := make(chan string, 1)
+	k.HandleFunc("event", func(r *Request) (interface{}, error) {
+		events <- r.Args.One().MustString()
+		return nil, nil
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := New("exp", "0.0.1")
+
+	c := e.NewClient("http://127.0.0.1:10014/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Notify("event", "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case s := <-events:
+		if s != "hello" {
+			t.Errorf("got %q, want: hello", s)
+		}
+	case <-time.After(4 * time.Second):
+		t.Fatal("notification is not received")
+	}
+
+	if n := c.CallbackCount(); n != 0 {
+		t.Errorf("got %d callbacks, want: 0", n)
+	}
+}
+
+func TestCallbackTTLResponse(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.Port = 10037
+	k.Config.DisableAuthentication = true
+
+	k.HandleFunc("slow", func(r *Request) (interface{}, error) {
+		time.Sleep(300 * time.Millisecond)
+		return "done", nil
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	c := New("exp", "0.0.1").NewClient("http://127.0.0.1:10037/kite")
+	c.SetCallbackTTL(50 * time.Millisecond)
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// the response callback outlives the TTL
+	result, err := c.TellWithTimeout("slow", 4*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := result.MustString(); s != "done" {
+		t.Errorf("got %q, want: done", s)
+	}
+
+	if n := c.CallbackCount(); n != 0 {
+		t.Errorf("got %d callbacks, want: 0", n)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.Port = 10015
+	k.Config.DisableAuthentication = true
+	k.HandleFunc("square", func(r *Request) (interface{}, error) {
+		a := r.Args.One().MustFloat64()
+		return a * a, nil
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := New("exp", "0.0.1")
+
+	c := e.NewClient("http://127.0.0.1:10015/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	b := c.Batch()
+	b.Timeout = 4 * time.Second
+
+	var responses []chan *response
+	for i := 0; i < 10; i++ {
+		responses = append(responses, b.Go("square", i))
+	}
+
+	if n := b.Len(); n != 10 {
+		t.Errorf("got %d queued calls, want: 10", n)
+	}
+
+	b.Flush()
+
+	for i, ch := range responses {
+		resp := <-ch
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+
+		if n := resp.Result.MustFloat64(); n != float64(i*i) {
+			t.Errorf("got %v, want: %d", n, i*i)
+		}
+	}
+}
+
+// stalledTransport is a Transport of a remote kite that doesn't read the
+// messages.
+type stalledTransport struct{}
+
+func (stalledTransport) ID() string                               { return "stalled" }
+func (stalledTransport) Send(msg string) error                    { select {} }
+func (stalledTransport) Recv() (string, error)                    { select {} }
+func (stalledTransport) Close(status uint32, reason string) error { return nil }
+
+func TestSendQueueFull(t *testing.T) {
+	e := New("exp", "0.0.1")
+	e.Config.SendQueueSize = 2
+
+	c := e.NewClient("")
+	c.session = stalledTransport{}
+
+	// nothing is sent without sendHub
+	for i := 0; i < 2; i++ {
+		if err := c.sendData([]byte("message")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.sendData([]byte("message")); err != ErrSendQueueFull {
+		t.Errorf("got %v, want: %v", err, ErrSendQueueFull)
+	}
+
+	err := c.Notify("event")
+	if kiteErr, ok := err.(*Error); !ok || kiteErr.Type != "sendQueueFull" {
+		t.Errorf("got %v, want a sendQueueFull error", err)
+	}
+}
+
+// closeRecorder is a stalledTransport that records whether it's closed.
+type closeRecorder struct {
+	stalledTransport
+	closed chan struct{}
+}
+
+func (r *closeRecorder) Close(status uint32, reason string) error {
+	close(r.closed)
+	return nil
+}
+
+func TestKeepAliveSendQueueFull(t *testing.T) {
+	e := New("exp", "0.0.1")
+	e.Config.SendQueueSize = 1
+
+	session := &closeRecorder{closed: make(chan struct{})}
+
+	c := e.NewClient("")
+	c.session = session
+
+	// nothing is sent without sendHub
+	if err := c.sendData([]byte("message")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pings can't be sent, but the connection is not dead
+	c.SetKeepAlive(10*time.Millisecond, 10*time.Millisecond)
+	defer c.SetKeepAlive(0, 0)
+
+	select {
+	case <-session.closed:
+		t.Error("connection is closed because of the full send queue")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestPendingCallCancel(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.Port = 10016
+	k.Config.DisableAuthentication = true
+
+	release := make(chan struct{})
+	k.HandleFunc("slow", func(r *Request) (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+	defer close(release)
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := New("exp", "0.0.1")
+
+	c := e.NewClient("http://127.0.0.1:10016/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	call := c.Go("slow")
+	c.Cancel(call)
+
+	select {
+	case resp := <-call:
+		if kiteErr, ok := resp.Err.(*Error); !ok || kiteErr.Type != "canceled" {
+			t.Errorf("got %v, want a canceled error", resp.Err)
+		}
+	case <-time.After(4 * time.Second):
+		t.Fatal("canceled call is not completed")
+	}
+
+	if n := c.CallbackCount(); n != 0 {
+		t.Errorf("got %d callbacks, want: 0", n)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.Port = 10021
+	k.Config.DisableAuthentication = true
+	k.Config.CompressionThreshold = 1024
+
+	k.HandleFunc("echo", func(r *Request) (interface{}, error) {
+		return r.Args.One().MustString(), nil
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := New("exp", "0.0.1")
+	e.Config.CompressionThreshold = 1024
+
+	c := e.NewClient("http://127.0.0.1:10021/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if session, ok := c.session.(*sockjsclient.WebsocketSession); !ok || !session.Compressed() {
+		t.Error("compression is not negotiated")
+	}
+
+	for _, size := range []int{10, 64 << 10} {
+		s := strings.Repeat("a", size)
+
+		result, err := c.TellWithTimeout("echo", 4*time.Second, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := result.MustString(); got != s {
+			t.Errorf("got %d bytes, want %d", len(got), size)
+		}
+	}
+
+	// the compression is not negotiated with the clients that don't ask for it
+	plain := New("plain", "0.0.1").NewClient("http://127.0.0.1:10021/kite")
+	if err := plain.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+
+	if session, ok := plain.session.(*sockjsclient.WebsocketSession); !ok || session.Compressed() {
+		t.Error("compression is negotiated with a client that doesn't support it")
+	}
+}
+
+func TestTellBinary(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.Port = 10022
+	k.HandleBinary()
+	k.Config.DisableAuthentication = true
+
+	k.HandleFunc("reverse", func(r *Request) (interface{}, error) {
+		if r.Args.One().MustString() != "please" {
+			return nil, errors.New("missing argument")
+		}
+
+		b := make(Binary, len(r.Binary))
+		for i, c := range r.Binary {
+			b[len(b)-1-i] = c
+		}
+		return b, nil
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := New("exp", "0.0.1")
+
+	c := e.NewClient("http://127.0.0.1:10022/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	data := []byte{0, 1, 2, 0xfe, 0xff}
+
+	result, binary, err := c.TellBinary("reverse", data, "please")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != nil {
+		t.Errorf("got result %s, want nil", result.Raw)
+	}
+
+	if want := []byte{0xff, 0xfe, 2, 1, 0}; !bytes.Equal(binary, want) {
+		t.Errorf("got binary %v, want %v", binary, want)
+	}
+
+	// the callers that don't accept binary get it as base64
+	result, err = c.Tell("reverse", "please")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := result.MustString(); s != "" {
+		t.Errorf("got %q for empty binary, want empty string", s)
+	}
+}
+
+func TestBinaryAuthentication(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.Port = 10045
+	k.HandleBinary()
+	k.Config.MaxBinaryStoreSize = 8
+
+	k.Authenticators["token"] = func(r *Request) error {
+		if r.Auth.Key != "secret" {
+			return errors.New("invalid token")
+		}
+
+		r.Username = "alice"
+		return nil
+	}
+
+	k.HandleFunc("size", func(r *Request) (interface{}, error) {
+		return len(r.Binary), nil
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	upload := func(auth string, data string) int {
+		req, err := http.NewRequest("POST", "http://127.0.0.1:10045/kite/binary", strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	for _, auth := range []string{"", "token invalid", "kiteKey", "hmac secret"} {
+		if code := upload(auth, "data"); code != http.StatusUnauthorized {
+			t.Errorf("%q: got status %d, want: %d", auth, code, http.StatusUnauthorized)
+		}
+	}
+
+	c := New("exp", "0.0.1").NewClient("http://127.0.0.1:10045/kite")
+	c.Auth = &Auth{Type: "token", Key: "secret"}
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	result, _, err := c.TellBinary("size", []byte("12345"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := result.MustFloat64(); n != 5 {
+		t.Errorf("got size %v, want: 5", n)
+	}
+
+	// the unclaimed payloads count towards the total size
+	if code := upload("token secret", "12345"); code != http.StatusOK {
+		t.Fatalf("got status %d, want: %d", code, http.StatusOK)
+	}
+
+	if code := upload("token secret", "12345"); code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d after the total size is exceeded, want: %d", code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.Port = 10025
+	k.Config.DisableAuthentication = true
+	k.Config.HandlerTimeout = time.Minute
+
+	release := make(chan struct{})
+	defer close(release)
+
+	k.HandleFunc("hang", func(r *Request) (interface{}, error) {
+		<-release
+		return nil, nil
+	}).Timeout(100 * time.Millisecond)
+
+	k.HandleFunc("fast", func(r *Request) (interface{}, error) {
+		return "ok", nil
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := New("exp", "0.0.1")
+	c := e.NewClient("http://127.0.0.1:10025/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err := c.TellWithTimeout("hang", 4*time.Second)
+	kerr, ok := err.(*Error)
+	if !ok || kerr.Type != "handlerTimeout" {
+		t.Fatalf("got error %v, want handlerTimeout", err)
+	}
+
+	if want := `Method "hang" has not returned in 100ms`; kerr.Message != want {
+		t.Errorf("got message %q, want: %q", kerr.Message, want)
+	}
+
+	// the kite has answered, it's not unreachable
+	if IsDeadPeer(err) {
+		t.Error("handler timeout is taken for a dead peer")
+	}
+
+	result, err := c.TellWithTimeout("fast", 4*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := result.MustString(); s != "ok" {
+		t.Errorf("got %q, want ok", s)
+	}
+}
+
+func TestPanicHandler(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.Port = 10026
+	k.Config.DisableAuthentication = true
+
+	stacks := make(chan []byte, 1)
+	k.PanicHandler = func(r *Request, recovered interface{}, stack []byte) *Error {
+		stacks <- stack
+		return &Error{
+			Type:    "internalError",
+			Message: fmt.Sprintf("%s failed: %v", r.Method, recovered),
+		}
+	}
+
+	k.HandleFunc("boom", func(r *Request) (interface{}, error) {
+		panic("boom")
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := New("exp", "0.0.1")
+	c := e.NewClient("http://127.0.0.1:10026/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err := c.TellWithTimeout("boom", 4*time.Second)
+	kerr, ok := err.(*Error)
+	if !ok || kerr.Type != "internalError" || kerr.Message != "boom failed: boom" {
+		t.Fatalf("got error %v, want internalError", err)
+	}
+
+	if stack := <-stacks; !strings.Contains(string(stack), "TestPanicHandler") {
+		t.Errorf("stack does not contain the handler:\n%s", stack)
+	}
+}
+
+func TestHandlerFunc(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.HandleFunc("square", func(r *Request) (interface{}, error) {
+		a := r.Args.One().MustFloat64()
+		return a * a, nil
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/rpc/kite/", k.HandlerFunc("/rpc/kite"))
+	mux.HandleFunc("/other", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "other")
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	e := New("exp", "0.0.1")
+	c := e.NewClient(srv.URL + "/rpc/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	result, err := c.TellWithTimeout("square", 4*time.Second, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := result.MustFloat64(); n != 9 {
+		t.Errorf("got %v, want: 9", n)
+	}
+
+	resp, err := http.Get(srv.URL + "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if body, _ := ioutil.ReadAll(resp.Body); string(body) != "other" {
+		t.Errorf("got %q, want: %q", body, "other")
+	}
+}
+
+func TestServe(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 0
+
+	go k.Serve(l)
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	if port := l.Addr().(*net.TCPAddr).Port; k.Config.Port != port {
+		t.Errorf("got port %d, want: %d", k.Config.Port, port)
+	}
+
+	e := New("exp", "0.0.1")
+	c := e.NewClient("http://" + l.Addr().String() + "/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.TellWithTimeout("kite.ping", 4*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,13 +3,23 @@ package command
 import (
 	"archive/tar"
 	"compress/gzip"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -34,25 +44,67 @@ func (c *Install) Synopsis() string {
 
 func (c *Install) Help() string {
 	helpText := `
-Usage: kitectl install URL
+Usage: kitectl install [options] <name or URL>
 
-  Installs a kite from the given URL. Example: github.com/cenkalti/math.kite
+  Installs a kite from the given repository name or the URL of its manifest
+  file into the kites directory in kite home.
+  Example: github.com/cenkalti/math.kite
+
+  The bundle must be signed with the key in the "signatures" field of the
+  manifest. The signature is verified with the public key, which is
+  "install.pub" in kite home by default.
+
+Options:
+
+  -key=path   PEM encoded RSA or Ed25519 public key to verify the bundle.
+  -insecure   Install the bundle without verifying its signature.
 `
 
 	return strings.TrimSpace(helpText)
 }
 
 func (c *Install) Run(args []string) int {
-	if len(args) != 1 {
+	var keyPath string
+	var insecure bool
+
+	flags := flag.NewFlagSet("install", flag.ExitOnError)
+	flags.StringVar(&keyPath, "key", "", "")
+	flags.BoolVar(&insecure, "insecure", false, "")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
 		c.Ui.Error("You should give a URL. Example: github.com/cenkalti/math.kite")
 		return 1
 	}
 
-	repoName := args[0]
+	manifestURL, repoName, err := getManifestURL(flags.Arg(0))
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	var publicKey crypto.PublicKey
+	if !insecure {
+		if keyPath == "" {
+			kiteHome, err := kitekey.KiteHome()
+			if err != nil {
+				c.Ui.Error(err.Error())
+				return 1
+			}
+
+			keyPath = filepath.Join(kiteHome, "install.pub")
+		}
+
+		publicKey, err = readPublicKey(keyPath)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Cannot read the public key, use -insecure to skip verification: %s", err))
+			return 1
+		}
+	}
 
 	// Download manifest
 	c.Ui.Output("Downloading manifest file...")
-	manifest, err := getManifest(repoName)
+	manifest, err := getManifest(manifestURL)
 	if err != nil {
 		c.Ui.Error(err.Error())
 		return 1
@@ -72,17 +124,34 @@ func (c *Install) Run(args []string) int {
 		return 1
 	}
 
-	// Make download request to the kite binary
-	fmt.Println("Downloading kite...")
-	targz, err := http.Get(binaryURL)
+	// Download the bundle into a temporary file first, it must not be
+	// extracted before its signature is verified.
+	c.Ui.Output("Downloading kite...")
+	bundle, digest, err := downloadBundle(binaryURL)
 	if err != nil {
 		c.Ui.Error(err.Error())
 		return 1
 	}
-	defer targz.Body.Close()
+	defer os.Remove(bundle.Name())
+	defer bundle.Close()
+
+	if !insecure {
+		signature, err := getSignature(manifest)
+		if err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+
+		if err := verifySignature(publicKey, digest, signature); err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+
+		c.Ui.Output("Signature is verified.")
+	}
 
 	// Extract gzip
-	gz, err := gzip.NewReader(targz.Body)
+	gz, err := gzip.NewReader(bundle)
 	if err != nil {
 		c.Ui.Error(err.Error())
 		return 1
@@ -97,6 +166,17 @@ func (c *Install) Run(args []string) int {
 	}
 	defer os.RemoveAll(tempKitePath)
 
+	installed, err := isInstalled(filepath.Join(repoName, version))
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	if installed {
+		c.Ui.Error(fmt.Sprintf("Already installed: %s", filepath.Join(repoName, version)))
+		return 1
+	}
+
 	err = extractTar(gz, tempKitePath)
 	if err != nil {
 		c.Ui.Error(err.Error())
@@ -115,18 +195,38 @@ func (c *Install) Run(args []string) int {
 		return 1
 	}
 
-	fmt.Println("Installed successfully:", filepath.Join(repoName, version))
+	c.Ui.Output(fmt.Sprintf("Installed successfully: %s", filepath.Join(repoName, version)))
 	return 0
 }
 
-func getManifest(repoName string) (map[string]interface{}, error) {
-	if !strings.HasPrefix(repoName, "github.com/") {
-		return nil, errors.New("Repo other than github.com is not supported for now")
+// getManifestURL returns the URL of the manifest file and the name of the
+// repository for the argument of the install command. The argument is either
+// a repository name like "github.com/cenkalti/math.kite", or a URL like
+// "https://example.com/math.kite" or "https://example.com/math.kite/.kite.json".
+func getManifestURL(arg string) (manifestURL, repoName string, err error) {
+	arg = strings.TrimRight(arg, "/")
+
+	if !strings.HasPrefix(arg, "http://") && !strings.HasPrefix(arg, "https://") {
+		if !strings.HasPrefix(arg, "github.com/") {
+			return "", "", errors.New("Repo other than github.com is not supported for now")
+		}
+
+		return "http://raw." + arg + "/master/.kite.json", arg, nil
 	}
 
-	repoName = strings.TrimRight(repoName, "/")
-	manifestURL := "http://raw." + repoName + "/master/.kite.json"
+	u, err := url.Parse(arg)
+	if err != nil {
+		return "", "", err
+	}
 
+	if path.Ext(u.Path) == ".json" {
+		return arg, u.Host + path.Dir(u.Path), nil
+	}
+
+	return arg + "/.kite.json", u.Host + u.Path, nil
+}
+
+func getManifest(manifestURL string) (map[string]interface{}, error) {
 	res, err := http.Get(manifestURL)
 	if err != nil {
 		return nil, err
@@ -155,6 +255,40 @@ func getManifest(repoName string) (map[string]interface{}, error) {
 	return manifest, nil
 }
 
+// downloadBundle downloads the bundle at binaryURL into a temporary file. It
+// returns the file, seeked to the start, and the SHA-256 digest of it.
+func downloadBundle(binaryURL string) (*os.File, []byte, error) {
+	res, err := http.Get(binaryURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, nil, fmt.Errorf("Unexpected response from server: %d", res.StatusCode)
+	}
+
+	f, err := ioutil.TempFile("", "kite-bundle-")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), res.Body); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, nil, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, nil, err
+	}
+
+	return f, h.Sum(nil), nil
+}
+
 func getBinaryURL(manifest map[string]interface{}) (string, error) {
 	platforms, ok := manifest["platforms"].(map[string]interface{})
 	if !ok {
@@ -176,6 +310,69 @@ func getBinaryURL(manifest map[string]interface{}) (string, error) {
 	return binaryURL, nil
 }
 
+// getSignature returns the signature of the bundle for the current platform.
+func getSignature(manifest map[string]interface{}) (string, error) {
+	signatures, ok := manifest["signatures"].(map[string]interface{})
+	if !ok {
+		return "", errors.New("package is not signed: no signatures key in kite manifest")
+	}
+
+	platform := runtime.GOOS + "_" + runtime.GOARCH
+
+	signature, ok := signatures[platform].(string)
+	if !ok {
+		return "", fmt.Errorf("package is not signed for platform: %s", platform)
+	}
+
+	return signature, nil
+}
+
+// readPublicKey reads the PEM encoded public key at path.
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data is found")
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// verifySignature checks the base64 encoded signature of the SHA-256 digest
+// of the bundle. RSA keys are verified with PKCS #1 v1.5, Ed25519 keys sign
+// the digest itself.
+func verifySignature(key crypto.PublicKey, digest []byte, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %s", err)
+	}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, digest, sig) {
+			err = errors.New("verification failed")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type: %T", key)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid signature: %s", err)
+	}
+
+	return nil
+}
+
 func getVersion(manifest map[string]interface{}) (string, error) {
 	version, ok := manifest["version"].(string)
 	if !ok {
@@ -187,7 +384,6 @@ func getVersion(manifest map[string]interface{}) (string, error) {
 
 // extractTar reads from the io.Reader and writes the files into the directory.
 func extractTar(r io.Reader, dir string) error {
-	first := true // true if we are on the first entry of tarball
 	tr := tar.NewReader(r)
 	for {
 		hdr, err := tr.Next()
@@ -199,44 +395,54 @@ func extractTar(r io.Reader, dir string) error {
 			return err
 		}
 
-		// Check if the same kite version is installed before
-		if first {
-			first = false
-			kiteName := strings.TrimSuffix(hdr.Name, ".kite/")
-
-			installed, err := isInstalled(kiteName)
-			if err != nil {
-				return err
-			}
-
-			if installed {
-				return fmt.Errorf("Already installed: %s", kiteName)
-			}
+		// don't let the entries to be written outside of dir
+		name := filepath.Clean(hdr.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path in package: %s", hdr.Name)
 		}
 
-		path := filepath.Join(dir, hdr.Name)
+		target := filepath.Join(dir, name)
 
-		if hdr.FileInfo().IsDir() {
-			os.MkdirAll(path, 0700)
-		} else {
+		switch {
+		case hdr.FileInfo().IsDir():
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+		case hdr.FileInfo().Mode().IsRegular():
 			mode := 0600
 			if isBinaryFile(hdr.Name) {
 				mode = 0700
 			}
 
-			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(mode))
-			if err != nil {
+			if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
 				return err
 			}
 
-			if _, err := io.Copy(f, tr); err != nil {
+			if err := writeFile(target, tr, os.FileMode(mode)); err != nil {
 				return err
 			}
+		default:
+			return fmt.Errorf("unsupported file type in package: %s", hdr.Name)
 		}
 	}
 	return nil
 }
 
+// writeFile writes the content of r to the file at path.
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
 // validatePackage does some checks on kite bundle and returns the bundle path.
 func validatePackage(tempKitePath, repoName string) (bundlePath string, err error) {
 	dirs, err := ioutil.ReadDir(tempKitePath)
@@ -261,7 +467,7 @@ func validatePackage(tempKitePath, repoName string) (bundlePath string, err erro
 	return bundlePath, err
 }
 
-// installKite moves the .kite bundle into ~/kd/kites.
+// installKite moves the .kite bundle into the kites directory in kite home.
 func installKite(bundlePath, repoName, version string) error {
 	kiteHome, err := kitekey.KiteHome()
 	if err != nil {
@@ -1,23 +1,94 @@
 package command
 
 import (
+	"archive/tar"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
 	"testing"
 )
 
-func testSplitVersion(t *testing.T) {
-	name, version, err := splitVersion("asdf-1.2.3", false)
+func TestGetManifestURL(t *testing.T) {
+	tests := []struct {
+		arg      string
+		manifest string
+		repo     string
+	}{
+		{"github.com/cenkalti/math.kite", "http://raw.github.com/cenkalti/math.kite/master/.kite.json", "github.com/cenkalti/math.kite"},
+		{"https://example.com/kites/math.kite/", "https://example.com/kites/math.kite/.kite.json", "example.com/kites/math.kite"},
+		{"https://example.com/kites/math.kite/manifest.json", "https://example.com/kites/math.kite/manifest.json", "example.com/kites/math.kite"},
+	}
+
+	for _, test := range tests {
+		manifest, repo, err := getManifestURL(test.arg)
+		if err != nil {
+			t.Errorf("%s: %s", test.arg, err)
+			continue
+		}
+
+		if manifest != test.manifest || repo != test.repo {
+			t.Errorf("%s: got %s %s, want: %s %s", test.arg, manifest, repo, test.manifest, test.repo)
+		}
+	}
+
+	if _, _, err := getManifestURL("bitbucket.org/cenkalti/math.kite"); err == nil {
+		t.Error("expected error for unsupported repo")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	digest := sha256.Sum256([]byte("bundle"))
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, digest[:]))
+	if err := verifySignature(pub, digest[:], signature); err != nil {
 		t.Error(err)
 	}
-	if name != "asdf" {
-		t.Error("Name is not ok:", name)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
 	}
-	if version != "1.2.3" {
-		t.Error("Version is not ok:", version)
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	name, version, err = splitVersion("asdf", false)
-	if err == nil {
+	if err := verifySignature(&rsaKey.PublicKey, digest[:], base64.StdEncoding.EncodeToString(sig)); err != nil {
 		t.Error(err)
 	}
+
+	other := sha256.Sum256([]byte("tampered"))
+	if err := verifySignature(pub, other[:], signature); err == nil {
+		t.Error("tampered bundle is verified")
+	}
+}
+
+func TestExtractTarInvalidPath(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "../evil", Mode: 0600, Size: 4, Typeflag: tar.TypeReg})
+	tw.Write([]byte("evil"))
+	tw.Close()
+
+	dir, err := ioutil.TempDir("", "kite-install-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := extractTar(&buf, dir); err == nil {
+		t.Error("entry outside of the directory is extracted")
+	}
 }
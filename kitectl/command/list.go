@@ -1,11 +1,16 @@
 package command
 
 import (
+	"bytes"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/koding/kite/kitekey"
 	"github.com/mitchellh/cli"
@@ -22,25 +27,48 @@ func NewList() cli.CommandFactory {
 }
 
 func (c *List) Synopsis() string {
-	return "Lists installed kites"
+	return "Lists installed or running kites"
 }
 
 func (c *List) Help() string {
 	helpText := `
-Usage: kitectl list
+Usage: kitectl list [options]
 
   Lists installed kites.
+
+Options:
+
+  -running  List the kites that are run in background with "kitectl run".
+  -json     Print the list as JSON.
 `
 	return strings.TrimSpace(helpText)
 }
 
-func (c *List) Run(_ []string) int {
+func (c *List) Run(args []string) int {
+	var running, asJSON bool
+
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	flags.BoolVar(&running, "running", false, "")
+	flags.BoolVar(&asJSON, "json", false, "")
+	flags.Parse(args)
+
+	if running {
+		return c.listRunning(asJSON)
+	}
+
 	kites, err := getInstalledKites("")
 	if err != nil {
 		c.Ui.Error(err.Error())
 		return 1
 	}
 
+	if asJSON {
+		if kites == nil {
+			kites = []*InstalledKite{}
+		}
+		return c.printJSON(kites)
+	}
+
 	for _, k := range kites {
 		c.Ui.Output(k.String())
 	}
@@ -48,6 +76,47 @@ func (c *List) Run(_ []string) int {
 	return 0
 }
 
+// listRunning lists the kites that have pidfiles.
+func (c *List) listRunning(asJSON bool) int {
+	pidFiles, err := ReadPidFiles()
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	if asJSON {
+		if pidFiles == nil {
+			pidFiles = []*PidFile{}
+		}
+		return c.printJSON(pidFiles)
+	}
+
+	w := new(tabwriter.Writer)
+	var buf bytes.Buffer
+	w.Init(&buf, 0, 8, 1, '\t', 0)
+
+	fmt.Fprintln(w, "NAME\tPID\tUPTIME\tRESTARTS\tLOG FILE")
+	for _, p := range pidFiles {
+		uptime := time.Since(p.StartedAt) / time.Second * time.Second
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%s\n", p.Name, p.Pid, uptime, p.Restarts, p.LogFile)
+	}
+	w.Flush()
+
+	c.Ui.Output(strings.TrimSpace(buf.String()))
+	return 0
+}
+
+func (c *List) printJSON(v interface{}) int {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	c.Ui.Output(string(data))
+	return 0
+}
+
 // getIntalledKites returns installed kites in .kd/kites folder.
 // an empty argument returns all kites.
 func getInstalledKites(kiteName string) ([]*InstalledKite, error) {
@@ -111,10 +180,10 @@ func getInstalledKites(kiteName string) ([]*InstalledKite, error) {
 }
 
 type InstalledKite struct {
-	Domain  string
-	User    string
-	Repo    string
-	Version string
+	Domain  string `json:"domain"`
+	User    string `json:"user"`
+	Repo    string `json:"repo"`
+	Version string `json:"version"`
 }
 
 func NewInstalledKite(domain, user, repo, version string) *InstalledKite {
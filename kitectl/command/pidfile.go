@@ -0,0 +1,166 @@
+package command
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/koding/kite/kitekey"
+)
+
+// PidFile describes a kite that is run under a supervisor with
+// "kitectl run". It's written as JSON into the run directory in kite home
+// and removed when the supervisor exits.
+type PidFile struct {
+	Name          string    `json:"name"`
+	Pid           int       `json:"pid"`           // pid of the kite process
+	SupervisorPid int       `json:"supervisorPid"` // pid of the supervisor process
+	Binary        string    `json:"binary"`
+	Args          []string  `json:"args,omitempty"`
+	LogFile       string    `json:"logFile,omitempty"`
+	StartedAt     time.Time `json:"startedAt"` // start time of the current kite process
+	Restarts      int       `json:"restarts"`
+}
+
+// runDir returns the directory the pidfiles are written to.
+func runDir() (string, error) {
+	kiteHome, err := kitekey.KiteHome()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(kiteHome, "run"), nil
+}
+
+// pidFilePath returns the path of the pidfile of the kite with the name.
+func pidFilePath(name string) (string, error) {
+	dir, err := runDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, name+".pid"), nil
+}
+
+// Write writes the pidfile, replacing the existing one.
+func (p *PidFile) Write() error {
+	path, err := pidFilePath(p.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so readers never see a partial file
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Remove removes the pidfile.
+func (p *PidFile) Remove() error {
+	path, err := pidFilePath(p.Name)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// Running returns true if the supervisor of the kite is still running.
+func (p *PidFile) Running() bool {
+	return processRunning(p.SupervisorPid)
+}
+
+// ReadPidFile reads the pidfile of the kite with the name.
+func ReadPidFile(name string) (*PidFile, error) {
+	path, err := pidFilePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return readPidFile(path)
+}
+
+func readPidFile(path string) (*PidFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p PidFile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// ReadPidFiles returns the pidfiles of all kites that are run with
+// "kitectl run". Pidfiles of the supervisors that are not running anymore are
+// removed.
+func ReadPidFiles() ([]*PidFile, error) {
+	dir, err := runDir()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var pidFiles []*PidFile
+
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".pid") {
+			continue
+		}
+
+		path := filepath.Join(dir, f.Name())
+
+		p, err := readPidFile(path)
+		if err != nil {
+			continue
+		}
+
+		// stale, supervisor is killed without cleaning up
+		if !p.Running() {
+			os.Remove(path)
+			continue
+		}
+
+		pidFiles = append(pidFiles, p)
+	}
+
+	return pidFiles, nil
+}
+
+// processRunning returns true if there is a process with the pid.
+func processRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	// signal 0 checks the existence of the process without sending a signal
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
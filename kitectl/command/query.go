@@ -1,6 +1,7 @@
 package command
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"strings"
@@ -44,6 +45,9 @@ Options:
   -region=Asia          Region of the kite.
   -hostname=caprica     Hostname of the kite.
   -id=<UUID>            Unique ID of the kite.
+  -json                 Print the kites as JSON.
+
+  Version can also be a constraint like ">=1.0.0, <2.0.0" if name is given.
 `
 	return strings.TrimSpace(helpText)
 }
@@ -53,6 +57,7 @@ func (c *Query) Run(args []string) int {
 	c.KiteClient.Config.Transport = config.XHRPolling
 
 	var query protocol.KontrolQuery
+	var asJSON bool
 
 	flags := flag.NewFlagSet("query", flag.ExitOnError)
 	flags.StringVar(&query.Username, "username", c.KiteClient.Kite().Username, "")
@@ -62,14 +67,37 @@ func (c *Query) Run(args []string) int {
 	flags.StringVar(&query.Region, "region", "", "")
 	flags.StringVar(&query.Hostname, "hostname", "", "")
 	flags.StringVar(&query.ID, "id", "", "")
+	flags.BoolVar(&asJSON, "json", false, "")
 	flags.Parse(args)
 
 	result, err := c.KiteClient.GetKites(&query)
+	if err == kite.ErrNoKitesAvailable && asJSON {
+		err = nil
+	}
 	if err != nil {
 		c.Ui.Error(err.Error())
 		return 1
 	}
 
+	if asJSON {
+		kites := make([]*protocol.KiteWithToken, len(result))
+		for i, client := range result {
+			kites[i] = &protocol.KiteWithToken{
+				Kite: client.Kite,
+				URL:  client.URL,
+			}
+		}
+
+		data, err := json.MarshalIndent(kites, "", "  ")
+		if err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+
+		c.Ui.Output(string(data))
+		return 0
+	}
+
 	for i, client := range result {
 		var k *protocol.Kite = &client.Kite
 		c.Ui.Output(fmt.Sprintf(
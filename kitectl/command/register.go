@@ -1,11 +1,17 @@
 package command
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
 	"strings"
 	"time"
 
 	"github.com/koding/kite"
+	"github.com/koding/kite/dnode"
 	"github.com/koding/kite/kitekey"
 	"github.com/mitchellh/cli"
 )
@@ -37,21 +43,28 @@ Usage: kitectl register [options]
   Registers your host to a kite authority.
   If no server is specified, "https://discovery.koding.io/kite" is the default.
 
+  If the kite authority asks for an approval, the authentication page is
+  opened in the browser. The kite.key is written into kite home after the
+  registration is approved and it's verified with the kite authority.
+
 Options:
 
   -to=https://discovery.koding.io/kite  Kontrol URL
   -username=koding                      Username
+  -no-browser                           Print the authentication URL only
 `
 	return strings.TrimSpace(helpText)
 }
 
 func (c *Register) Run(args []string) int {
 	var kontrolURL, username string
+	var noBrowser bool
 	var err error
 
 	flags := flag.NewFlagSet("register", flag.ExitOnError)
 	flags.StringVar(&kontrolURL, "to", defaultKontrolURL, "Kontrol URL")
 	flags.StringVar(&username, "username", "", "Username")
+	flags.BoolVar(&noBrowser, "no-browser", false, "")
 	flags.Parse(args)
 
 	// Open up a prompt
@@ -79,8 +92,30 @@ func (c *Register) Run(args []string) int {
 		c.Ui.Error(err.Error())
 		return 1
 	}
+	defer kontrol.Close()
+
+	authURL := dnode.Callback(func(args *dnode.Partial) {
+		u := args.One().MustString()
+
+		c.Ui.Output("Open the following URL in your browser to approve the registration:\n\n  " + u + "\n")
+
+		if !noBrowser {
+			if err := openBrowser(u); err != nil {
+				c.Ui.Output(fmt.Sprintf("Cannot open the browser: %s", err))
+			}
+		}
+	})
+
+	enrollArgs := map[string]interface{}{
+		"username": username,
+		"authURL":  authURL,
+	}
 
-	result, err := kontrol.TellWithTimeout("registerMachine", 5*time.Minute, username)
+	result, err := kontrol.TellWithTimeout("enrollMachine", 10*time.Minute, enrollArgs)
+	if kiteErr, ok := err.(*kite.Error); ok && kiteErr.Type == "methodNotFound" {
+		// kontrol doesn't support the enrollment
+		result, err = kontrol.TellWithTimeout("registerMachine", 5*time.Minute, username)
+	}
 	if err != nil {
 		c.Ui.Error(err.Error())
 		return 1
@@ -91,7 +126,70 @@ func (c *Register) Run(args []string) int {
 		return 1
 	}
 
-	c.Ui.Info("Registered successfully")
+	registered, err := c.verify(kontrolURL)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Cannot verify the kite.key: %s", err))
+		return 1
+	}
+
+	c.Ui.Info(fmt.Sprintf("Registered successfully as %q", registered))
 
 	return 0
 }
+
+// verify checks the written kite.key file and authenticates with it to the
+// kontrol at kontrolURL. It returns the username in the kite.key.
+func (c *Register) verify(kontrolURL string) (string, error) {
+	keyPath, err := kitekey.ProfilePath(kitekey.Profile())
+	if err != nil {
+		return "", err
+	}
+
+	fi, err := os.Stat(keyPath)
+	if err != nil {
+		return "", err
+	}
+
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("kite.key is accessible by other users: %s", fi.Mode().Perm())
+	}
+
+	key, err := kitekey.Parse()
+	if err != nil {
+		return "", err
+	}
+
+	username, _ := key.Claims["sub"].(string)
+	if username == "" {
+		return "", errors.New("no username in kite.key")
+	}
+
+	kontrol := c.KiteClient.NewClient(kontrolURL)
+	kontrol.Auth = &kite.Auth{
+		Type: "kiteKey",
+		Key:  key.Raw,
+	}
+
+	if err := kontrol.Dial(); err != nil {
+		return "", err
+	}
+	defer kontrol.Close()
+
+	if _, err := kontrol.TellWithTimeout("getKeys", 10*time.Second); err != nil {
+		return "", err
+	}
+
+	return username, nil
+}
+
+// openBrowser opens the URL in the default browser of the user.
+func openBrowser(u string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", u).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", u).Start()
+	default:
+		return exec.Command("xdg-open", u).Start()
+	}
+}
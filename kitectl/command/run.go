@@ -1,6 +1,9 @@
 package command
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -26,30 +29,116 @@ func (c *Run) Synopsis() string {
 
 func (c *Run) Help() string {
 	helpText := `
-Usage: kitectl run kitename
+Usage: kitectl run [options] <kitename or binary> [args...]
 
-  Runs the given kite.
+  Runs the given kite. Kite can be an installed kite or path of a kite
+  binary.
+
+  With any of the options below, the kite is run in the background under a
+  supervisor process. The supervisor writes a pidfile into the run directory
+  in kite home that is used by other kitectl commands.
+
+Options:
+
+  -restart-on-crash  Restart the kite with backoff when it exits with an error.
+  -log-file=path     Append stdout and stderr of the kite to the file.
 `
 	return strings.TrimSpace(helpText)
 }
 
 func (c *Run) Run(args []string) int {
+	var restartOnCrash bool
+	var logFile string
+
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	flags.BoolVar(&restartOnCrash, "restart-on-crash", false, "")
+	flags.StringVar(&logFile, "log-file", "", "")
+	flags.Parse(args)
 
 	// Parse kite name
-	if len(args) == 0 {
+	if flags.NArg() == 0 {
 		c.Ui.Output(c.Help())
 		return 1
 	}
 
-	// User is allowed to enter kite name in these forms: "fs" or "github.com/koding/fs.kite/1.0.0"
-	suppliedName := args[0]
+	kiteArgs := flags.Args()
 
-	installedKites, err := getInstalledKites(suppliedName)
+	name, binPath, err := findKiteBinary(kiteArgs[0])
 	if err != nil {
 		c.Ui.Error(err.Error())
 		return 1
 	}
 
+	if !restartOnCrash && logFile == "" {
+		err = syscall.Exec(binPath, kiteArgs, os.Environ())
+		if err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+
+		return 0
+	}
+
+	if logFile != "" {
+		if logFile, err = filepath.Abs(logFile); err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+	}
+
+	s := &supervisor{
+		name:           name,
+		binary:         binPath,
+		args:           kiteArgs[1:],
+		logFile:        logFile,
+		restartOnCrash: restartOnCrash,
+	}
+
+	// we are the detached process started below
+	if os.Getenv(supervisorEnv) != "" {
+		if err := s.run(); err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+
+		return 0
+	}
+
+	if p, err := ReadPidFile(name); err == nil && p.Running() {
+		c.Ui.Error(fmt.Sprintf("Kite %q is already running with pid %d", name, p.Pid))
+		return 1
+	}
+
+	pid, err := s.daemonize(append([]string{"run"}, args...))
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	c.Ui.Output(fmt.Sprintf("Kite %q is started under supervisor with pid %d", name, pid))
+	return 0
+}
+
+// findKiteBinary returns the name and the binary path of the kite. The kite
+// is either an installed kite in the forms "fs" or
+// "github.com/koding/fs.kite/1.0.0", or a path to the binary of the kite.
+func findKiteBinary(suppliedName string) (name, binPath string, err error) {
+	if strings.ContainsRune(suppliedName, os.PathSeparator) {
+		if fi, err := os.Stat(suppliedName); err == nil && !fi.IsDir() {
+			binPath, err := filepath.Abs(suppliedName)
+			if err != nil {
+				return "", "", err
+			}
+
+			return filepath.Base(binPath), binPath, nil
+		}
+	}
+
+	installedKites, err := getInstalledKites(suppliedName)
+	if err != nil {
+		return "", "", err
+	}
+
 	var matched []*InstalledKite
 
 	for _, ik := range installedKites {
@@ -67,27 +156,20 @@ func (c *Run) Run(args []string) int {
 	}
 
 	if len(matched) == 0 {
-		c.Ui.Error("Kite not found")
-		return 1
+		return "", "", errors.New("Kite not found")
 	}
 
 	if len(matched) > 1 {
-		c.Ui.Error("More than one version is installed. Please give a full kite name as: domain/user/repo/version")
-		return 1
+		return "", "", errors.New("More than one version is installed. Please give a full kite name as: domain/user/repo/version")
 	}
 
 	kiteHome, err := kitekey.KiteHome()
 	if err != nil {
-		c.Ui.Error(err.Error())
-		return 1
+		return "", "", err
 	}
 
-	binPath := filepath.Join(kiteHome, "kites", matched[0].BinPath())
-	err = syscall.Exec(binPath, args, os.Environ())
-	if err != nil {
-		c.Ui.Error(err.Error())
-		return 1
-	}
+	name = strings.TrimSuffix(matched[0].Repo, ".kite")
+	binPath = filepath.Join(kiteHome, "kites", matched[0].BinPath())
 
-	return 0
+	return name, binPath, nil
 }
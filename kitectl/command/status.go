@@ -0,0 +1,154 @@
+package command
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/koding/kite"
+	"github.com/mitchellh/cli"
+)
+
+type Status struct {
+	KiteClient *kite.Kite
+	Ui         cli.Ui
+}
+
+func NewStatus() cli.CommandFactory {
+	return func() (cli.Command, error) {
+		return &Status{
+			KiteClient: DefaultKiteClient,
+			Ui:         DefaultUi,
+		}, nil
+	}
+}
+
+func (c *Status) Synopsis() string {
+	return "Shows the health of a kite"
+}
+
+func (c *Status) Help() string {
+	helpText := `
+Usage: kitectl status [options] <kite-url-or-query>
+
+  Checks whether the kite is alive and prints its version and uptime.
+
+  The kite can be given as a URL or as a kontrol query, as in "kitectl tell".
+  The exit status is non-zero if the kite is not reachable.
+
+Options:
+
+  -timeout=4s  Timeout of the calls to the kite.
+  -json        Print the status as JSON.
+`
+	return strings.TrimSpace(helpText)
+}
+
+// kiteStatus is printed by the status command.
+type kiteStatus struct {
+	URL     string       `json:"url"`
+	Alive   bool         `json:"alive"`
+	Latency float64      `json:"latency"` // of the ping, in seconds
+	Status  *kite.Status `json:"status,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+func (c *Status) Run(args []string) int {
+	var timeout time.Duration
+	var asJSON bool
+
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	flags.DurationVar(&timeout, "timeout", 4*time.Second, "")
+	flags.BoolVar(&asJSON, "json", false, "")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		c.Ui.Output(c.Help())
+		return 1
+	}
+
+	status := c.status(flags.Arg(0), timeout)
+
+	if asJSON {
+		data, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			c.Ui.Error(err.Error())
+			return 1
+		}
+
+		c.Ui.Output(string(data))
+	} else {
+		c.print(status)
+	}
+
+	if !status.Alive {
+		return 1
+	}
+
+	return 0
+}
+
+// status pings the kite and gets its status.
+func (c *Status) status(to string, timeout time.Duration) *kiteStatus {
+	s := &kiteStatus{URL: to}
+
+	remote, err := remoteKite(c.KiteClient, to)
+	if err != nil {
+		s.Error = err.Error()
+		return s
+	}
+
+	s.URL = remote.URL
+
+	if err := remote.DialTimeout(timeout); err != nil {
+		s.Error = err.Error()
+		return s
+	}
+	defer remote.Close()
+
+	start := time.Now()
+	if _, err := remote.TellWithTimeout("kite.ping", timeout); err != nil {
+		s.Error = err.Error()
+		return s
+	}
+
+	s.Alive = true
+	s.Latency = time.Since(start).Seconds()
+
+	// older kites do not have the kite.status method
+	result, err := remote.TellWithTimeout("kite.status", timeout)
+	if err != nil {
+		s.Error = err.Error()
+		return s
+	}
+
+	if err := result.Unmarshal(&s.Status); err != nil {
+		s.Error = err.Error()
+	}
+
+	return s
+}
+
+func (c *Status) print(s *kiteStatus) {
+	if !s.Alive {
+		c.Ui.Error(fmt.Sprintf("%s is not reachable: %s", s.URL, s.Error))
+		return
+	}
+
+	c.Ui.Output(fmt.Sprintf("URL:      %s", s.URL))
+	c.Ui.Output(fmt.Sprintf("Latency:  %s", time.Duration(s.Latency*float64(time.Second))))
+
+	if s.Status == nil {
+		c.Ui.Output(fmt.Sprintf("Status:   not available (%s)", s.Error))
+		return
+	}
+
+	uptime := time.Duration(s.Status.Uptime) * time.Second
+
+	c.Ui.Output(fmt.Sprintf("Kite:     %s", s.Status.Kite))
+	c.Ui.Output(fmt.Sprintf("Version:  %s", s.Status.Kite.Version))
+	c.Ui.Output(fmt.Sprintf("Uptime:   %s", uptime))
+	c.Ui.Output(fmt.Sprintf("Clients:  %d", s.Status.Clients))
+}
@@ -0,0 +1,141 @@
+package command
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// supervisorEnv is set in the environment of the detached supervisor process
+// started by "kitectl run".
+const supervisorEnv = "KITECTL_SUPERVISOR"
+
+// stableDuration is the duration a kite must run without crashing for the
+// restart backoff to be reset.
+const stableDuration = time.Minute
+
+// supervisor runs a kite binary, captures its output and restarts it when
+// it crashes.
+type supervisor struct {
+	name           string
+	binary         string
+	args           []string
+	logFile        string
+	restartOnCrash bool
+}
+
+// daemonize starts the current command again as a detached process that
+// supervises the kite. It returns the pid of the new process.
+func (s *supervisor) daemonize(args []string) (int, error) {
+	self, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	cmd := exec.Command(self, args...)
+	cmd.Env = append(os.Environ(), supervisorEnv+"=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true} // detach from terminal
+
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	return cmd.Process.Pid, cmd.Process.Release()
+}
+
+// run runs the kite until it exits successfully, it crashes and restarting is
+// disabled, or the supervisor is signaled to stop.
+func (s *supervisor) run() error {
+	output := io.Writer(ioutil.Discard)
+	if s.logFile != "" {
+		if err := os.MkdirAll(filepath.Dir(s.logFile), 0755); err != nil {
+			return err
+		}
+
+		f, err := os.OpenFile(s.logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		output = f
+	}
+
+	pidFile := &PidFile{
+		Name:          s.name,
+		SupervisorPid: os.Getpid(),
+		Binary:        s.binary,
+		Args:          s.args,
+		LogFile:       s.logFile,
+	}
+	defer pidFile.Remove()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = 0 // never give up
+
+	for {
+		cmd := exec.Command(s.binary, s.args...)
+		cmd.Stdout = output
+		cmd.Stderr = output
+
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+
+		pidFile.Pid = cmd.Process.Pid
+		pidFile.StartedAt = time.Now().UTC()
+		if err := pidFile.Write(); err != nil {
+			cmd.Process.Kill()
+			return err
+		}
+
+		exited := make(chan error, 1)
+		go func() { exited <- cmd.Wait() }()
+
+		var err error
+		select {
+		case sig := <-signals:
+			cmd.Process.Signal(sig)
+			<-exited
+			return nil
+		case err = <-exited:
+		}
+
+		if err == nil {
+			return nil // exited successfully, nothing to restart
+		}
+
+		if !s.restartOnCrash {
+			return err
+		}
+
+		if time.Since(pidFile.StartedAt) > stableDuration {
+			b.Reset()
+		}
+
+		wait := b.NextBackOff()
+		if wait == backoff.Stop {
+			return errors.New("giving up restarting the kite")
+		}
+
+		select {
+		case <-signals:
+			return nil
+		case <-time.After(wait):
+		}
+
+		pidFile.Restarts++
+	}
+}
@@ -1,13 +1,17 @@
 package command
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
-	"strconv"
+	"fmt"
 	"strings"
 	"time"
 
 	"github.com/koding/kite"
+	"github.com/koding/kite/config"
 	"github.com/koding/kite/kitekey"
+	"github.com/koding/kite/protocol"
 	"github.com/mitchellh/cli"
 )
 
@@ -31,15 +35,24 @@ func (c *Tell) Synopsis() string {
 
 func (c *Tell) Help() string {
 	helpText := `
-Usage: kitectl tell [options]
+Usage: kitectl tell [options] <kite-url-or-query> <method> [json-args...]
 
-  Calls a method on a kite.
+  Calls a method on a kite and prints the JSON result.
+
+  The kite can be given as a URL or as a kontrol query in the form of
+  "/username/environment/name/version/region/hostname/id". Trailing fields of
+  the query can be omitted. When a query is given, the first matching kite is
+  called with the token received from kontrol. Otherwise kite.key is used for
+  authentication.
+
+  Each argument is parsed as JSON. Arguments that are not valid JSON are sent
+  as strings.
 
 Options:
 
   -to=URL          URL of the remote kite
   -method=divide   Method name to be invoked
-  -timeout=4       Timeout in seconds.
+  -timeout=4s      Timeout of the call.
 `
 	return strings.TrimSpace(helpText)
 }
@@ -55,36 +68,41 @@ func (c *Tell) Run(args []string) int {
 	flags.DurationVar(&timeout, "timeout", 4*time.Second, "timeout of tell method")
 	flags.Parse(args)
 
+	methodArgs := flags.Args()
+
+	if to == "" && len(methodArgs) > 0 {
+		to, methodArgs = methodArgs[0], methodArgs[1:]
+	}
+
+	if method == "" && len(methodArgs) > 0 {
+		method, methodArgs = methodArgs[0], methodArgs[1:]
+	}
+
 	if to == "" || method == "" {
 		c.Ui.Output(c.Help())
 		return 1
 	}
 
-	key, err := kitekey.Read()
+	remote, err := c.remote(to)
 	if err != nil {
 		c.Ui.Error(err.Error())
 		return 1
 	}
 
-	remote := c.KiteClient.NewClient(to)
-	remote.Auth = &kite.Auth{
-		Type: "kiteKey",
-		Key:  key,
-	}
-
 	if err = remote.Dial(); err != nil {
 		c.Ui.Error(err.Error())
 		return 1
 	}
+	defer remote.Close()
 
 	// Convert args to []interface{} in order to pass it to Tell() method.
-	methodArgs := flags.Args()
 	params := make([]interface{}, len(methodArgs))
 	for i, arg := range methodArgs {
-		if number, err := strconv.Atoi(arg); err != nil {
+		var v interface{}
+		if err := json.Unmarshal([]byte(arg), &v); err != nil {
 			params[i] = arg
 		} else {
-			params[i] = number
+			params[i] = v
 		}
 	}
 
@@ -95,10 +113,60 @@ func (c *Tell) Run(args []string) int {
 	}
 
 	if result == nil {
-		c.Ui.Info("nil")
-	} else {
+		c.Ui.Info("null")
+		return 0
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, result.Raw, "", "  "); err != nil {
 		c.Ui.Info(string(result.Raw))
+	} else {
+		c.Ui.Info(out.String())
 	}
 
 	return 0
 }
+
+// remote returns a client for the kite at the given URL or the first kite
+// that matches the given kontrol query.
+func (c *Tell) remote(to string) (*kite.Client, error) {
+	return remoteKite(c.KiteClient, to)
+}
+
+// remoteKite returns a client of k for the kite at the given URL or the first
+// kite that matches the given kontrol query.
+func remoteKite(k *kite.Kite, to string) (*kite.Client, error) {
+	if !strings.HasPrefix(to, "/") {
+		key, err := kitekey.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		remote := k.NewClient(to)
+		remote.Auth = &kite.Auth{
+			Type: "kiteKey",
+			Key:  key,
+		}
+
+		return remote, nil
+	}
+
+	if strings.Count(strings.Trim(to, "/"), "/") > 6 {
+		return nil, fmt.Errorf("invalid kite query: %s", to)
+	}
+
+	query, err := protocol.KiteFromString(to)
+	if err != nil {
+		return nil, err
+	}
+
+	k.Config = config.MustGet()
+	k.Config.Transport = config.XHRPolling
+
+	clients, err := k.GetKites(query.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	return clients[0], nil
+}
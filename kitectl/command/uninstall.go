@@ -31,6 +31,7 @@ func (c *Uninstall) Help() string {
 Usage: kitectl uninstall kitename
 
   Uninstall the given kite. Example kitename: github.com/koding/fs.kite/1.0.0
+  All versions of the kite are uninstalled if the version is not given.
 `
 	return strings.TrimSpace(helpText)
 }
@@ -66,6 +67,11 @@ func (c *Uninstall) Run(args []string) int {
 		return 1
 	}
 
+	// remove the directory of the kite if no other version is left, it
+	// fails if the directory is not empty.
+	os.Remove(filepath.Dir(bundlePath))
+
+	c.Ui.Output(fmt.Sprintf("Uninstalled successfully: %s", fullName))
 	return 0
 }
 
@@ -21,6 +21,7 @@ func main() {
 		"tell":      command.NewTell(),
 		"uninstall": command.NewUninstall(),
 		"list":      command.NewList(),
+		"status":    command.NewStatus(),
 		"install":   command.NewInstall(),
 	}
 
@@ -2,6 +2,7 @@
 package kitekey
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
 	"os/user"
@@ -9,13 +10,19 @@ import (
 	"strings"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/koding/kite/jwtkeys"
 )
 
 const (
 	kiteDirName     = ".kite"
 	kiteKeyFileName = "kite.key"
+	profilesDirName = "profiles"
 )
 
+// ErrInvalidProfile is returned for the profile names that can't be used as
+// a directory name.
+var ErrInvalidProfile = errors.New("kitekey: invalid profile name")
+
 // KiteHome returns the home path of Kite directory.
 // The returned value can be overriden by setting KITE_HOME environment variable.
 func KiteHome() (string, error) {
@@ -30,17 +37,70 @@ func KiteHome() (string, error) {
 	return filepath.Join(usr.HomeDir, kiteDirName), nil
 }
 
-func kiteKeyPath() (string, error) {
+// Profile returns the name of the profile selected with the KITE_PROFILE
+// environment variable. Empty means the default kite.key.
+func Profile() string {
+	return os.Getenv("KITE_PROFILE")
+}
+
+// ProfilePath returns the path of the kite.key file of the profile, which is
+// "profiles/<name>/kite.key" in kite home. The path of the default kite.key
+// is returned if the name is empty.
+func ProfilePath(name string) (string, error) {
 	kiteHome, err := KiteHome()
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(kiteHome, kiteKeyFileName), nil
+
+	if name == "" {
+		return filepath.Join(kiteHome, kiteKeyFileName), nil
+	}
+
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", ErrInvalidProfile
+	}
+
+	return filepath.Join(kiteHome, profilesDirName, name, kiteKeyFileName), nil
+}
+
+// Profiles returns the names of the profiles that have a kite.key file.
+func Profiles() ([]string, error) {
+	kiteHome, err := KiteHome()
+	if err != nil {
+		return nil, err
+	}
+
+	dirs, err := ioutil.ReadDir(filepath.Join(kiteHome, profilesDirName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(kiteHome, profilesDirName, dir.Name(), kiteKeyFileName)); err == nil {
+			names = append(names, dir.Name())
+		}
+	}
+
+	return names, nil
+}
+
+func kiteKeyPath() (string, error) {
+	return ProfilePath(Profile())
 }
 
-// Read the contents of the kite.key file.
+// Read the contents of the kite.key file. The kite.key of the profile in
+// KITE_PROFILE environment variable is read if it's set.
 func Read() (string, error) {
-	keyPath, err := kiteKeyPath()
+	return ReadProfile(Profile())
+}
+
+// ReadProfile reads the contents of the kite.key file of the profile.
+func ReadProfile(name string) (string, error) {
+	keyPath, err := ProfilePath(name)
 	if err != nil {
 		return "", err
 	}
@@ -51,9 +111,15 @@ func Read() (string, error) {
 	return strings.TrimSpace(string(data)), nil
 }
 
-// Write over the kite.key file.
+// Write over the kite.key file. The kite.key of the profile in KITE_PROFILE
+// environment variable is written if it's set.
 func Write(kiteKey string) error {
-	keyPath, err := kiteKeyPath()
+	return WriteProfile(Profile(), kiteKey)
+}
+
+// WriteProfile writes over the kite.key file of the profile.
+func WriteProfile(name, kiteKey string) error {
+	keyPath, err := ProfilePath(name)
 	if err != nil {
 		return err
 	}
@@ -72,7 +138,12 @@ func Write(kiteKey string) error {
 
 // Parse the kite.key file and return it as JWT token.
 func Parse() (*jwt.Token, error) {
-	kiteKey, err := Read()
+	return ParseProfile(Profile())
+}
+
+// ParseProfile parses the kite.key file of the profile.
+func ParseProfile(name string) (*jwt.Token, error) {
+	kiteKey, err := ReadProfile(name)
 	if err != nil {
 		return nil, err
 	}
@@ -80,7 +151,13 @@ func Parse() (*jwt.Token, error) {
 	return jwt.Parse(kiteKey, GetKontrolKey)
 }
 
-// GetKontrolKey is used as key getter func for jwt.Parse() function.
+// GetKontrolKey is used as key getter func for jwt.Parse() function. The key
+// is the kontrol key in the token, which can be an RSA, ECDSA or Ed25519 key.
 func GetKontrolKey(token *jwt.Token) (interface{}, error) {
-	return []byte(token.Claims["kontrolKey"].(string)), nil
+	key, ok := token.Claims["kontrolKey"].(string)
+	if !ok {
+		return nil, errors.New("kontrol key is not present in kite key")
+	}
+
+	return jwtkeys.VerificationKey(token.Method, key)
 }
@@ -0,0 +1,55 @@
+package kitekey
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kitekey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("KITE_HOME", dir)
+	defer os.Unsetenv("KITE_HOME")
+
+	if err := Write("default"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteProfile("staging", "staging"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "profiles", "staging", "kite.key")); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("KITE_PROFILE", "staging")
+	defer os.Unsetenv("KITE_PROFILE")
+
+	if key, err := Read(); err != nil || key != "staging" {
+		t.Errorf("got %q, %v; want the staging key", key, err)
+	}
+
+	if key, err := ReadProfile(""); err != nil || key != "default" {
+		t.Errorf("got %q, %v; want the default key", key, err)
+	}
+
+	profiles, err := Profiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(profiles) != 1 || profiles[0] != "staging" {
+		t.Errorf("got profiles %v", profiles)
+	}
+
+	if _, err := ProfilePath("../production"); err != ErrInvalidProfile {
+		t.Errorf("got %v, want: %v", err, ErrInvalidProfile)
+	}
+}
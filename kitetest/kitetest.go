@@ -0,0 +1,195 @@
+// Package kitetest provides helpers for testing kites. It can run a kontrol
+// with in-memory storage, start kites on random ports and connect kites to
+// each other in-process, without any network connection.
+package kitetest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/config"
+	"github.com/koding/kite/kontrol"
+	"github.com/koding/kite/sockjsclient"
+	"github.com/koding/kite/testkeys"
+	"github.com/koding/kite/testutil"
+)
+
+// Kontrol is a kontrol server with in-memory storage. It uses the keys in
+// testkeys package for signing tokens.
+type Kontrol struct {
+	*kontrol.Kontrol
+
+	// URL is the URL of the kontrol kite.
+	URL string
+}
+
+// NewKontrol starts a new Kontrol on a random port and waits until it's
+// ready to accept connections.
+func NewKontrol() (*Kontrol, error) {
+	port, err := FreePort()
+	if err != nil {
+		return nil, err
+	}
+
+	kontrolURL := fmt.Sprintf("http://127.0.0.1:%d/kite", port)
+
+	conf := testutil.NewConfig()
+	conf.KontrolURL = kontrolURL
+	conf.Port = port
+
+	k := kontrol.New(conf, "0.0.1", testkeys.Public, testkeys.Private)
+	k.SetStorage(kontrol.NewMemoryStorage())
+
+	go k.Run()
+	<-k.Kite.ServerReadyNotify()
+
+	return &Kontrol{Kontrol: k, URL: kontrolURL}, nil
+}
+
+// Config returns a new config for kites that use this kontrol.
+func (k *Kontrol) Config() *config.Config {
+	conf := testutil.NewConfig()
+	conf.KontrolURL = k.URL
+	return conf
+}
+
+// NewKite returns a new kite that uses this kontrol.
+func (k *Kontrol) NewKite(name, version string) *kite.Kite {
+	kt := kite.New(name, version)
+	kt.Config = k.Config()
+	return kt
+}
+
+// Register starts the kite on a random port and registers it to kontrol.
+func (k *Kontrol) Register(kt *kite.Kite) error {
+	kiteURL, err := Start(kt)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(kiteURL)
+	if err != nil {
+		return err
+	}
+
+	_, err = kt.Register(u)
+	return err
+}
+
+// FreePort returns a TCP port that is free to listen on localhost.
+func FreePort() (int, error) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Start runs the kite on a random port if its port is not set and waits
+// until it's ready to accept connections. It returns the URL of the kite.
+func Start(k *kite.Kite) (string, error) {
+	if k.Config.Port == 0 {
+		port, err := FreePort()
+		if err != nil {
+			return "", err
+		}
+
+		k.Config.Port = port
+	}
+
+	if k.Config.IP == "" {
+		k.Config.IP = "127.0.0.1"
+	}
+
+	go k.Run()
+	<-k.ServerReadyNotify()
+
+	return fmt.Sprintf("http://%s/kite", k.Addr()), nil
+}
+
+// Connect returns a client of local that is connected to remote in-process,
+// through a Pipe. The kite key of local is used for authentication if it's
+// set in its config.
+func Connect(local, remote *kite.Kite) (*kite.Client, error) {
+	c := local.NewClient("")
+	c.Kite = *remote.Kite()
+
+	if local.Config.KiteKey != "" {
+		c.Auth = &kite.Auth{
+			Type: "kiteKey",
+			Key:  local.Config.KiteKey,
+		}
+	}
+
+	c.Dialer = func(_ *sockjsclient.DialOptions) (kite.Transport, error) {
+		l, r := Pipe()
+		go remote.ServeTransport(r)
+		return l, nil
+	}
+
+	if err := c.Dial(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ErrPipeClosed is returned from the Pipe transports after they are closed.
+var ErrPipeClosed = errors.New("pipe is closed")
+
+// Pipe returns a pair of connected in-memory Transports. Messages sent from
+// one are received by the other. Closing either of them closes both.
+func Pipe() (kite.Transport, kite.Transport) {
+	a := make(chan string)
+	b := make(chan string)
+
+	p := &pipe{closed: make(chan struct{})}
+	id := fmt.Sprintf("pipe-%p", p)
+
+	return &pipeEnd{pipe: p, id: id + "-0", recv: a, send: b},
+		&pipeEnd{pipe: p, id: id + "-1", recv: b, send: a}
+}
+
+// pipe is the state shared by both ends of a Pipe.
+type pipe struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+type pipeEnd struct {
+	*pipe
+	id   string
+	recv <-chan string
+	send chan<- string
+}
+
+func (p *pipeEnd) ID() string { return p.id }
+
+func (p *pipeEnd) Send(msg string) error {
+	select {
+	case p.send <- msg:
+		return nil
+	case <-p.closed:
+		return ErrPipeClosed
+	}
+}
+
+func (p *pipeEnd) Recv() (string, error) {
+	select {
+	case msg := <-p.recv:
+		return msg, nil
+	case <-p.closed:
+		return "", ErrPipeClosed
+	}
+}
+
+func (p *pipeEnd) Close(status uint32, reason string) error {
+	p.once.Do(func() { close(p.closed) })
+	return nil
+}
@@ -0,0 +1,33 @@
+package kitetest
+
+import (
+	"testing"
+
+	"github.com/koding/kite"
+)
+
+func TestConnect(t *testing.T) {
+	remote := kite.New("remote", "0.0.1")
+	remote.Config.DisableAuthentication = true
+	remote.HandleFunc("square", func(r *kite.Request) (interface{}, error) {
+		a := r.Args.One().MustFloat64()
+		return a * a, nil
+	})
+
+	local := kite.New("local", "0.0.1")
+
+	c, err := Connect(local, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var result float64
+	if err := c.Call("square", 4, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if result != 16 {
+		t.Fatalf("got %v, want: 16", result)
+	}
+}
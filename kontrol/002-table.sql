@@ -14,6 +14,8 @@ CREATE UNLOGGED TABLE "kite"."kite" (
     hostname TEXT NOT NULL,
     id uuid PRIMARY KEY,
     url TEXT NOT NULL,
+    labels jsonb NOT NULL DEFAULT '{}',
+    urls jsonb NOT NULL DEFAULT '{}',
     created_at timestamptz NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'), -- you may set a global timezone
     updated_at timestamptz NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC')
 );
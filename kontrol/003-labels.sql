@@ -0,0 +1,3 @@
+-- Adds the labels column to a kite table created before the labels are
+-- introduced. It's not needed for a fresh start.
+ALTER TABLE "kite"."kite" ADD COLUMN IF NOT EXISTS labels jsonb NOT NULL DEFAULT '{}';
@@ -0,0 +1,3 @@
+-- Adds the urls column for the network URLs of the kites to a kite table
+-- created before. It's not needed for a fresh start.
+ALTER TABLE "kite"."kite" ADD COLUMN IF NOT EXISTS urls jsonb NOT NULL DEFAULT '{}';
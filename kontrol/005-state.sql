@@ -0,0 +1,10 @@
+-- Adds the state table, which keeps the revoked tokens, the blocklist and the
+-- signing keys shared by the kontrol instances in stateless mode. Unlike the
+-- kite table it's logged, the state must survive the crashes.
+CREATE TABLE IF NOT EXISTS "kite"."state" (
+    key TEXT PRIMARY KEY,
+    value BYTEA NOT NULL
+);
+
+-- add proper permissions for table
+GRANT SELECT, INSERT, UPDATE ON "kite"."state" TO "kontrol";
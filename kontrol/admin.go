@@ -0,0 +1,210 @@
+package kontrol
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/protocol"
+)
+
+// AdminKite is a registered kite in the result of the "listAllKites" method.
+type AdminKite struct {
+	Kite     protocol.Kite `json:"kite"`
+	URL      string        `json:"url"`
+	LastSeen time.Time     `json:"lastSeen,omitempty"` // zero if it's registered to another kontrol
+}
+
+// Stats is the result of the "stats" method.
+type Stats struct {
+	Kites          int           `json:"kites"` // -1 if the storage can't list the kites
+	HTTPHeartbeats int           `json:"httpHeartbeats"`
+	Watchers       int           `json:"watchers"`
+	RevokedTokens  int           `json:"revokedTokens"`
+	Uptime         time.Duration `json:"uptime"`
+}
+
+// seen records that the kite with the id is registered or has sent a
+// heartbeat.
+func (k *Kontrol) seen(id string) {
+	k.lastSeenMu.Lock()
+	k.lastSeen[id] = time.Now().UTC()
+	k.lastSeenMu.Unlock()
+}
+
+// isAdmin returns true if the user is the owner of kontrol or one of Admins.
+func (k *Kontrol) isAdmin(username string) bool {
+	if username == k.Kite.Kite().Username {
+		return true
+	}
+
+	for _, admin := range k.Admins {
+		if username == admin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorizeAdmin allows only the admins to call the method.
+func (k *Kontrol) authorizeAdmin(r *kite.Request) error {
+	if !k.isAdmin(r.Username) {
+		return fmt.Errorf("user %q is not an admin", r.Username)
+	}
+
+	return nil
+}
+
+// AllKites returns all registered kites. It returns an error if the storage
+// doesn't implement Lister.
+func (k *Kontrol) AllKites() ([]*AdminKite, error) {
+	lister, ok := k.backend().(Lister)
+	if !ok {
+		return nil, errors.New("storage can't list all kites")
+	}
+
+	kites, err := lister.List()
+	if err != nil {
+		return nil, err
+	}
+
+	k.lastSeenMu.Lock()
+	defer k.lastSeenMu.Unlock()
+
+	result := make([]*AdminKite, len(kites))
+	for i, kite := range kites {
+		result[i] = &AdminKite{
+			Kite:     kite.Kite,
+			URL:      kite.URL,
+			LastSeen: k.lastSeen[kite.Kite.ID],
+		}
+	}
+
+	sort.Sort(byKite(result))
+
+	return result, nil
+}
+
+// handleListAllKites returns all registered kites regardless of their
+// usernames.
+func (k *Kontrol) handleListAllKites(r *kite.Request) (interface{}, error) {
+	return k.AllKites()
+}
+
+// handleForceDeregister deletes the kite with the id given as the only
+// argument from the storage. Note that a running kite registers again with
+// its next heartbeat.
+func (k *Kontrol) handleForceDeregister(r *kite.Request) (interface{}, error) {
+	id, err := r.Args.One().String()
+	if err != nil || id == "" {
+		return nil, errors.New("Invalid kite id")
+	}
+
+	kites, err := k.AllKites()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, kite := range kites {
+		if kite.Kite.ID != id {
+			continue
+		}
+
+		k.log.Info("Kite is deregistered by %s: %s", r.Username, &kite.Kite)
+		k.remove(&kite.Kite)
+		count(&k.metrics.deregistrations)
+		k.notifyWebhooks(protocol.Deregister, &kite.Kite, "")
+		return nil, nil
+	}
+
+	return nil, ErrKiteNotFound
+}
+
+// handleStats returns the statistics of kontrol.
+func (k *Kontrol) handleStats(r *kite.Request) (interface{}, error) {
+	stats := &Stats{
+		Kites:         -1,
+		RevokedTokens: len(k.revokedTokens()),
+		Uptime:        time.Since(k.startedAt),
+	}
+
+	if kites, err := k.AllKites(); err == nil {
+		stats.Kites = len(kites)
+	}
+
+	k.heartbeatsMu.Lock()
+	stats.HTTPHeartbeats = len(k.heartbeats)
+	k.heartbeatsMu.Unlock()
+
+	k.watchersMu.Lock()
+	stats.Watchers = len(k.watchers)
+	k.watchersMu.Unlock()
+
+	return stats, nil
+}
+
+// byKite sorts the kites by their usernames, names, versions and ids.
+type byKite []*AdminKite
+
+func (b byKite) Len() int      { return len(b) }
+func (b byKite) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byKite) Less(i, j int) bool {
+	return b[i].Kite.String() < b[j].Kite.String()
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"since": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return time.Since(t).Truncate(time.Second).String() + " ago"
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>kontrol</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.3em 1em; border-bottom: 1px solid #ddd; text-align: left; }
+</style>
+</head>
+<body>
+<h1>kontrol</h1>
+{{if .Error}}<p>{{.Error}}</p>{{else}}
+<p>{{len .Kites}} kites</p>
+<table>
+<tr><th>Username</th><th>Environment</th><th>Name</th><th>Version</th><th>Region</th><th>Hostname</th><th>ID</th><th>URL</th><th>Last seen</th></tr>
+{{range .Kites}}<tr><td>{{.Kite.Username}}</td><td>{{.Kite.Environment}}</td><td>{{.Kite.Name}}</td><td>{{.Kite.Version}}</td><td>{{.Kite.Region}}</td><td>{{.Kite.Hostname}}</td><td>{{.Kite.ID}}</td><td>{{.URL}}</td><td>{{since .LastSeen}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// DashboardHandler returns an http.Handler that shows the registered kites in
+// an HTML page. It's not authenticated, it must be served behind the
+// authentication of the application, like:
+//
+//	k.Kite.HandleHTTP("/dashboard", requireLogin(k.DashboardHandler()))
+func (k *Kontrol) DashboardHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var data struct {
+			Kites []*AdminKite
+			Error error
+		}
+
+		data.Kites, data.Error = k.AllKites()
+
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := dashboardTemplate.Execute(rw, &data); err != nil {
+			k.log.Error("dashboard error: %s", err)
+		}
+	})
+}
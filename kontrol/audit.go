@@ -0,0 +1,113 @@
+package kontrol
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/protocol"
+)
+
+// AuditEntry is a record of the audit log of kontrol. Every register call and
+// every token issued by kontrol is recorded.
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`               // "register", "getKites", "getToken" or "renewToken"
+	Username   string    `json:"username"`             // authenticated username of the caller
+	Kite       string    `json:"kite"`                 // caller kite
+	KiteKeyID  string    `json:"kiteKeyID,omitempty"`  // id of the kite key of the caller, if known
+	RemoteAddr string    `json:"remoteAddr,omitempty"` // remote address of the caller, if known
+	Audience   string    `json:"audience,omitempty"`   // audience of the issued token
+	URL        string    `json:"url,omitempty"`        // registered URL
+	Error      string    `json:"error,omitempty"`      // message of the error if the call has failed
+}
+
+// AuditSink receives the entries of the audit log. The entries that can't be
+// recorded are logged by kontrol with the returned error.
+type AuditSink interface {
+	Audit(e *AuditEntry) error
+}
+
+// AuditSinkFunc is a type adapter to allow the use of ordinary functions as
+// AuditSink.
+type AuditSinkFunc func(e *AuditEntry) error
+
+// Audit calls f(e)
+func (f AuditSinkFunc) Audit(e *AuditEntry) error {
+	return f(e)
+}
+
+// NewJSONAuditSink returns an AuditSink that writes every entry to w as a
+// JSON object in a single line. Open the files with os.O_APPEND to keep the
+// log append-only.
+func NewJSONAuditSink(w io.Writer) AuditSink {
+	return &jsonAuditSink{enc: json.NewEncoder(w)}
+}
+
+type jsonAuditSink struct {
+	enc *json.Encoder
+	mu  sync.Mutex // serializes writes
+}
+
+func (s *jsonAuditSink) Audit(e *AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.enc.Encode(e)
+}
+
+// audit sends the entry to the audit log, if there is any.
+func (k *Kontrol) audit(e *AuditEntry, err error) {
+	if k.AuditLog == nil {
+		return
+	}
+
+	e.Time = time.Now().UTC()
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	if err := k.AuditLog.Audit(e); err != nil {
+		k.log.Error("cannot write the %s entry of %s to the audit log: %s", e.Action, e.Username, err)
+	}
+}
+
+// auditRequest records the call of the method to the audit log.
+func (k *Kontrol) auditRequest(r *kite.Request, audience, url string, err error) {
+	e := auditEntry(r)
+	e.Audience = audience
+	e.URL = url
+
+	k.audit(e, err)
+}
+
+// auditEntry returns the audit log entry of the call of the method.
+func auditEntry(r *kite.Request) *AuditEntry {
+	return &AuditEntry{
+		Action:     r.Method,
+		Username:   r.Username,
+		Kite:       r.Client.Kite.String(),
+		KiteKeyID:  r.KiteKeyID,
+		RemoteAddr: r.Client.RemoteAddr(),
+	}
+}
+
+// auditHTTP records the register request received via HTTP to the audit log.
+// The username is empty if the kite key can't be authenticated.
+func (k *Kontrol) auditHTTP(req *http.Request, args *protocol.RegisterArgs, username string, err error) {
+	e := &AuditEntry{
+		Action:     "register",
+		Username:   username,
+		RemoteAddr: req.RemoteAddr,
+		URL:        args.URL,
+	}
+
+	if args.Kite != nil {
+		e.Kite = args.Kite.String()
+	}
+
+	k.audit(e, err)
+}
@@ -0,0 +1,110 @@
+package kontrol
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/protocol"
+)
+
+func TestAuditLog(t *testing.T) {
+	var (
+		entries []*AuditEntry
+		mu      sync.Mutex
+	)
+
+	kon.AuditLog = AuditSinkFunc(func(e *AuditEntry) error {
+		mu.Lock()
+		entries = append(entries, e)
+		mu.Unlock()
+		return nil
+	})
+	defer func() { kon.AuditLog = nil }()
+
+	m := kite.New("auditkite", "1.0.0")
+	m.Config = conf.Copy()
+	defer m.Close()
+
+	kiteURL := &url.URL{Scheme: "http", Host: "localhost:4452", Path: "/kite"}
+	if _, err := m.Register(kiteURL); err != nil {
+		t.Fatal(err)
+	}
+
+	query := &protocol.KontrolQuery{
+		Username:    conf.Username,
+		Environment: conf.Environment,
+		Name:        "auditkite",
+	}
+
+	exp := kite.New("exp-audit", "0.0.1")
+	exp.Config = conf.Copy()
+
+	if _, err := exp.GetKites(query); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var register, getKites *AuditEntry
+	for _, e := range entries {
+		switch {
+		case e.Action == "register" && e.Kite == m.Kite().String():
+			register = e
+		case e.Action == "getKites" && e.Kite == exp.Kite().String():
+			getKites = e
+		}
+	}
+
+	if register == nil || register.URL != kiteURL.String() || register.Username != conf.Username {
+		t.Errorf("got register entry %+v", register)
+	}
+
+	if getKites == nil || getKites.Audience != getAudience(query) || getKites.Error != "" {
+		t.Errorf("got getKites entry %+v", getKites)
+	}
+}
+
+func TestJSONAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewJSONAuditSink(&buf)
+
+	for _, e := range []*AuditEntry{
+		{Action: "getToken", Username: "alice", Audience: "/alice"},
+		{Action: "register", Username: "bob", Error: "denied"},
+	} {
+		if err := sink.Audit(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dec := json.NewDecoder(&buf)
+	for _, want := range []string{"alice", "bob"} {
+		var e AuditEntry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+
+		if e.Username != want {
+			t.Errorf("got username %q, want: %q", e.Username, want)
+		}
+	}
+
+	// the write errors are returned
+	f, err := ioutil.TempFile("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	if err := NewJSONAuditSink(f).Audit(&AuditEntry{Action: "getToken"}); err == nil {
+		t.Error("error is not returned after writing to a closed file")
+	}
+}
@@ -0,0 +1,123 @@
+package kontrol
+
+import (
+	"errors"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/protocol"
+)
+
+// handleBlock adds the kite ids, the kite key ids and the usernames given as
+// the only argument to the blocklist, like {"kites": ["id"], "keys": ["jti"],
+// "users": ["name"]}. The kites with the ids are deregistered and can't
+// register again. The blocked kite keys and users are disconnected, their
+// requests to kontrol are refused and the new blocklist is pushed to the
+// connected kites, which refuse them too. It's meant for the compromised
+// machines, whose kite keys must be blocked, the kite ids are chosen by the
+// kites.
+func (k *Kontrol) handleBlock(r *kite.Request) (interface{}, error) {
+	var args protocol.Blocklist
+	if err := r.Args.One().Unmarshal(&args); err != nil || len(args.Kites)+len(args.Keys)+len(args.Users) == 0 {
+		return nil, errors.New("Invalid blocklist")
+	}
+
+	k.blocklistMu.Lock()
+	b := k.Kite.Blocklist()
+	b.Kites = append(b.Kites, args.Kites...)
+	b.Keys = append(b.Keys, args.Keys...)
+	b.Users = append(b.Users, args.Users...)
+	k.Kite.SetBlocklist(b)
+	k.blocklistMu.Unlock()
+
+	err := k.updateState(func(s *sharedState) {
+		s.Blocklist.Kites = append(s.Blocklist.Kites, args.Kites...)
+		s.Blocklist.Keys = append(s.Blocklist.Keys, args.Keys...)
+		s.Blocklist.Users = append(s.Blocklist.Users, args.Users...)
+	})
+	if err != nil {
+		k.log.Error("cannot share the blocklist: %s", err)
+		return nil, errors.New("internal error - block")
+	}
+
+	k.log.Info("Kites %v, kite keys %v and users %v are blocked by %s", args.Kites, args.Keys, args.Users, r.Username)
+
+	var queries []*protocol.KontrolQuery
+	for _, id := range args.Kites {
+		queries = append(queries, &protocol.KontrolQuery{ID: id})
+	}
+	for _, username := range args.Users {
+		queries = append(queries, &protocol.KontrolQuery{Username: username})
+	}
+
+	for _, query := range queries {
+		kites, err := k.storage.Get(query)
+		if err != nil && err != ErrKiteNotFound {
+			k.log.Error("storage get '%+v' error: %s", query, err)
+			continue
+		}
+
+		for _, kite := range kites {
+			k.deregister(&kite.Kite)
+		}
+	}
+
+	k.Kite.Broadcast("kite.setBlocklist", k.Kite.Blocklist())
+
+	return nil, nil
+}
+
+// handleUnblock removes the kite ids, the kite key ids and the usernames
+// given as the only argument from the blocklist and pushes the new blocklist to the connected
+// kites.
+func (k *Kontrol) handleUnblock(r *kite.Request) (interface{}, error) {
+	var args protocol.Blocklist
+	if err := r.Args.One().Unmarshal(&args); err != nil {
+		return nil, errors.New("Invalid blocklist")
+	}
+
+	k.blocklistMu.Lock()
+	b := k.Kite.Blocklist()
+	b.Kites = without(b.Kites, args.Kites)
+	b.Keys = without(b.Keys, args.Keys)
+	b.Users = without(b.Users, args.Users)
+	k.Kite.SetBlocklist(b)
+	k.blocklistMu.Unlock()
+
+	err := k.updateState(func(s *sharedState) {
+		s.Blocklist.Kites = without(s.Blocklist.Kites, args.Kites)
+		s.Blocklist.Keys = without(s.Blocklist.Keys, args.Keys)
+		s.Blocklist.Users = without(s.Blocklist.Users, args.Users)
+	})
+	if err != nil {
+		k.log.Error("cannot share the blocklist: %s", err)
+		return nil, errors.New("internal error - unblock")
+	}
+
+	k.log.Info("Kites %v, kite keys %v and users %v are unblocked by %s", args.Kites, args.Keys, args.Users, r.Username)
+
+	k.Kite.Broadcast("kite.setBlocklist", k.Kite.Blocklist())
+
+	return nil, nil
+}
+
+// handleGetBlocklist returns the blocked kite ids, kite key ids and usernames.
+func (k *Kontrol) handleGetBlocklist(r *kite.Request) (interface{}, error) {
+	return k.Kite.Blocklist(), nil
+}
+
+// without returns the strings in a that are not in b.
+func without(a, b []string) []string {
+	remove := make(map[string]struct{}, len(b))
+	for _, s := range b {
+		remove[s] = struct{}{}
+	}
+
+	result := make([]string, 0, len(a))
+	for _, s := range a {
+		if _, ok := remove[s]; !ok {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
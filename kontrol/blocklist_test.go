@@ -0,0 +1,112 @@
+package kontrol
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/protocol"
+	"github.com/koding/kite/testutil"
+)
+
+func TestBlock(t *testing.T) {
+	key := testutil.NewKiteKey()
+	keyID := key.Claims["jti"].(string)
+
+	m := kite.New("blockedkite", "1.0.0")
+	m.Config = conf.Copy()
+	m.Config.KiteKey = key.Raw
+	defer m.Close()
+
+	kiteURL := &url.URL{Scheme: "http", Host: "localhost:4455", Path: "/kite"}
+	if _, err := m.Register(kiteURL); err != nil {
+		t.Fatal(err)
+	}
+
+	query := &protocol.KontrolQuery{
+		Username:    conf.Username,
+		Environment: conf.Environment,
+		Name:        "blockedkite",
+	}
+
+	exp := kite.New("exp-block", "0.0.1")
+	exp.Config = conf.Copy()
+	defer exp.Close()
+
+	if _, err := exp.GetKites(query); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := m.GetToken(m.Kite())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := exp.NewClient(conf.KontrolURL)
+	c.Auth = &kite.Auth{Type: "kiteKey", Key: conf.KiteKey}
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	blocklist := &protocol.Blocklist{Kites: []string{m.Kite().ID}, Keys: []string{keyID}}
+	if _, err := c.TellWithTimeout("block", 4*time.Second, blocklist); err != nil {
+		t.Fatal(err)
+	}
+	defer c.TellWithTimeout("unblock", 4*time.Second, blocklist)
+
+	if _, err := exp.GetKites(query); err != kite.ErrNoKitesAvailable {
+		t.Errorf("got error %v, want: %v", err, kite.ErrNoKitesAvailable)
+	}
+
+	if _, err := m.RegisterHTTP(kiteURL); err == nil {
+		t.Error("blocked kite is registered")
+	}
+
+	// the kite id is chosen by the kite, the kite key must be refused with
+	// any id
+	m2 := kite.New("blockedkite", "1.0.0")
+	m2.Config = m.Config.Copy()
+	defer m2.Close()
+
+	if _, err := m2.RegisterHTTP(kiteURL); err == nil {
+		t.Error("blocked kite key is registered with another kite id")
+	}
+
+	req, err := http.NewRequest("GET", "http://localhost:5555/kites?username="+conf.Username, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+key.Raw)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d for the blocked kite key, want: %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	// the blocklist is pushed to the kites connected to kontrol
+	deadline := time.Now().Add(4 * time.Second)
+	for !exp.IsKeyBlocked(keyID) {
+		if time.Now().After(deadline) {
+			t.Fatal("blocklist is not pushed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// the tokens issued for the kite key are refused by the kites too
+	r := &kite.Request{LocalKite: exp, Auth: &kite.Auth{Type: "token", Key: token}}
+	if err := exp.AuthenticateFromToken(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.KiteKeyID != keyID {
+		t.Errorf("got kite key id %q in the token, want: %q", r.KiteKeyID, keyID)
+	}
+}
@@ -0,0 +1,128 @@
+package kontrol
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/protocol"
+)
+
+// handleDeregister deletes the calling kite from the storage, so it's not
+// returned from the queries anymore without waiting for its heartbeats to
+// time out. The heartbeats of the kite are ignored after that until it
+// registers again.
+func (k *Kontrol) handleDeregister(r *kite.Request) (interface{}, error) {
+	// Same as register, only the kites themselves can deregister.
+	if r.Auth.Type != "kiteKey" {
+		return nil, fmt.Errorf("Unexpected authentication type: %s", r.Auth.Type)
+	}
+
+	remote := &r.Client.Kite
+	if err := validateKiteKey(remote); err != nil {
+		return nil, err
+	}
+
+	// The registrations and the heartbeats are kept by the kite IDs, which
+	// the kites choose themselves. Don't let a kite stop the ones of another
+	// user's kite with the same ID.
+	kites, err := k.storage.Get(&protocol.KontrolQuery{ID: remote.ID})
+	if err != nil {
+		k.log.Error("storage get '%s' error: %s", remote, err)
+		return nil, errors.New("internal error - deregister")
+	}
+
+	for _, kite := range kites {
+		if kite.Kite.Username != r.Username {
+			return nil, fmt.Errorf("Kite is registered by another user: %s", remote.ID)
+		}
+	}
+
+	k.log.Info("Deregister request from: %s", remote)
+
+	k.deregister(remote)
+	return nil, nil
+}
+
+// deregister deletes the kite from the storage and ignores its heartbeats
+// until it registers again.
+func (k *Kontrol) deregister(kite *protocol.Kite) {
+	// stops the updaters of the kite
+	k.stopRegistration(kite.ID)
+
+	// the kites registered via HTTP are not waited for their heartbeats
+	k.heartbeatsMu.Lock()
+	if updateTimer, ok := k.heartbeats[kite.ID]; ok {
+		updateTimer.Stop()
+		delete(k.heartbeats, kite.ID)
+		delete(k.heartbeatUsers, kite.ID)
+	}
+	k.heartbeatsMu.Unlock()
+
+	k.remove(kite)
+	count(&k.metrics.deregistrations)
+	k.notifyWebhooks(protocol.Deregister, kite, "")
+}
+
+// addRegistration returns the channel which is closed when the kite with the
+// id deregisters or registers again. The channel of the previous
+// registration is closed, so its updater doesn't expire the kite.
+func (k *Kontrol) addRegistration(id string) chan struct{} {
+	stop := make(chan struct{})
+
+	k.registrationsMu.Lock()
+	if prev, ok := k.registrations[id]; ok {
+		close(prev)
+	}
+	k.registrations[id] = stop
+	k.registrationsMu.Unlock()
+
+	return stop
+}
+
+// removeRegistration forgets the registration of the kite with the id if it
+// hasn't registered again.
+func (k *Kontrol) removeRegistration(id string, stop chan struct{}) {
+	k.registrationsMu.Lock()
+	if k.registrations[id] == stop {
+		delete(k.registrations, id)
+	}
+	k.registrationsMu.Unlock()
+}
+
+// expireRegistration expires the kite unless it has deregistered or
+// registered again after the registration with the stop channel. The
+// registration is forgotten when the kite disconnects, so the channel of a
+// disconnected kite isn't closed when it registers again. The lock is held
+// while expiring, so a new registration isn't deleted from the storage.
+func (k *Kontrol) expireRegistration(kite *protocol.Kite, stop chan struct{}) {
+	k.registrationsMu.Lock()
+	defer k.registrationsMu.Unlock()
+
+	select {
+	case <-stop:
+		return
+	default:
+	}
+
+	current, ok := k.registrations[kite.ID]
+	if ok && current != stop {
+		return
+	}
+
+	delete(k.registrations, kite.ID)
+	k.expire(kite)
+}
+
+// stopRegistration closes the channel of the registration of the kite with
+// the id.
+func (k *Kontrol) stopRegistration(id string) {
+	k.registrationsMu.Lock()
+	stop, ok := k.registrations[id]
+	delete(k.registrations, id)
+	k.registrationsMu.Unlock()
+
+	if ok {
+		close(stop)
+	}
+}
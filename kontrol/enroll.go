@@ -0,0 +1,98 @@
+package kontrol
+
+import (
+	"errors"
+	"time"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/dnode"
+	"github.com/nu7hatch/gouuid"
+)
+
+// EnrollTimeout is the duration a machine enrollment waits for the approval
+// of the user.
+var EnrollTimeout = 5 * time.Minute
+
+// ErrEnrollmentNotFound is returned from ApproveEnrollment if there is no
+// pending enrollment with the id.
+var ErrEnrollmentNotFound = errors.New("enrollment not found")
+
+// handleEnrollMachine returns a new kite.key for the machine after the user
+// approves it in the browser. The URL to open is sent to the "authURL"
+// callback of the arguments. The kite.key is issued for the username that
+// approves the enrollment.
+func (k *Kontrol) handleEnrollMachine(r *kite.Request) (interface{}, error) {
+	var args struct {
+		Username string         `json:"username"`
+		AuthURL  dnode.Function `json:"authURL"`
+	}
+
+	if err := r.Args.One().Unmarshal(&args); err != nil || args.Username == "" {
+		return nil, errors.New("invalid arguments")
+	}
+
+	if k.EnrollAuthURL == nil {
+		if k.MachineAuthenticate != nil {
+			if err := k.MachineAuthenticate(r); err != nil {
+				return nil, errors.New("cannot authenticate user")
+			}
+		}
+
+		return k.registerUser(args.Username)
+	}
+
+	if !args.AuthURL.IsValid() {
+		return nil, errors.New("authURL callback is missing")
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, errors.New("cannot generate an enrollment id")
+	}
+
+	approved := make(chan string, 1)
+
+	k.enrollmentsMu.Lock()
+	k.enrollments[id.String()] = approved
+	k.enrollmentsMu.Unlock()
+
+	defer func() {
+		k.enrollmentsMu.Lock()
+		delete(k.enrollments, id.String())
+		k.enrollmentsMu.Unlock()
+	}()
+
+	disconnected := make(chan struct{})
+	r.Client.OnDisconnect(func() { close(disconnected) })
+
+	if err := args.AuthURL.Call(k.EnrollAuthURL(args.Username, id.String())); err != nil {
+		return nil, err
+	}
+
+	select {
+	case username := <-approved:
+		k.log.Info("Machine enrollment of %q is approved by %q", args.Username, username)
+		return k.registerUser(username)
+	case <-disconnected:
+		return nil, errors.New("machine is disconnected")
+	case <-time.After(EnrollTimeout):
+		return nil, errors.New("enrollment is not approved in time")
+	}
+}
+
+// ApproveEnrollment approves the pending machine enrollment with the id. It
+// must be called by the page at EnrollAuthURL after the user is
+// authenticated. The kite.key of the machine is issued for the username.
+func (k *Kontrol) ApproveEnrollment(id, username string) error {
+	k.enrollmentsMu.Lock()
+	approved, ok := k.enrollments[id]
+	delete(k.enrollments, id)
+	k.enrollmentsMu.Unlock()
+
+	if !ok {
+		return ErrEnrollmentNotFound
+	}
+
+	approved <- username
+	return nil
+}
@@ -14,6 +14,9 @@ import (
 	"github.com/koding/kite/protocol"
 )
 
+// etcdStatePrefix is the prefix of the keys of the shared state of kontrol.
+const etcdStatePrefix = "/kontrol/state/"
+
 // keyOrder defines the order of the query paramaters.
 var keyOrder = []string{
 	"username",
@@ -146,7 +149,7 @@ func (e *Etcd) Get(query *protocol.KontrolQuery) (Kites, error) {
 	_, err = version.NewVersion(query.Version)
 	if err != nil && query.Version != "" {
 		// now parse our constraint
-		versionConstraint, err = version.NewConstraint(query.Version)
+		versionConstraint, err = parseVersionConstraint(query.Version)
 		if err != nil {
 			// version is a malformed, just return the error
 			return nil, err
@@ -206,6 +209,141 @@ func (e *Etcd) Get(query *protocol.KontrolQuery) (Kites, error) {
 	return kites, nil
 }
 
+// List implements the Lister interface.
+func (e *Etcd) List() (Kites, error) {
+	resp, err := e.client.Get(KitesPrefix, false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	kites := make(Kites, 0)
+
+	for _, node := range NewNode(resp.Node).Flatten() {
+		// skip the id keys, they are the copies of the kite keys
+		kite, err := node.Kite()
+		if err != nil {
+			continue
+		}
+
+		kites = append(kites, kite)
+	}
+
+	return kites, nil
+}
+
+// Watch implements the Watcher interface.
+func (e *Etcd) Watch(query *protocol.KontrolQuery, events chan<- *protocol.KiteEvent, stop <-chan struct{}) error {
+	etcdKey, err := GetQueryKey(query)
+	if err != nil {
+		return err
+	}
+
+	// Same as Get(), watch all versions under the name and filter the
+	// events if the version field contains a constraint.
+	var hasVersionConstraint bool
+	var keyRest string
+	var versionConstraint version.Constraints
+	_, err = version.NewVersion(query.Version)
+	if err != nil && query.Version != "" {
+		versionConstraint, err = parseVersionConstraint(query.Version)
+		if err != nil {
+			return err
+		}
+
+		hasVersionConstraint = true
+		nameQuery := &protocol.KontrolQuery{
+			Username:    query.Username,
+			Environment: query.Environment,
+			Name:        query.Name,
+		}
+		etcdKey, _ = GetQueryKey(nameQuery)
+
+		keyRest = "/" + strings.TrimRight(
+			query.Region+"/"+query.Hostname+"/"+query.ID, "/")
+	}
+
+	receiver := make(chan *etcd.Response)
+	etcdStop := make(chan bool)
+	go func() {
+		<-stop
+		close(etcdStop)
+	}()
+
+	// receiver is closed by etcd client when the watch stops.
+	go func() {
+		for resp := range receiver {
+			event := etcdEvent(resp)
+			if event == nil {
+				continue
+			}
+
+			if hasVersionConstraint && !isValid(&event.Kite, versionConstraint, keyRest) {
+				continue
+			}
+
+			select {
+			case events <- event:
+			case <-stop:
+			}
+		}
+	}()
+
+	_, err = e.client.Watch(KitesPrefix+etcdKey, 0, true, receiver, etcdStop)
+	if err == etcd.ErrWatchStoppedByUser {
+		return nil
+	}
+
+	return err
+}
+
+// etcdEvent converts an etcd watch response to a kite event. It returns nil
+// if the response is not about a kite's registration.
+func etcdEvent(resp *etcd.Response) *protocol.KiteEvent {
+	switch resp.Action {
+	case "set", "create":
+		k, err := NewNode(resp.Node).Kite()
+		if err != nil {
+			return nil // not a kite key, like the ID lookup keys
+		}
+
+		// PrevNode is empty if the kite has registered for the first time.
+		// Other updates of a registered kite are only interesting if the URL
+		// has changed.
+		if resp.PrevNode != nil {
+			prev, err := NewNode(resp.PrevNode).Kite()
+			if err == nil && prev.URL == k.URL && sameURLs(prev.URLs, k.URLs) {
+				return nil
+			}
+
+			return &protocol.KiteEvent{
+				Action: protocol.Update,
+				Kite:   k.Kite,
+				URL:    k.URL,
+				URLs:   k.URLs,
+			}
+		}
+
+		return &protocol.KiteEvent{
+			Action: protocol.Register,
+			Kite:   k.Kite,
+			URL:    k.URL,
+			URLs:   k.URLs,
+		}
+	case "delete", "expire":
+		k, err := NewNode(resp.Node).KiteFromKey()
+		if err != nil {
+			return nil
+		}
+
+		return &protocol.KiteEvent{
+			Action: protocol.Deregister,
+			Kite:   *k,
+		}
+	}
+
+	return nil
+}
+
 func (e *Etcd) etcdKey(query *protocol.KontrolQuery) (string, error) {
 	if onlyIDQuery(query) {
 		resp, err := e.client.Get(KitesPrefix+"/"+query.ID, false, true)
@@ -224,6 +362,79 @@ type RegisterValue struct {
 	URL string `json:"url"`
 }
 
+// GetState implements the StateStorage interface.
+func (e *Etcd) GetState(key string) ([]byte, error) {
+	resp, err := e.client.Get(etcdStatePrefix+key, false, false)
+	if etcdErrorCode(err) == etcdKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(resp.Node.Value), nil
+}
+
+// UpdateState implements the StateStorage interface. The new value is
+// swapped only if the key is not changed since it's read, otherwise it's
+// read and updated again.
+func (e *Etcd) UpdateState(key string, update func(value []byte) ([]byte, error)) error {
+	etcdKey := etcdStatePrefix + key
+
+	for {
+		var current []byte
+		var index uint64
+
+		resp, err := e.client.Get(etcdKey, false, false)
+		switch {
+		case etcdErrorCode(err) == etcdKeyNotFound:
+		case err != nil:
+			return err
+		default:
+			current = []byte(resp.Node.Value)
+			index = resp.Node.ModifiedIndex
+		}
+
+		value, err := update(current)
+		if err != nil {
+			return err
+		}
+
+		if index == 0 {
+			_, err = e.client.Create(etcdKey, string(value), 0)
+		} else {
+			_, err = e.client.CompareAndSwap(etcdKey, string(value), 0, "", index)
+		}
+
+		switch etcdErrorCode(err) {
+		case etcdTestFailed, etcdNodeExist:
+			continue // changed by another instance
+		}
+
+		return err
+	}
+}
+
+// error codes of etcd
+const (
+	etcdKeyNotFound = 100
+	etcdTestFailed  = 101
+	etcdNodeExist   = 105
+)
+
+// etcdErrorCode returns the code of the etcd error, or zero if err is not
+// returned by etcd.
+func etcdErrorCode(err error) int {
+	switch e := err.(type) {
+	case *etcd.EtcdError:
+		return e.ErrorCode
+	case etcd.EtcdError:
+		return e.ErrorCode
+	}
+
+	return 0
+}
+
 // validateKiteKey returns a string representing the kite uniquely
 // that is suitable to use as a key for etcd.
 func validateKiteKey(k *protocol.Kite) error {
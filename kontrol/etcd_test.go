@@ -0,0 +1,62 @@
+package kontrol
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/coreos/go-etcd/etcd"
+	kontrolprotocol "github.com/koding/kite/kontrol/protocol"
+	"github.com/koding/kite/protocol"
+)
+
+func TestEtcdEvent(t *testing.T) {
+	kite := newMemoryKite("math", "1.0.0", "1")
+
+	node := func(url string) *etcd.Node {
+		value, err := json.Marshal(&kontrolprotocol.RegisterValue{URL: url})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return &etcd.Node{Key: KitesPrefix + kite.String(), Value: string(value)}
+	}
+
+	idNode := &etcd.Node{Key: KitesPrefix + "/" + kite.ID, Value: node("http://a").Value}
+
+	tests := []struct {
+		name   string
+		resp   *etcd.Response
+		action protocol.KiteAction // empty if there is no event
+		url    string
+	}{
+		{"register", &etcd.Response{Action: "set", Node: node("http://a")}, protocol.Register, "http://a"},
+		{"create", &etcd.Response{Action: "create", Node: node("http://a")}, protocol.Register, "http://a"},
+		{"refresh", &etcd.Response{Action: "set", Node: node("http://a"), PrevNode: node("http://a")}, "", ""},
+		{"new url", &etcd.Response{Action: "set", Node: node("http://b"), PrevNode: node("http://a")}, protocol.Update, "http://b"},
+		{"id key", &etcd.Response{Action: "set", Node: idNode}, "", ""},
+		{"delete", &etcd.Response{Action: "delete", Node: &etcd.Node{Key: KitesPrefix + kite.String()}}, protocol.Deregister, ""},
+		{"expire", &etcd.Response{Action: "expire", Node: &etcd.Node{Key: KitesPrefix + kite.String()}}, protocol.Deregister, ""},
+		{"expire id key", &etcd.Response{Action: "expire", Node: &etcd.Node{Key: idNode.Key}}, "", ""},
+		{"get", &etcd.Response{Action: "get", Node: node("http://a")}, "", ""},
+	}
+
+	for _, test := range tests {
+		event := etcdEvent(test.resp)
+		if test.action == "" {
+			if event != nil {
+				t.Errorf("%s: got %s event, want none", test.name, event.Action)
+			}
+			continue
+		}
+
+		if event == nil {
+			t.Errorf("%s: got no event, want: %s", test.name, test.action)
+			continue
+		}
+
+		if event.Action != test.action || event.URL != test.url || event.Kite.ID != kite.ID {
+			t.Errorf("%s: got %s %s %s, want: %s %s %s", test.name, event.Action, event.URL,
+				event.Kite.ID, test.action, test.url, kite.ID)
+		}
+	}
+}
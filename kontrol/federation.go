@@ -0,0 +1,211 @@
+package kontrol
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/protocol"
+)
+
+// FederationTimeout is the timeout of the queries forwarded to the peers.
+var FederationTimeout = 4 * time.Second
+
+// peer is a kontrol of another cluster that the queries are forwarded to.
+type peer struct {
+	cluster string
+	url     string
+	kiteKey string
+
+	once      sync.Once
+	client    *kite.Client
+	connected chan bool
+	err       error
+}
+
+// federatedGetKitesArgs are the arguments of the "federatedGetKites" method.
+type federatedGetKitesArgs struct {
+	Query *protocol.KontrolQuery `json:"query"`
+
+	// Username is the user that the query is forwarded for, the tokens are
+	// issued to it.
+	Username string `json:"username"`
+}
+
+// AddPeer federates kontrol with the kontrol at url in another cluster. The
+// queries for the cluster are forwarded to the peer, authenticated with the
+// kite key, which must be issued by the peer to the owner of this kontrol.
+// The owner must be one of the FederationUsers of the peer, allowed to query
+// for the users of this kontrol. It must be called once for each cluster.
+func (k *Kontrol) AddPeer(cluster, url, kiteKey string) {
+	k.peersMu.Lock()
+	k.peers[cluster] = &peer{
+		cluster: cluster,
+		url:     url,
+		kiteKey: kiteKey,
+	}
+	k.peersMu.Unlock()
+}
+
+// authorizePeer allows only the FederationUsers to call the method.
+func (k *Kontrol) authorizePeer(r *kite.Request) error {
+	if _, ok := k.FederationUsers[r.Username]; !ok {
+		return fmt.Errorf("user %q is not a federated kontrol", r.Username)
+	}
+
+	return nil
+}
+
+// peerAllowed returns true if the peer with the username can query the kites
+// on behalf of the user.
+func (k *Kontrol) peerAllowed(peer, username string) bool {
+	for _, u := range k.FederationUsers[peer] {
+		if u == "*" || u == username {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleFederatedGetKites returns the kites of the local cluster for the
+// query forwarded by a peer, with the tokens issued to the user of the query.
+// The kites come with the public key of the tokens, the kites of the other
+// clusters can't verify them otherwise.
+func (k *Kontrol) handleFederatedGetKites(r *kite.Request) (interface{}, error) {
+	var args federatedGetKitesArgs
+	if err := r.Args.One().Unmarshal(&args); err != nil || args.Query == nil || args.Username == "" {
+		return nil, errors.New("invalid federated query")
+	}
+
+	if !k.peerAllowed(r.Username, args.Username) {
+		return nil, fmt.Errorf("user %q cannot query for user %q", r.Username, args.Username)
+	}
+
+	if k.Kite.IsBlocked("", args.Username) {
+		return nil, kite.ErrBlocked
+	}
+
+	// the peers only query the local kites, so the queries don't loop
+	query := args.Query
+	query.Clusters = nil
+
+	audience := getAudience(query)
+
+	token, publicKey, err := k.newTokenWithKey(audience, args.Username, "")
+	k.auditRequest(r, audience, "", err)
+	if err != nil {
+		return nil, err
+	}
+
+	kites, err := k.getKites(query)
+	if err != nil {
+		return nil, err
+	}
+
+	kites.Attach(token)
+	for _, kite := range kites {
+		kite.Cluster = k.Cluster
+		kite.KontrolKey = publicKey
+	}
+
+	return &protocol.GetKitesResult{
+		Kites: kites,
+		Total: len(kites),
+	}, nil
+}
+
+// federatedKites returns the kites matching the query in the clusters of
+// the peers selected with query.Clusters. The peers are queried concurrently,
+// the ones that fail are logged and skipped.
+func (k *Kontrol) federatedKites(query *protocol.KontrolQuery, username string) Kites {
+	var peers []*peer
+
+	k.peersMu.Lock()
+	for _, p := range k.peers {
+		if wantsCluster(query.Clusters, p.cluster) {
+			peers = append(peers, p)
+		}
+	}
+	k.peersMu.Unlock()
+
+	results := make([]Kites, len(peers))
+
+	var wg sync.WaitGroup
+	for i, p := range peers {
+		wg.Add(1)
+		go func(i int, p *peer) {
+			defer wg.Done()
+
+			kites, err := k.queryPeer(p, query, username)
+			if err != nil {
+				k.log.Error("federated query to cluster '%s' error: %s", p.cluster, err)
+				return
+			}
+
+			results[i] = kites
+		}(i, p)
+	}
+	wg.Wait()
+
+	var kites Kites
+	for _, r := range results {
+		kites = append(kites, r...)
+	}
+
+	return kites
+}
+
+// queryPeer forwards the query of the user to the peer.
+func (k *Kontrol) queryPeer(p *peer, query *protocol.KontrolQuery, username string) (Kites, error) {
+	p.once.Do(func() {
+		p.client = k.Kite.NewClient(p.url)
+		p.client.Kite = protocol.Kite{Name: "kontrol"} // for logging purposes
+		p.client.Auth = &kite.Auth{
+			Type: "kiteKey",
+			Key:  p.kiteKey,
+		}
+
+		p.connected, p.err = p.client.DialForever()
+	})
+
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	select {
+	case <-p.connected:
+	case <-time.After(FederationTimeout):
+		return nil, errors.New("not connected to the peer")
+	}
+
+	args := &federatedGetKitesArgs{
+		Query:    query,
+		Username: username,
+	}
+
+	response, err := p.client.TellWithTimeout("federatedGetKites", FederationTimeout, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var result protocol.GetKitesResult
+	if err := response.Unmarshal(&result); err != nil {
+		return nil, err
+	}
+
+	return result.Kites, nil
+}
+
+// wantsCluster returns true if the cluster is one of the clusters.
+func wantsCluster(clusters []string, cluster string) bool {
+	for _, c := range clusters {
+		if c == "*" || c == cluster {
+			return true
+		}
+	}
+
+	return false
+}
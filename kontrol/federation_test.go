@@ -0,0 +1,80 @@
+package kontrol
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/protocol"
+	"github.com/koding/kite/testkeys"
+)
+
+func TestFederation(t *testing.T) {
+	conf2 := conf.Copy()
+	conf2.Port = 5556
+	conf2.KontrolURL = "http://localhost:5556/kite"
+
+	kon2 := New(conf2.Copy(), "0.0.1", testkeys.Public, testkeys.Private)
+	kon2.SetStorage(NewMemoryStorage())
+	kon2.Cluster = "remote"
+	kon2.FederationUsers = map[string][]string{conf.Username: {conf.Username}}
+
+	// the tokens of the peer are signed with a key the local kites don't know
+	kon2.RotateKey(newTestKeyPair(t))
+
+	go kon2.Run()
+	<-kon2.Kite.ServerReadyNotify()
+	defer kon2.Close()
+
+	kon.AddPeer("remote", conf2.KontrolURL, conf.KiteKey)
+	defer func() {
+		kon.peersMu.Lock()
+		delete(kon.peers, "remote")
+		kon.peersMu.Unlock()
+	}()
+
+	m := kite.New("federatedkite", "1.0.0")
+	m.Config = conf2.Copy()
+	defer m.Close()
+
+	kiteURL := &url.URL{Scheme: "http", Host: "localhost:4454", Path: "/kite"}
+	if _, err := m.Register(kiteURL); err != nil {
+		t.Fatal(err)
+	}
+
+	query := &protocol.KontrolQuery{
+		Username:    conf.Username,
+		Environment: conf.Environment,
+		Name:        "federatedkite",
+	}
+
+	exp := kite.New("exp-federation", "0.0.1")
+	exp.Config = conf.Copy()
+
+	if _, err := exp.GetKites(query); err != kite.ErrNoKitesAvailable {
+		t.Fatalf("got error %v for the local cluster, want: %v", err, kite.ErrNoKitesAvailable)
+	}
+
+	for _, clusters := range [][]string{{"remote"}, {"*"}} {
+		query.Clusters = clusters
+
+		kites, err := exp.GetKites(query)
+		if err != nil {
+			t.Fatalf("%v: %s", clusters, err)
+		}
+
+		if len(kites) != 1 || kites[0].ID != m.Id {
+			t.Fatalf("%v: got %d kites, want: %s", clusters, len(kites), m.Id)
+		}
+	}
+
+	query.Clusters = []string{"remote"}
+	if kites := kon.federatedKites(query, "otheruser"); len(kites) != 0 {
+		t.Fatalf("got %d kites for a user the peer is not allowed to query for", len(kites))
+	}
+
+	query.Clusters = []string{"other"}
+	if _, err := exp.GetKites(query); err != kite.ErrNoKitesAvailable {
+		t.Fatalf("got error %v for an unknown cluster, want: %v", err, kite.ErrNoKitesAvailable)
+	}
+}
@@ -3,8 +3,12 @@ package kontrol
 import (
 	"errors"
 	"fmt"
+	"math"
+	"net/url"
+	"strconv"
 	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/koding/kite"
 	"github.com/koding/kite/dnode"
 	"github.com/koding/kite/kontrol/onceevery"
@@ -12,45 +16,84 @@ import (
 	"github.com/koding/kite/protocol"
 )
 
-func (k *Kontrol) handleRegister(r *kite.Request) (interface{}, error) {
+func (k *Kontrol) handleRegister(r *kite.Request) (result interface{}, err error) {
 	k.log.Info("Register request from: %s", r.Client.Kite)
 
+	var kiteURL string
+	defer func() {
+		// the arguments are not validated before the Must methods
+		if rec := recover(); rec != nil {
+			k.auditRequest(r, "", kiteURL, fmt.Errorf("%v", rec))
+			panic(rec)
+		}
+
+		k.auditRequest(r, "", kiteURL, err)
+	}()
+
 	if r.Args.One().MustMap()["url"].MustString() == "" {
 		return nil, errors.New("invalid url")
 	}
 
 	var args struct {
-		URL string `json:"url"`
+		URL  string            `json:"url"`
+		URLs map[string]string `json:"urls"`
 	}
 	r.Args.One().MustUnmarshal(&args)
 	if args.URL == "" {
 		return nil, errors.New("empty url")
 	}
 
+	if err := validateURLs(args.URLs); err != nil {
+		return nil, err
+	}
+
 	// Only accept requests with kiteKey because we need this info
 	// for generating tokens for this kite.
 	if r.Auth.Type != "kiteKey" {
 		return nil, fmt.Errorf("Unexpected authentication type: %s", r.Auth.Type)
 	}
 
-	kiteURL := args.URL
+	kiteURL = args.URL
 	remote := r.Client
 
 	if err := validateKiteKey(&remote.Kite); err != nil {
 		return nil, err
 	}
 
+	if err := validateWeight(remote.Kite.Labels); err != nil {
+		return nil, err
+	}
+
+	// the blocked kite keys and users are refused while authenticating
+	if k.Kite.IsBlocked(remote.Kite.ID, "") {
+		return nil, kite.ErrBlocked
+	}
+
+	if err := allow(k.RegisterLimit, r.Username); err != nil {
+		return nil, err
+	}
+
 	value := &kontrolprotocol.RegisterValue{
-		URL: kiteURL,
+		URL:    kiteURL,
+		Labels: remote.Kite.Labels,
+		URLs:   args.URLs,
 	}
 
+	// closed when the kite deregisters or registers again, it's added
+	// before the storage so an updater of the previous registration doesn't
+	// delete the new one
+	deregistered := k.addRegistration(remote.Kite.ID)
+
 	// Register first by adding the value to the storage. Return if there is
 	// any error.
 	if err := k.storage.Upsert(&remote.Kite, value); err != nil {
 		k.log.Error("storage add '%s' error: %s", remote.Kite, err)
+		k.removeRegistration(remote.Kite.ID, deregistered)
 		return nil, errors.New("internal error - register")
 	}
 
+	k.seen(remote.Kite.ID)
+
 	every := onceevery.New(UpdateInterval)
 
 	ping := make(chan struct{}, 1)
@@ -72,6 +115,13 @@ func (k *Kontrol) handleRegister(r *kite.Request) (interface{}, error) {
 				k.log.Debug("Kite didn't sent any heartbeat %s.", remote.Kite)
 				every.Stop()
 				closed = true
+
+				// Do not wait for the key to expire, the kite is gone.
+				k.expireRegistration(&remote.Kite, deregistered)
+				return
+			case <-deregistered:
+				k.log.Debug("Kite is deregistered, stopping the updater %s", remote.Kite)
+				every.Stop()
 				return
 			}
 		}
@@ -83,6 +133,21 @@ func (k *Kontrol) handleRegister(r *kite.Request) (interface{}, error) {
 		dnode.Callback(func(args *dnode.Partial) {
 			k.log.Debug("Kite send us an heartbeat. %s", remote.Kite)
 
+			// the kite is deleted already, don't register it again
+			select {
+			case <-deregistered:
+				return
+			default:
+			}
+
+			k.seen(remote.Kite.ID)
+
+			// newer kites report their loads with the heartbeats
+			var load protocol.Load
+			if a, err := args.SliceOfLength(1); err == nil && a[0].Unmarshal(&load) == nil {
+				k.setLoad(remote.Kite.ID, &load)
+			}
+
 			k.clientLocks.Get(remote.Kite.ID).Lock()
 			defer k.clientLocks.Get(remote.Kite.ID).Unlock()
 
@@ -110,63 +175,189 @@ func (k *Kontrol) handleRegister(r *kite.Request) (interface{}, error) {
 	remote.GoWithTimeout("kite.heartbeat", 4*time.Second, heartbeatArgs...)
 
 	k.log.Info("Kite registered: %s", remote.Kite)
+	count(&k.metrics.registrations)
+	k.notifyWebhooks(protocol.Register, &remote.Kite, kiteURL)
 
 	remote.OnDisconnect(func() {
 		k.log.Info("Kite disconnected: %s", remote.Kite)
 		every.Stop()
+		k.removeRegistration(remote.Kite.ID, deregistered)
 	})
 
 	// send response back to the kite, also identify him with the new name
 	return &protocol.RegisterResult{URL: args.URL}, nil
 }
 
+// getKites returns the kites matching the query from the storage. The labels
+// of the query are checked here for the storages that don't support them.
+// The URLs of the kites are selected for the network of the query.
+func (k *Kontrol) getKites(query *protocol.KontrolQuery) (Kites, error) {
+	kites, err := k.storage.Get(query)
+	if err != nil {
+		return nil, err
+	}
+
+	kites.FilterLabels(query.Labels)
+
+	for _, kite := range kites {
+		kite.URL = kite.URLFor(query.Network)
+	}
+
+	k.attachLoads(kites)
+	kites.Order(query)
+
+	return kites, nil
+}
+
+// validateURLs returns an error if any of the network URLs given with the
+// register request is empty or invalid.
+func validateURLs(urls map[string]string) error {
+	for network, u := range urls {
+		if network == "" || u == "" {
+			return errors.New("invalid network url")
+		}
+
+		if _, err := url.Parse(u); err != nil {
+			return fmt.Errorf("invalid url for network %q: %s", network, err)
+		}
+	}
+
+	return nil
+}
+
+// validateWeight returns an error if the kite sets an invalid weight with the
+// protocol.WeightLabel. The weight must be a finite number that is not
+// negative.
+func validateWeight(labels map[string]string) error {
+	s, ok := labels[protocol.WeightLabel]
+	if !ok {
+		return nil
+	}
+
+	w, err := strconv.ParseFloat(s, 64)
+	if err != nil || w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+		return fmt.Errorf("invalid weight: %q", s)
+	}
+
+	return nil
+}
+
 func (k *Kontrol) handleGetKites(r *kite.Request) (interface{}, error) {
 	// This type is here until inversion branch is merged.
 	// Reason: We can't use the same struct for marshaling and unmarshaling.
 	// TODO use the struct in protocol
 	type GetKitesArgs struct {
-		Query *protocol.KontrolQuery `json:"query"`
+		Query  *protocol.KontrolQuery `json:"query"`
+		Offset int                    `json:"offset"`
+		Limit  int                    `json:"limit"`
+	}
+
+	if err := allow(k.GetKitesLimit, r.Username); err != nil {
+		return nil, err
 	}
 
+	count(&k.metrics.queries)
+
 	var args GetKitesArgs
 	r.Args.One().MustUnmarshal(&args)
 
-	query := args.Query
+	result, err := k.queryKites(auditEntry(r), args.Query, args.Offset, args.Limit, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// queryKites returns the page of the kites matching the query for the user of
+// the audit entry e, which records the issued token to the audit log. It's
+// shared by the getKites method and the "GET /kites" endpoint. The kites of
+// this cluster get the token only if withTokens is true.
+func (k *Kontrol) queryKites(e *AuditEntry, query *protocol.KontrolQuery, offset, limit int, withTokens bool) (*protocol.GetKitesResult, error) {
+	if offset < 0 || limit < 0 {
+		return nil, errors.New("offset and limit cannot be negative")
+	}
+
+	if k.MaxKitesPerQuery > 0 && (limit == 0 || limit > k.MaxKitesPerQuery) {
+		limit = k.MaxKitesPerQuery
+	}
 
 	// audience will go into the token as "aud" claim.
 	audience := getAudience(query)
 
 	// Generate token once here because we are using the same token for every
 	// kite we return and generating many tokens is really slow.
-	token, err := generateToken(audience, r.Username,
-		k.Kite.Kite().Username, k.privateKey)
-	if err != nil {
-		return nil, err
+	var token string
+	if withTokens {
+		var err error
+		token, err = k.newToken(audience, e.Username, e.KiteKeyID)
+		e.Audience = audience
+		k.audit(e, err)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Get kites from the storage
-	kites, err := k.storage.Get(query)
-	if err != nil {
-		return nil, err
+	var kites Kites
+	if len(query.Clusters) == 0 || wantsCluster(query.Clusters, k.Cluster) {
+		var err error
+		kites, err = k.getKites(query)
+		if err != nil {
+			return nil, err
+		}
+
+		if withTokens {
+			kites.Attach(token)
+		}
 	}
 
-	// Attach tokens to kites
-	kites.Attach(token)
+	// The kites of the other clusters come with the tokens of their
+	// kontrols.
+	if len(query.Clusters) != 0 {
+		for _, kite := range kites {
+			kite.Cluster = k.Cluster
+		}
+
+		kites = append(kites, k.federatedKites(query, e.Username)...)
+		kites.Order(query)
+	}
+
+	// Pages are consistent between the calls only if the kites are in the
+	// same order, which the storages don't guarantee. Weighted and load
+	// orders change on every call anyway.
+	if (offset > 0 || limit > 0) && !query.Weighted && !query.LeastLoaded {
+		kites.SortByID()
+		if query.PreferRegion != "" {
+			kites.PreferRegion(query.PreferRegion)
+		}
+	}
+
+	total := len(kites)
+	kites = kites.Page(offset, limit)
 
 	return &protocol.GetKitesResult{
 		Kites: kites,
+		Total: total,
 	}, nil
 }
 
-func (k *Kontrol) handleGetToken(r *kite.Request) (interface{}, error) {
+func (k *Kontrol) handleGetToken(r *kite.Request) (result interface{}, err error) {
+	var audience string
+	defer func() { k.auditRequest(r, audience, "", err) }()
+
+	if err := allow(k.GetTokenLimit, r.Username); err != nil {
+		return nil, err
+	}
+
 	var query *protocol.KontrolQuery
-	err := r.Args.One().Unmarshal(&query)
+	err = r.Args.One().Unmarshal(&query)
 	if err != nil {
 		return nil, errors.New("Invalid query")
 	}
 
 	// check if it's exist
-	kites, err := k.storage.Get(query)
+	kites, err := k.getKites(query)
 	if err != nil {
 		return nil, err
 	}
@@ -175,9 +366,54 @@ func (k *Kontrol) handleGetToken(r *kite.Request) (interface{}, error) {
 		return nil, errors.New("query matches more than one kite")
 	}
 
-	audience := getAudience(query)
+	audience = getAudience(query)
+
+	return k.newToken(audience, r.Username, r.KiteKeyID)
+}
+
+// handleRenewToken returns a new token for the given token, which must be
+// still valid and issued to the caller. It's used by kites to renew the
+// tokens they use for long-lived connections before they expire.
+func (k *Kontrol) handleRenewToken(r *kite.Request) (result interface{}, err error) {
+	var audience string
+	defer func() { k.auditRequest(r, audience, "", err) }()
+
+	tokenString, err := r.Args.One().String()
+	if err != nil {
+		return nil, errors.New("Invalid token")
+	}
+
+	token, err := jwt.Parse(tokenString, k.Kite.RSAKey)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse token: %s", err)
+	}
+
+	if !token.Valid {
+		return nil, errors.New("Invalid signature in token")
+	}
+
+	// A revoked token must not be exchanged for a new one.
+	if jti, _ := token.Claims["jti"].(string); k.Kite.IsTokenRevoked(jti) {
+		return nil, errors.New("token is revoked")
+	}
+
+	username, ok := token.Claims["sub"].(string)
+	if !ok {
+		return nil, errors.New("Username is not present in token")
+	}
+
+	// A kite can only renew its own tokens.
+	if username != r.Username {
+		return nil, fmt.Errorf("token is not issued to %q", r.Username)
+	}
+
+	if err := allow(k.GetTokenLimit, username); err != nil {
+		return nil, err
+	}
+
+	audience, _ = token.Claims["aud"].(string)
 
-	return generateToken(audience, r.Username, k.Kite.Kite().Username, k.privateKey)
+	return k.newToken(audience, username, r.KiteKeyID)
 }
 
 func (k *Kontrol) handleMachine(r *kite.Request) (interface{}, error) {
@@ -5,8 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/koding/kite"
 	kontrolprotocol "github.com/koding/kite/kontrol/protocol"
 	"github.com/koding/kite/protocol"
 )
@@ -18,19 +20,46 @@ func (k *Kontrol) handleHeartbeat(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	k.heartbeatsMu.Lock()
-	defer k.heartbeatsMu.Unlock()
-
 	k.log.Debug("Heartbeat received '%s'", id)
-	if updateTimer, ok := k.heartbeats[id]; ok {
+
+	if k.Kite.IsBlocked(id, "") {
+		http.Error(rw, kite.ErrBlocked.Error(), http.StatusForbidden)
+		return
+	}
+
+	k.heartbeatsMu.Lock()
+	updateTimer, ok := k.heartbeats[id]
+	username := k.heartbeatUsers[id]
+	if ok {
 		// try to reset the timer every time the remote kite sends us a
 		// heartbeat. Because the timer get reset, the timer is never fired, so
 		// the value get always updated with the updater in the background
 		// according to the write interval. If the kite doesn't send any
 		// heartbeat, the timer func is being called, which stops the updater
-		// so the key is being deleted automatically via the TTL mechanism.
+		// and expires the kite, so it's deleted from the storage.
 		updateTimer.Reset(HeartbeatInterval + HeartbeatDelay)
 		k.heartbeats[id] = updateTimer
+	}
+	k.heartbeatsMu.Unlock()
+
+	// Behind a load balancer the kite may have registered to another
+	// instance, keep it in the storage on behalf of that instance. The
+	// storage is touched only for the heartbeats sent with the kite key of
+	// the owner of the kite, the others are asked to register again.
+	if !ok && k.Stateless {
+		username, ok = k.refreshKite(req, id)
+	}
+
+	if ok {
+		k.seen(id)
+
+		if load, ok := loadFromQuery(req.URL.Query()); ok {
+			if k.authenticateHeartbeat(req, username) {
+				k.setLoad(id, load)
+			} else {
+				k.log.Debug("Ignoring the unauthenticated load of '%s'", id)
+			}
+		}
 
 		k.log.Debug("Sending pong '%s'", id)
 		rw.Write([]byte("pong"))
@@ -73,10 +102,16 @@ func (k *Kontrol) handleRegisterHTTP(rw http.ResponseWriter, req *http.Request)
 		return
 	}
 
+	if err := validateURLs(args.URLs); err != nil {
+		http.Error(rw, jsonError(err), http.StatusBadRequest)
+		return
+	}
+
 	// decode and authenticated the token key. We'll get the authenticated
 	// username
-	username, err := k.Kite.AuthenticateSimpleKiteKey(args.Auth.Key)
+	username, keyID, err := k.Kite.AuthenticateKiteKey(args.Auth.Key)
 	if err != nil {
+		k.auditHTTP(req, &args, "", err)
 		http.Error(rw, jsonError(err), http.StatusUnauthorized)
 		return
 	}
@@ -87,23 +122,47 @@ func (k *Kontrol) handleRegisterHTTP(rw http.ResponseWriter, req *http.Request)
 	// Be sure we have a valid Kite representation. We should not allow someone
 	// with an empty field to be registered.
 	if err := validateKiteKey(remoteKite); err != nil {
+		k.auditHTTP(req, &args, username, err)
 		http.Error(rw, jsonError(err), http.StatusBadRequest)
 		return
 	}
 
+	if err := validateWeight(remoteKite.Labels); err != nil {
+		k.auditHTTP(req, &args, username, err)
+		http.Error(rw, jsonError(err), http.StatusBadRequest)
+		return
+	}
+
+	if k.Kite.IsBlocked(remoteKite.ID, username) || k.Kite.IsKeyBlocked(keyID) {
+		k.auditHTTP(req, &args, username, kite.ErrBlocked)
+		http.Error(rw, jsonError(kite.ErrBlocked), http.StatusForbidden)
+		return
+	}
+
+	if err := allow(k.RegisterLimit, username); err != nil {
+		k.auditHTTP(req, &args, username, err)
+		http.Error(rw, jsonError(err), http.StatusTooManyRequests)
+		return
+	}
+
 	// This will be stored into the final storage
 	value := &kontrolprotocol.RegisterValue{
-		URL: args.URL,
+		URL:    args.URL,
+		Labels: remoteKite.Labels,
+		URLs:   args.URLs,
 	}
 
 	// Register first by adding the value to the storage. Return if there is
 	// any error.
 	if err := k.storage.Upsert(remoteKite, value); err != nil {
 		k.log.Error("storage add '%s' error: %s", remoteKite, err)
+		k.auditHTTP(req, &args, username, err)
 		http.Error(rw, jsonError(errors.New("internal error - register")), http.StatusInternalServerError)
 		return
 	}
 
+	k.seen(remoteKite.ID)
+
 	k.heartbeatsMu.Lock()
 	defer k.heartbeatsMu.Unlock()
 
@@ -113,12 +172,14 @@ func (k *Kontrol) handleRegisterHTTP(rw http.ResponseWriter, req *http.Request)
 		k.log.Info("Kite was already register (via HTTP), use timer cache %s", remoteKite)
 		updateTimer.Reset(HeartbeatInterval + HeartbeatDelay)
 		k.heartbeats[remoteKite.ID] = updateTimer
+		k.heartbeatUsers[remoteKite.ID] = remoteKite.Username
 	} else {
 		// we create a new ticker which is going to update the key periodically in
 		// the storage so it's always up to date. Instead of updating the key
 		// periodically according to the HeartBeatInterval below, we are buffering
 		// the write speed here with the UpdateInterval.
 		stopped := make(chan struct{})
+		deregistered := k.addRegistration(remoteKite.ID)
 		updater := time.NewTicker(UpdateInterval)
 		updaterFunc := func() {
 			for {
@@ -132,6 +193,10 @@ func (k *Kontrol) handleRegisterHTTP(rw http.ResponseWriter, req *http.Request)
 				case <-stopped:
 					k.log.Info("Kite is nonactive (via HTTP). Updater is closed %s", remoteKite)
 					return
+				case <-deregistered:
+					k.log.Info("Kite is deregistered (via HTTP). Updater is closed %s", remoteKite)
+					updater.Stop()
+					return
 				}
 			}
 		}
@@ -140,6 +205,7 @@ func (k *Kontrol) handleRegisterHTTP(rw http.ResponseWriter, req *http.Request)
 		// we are now creating a timer that is going to call the function which
 		// stops the background updater if it's not resetted. The time is being
 		// resetted on a separate HTTP endpoint "/heartbeat"
+		k.heartbeatUsers[remoteKite.ID] = remoteKite.Username
 		k.heartbeats[remoteKite.ID] = time.AfterFunc(HeartbeatInterval+HeartbeatDelay, func() {
 			k.log.Info("Kite didn't sent any heartbeat (via HTTP). Stopping the updater %s",
 				remoteKite)
@@ -156,10 +222,24 @@ func (k *Kontrol) handleRegisterHTTP(rw http.ResponseWriter, req *http.Request)
 			}
 
 			delete(k.heartbeats, remoteKite.ID)
+			delete(k.heartbeatUsers, remoteKite.ID)
+			k.removeRegistration(remoteKite.ID, deregistered)
+
+			// The heartbeats may be going to another instance, which
+			// keeps the kite until it expires in the storage.
+			if k.Stateless {
+				return
+			}
+
+			// Do not wait for the key to expire, the kite is gone.
+			k.expireRegistration(remoteKite, deregistered)
 		})
 	}
 
 	k.log.Info("Kite registered (via HTTP): %s", remoteKite)
+	k.auditHTTP(req, &args, username, nil)
+	count(&k.metrics.registrations)
+	k.notifyWebhooks(protocol.Register, remoteKite, args.URL)
 
 	rr := &protocol.RegisterResult{
 		URL:               args.URL,
@@ -174,6 +254,78 @@ func (k *Kontrol) handleRegisterHTTP(rw http.ResponseWriter, req *http.Request)
 	}
 }
 
+// authenticateHeartbeat returns true if the heartbeat is sent with the kite
+// key of the user in the Authorization header as "Bearer <kite key>".
+func (k *Kontrol) authenticateHeartbeat(req *http.Request, username string) bool {
+	key := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if key == "" || username == "" {
+		return false
+	}
+
+	authenticated, err := k.Kite.AuthenticateSimpleKiteKey(key)
+	return err == nil && authenticated == username
+}
+
+// refreshKite updates the kite with the id in the storage, so it doesn't
+// expire. It returns the username of the kite, or false if the kite is not
+// in the storage or the heartbeat is not sent with the kite key of its
+// owner.
+func (k *Kontrol) refreshKite(req *http.Request, id string) (string, bool) {
+	key := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if key == "" {
+		return "", false
+	}
+
+	username, err := k.Kite.AuthenticateSimpleKiteKey(key)
+	if err != nil {
+		return "", false
+	}
+
+	kites, err := k.storage.Get(&protocol.KontrolQuery{ID: id})
+	if err != nil || len(kites) == 0 || kites[0].Kite.Username != username {
+		return "", false
+	}
+
+	kite := kites[0]
+	value := &kontrolprotocol.RegisterValue{
+		URL:    kite.URL,
+		Labels: kite.Kite.Labels,
+		URLs:   kite.URLs,
+	}
+
+	if err := k.storage.Update(&kite.Kite, value); err != nil {
+		k.log.Error("storage update '%s' error: %s", &kite.Kite, err)
+		return "", false
+	}
+
+	return kite.Kite.Username, true
+}
+
+// expire deletes the kite which has stopped sending heartbeats from the
+// storage, so the watchers are notified that it's removed.
+func (k *Kontrol) expire(kite *protocol.Kite) {
+	k.log.Info("Kite is expired: %s", kite)
+
+	k.remove(kite)
+	count(&k.metrics.expirations)
+	k.notifyWebhooks(Expire, kite, "")
+}
+
+// remove deletes the kite from the storage and forgets its heartbeats.
+func (k *Kontrol) remove(kite *protocol.Kite) {
+	if err := k.storage.Delete(kite); err != nil {
+		k.log.Error("storage delete '%s' error: %s", kite, err)
+	}
+
+	k.lastSeenMu.Lock()
+	delete(k.lastSeen, kite.ID)
+	k.lastSeenMu.Unlock()
+
+	k.loadsMu.Lock()
+	delete(k.loads, kite.ID)
+	k.loadsMu.Unlock()
+}
+
 // jsonError returns a JSON string of form {"err" : "error content"}
 func jsonError(err error) string {
 	var errMsg struct {
@@ -0,0 +1,181 @@
+package kontrol
+
+import (
+	"errors"
+	"time"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/jwtkeys"
+)
+
+// signingKey returns the key id, the public and the private key that are
+// used for signing new tokens.
+func (k *Kontrol) signingKey() (kid, publicKey, privateKey string) {
+	k.keysMu.RLock()
+	defer k.keysMu.RUnlock()
+	return k.keyID, k.publicKey, k.privateKey
+}
+
+// newToken returns a token for username with the audience, signed with the
+// current signing key. The token carries the id of the kite key it's issued
+// for, if known, so the kites can refuse it when the kite key is blocked.
+func (k *Kontrol) newToken(aud, username, kiteKeyID string) (string, error) {
+	token, _, err := k.newTokenWithKey(aud, username, kiteKeyID)
+	return token, err
+}
+
+// newTokenWithKey is like newToken, but it returns the public key of the
+// token's signature too.
+func (k *Kontrol) newTokenWithKey(aud, username, kiteKeyID string) (token, publicKey string, err error) {
+	var scopes []string
+	if k.TokenScopes != nil {
+		scopes = k.TokenScopes(username, aud)
+	}
+
+	var roles []string
+	if k.TokenRoles != nil {
+		roles = k.TokenRoles(username, aud)
+	}
+
+	ttl, leeway := k.tokenTTL(username, aud)
+
+	kid, publicKey, privateKey := k.signingKey()
+	token, err = generateToken(aud, username, k.Kite.Kite().Username, kid, privateKey, k.SigningMethod, kiteKeyID, scopes, roles, ttl, leeway)
+	if err != nil {
+		return "", "", err
+	}
+
+	count(&k.metrics.tokens)
+	return token, publicKey, nil
+}
+
+// DefaultMaxTokenTTL is the default of Kontrol.MaxTokenTTL.
+const DefaultMaxTokenTTL = 7 * 24 * time.Hour
+
+// tokenTTL returns the TTL and the leeway of the tokens issued to username
+// for the audience.
+func (k *Kontrol) tokenTTL(username, aud string) (ttl, leeway time.Duration) {
+	ttl, leeway = k.defaultTokenTTL()
+
+	if k.TokenTTLPolicy != nil {
+		if t := k.TokenTTLPolicy(username, aud); t > 0 {
+			ttl = t
+
+			if max := k.maxTokenTTL(); ttl > max {
+				ttl = max
+			}
+		}
+	}
+
+	return ttl, leeway
+}
+
+// defaultTokenTTL returns the TTL and the leeway of the tokens without
+// TokenTTLPolicy.
+func (k *Kontrol) defaultTokenTTL() (ttl, leeway time.Duration) {
+	ttl, leeway = TokenTTL, TokenLeeway
+
+	if k.TokenTTL != 0 {
+		ttl = k.TokenTTL
+	}
+
+	if k.TokenLeeway != 0 {
+		leeway = k.TokenLeeway
+	}
+
+	return ttl, leeway
+}
+
+func (k *Kontrol) maxTokenTTL() time.Duration {
+	if k.MaxTokenTTL == 0 {
+		return DefaultMaxTokenTTL
+	}
+
+	return k.MaxTokenTTL
+}
+
+// revocationTTL returns how long the revoked token ids are kept, which is
+// the longest time a token issued by kontrol can be valid. It depends only
+// on the configuration, so it's the same after a restart and on the other
+// instances in stateless mode.
+func (k *Kontrol) revocationTTL() time.Duration {
+	ttl, leeway := k.defaultTokenTTL()
+
+	if k.TokenTTLPolicy != nil {
+		if max := k.maxTokenTTL(); max > ttl {
+			ttl = max
+		}
+	}
+
+	return ttl + leeway
+}
+
+// RotateKey replaces the key pair used for signing tokens and returns the id
+// of the new key. Tokens signed with the previous keys are still accepted
+// until their keys are retired with RetireKey, so the kites have time to get
+// new tokens and to learn the new key. The keys are shared with the other
+// instances in stateless mode.
+func (k *Kontrol) RotateKey(publicKey, privateKey string) string {
+	if _, _, err := jwtkeys.SigningKeyMethod(privateKey, k.SigningMethod); err != nil {
+		k.log.Error("new signing key cannot be used: %s", err)
+	}
+
+	kid := k.Kite.AddKontrolKey(publicKey)
+
+	k.keysMu.Lock()
+	k.keyID = kid
+	k.publicKey = publicKey
+	k.privateKey = privateKey
+	k.keysMu.Unlock()
+
+	err := k.updateState(func(s *sharedState) {
+		k.shareKeys(s)
+		s.Keys[kid] = publicKey
+		s.SigningKey = &signingKey{ID: kid, PublicKey: publicKey, PrivateKey: privateKey}
+	})
+	if err != nil {
+		k.log.Error("cannot share the signing key: %s", err)
+	}
+
+	k.log.Info("Signing key is rotated, new key id: %s", kid)
+	return kid
+}
+
+// RetireKey stops accepting the tokens signed with the key. The key that is
+// currently used for signing tokens can not be retired.
+func (k *Kontrol) RetireKey(kid string) error {
+	current, _, _ := k.signingKey()
+	if kid == current {
+		return errors.New("cannot retire the current signing key")
+	}
+
+	if _, ok := k.Kite.KontrolKeys()[kid]; !ok {
+		return errors.New("key not found")
+	}
+
+	k.Kite.RemoveKontrolKey(kid)
+
+	return k.updateState(func(s *sharedState) {
+		k.shareKeys(s)
+		delete(s.Keys, kid)
+	})
+}
+
+// shareKeys puts the keys of this instance to the shared state if they are
+// not shared yet.
+func (k *Kontrol) shareKeys(s *sharedState) {
+	if s.SigningKey != nil {
+		return
+	}
+
+	kid, publicKey, privateKey := k.signingKey()
+	s.SigningKey = &signingKey{ID: kid, PublicKey: publicKey, PrivateKey: privateKey}
+	s.Keys = k.Kite.KontrolKeys()
+}
+
+// handleGetKeys returns the public keys that are accepted by kontrol by their
+// key ids. Kites can add these to their trusted keys with
+// Kite.AddKontrolKey.
+func (k *Kontrol) handleGetKeys(r *kite.Request) (interface{}, error) {
+	return k.Kite.KontrolKeys(), nil
+}
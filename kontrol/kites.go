@@ -1,7 +1,9 @@
 package kontrol
 
 import (
+	"math"
 	"math/rand"
+	"sort"
 	"strings"
 
 	"github.com/hashicorp/go-version"
@@ -30,22 +32,103 @@ func (k Kites) Shuffle() {
 	k = shuffled
 }
 
+// ShuffleWeighted orders the kites randomly, the kites with more weight being
+// more likely to be before the others. See protocol.WeightLabel. Kites with
+// zero weight are moved to the end.
+func (k Kites) ShuffleWeighted() {
+	// Weighted random sampling: each kite gets the key u^(1/w) for a random
+	// u in (0, 1) and the kites are ordered by their keys.
+	keys := make(map[*protocol.KiteWithToken]float64, len(k))
+	for _, kite := range k {
+		w := kite.Kite.Weight()
+		if w == 0 {
+			keys[kite] = -1
+			continue
+		}
+
+		keys[kite] = math.Pow(1-rand.Float64(), 1/w)
+	}
+
+	sort.SliceStable(k, func(i, j int) bool {
+		return keys[k[i]] > keys[k[j]]
+	})
+}
+
+// PreferRegion moves the kites in the region before the others, keeping the
+// order of the kites otherwise.
+func (k Kites) PreferRegion(region string) {
+	sort.SliceStable(k, func(i, j int) bool {
+		return k[i].Kite.Region == region && k[j].Kite.Region != region
+	})
+}
+
+// Order orders the kites as requested by the query.
+func (k Kites) Order(query *protocol.KontrolQuery) {
+	if query.Weighted {
+		k.ShuffleWeighted()
+	}
+	if query.LeastLoaded {
+		k.OrderByLoad()
+	}
+	if query.PreferRegion != "" {
+		k.PreferRegion(query.PreferRegion)
+	}
+}
+
+// SortByID sorts the kites by their IDs.
+func (k Kites) SortByID() {
+	sort.Slice(k, func(i, j int) bool {
+		return k[i].Kite.ID < k[j].Kite.ID
+	})
+}
+
+// Page returns the kites after the offset, at most limit of them. Zero limit
+// means no limit.
+func (k Kites) Page(offset, limit int) Kites {
+	if offset >= len(k) {
+		return Kites{}
+	}
+
+	k = k[offset:]
+	if limit > 0 && limit < len(k) {
+		k = k[:limit]
+	}
+
+	return k
+}
+
 // Filter filters out kites with the given constraints
-func (k Kites) Filter(constraint version.Constraints, keyRest string) {
+func (k *Kites) Filter(constraint version.Constraints, keyRest string) {
 	filtered := make(Kites, 0)
-	for _, kite := range k {
+	for _, kite := range *k {
 		if isValid(&kite.Kite, constraint, keyRest) {
 			filtered = append(filtered, kite)
 		}
 	}
 
-	k = filtered
+	*k = filtered
+}
+
+// FilterLabels filters out kites that don't have all of the labels.
+func (k *Kites) FilterLabels(labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+
+	filtered := make(Kites, 0)
+	for _, kite := range *k {
+		if kite.Kite.HasLabels(labels) {
+			filtered = append(filtered, kite)
+		}
+	}
+
+	*k = filtered
 }
 
 func isValid(k *protocol.Kite, c version.Constraints, keyRest string) bool {
 	// Check the version constraint.
-	v, _ := version.NewVersion(k.Version)
-	if !c.Check(v) {
+	v, err := version.NewVersion(k.Version)
+	if err != nil || !c.Check(v) {
 		return false
 	}
 
@@ -57,3 +140,44 @@ func isValid(k *protocol.Kite, c version.Constraints, keyRest string) bool {
 
 	return true
 }
+
+// versionOperators are the operators that can precede a version in a
+// constraint.
+var versionOperators = map[string]bool{
+	"=": true, "!=": true, ">": true, "<": true, ">=": true, "<=": true, "~>": true,
+}
+
+// isVersionConstraint returns true if the version field of a query is a
+// constraint instead of a single version.
+func isVersionConstraint(v string) bool {
+	if v == "" {
+		return false
+	}
+
+	_, err := version.NewVersion(v)
+	return err != nil
+}
+
+// parseVersionConstraint parses the version field of a query. The
+// constraints can be separated with commas or spaces, so both
+// ">= 1.2.0, < 2.0.0" and ">=1.2.0 <2.0.0" are accepted.
+func parseVersionConstraint(v string) (version.Constraints, error) {
+	fields := strings.FieldsFunc(v, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+
+	constraints := make([]string, 0, len(fields))
+	for i := 0; i < len(fields); i++ {
+		c := fields[i]
+
+		// the operator is separated from its version by a space
+		if versionOperators[c] && i+1 < len(fields) {
+			c += fields[i+1]
+			i++
+		}
+
+		constraints = append(constraints, c)
+	}
+
+	return version.NewConstraint(strings.Join(constraints, ", "))
+}
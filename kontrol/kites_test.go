@@ -0,0 +1,71 @@
+package kontrol
+
+import (
+	"testing"
+
+	"github.com/koding/kite/protocol"
+)
+
+func weightedKite(id, region, weight string) *protocol.KiteWithToken {
+	kite := &protocol.KiteWithToken{Kite: protocol.Kite{ID: id, Region: region}}
+	if weight != "" {
+		kite.Kite.Labels = map[string]string{protocol.WeightLabel: weight}
+	}
+	return kite
+}
+
+func TestShuffleWeighted(t *testing.T) {
+	first := make(map[string]int)
+
+	for i := 0; i < 1000; i++ {
+		kites := Kites{
+			weightedKite("drained", "", "0"),
+			weightedKite("small", "", ""),
+			weightedKite("big", "", "9"),
+		}
+
+		kites.ShuffleWeighted()
+
+		if kites[2].Kite.ID != "drained" {
+			t.Fatalf("kite with zero weight is not the last: %s", kites[2].Kite.ID)
+		}
+		first[kites[0].Kite.ID]++
+	}
+
+	// big is expected to be the first in 90% of the shuffles
+	if first["big"] < 800 || first["small"] == 0 {
+		t.Errorf("kites are not ordered by their weights: %v", first)
+	}
+}
+
+func TestValidateWeight(t *testing.T) {
+	for _, weight := range []string{"NaN", "+Inf", "-Inf", "-1", "heavy"} {
+		if err := validateWeight(weightedKite("a", "", weight).Kite.Labels); err == nil {
+			t.Errorf("weight %s is accepted", weight)
+		}
+	}
+
+	for _, weight := range []string{"", "0", "2.5"} {
+		if err := validateWeight(weightedKite("a", "", weight).Kite.Labels); err != nil {
+			t.Errorf("weight %q is rejected: %s", weight, err)
+		}
+	}
+}
+
+func TestPreferRegion(t *testing.T) {
+	kites := Kites{
+		weightedKite("a", "us", ""),
+		weightedKite("b", "eu", ""),
+		weightedKite("c", "us", ""),
+		weightedKite("d", "eu", ""),
+	}
+
+	kites.PreferRegion("eu")
+
+	want := []string{"b", "d", "a", "c"}
+	for i, id := range want {
+		if kites[i].Kite.ID != id {
+			t.Fatalf("got kite %q at %d, want: %q", kites[i].Kite.ID, i, id)
+		}
+	}
+}
@@ -3,7 +3,9 @@
 package kontrol
 
 import (
+	"context"
 	"errors"
+	"io"
 	"math/rand"
 	"strings"
 	"sync"
@@ -12,8 +14,10 @@ import (
 	"github.com/dgrijalva/jwt-go"
 	"github.com/koding/kite"
 	"github.com/koding/kite/config"
+	"github.com/koding/kite/jwtkeys"
 	"github.com/koding/kite/kitekey"
 	kontrolprotocol "github.com/koding/kite/kontrol/protocol"
+	"github.com/koding/kite/protocol"
 	"github.com/nu7hatch/gouuid"
 )
 
@@ -30,6 +34,9 @@ var (
 	tokenCache   = make(map[string]string)
 	tokenCacheMu sync.Mutex
 
+	// tokenCacheIDs maps the ids of the cached tokens to their cache keys.
+	tokenCacheIDs = make(map[string]string)
+
 	// HeartbeatInterval is the interval in which kites are sending heartbeats
 	HeartbeatInterval = time.Second * 10
 
@@ -58,15 +65,172 @@ type Kontrol struct {
 	// before they register to this machine.
 	MachineAuthenticate func(r *kite.Request) error
 
+	// EnrollAuthURL returns the URL of the page that the user must open in
+	// the browser to approve the enrollment of a machine with the
+	// "enrollMachine" method. The page must authenticate the user and call
+	// ApproveEnrollment with the id. If nil, "enrollMachine" is the same as
+	// "registerMachine".
+	EnrollAuthURL func(username, id string) string
+
+	// TokenScopes returns the scopes that are embedded into the "scopes"
+	// claim of the tokens issued to username for the audience. Kites can
+	// restrict their methods to the tokens having certain scopes with
+	// kite.RequireScope. Tokens have no scopes if it's nil.
+	TokenScopes func(username, audience string) []string
+
+	// TokenRoles returns the roles that are embedded into the "roles" claim
+	// of the tokens issued to username for the audience. Kites can restrict
+	// their methods to the callers having certain roles with
+	// kite.RequireRole. Tokens have no roles if it's nil.
+	TokenRoles func(username, audience string) []string
+
+	// TokenTTL and TokenLeeway override the package level TokenTTL and
+	// TokenLeeway for the tokens issued by this kontrol if they are not
+	// zero.
+	TokenTTL    time.Duration
+	TokenLeeway time.Duration
+
+	// TokenTTLPolicy returns the TTL of the tokens issued to username for
+	// the audience, which is the query of the kites the token is for. It
+	// lets the operators issue short-lived tokens for the sensitive kites
+	// and longer ones for the batch jobs. TokenTTL is used if it's nil or
+	// returns zero.
+	TokenTTLPolicy func(username, audience string) time.Duration
+
+	// MaxTokenTTL caps the TTLs returned by TokenTTLPolicy. The revoked
+	// token ids are kept for it if TokenTTLPolicy is set, as the tokens can
+	// be valid for that long. DefaultMaxTokenTTL is used if it's zero.
+	MaxTokenTTL time.Duration
+
+	// SigningMethod is the algorithm of the tokens and the kite keys signed
+	// by kontrol, like "RS512". The default is chosen by the type of the
+	// signing key, see package jwtkeys. Run panics if the key can't be used
+	// with it, the rotated keys must be usable with it too.
+	SigningMethod string
+
 	// RSA keys
 	publicKey  string // for validating tokens
 	privateKey string // for signing tokens
+	keyID      string // id of the keys above, sent in "kid" header
+	keysMu     sync.RWMutex
 
 	clientLocks *IdLock
 
 	heartbeats   map[string]*time.Timer
 	heartbeatsMu sync.Mutex // protects each clients heartbeat timer
 
+	// heartbeatUsers are the usernames of the kites in heartbeats, the loads
+	// sent with the heartbeats are accepted only from them.
+	heartbeatUsers map[string]string
+
+	// watchers contains the stop functions of the running watchers. Keys
+	// are watcher IDs.
+	watchers   map[string]func()
+	watchersMu sync.Mutex
+
+	// revoked contains the ids of the revoked tokens and the time they
+	// can be forgotten.
+	revoked   map[string]time.Time
+	revokedMu sync.Mutex
+
+	// lastSeen contains the last registration or heartbeat times of the
+	// kites. Keys are kite IDs.
+	lastSeen   map[string]time.Time
+	lastSeenMu sync.Mutex
+
+	// loads contains the last loads reported by the kites with their
+	// heartbeats. Keys are kite IDs.
+	loads   map[string]*protocol.Load
+	loadsMu sync.Mutex
+
+	metrics *metrics
+
+	// done is closed when kontrol is closed.
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// registrations contains the channels closed when the kites registered
+	// over their connections deregister. Keys are kite IDs.
+	registrations   map[string]chan struct{}
+	registrationsMu sync.Mutex
+
+	// blocklistMu serializes the changes of the blocklist, which is kept
+	// by the kite of kontrol.
+	blocklistMu sync.Mutex
+
+	// enrollments contains the channels of the machine enrollments waiting
+	// for approval. Keys are enrollment IDs.
+	enrollments   map[string]chan string
+	enrollmentsMu sync.Mutex
+
+	// Admins are the usernames that can call the admin methods, in addition
+	// to the owner of kontrol.
+	Admins []string
+
+	// MaxKitesPerQuery limits the number of kites returned by a getKites
+	// call, callers get the rest with the next pages. Zero means no limit.
+	MaxKitesPerQuery int
+
+	// CORSOrigins are the origins of the browsers that can query the kites
+	// with the "GET /kites" endpoint, like "https://dashboard.example.com".
+	// "*" allows any origin. Nil disallows the cross-origin requests.
+	CORSOrigins []string
+
+	// RegisterLimit, GetKitesLimit and GetTokenLimit limit the rates of the
+	// register, getKites and getToken calls of each user. Kites registering
+	// via HTTP are limited too and GetTokenLimit applies to the renewToken
+	// calls as well. Nil means no limit.
+	RegisterLimit *kite.RateLimiter
+	GetKitesLimit *kite.RateLimiter
+	GetTokenLimit *kite.RateLimiter
+
+	// Stateless allows running many kontrol instances with a shared storage
+	// (etcd or PostgreSQL) behind a load balancer that doesn't keep the
+	// kites on the same instance. The HTTP heartbeats are accepted by any
+	// instance, which updates the kite in the storage, and the kites
+	// registered via HTTP are left to expire in the storage after KeyTTL
+	// instead of being deleted by the instance they registered to. The
+	// revoked tokens, the blocklist and the signing keys are shared via the
+	// storage if it implements StateStorage, otherwise they must be set on
+	// every instance. The enrollments are not shared.
+	Stateless bool
+
+	// Cluster is the name of the cluster of kontrol, which is set to the
+	// kites in the results of the queries searching the clusters of the
+	// peers added with AddPeer.
+	Cluster string
+
+	// FederationUsers are the usernames of the kontrols of the other
+	// clusters that can query the kites of this kontrol, mapped to the users
+	// they can query on behalf of. "*" allows all users. The users are
+	// assumed to be the same in all clusters.
+	FederationUsers map[string][]string
+
+	peers   map[string]*peer // keys are cluster names
+	peersMu sync.Mutex
+
+	// AuditLog receives the register calls and the tokens issued by kontrol,
+	// with the callers. Nil disables the audit log.
+	AuditLog AuditSink
+
+	// Webhooks are the URLs that are notified of the registered,
+	// deregistered and expired kites. See WebhookEvent for the requests.
+	Webhooks []string
+
+	// WebhookSecret, if set, signs the requests sent to the webhooks. See
+	// WebhookSignatureHeader.
+	WebhookSecret string
+
+	// the events are queued for each webhook, so a slow webhook doesn't
+	// delay the others. Keys are webhook URLs.
+	webhookQueues  map[string]chan *WebhookEvent
+	webhooksCtx    context.Context // canceled when kontrol is closed
+	webhooksCancel context.CancelFunc
+	webhooksClosed bool
+	webhooksMu     sync.Mutex
+
+	startedAt time.Time
+
 	// storage defines the storage of the kites.
 	storage Storage
 
@@ -86,6 +250,13 @@ type Kontrol struct {
 //     openssl genrsa -out testkey.pem 2048
 //     openssl rsa -in testkey.pem -pubout > testkey_pub.pem
 //
+// ECDSA and Ed25519 keys sign smaller tokens with ES256 and EdDSA instead of
+// RS256, they can be generated with:
+//     openssl ecparam -name prime256v1 -genkey -noout -out testkey.pem
+//     openssl genpkey -algorithm ed25519 -out testkey.pem
+// and their public keys with:
+//     openssl pkey -in testkey.pem -pubout > testkey_pub.pem
+//
 func New(conf *config.Config, version, publicKey, privateKey string) *Kontrol {
 	k := kite.New("kontrol", version)
 	k.Config = conf
@@ -96,21 +267,52 @@ func New(conf *config.Config, version, publicKey, privateKey string) *Kontrol {
 	}
 
 	kontrol := &Kontrol{
-		Kite:        k,
-		publicKey:   publicKey,
-		privateKey:  privateKey,
-		log:         k.Log,
-		clientLocks: NewIdlock(),
-		heartbeats:  make(map[string]*time.Timer, 0),
+		Kite:           k,
+		publicKey:      publicKey,
+		privateKey:     privateKey,
+		keyID:          kite.KeyID(publicKey),
+		log:            k.Log,
+		clientLocks:    NewIdlock(),
+		heartbeats:     make(map[string]*time.Timer, 0),
+		heartbeatUsers: make(map[string]string),
+		watchers:       make(map[string]func()),
+		revoked:        make(map[string]time.Time),
+		lastSeen:       make(map[string]time.Time),
+		loads:          make(map[string]*protocol.Load),
+		registrations:  make(map[string]chan struct{}),
+		metrics:        newMetrics(),
+		peers:          make(map[string]*peer),
+		enrollments:    make(map[string]chan string),
+		startedAt:      time.Now(),
+		done:           make(chan struct{}),
 	}
 
 	k.HandleFunc("register", kontrol.handleRegister)
+	k.HandleFunc("deregister", kontrol.handleDeregister)
 	k.HandleFunc("registerMachine", kontrol.handleMachine).DisableAuthentication()
+	k.HandleFunc("enrollMachine", kontrol.handleEnrollMachine).DisableAuthentication()
 	k.HandleFunc("getKites", kontrol.handleGetKites)
 	k.HandleFunc("getToken", kontrol.handleGetToken)
+	k.HandleFunc("renewToken", kontrol.handleRenewToken)
+	k.HandleFunc("watchKites", kontrol.handleWatchKites)
+	k.HandleFunc("cancelWatcher", kontrol.handleCancelWatcher)
+	k.HandleFunc("getKeys", kontrol.handleGetKeys)
+	k.HandleFunc("revokeToken", kontrol.handleRevokeToken)
+	k.HandleFunc("getRevokedTokens", kontrol.handleGetRevokedTokens)
+	k.HandleFunc("listAllKites", kontrol.handleListAllKites).Authorize(kontrol.authorizeAdmin)
+	k.HandleFunc("forceDeregister", kontrol.handleForceDeregister).Authorize(kontrol.authorizeAdmin)
+	k.HandleFunc("stats", kontrol.handleStats).Authorize(kontrol.authorizeAdmin)
+	k.HandleFunc("block", kontrol.handleBlock).Authorize(kontrol.authorizeAdmin)
+	k.HandleFunc("unblock", kontrol.handleUnblock).Authorize(kontrol.authorizeAdmin)
+	k.HandleFunc("getBlocklist", kontrol.handleGetBlocklist)
+	k.HandleFunc("federatedGetKites", kontrol.handleFederatedGetKites).Authorize(kontrol.authorizePeer)
+
+	// tokens signed by kontrol are validated with its own key
+	k.AddKontrolKey(publicKey)
 
 	k.HandleHTTPFunc("/register", kontrol.handleRegisterHTTP)
 	k.HandleHTTPFunc("/heartbeat", kontrol.handleHeartbeat)
+	k.HandleHTTPFunc("/kites", kontrol.handleKitesHTTP)
 
 	return kontrol
 }
@@ -126,21 +328,44 @@ func (k *Kontrol) Run() {
 		panic("kontrol storage is not set")
 	}
 
+	if k.SigningMethod != "" {
+		_, _, privateKey := k.signingKey()
+		if _, _, err := jwtkeys.SigningKeyMethod(privateKey, k.SigningMethod); err != nil {
+			panic(err)
+		}
+	}
+
 	// now go and register ourself
 	go k.registerSelf()
 
+	if _, ok := k.stateStorage(); ok {
+		go k.syncState()
+	}
+
 	k.Kite.Run()
 }
 
 // SetStorage sets the backend storage that kontrol is going to use to store
 // kites
 func (k *Kontrol) SetStorage(storage Storage) {
-	k.storage = storage
+	if storage == nil {
+		k.storage = nil
+		return
+	}
+
+	k.storage = &meteredStorage{Storage: storage, metrics: k.metrics}
 }
 
-// Close stops kontrol and closes all connections
+// Close stops kontrol and closes all connections. The storage is closed too
+// if it implements io.Closer.
 func (k *Kontrol) Close() {
 	k.Kite.Close()
+	k.closeWebhooks()
+	k.closeOnce.Do(func() { close(k.done) })
+
+	if c, ok := k.backend().(io.Closer); ok {
+		c.Close()
+	}
 }
 
 // InitializeSelf registers his host by writing a key to ~/.kite/kite.key
@@ -159,26 +384,35 @@ func (k *Kontrol) registerUser(username string) (kiteKey string, err error) {
 		return "", errors.New("cannot generate a token")
 	}
 
-	token := jwt.New(jwt.GetSigningMethod("RS256"))
+	kid, publicKey, privateKey := k.signingKey()
+
+	method, key, err := jwtkeys.SigningKeyMethod(privateKey, k.SigningMethod)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.New(method)
+	token.Header["kid"] = kid
 
 	token.Claims = map[string]interface{}{
-		"iss":        k.Kite.Kite().Username,         // Issuer
-		"sub":        username,                       // Subject
-		"iat":        time.Now().UTC().Unix(),        // Issued At
-		"jti":        tknID.String(),                 // JWT ID
-		"kontrolURL": k.Kite.Config.KontrolURL,       // Kontrol URL
-		"kontrolKey": strings.TrimSpace(k.publicKey), // Public key of kontrol
+		"iss":        k.Kite.Kite().Username,       // Issuer
+		"sub":        username,                     // Subject
+		"iat":        time.Now().UTC().Unix(),      // Issued At
+		"jti":        tknID.String(),               // JWT ID
+		"kontrolURL": k.Kite.Config.KontrolURL,     // Kontrol URL
+		"kontrolKey": strings.TrimSpace(publicKey), // Public key of kontrol
 	}
 
 	k.Kite.Log.Info("Registered machine on user: %s", username)
 
-	return token.SignedString([]byte(k.privateKey))
+	return token.SignedString(key)
 }
 
 // registerSelf adds Kontrol itself to the storage as a kite.
 func (k *Kontrol) registerSelf() {
 	value := &kontrolprotocol.RegisterValue{
-		URL: k.Kite.Config.KontrolURL,
+		URL:    k.Kite.Config.KontrolURL,
+		Labels: k.Kite.Config.Labels,
 	}
 
 	// change if the user wants something different
@@ -205,11 +439,16 @@ func (k *Kontrol) registerSelf() {
 
 // generateToken returns a JWT token string. Please see the URL for details:
 // http://tools.ietf.org/html/draft-ietf-oauth-json-web-token-13#section-4.1
-func generateToken(aud, username, issuer, privateKey string) (string, error) {
+//
+// The ttl identifies the expiration time after which the JWT MUST NOT be
+// accepted for processing. Implementers MAY provide for some small leeway,
+// usually no more than a few minutes, to account for clock skew.
+func generateToken(aud, username, issuer, kid, privateKey, alg, kiteKeyID string, scopes, roles []string, ttl, leeway time.Duration) (string, error) {
 	tokenCacheMu.Lock()
 	defer tokenCacheMu.Unlock()
 
-	uniqKey := aud + username + issuer // neglect privateKey, its always the same
+	// kid identifies the privateKey
+	uniqKey := aud + username + issuer + kid + alg + kiteKeyID + strings.Join(scopes, " ") + "|" + strings.Join(roles, " ") + ttl.String() + leeway.String()
 	signed, ok := tokenCache[uniqKey]
 	if ok {
 		return signed, nil
@@ -220,15 +459,13 @@ func generateToken(aud, username, issuer, privateKey string) (string, error) {
 		return "", errors.New("Server error: Cannot generate a token")
 	}
 
-	// Identifies the expiration time after which the JWT MUST NOT be accepted
-	// for processing.
-	ttl := TokenTTL
-
-	// Implementers MAY provide for some small leeway, usually no more than
-	// a few minutes, to account for clock skew.
-	leeway := TokenLeeway
+	method, key, err := jwtkeys.SigningKeyMethod(privateKey, alg)
+	if err != nil {
+		return "", err
+	}
 
-	tkn := jwt.New(jwt.GetSigningMethod("RS256"))
+	tkn := jwt.New(method)
+	tkn.Header["kid"] = kid
 	tkn.Claims["iss"] = issuer                                       // Issuer
 	tkn.Claims["sub"] = username                                     // Subject
 	tkn.Claims["aud"] = aud                                          // Audience
@@ -237,23 +474,48 @@ func generateToken(aud, username, issuer, privateKey string) (string, error) {
 	tkn.Claims["iat"] = time.Now().UTC().Unix()                      // Issued At
 	tkn.Claims["jti"] = tknID.String()                               // JWT ID
 
-	signed, err = tkn.SignedString([]byte(privateKey))
+	if kiteKeyID != "" {
+		tkn.Claims["kiteKeyID"] = kiteKeyID
+	}
+
+	if len(scopes) != 0 {
+		tkn.Claims["scopes"] = scopes
+	}
+
+	if len(roles) != 0 {
+		tkn.Claims["roles"] = roles
+	}
+
+	signed, err = tkn.SignedString(key)
 	if err != nil {
 		return "", errors.New("Server error: Cannot generate a token")
 	}
 
 	// cache our token
 	tokenCache[uniqKey] = signed
+	tokenCacheIDs[tknID.String()] = uniqKey
 
 	// cache invalidation, because we cache the token in tokenCache we need to
 	// invalidate it expiration time. This was handled usually within JWT, but
 	// now we have to do it manually for our own cache.
-	time.AfterFunc(TokenTTL-TokenLeeway, func() {
+	time.AfterFunc(ttl-leeway, func() {
 		tokenCacheMu.Lock()
 		defer tokenCacheMu.Unlock()
 
 		delete(tokenCache, uniqKey)
+		delete(tokenCacheIDs, tknID.String())
 	})
 
 	return signed, nil
 }
+
+// invalidateToken removes the token with the id from the token cache.
+func invalidateToken(jti string) {
+	tokenCacheMu.Lock()
+	defer tokenCacheMu.Unlock()
+
+	if uniqKey, ok := tokenCacheIDs[jti]; ok {
+		delete(tokenCache, uniqKey)
+		delete(tokenCacheIDs, jti)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"log"
 	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/koding/kite"
 	"github.com/koding/kite/config"
@@ -27,9 +29,32 @@ type Kontrol struct {
 
 	PublicKeyFile  string
 	PrivateKeyFile string
-
-	Machines []string
-	Version  string `default:"0.0.1"`
+	SigningMethod  string // like "RS512", chosen by the key type by default
+
+	Machines      []string
+	Version       string `default:"0.0.1"`
+	Webhooks      []string
+	WebhookSecret string   // signs the webhook requests
+	CORSOrigins   []string // browser origins that can query GET /kites
+	AuditLog      string   // path of the audit log file
+	Stateless     bool     // many instances behind a load balancer
+	Metrics       bool     // serves /metrics to the admins and publishes expvar
+
+	// federation with the kontrols of the other clusters, peers are given
+	// as "cluster,kontrolURL,kiteKeyFile" and the federation users as
+	// "kontrolUsername,username,..." where "*" allows all users
+	Cluster         string
+	Peers           []string
+	FederationUsers []string
+
+	// per user and minute, zero means no limit
+	RegisterLimit int64
+	GetKitesLimit int64
+	GetTokenLimit int64
+
+	// zero means the defaults of kontrol
+	TokenTTL    time.Duration
+	TokenLeeway time.Duration
 
 	Postgres struct {
 		Host     string `default:"localhost"`
@@ -79,6 +104,58 @@ func main() {
 		k.RegisterURL = conf.RegisterUrl
 	}
 
+	if conf.AuditLog != "" {
+		f, err := os.OpenFile(conf.AuditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			log.Fatalf("cannot open audit log: %s", err.Error())
+		}
+		defer f.Close()
+
+		k.AuditLog = kontrol.NewJSONAuditSink(f)
+	}
+
+	k.Webhooks = conf.Webhooks
+	k.WebhookSecret = conf.WebhookSecret
+	k.CORSOrigins = conf.CORSOrigins
+	k.Stateless = conf.Stateless
+	k.TokenTTL = conf.TokenTTL
+	k.TokenLeeway = conf.TokenLeeway
+	k.SigningMethod = conf.SigningMethod
+	k.Cluster = conf.Cluster
+
+	k.FederationUsers = make(map[string][]string)
+	for _, u := range conf.FederationUsers {
+		fields := strings.Split(u, ",")
+		if len(fields) < 2 {
+			log.Fatalf("invalid federation user: %s", u)
+		}
+
+		k.FederationUsers[fields[0]] = fields[1:]
+	}
+
+	for _, p := range conf.Peers {
+		fields := strings.Split(p, ",")
+		if len(fields) != 3 {
+			log.Fatalf("invalid peer: %s", p)
+		}
+
+		kiteKey, err := ioutil.ReadFile(fields[2])
+		if err != nil {
+			log.Fatalf("cannot read kite key of peer %s: %s", fields[0], err.Error())
+		}
+
+		k.AddPeer(fields[0], fields[1], strings.TrimSpace(string(kiteKey)))
+	}
+
+	if conf.Metrics {
+		k.HandleMetrics()
+		k.PublishMetrics("kontrol")
+	}
+
+	k.RegisterLimit = perMinute(conf.RegisterLimit)
+	k.GetKitesLimit = perMinute(conf.GetKitesLimit)
+	k.GetTokenLimit = perMinute(conf.GetTokenLimit)
+
 	switch os.Getenv("KONTROL_STORAGE") {
 	case "etcd":
 		k.SetStorage(kontrol.NewEtcd(conf.Machines, k.Kite.Log))
@@ -92,12 +169,24 @@ func main() {
 		}
 
 		k.SetStorage(kontrol.NewPostgres(postgresConf, k.Kite.Log))
+	case "memory":
+		k.SetStorage(kontrol.NewMemoryStorage())
 	}
 
 	k.Kite.SetLogLevel(kite.DEBUG)
 	k.Run()
 }
 
+// perMinute returns a rate limiter allowing n calls per minute, nil if n is
+// zero.
+func perMinute(n int64) *kite.RateLimiter {
+	if n <= 0 {
+		return nil
+	}
+
+	return kite.NewRateLimiter(time.Minute/time.Duration(n), n)
+}
+
 func initialKey(kontrolConf *Kontrol, publicKey, privateKey []byte) {
 	conf := config.New()
 
@@ -1,8 +1,19 @@
 package kontrol
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"net/http"
 	"net/url"
 	"os"
 	"strconv"
@@ -13,6 +24,7 @@ import (
 	"github.com/dgrijalva/jwt-go"
 	"github.com/koding/kite"
 	"github.com/koding/kite/config"
+	"github.com/koding/kite/dnode"
 	"github.com/koding/kite/kitekey"
 	"github.com/koding/kite/protocol"
 	"github.com/koding/kite/testkeys"
@@ -41,6 +53,8 @@ func init() {
 		kon.SetStorage(NewEtcd(nil, kon.Kite.Log))
 	case "postgres":
 		kon.SetStorage(NewPostgres(nil, kon.Kite.Log))
+	case "memory":
+		kon.SetStorage(NewMemoryStorage())
 	default:
 		kon.SetStorage(NewEtcd(nil, kon.Kite.Log))
 	}
@@ -126,6 +140,402 @@ func TestTokenInvalidation(t *testing.T) {
 
 }
 
+func TestTokenTTLPolicy(t *testing.T) {
+	ttls := map[string]time.Duration{
+		"shortlivedkite": time.Minute,
+		"defaultttlkite": 0,
+		"longlivedkite":  96 * time.Hour,
+	}
+
+	kon.TokenTTLPolicy = func(username, audience string) time.Duration {
+		a, _ := protocol.KiteFromString(audience)
+		return ttls[a.Name]
+	}
+	kon.MaxTokenTTL = 72 * time.Hour
+	defer func() {
+		kon.TokenTTLPolicy = nil
+		kon.MaxTokenTTL = 0
+	}()
+
+	_, leeway := kon.defaultTokenTTL()
+
+	for name, want := range map[string]time.Duration{
+		"shortlivedkite": time.Minute,
+		"defaultttlkite": TokenTTL,
+		"longlivedkite":  kon.MaxTokenTTL,
+	} {
+		m := kite.New(name, "1.0.0")
+		m.Config = conf.Copy()
+		defer m.Close()
+
+		kiteURL := &url.URL{Scheme: "http", Host: "localhost:4456", Path: "/kite"}
+		if _, err := m.Register(kiteURL); err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := m.GetToken(m.Kite())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tkn, err := jwt.Parse(token, m.RSAKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// exp is in seconds
+		exp := time.Unix(int64(tkn.Claims["exp"].(float64)), 0)
+		if ttl := exp.Sub(time.Now()); ttl > want+leeway+time.Second || ttl < want+leeway-2*time.Second {
+			t.Errorf("%s: got token TTL %s, want: %s", name, ttl, want+leeway)
+		}
+	}
+
+	// the revocations are kept as long as the longest token can be valid
+	if err := kon.revokeToken("ttl-policy-jti"); err != nil {
+		t.Fatal(err)
+	}
+
+	kon.revokedMu.Lock()
+	expires := kon.revoked["ttl-policy-jti"]
+	kon.revokedMu.Unlock()
+
+	if keep := expires.Sub(time.Now()); keep < kon.MaxTokenTTL+leeway-time.Second {
+		t.Errorf("revocation is kept for %s, want: %s", keep, kon.MaxTokenTTL+leeway)
+	}
+}
+
+func TestTokenRoles(t *testing.T) {
+	kon.TokenRoles = func(username, audience string) []string {
+		return []string{"admin"}
+	}
+	defer func() { kon.TokenRoles = nil }()
+
+	m := kite.New("adminkite", "1.0.0")
+	m.Config = conf.Copy()
+	defer m.Close()
+
+	kiteURL := &url.URL{Scheme: "http", Host: "localhost:4456", Path: "/kite"}
+	if _, err := m.Register(kiteURL); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := m.GetToken(m.Kite())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tkn, err := jwt.Parse(token, m.RSAKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roles, _ := tkn.Claims["roles"].([]interface{})
+	if len(roles) != 1 || roles[0] != "admin" {
+		t.Errorf("got roles %v, want: [admin]", tkn.Claims["roles"])
+	}
+}
+
+func TestRenewToken(t *testing.T) {
+	m := kite.New("mathworker8", "1.1.1")
+	m.Config = conf.Copy()
+	m.Config.Port = 6668
+
+	kiteURL := &url.URL{Scheme: "http", Host: "localhost:6668", Path: "/mathworker8"}
+	_, err := m.Register(kiteURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	token, err := m.GetToken(m.Kite())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renewed, err := m.RenewToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tkn, err := jwt.Parse(renewed, m.RSAKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sub := tkn.Claims["sub"].(string); sub != m.Kite().Username {
+		t.Errorf("invalid subject in renewed token: %s", sub)
+	}
+
+	if _, err := m.RenewToken("invalid"); err == nil {
+		t.Error("invalid token is renewed")
+	}
+}
+
+// newTestKeyPair returns a new PEM encoded RSA key pair.
+func newTestKeyPair(t *testing.T) (publicKey, privateKey string) {
+	rsaKey, err := rsa.GenerateKey(crand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	privateKey = string(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+	}))
+
+	return publicKey, privateKey
+}
+
+// encodeKeyPair returns the PEM encoded public and private keys of the key.
+func encodeKeyPair(t *testing.T, key crypto.Signer) (publicKey, privateKey string) {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	if der, err = x509.MarshalPKCS8PrivateKey(key); err != nil {
+		t.Fatal(err)
+	}
+
+	privateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	return publicKey, privateKey
+}
+
+func TestSigningKeys(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(crand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key    crypto.Signer
+		method string // SigningMethod of kontrol
+		alg    string
+	}{
+		{ecKey, "", "ES256"},
+		{edKey, "", "EdDSA"},
+		{rsaKey, "RS512", "RS512"},
+	}
+
+	for i, test := range tests {
+		publicKey, privateKey := encodeKeyPair(t, test.key)
+
+		c := conf.Copy()
+		c.Port = 5560 + i
+		c.KontrolURL = fmt.Sprintf("http://localhost:%d/kite", c.Port)
+		c.KontrolKey = publicKey
+
+		k := New(c.Copy(), "0.0.1", publicKey, privateKey)
+		k.SetStorage(NewMemoryStorage())
+		k.SigningMethod = test.method
+
+		go k.Run()
+		<-k.Kite.ServerReadyNotify()
+		defer k.Close()
+
+		kiteKey, err := k.registerUser(c.Username)
+		if err != nil {
+			t.Fatalf("%s: %s", test.alg, err)
+		}
+
+		c.KiteKey = kiteKey
+		c.Port = 4461 + i
+
+		// the kite authenticates the token of the caller with the key
+		m := kite.New("signedkite", "1.0.0")
+		m.Config = c.Copy()
+		m.HandleFunc("hello", func(r *kite.Request) (interface{}, error) {
+			return "hello " + r.Username, nil
+		})
+
+		go m.Run()
+		<-m.ServerReadyNotify()
+		defer m.Close()
+
+		kiteURL := &url.URL{Scheme: "http", Host: fmt.Sprintf("localhost:%d", c.Port), Path: "/kite"}
+		if _, err := m.Register(kiteURL); err != nil {
+			t.Fatalf("%s: %s", test.alg, err)
+		}
+
+		// the caller verifies the tokens in the result with the key
+		exp := kite.New("exp-signing", "0.0.1")
+		exp.Config = c.Copy()
+		defer exp.Close()
+
+		kites, err := exp.GetKites(&protocol.KontrolQuery{
+			Username:    c.Username,
+			Environment: c.Environment,
+			Name:        "signedkite",
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", test.alg, err)
+		}
+
+		token, err := jwt.Parse(kites[0].Auth.Key, exp.RSAKey)
+		if err != nil {
+			t.Fatalf("%s: %s", test.alg, err)
+		}
+
+		if token.Method.Alg() != test.alg {
+			t.Errorf("got signing method %s, want: %s", token.Method.Alg(), test.alg)
+		}
+
+		if err := kites[0].Dial(); err != nil {
+			t.Fatalf("%s: %s", test.alg, err)
+		}
+		defer kites[0].Close()
+
+		result, err := kites[0].TellWithTimeout("hello", 4*time.Second)
+		if err != nil {
+			t.Fatalf("%s: %s", test.alg, err)
+		}
+
+		if s := result.MustString(); s != "hello "+c.Username {
+			t.Errorf("%s: got %q", test.alg, s)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	publicKey, privateKey := newTestKeyPair(t)
+
+	oldKid := kite.KeyID(testkeys.Public)
+	newKid := kon.RotateKey(publicKey, privateKey)
+	defer func() {
+		kon.RotateKey(testkeys.Public, testkeys.Private)
+		if err := kon.RetireKey(newKid); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	m := kite.New("mathworker9", "1.1.1")
+	m.Config = conf.Copy()
+	m.Config.Port = 10006
+
+	kiteURL := &url.URL{Scheme: "http", Host: "localhost:10006", Path: "/mathworker9"}
+	if _, err := m.Register(kiteURL); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	token, err := m.GetToken(m.Kite())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new key is unknown until the kite updates its keys
+	if _, err := jwt.Parse(token, m.RSAKey); err == nil {
+		t.Fatal("token signed with an unknown key is accepted")
+	}
+
+	if err := m.UpdateKontrolKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	tkn, err := jwt.Parse(token, m.RSAKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if kid := tkn.Header["kid"]; kid != newKid {
+		t.Errorf("got kid %v, want: %s", kid, newKid)
+	}
+
+	if err := kon.RetireKey(newKid); err == nil {
+		t.Error("current signing key is retired")
+	}
+
+	if _, ok := m.KontrolKeys()[oldKid]; !ok {
+		t.Error("previous key is not trusted anymore before it's retired")
+	}
+
+	// the key in the config is not used after it's retired
+	oldToken, err := generateToken("aud", "testuser", "testuser", oldKid, testkeys.Private, "", "", nil, nil, time.Hour, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := jwt.Parse(oldToken, kon.Kite.RSAKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kon.RetireKey(oldKid); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := jwt.Parse(oldToken, kon.Kite.RSAKey); err == nil {
+		t.Error("token signed with the retired key is accepted")
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	m := kite.New("mathworker10", "1.1.1")
+	m.Config = conf.Copy()
+	m.Config.Port = 10007
+
+	kiteURL := &url.URL{Scheme: "http", Host: "localhost:10007", Path: "/mathworker10"}
+	if _, err := m.Register(kiteURL); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	token, err := m.GetToken(m.Kite())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tkn, err := jwt.Parse(token, m.RSAKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jti := tkn.Claims["jti"].(string)
+
+	if err := m.RevokeToken(jti); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.UpdateRevokedTokens(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !m.IsTokenRevoked(jti) {
+		t.Fatal("token is not in the revocation list")
+	}
+
+	if _, err := m.RenewToken(token); err == nil {
+		t.Error("revoked token is renewed")
+	}
+
+	newToken, err := m.GetToken(m.Kite())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if newToken == token {
+		t.Error("revoked token is returned from the cache")
+	}
+}
+
 func TestMultiple(t *testing.T) {
 	testDuration := time.Second * 10
 
@@ -253,6 +663,103 @@ func TestGetKites(t *testing.T) {
 	}
 }
 
+func TestGetKitesPage(t *testing.T) {
+	testName := "mathworker-page"
+
+	for i := 0; i < 3; i++ {
+		m := kite.New(testName, "1.0.0")
+		m.Config = conf.Copy()
+
+		kiteURL := &url.URL{Scheme: "http", Host: "localhost:" + strconv.Itoa(4447+i), Path: "/kite"}
+		if _, err := m.Register(kiteURL); err != nil {
+			t.Fatal(err)
+		}
+		defer m.Close()
+	}
+
+	query := &protocol.KontrolQuery{
+		Username:    conf.Username,
+		Environment: conf.Environment,
+		Name:        testName,
+	}
+
+	exp := kite.New("exp-page", "0.0.1")
+	exp.Config = conf.Copy()
+
+	seen := make(map[string]bool)
+	for offset := 0; offset < 3; offset++ {
+		kites, total, err := exp.GetKitesPage(query, offset, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if total != 3 || len(kites) != 1 {
+			t.Fatalf("got %d kites of %d, want: 1 of 3", len(kites), total)
+		}
+
+		seen[kites[0].ID] = true
+	}
+
+	if len(seen) != 3 {
+		t.Errorf("pages have returned the same kites: %v", seen)
+	}
+
+	kites, _, err := exp.GetKitesPage(query, 3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(kites) != 0 {
+		t.Errorf("got %d kites after the last page", len(kites))
+	}
+
+	// GetKites gets all of the pages
+	kon.MaxKitesPerQuery = 1
+	defer func() { kon.MaxKitesPerQuery = 0 }()
+
+	all, err := exp.GetKites(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen = make(map[string]bool)
+	for _, c := range all {
+		seen[c.ID] = true
+	}
+
+	if len(all) != 3 || len(seen) != 3 {
+		t.Errorf("got kites %v, want: 3 different kites", seen)
+	}
+
+	query.Weighted = true
+	if _, err := exp.GetKites(query); err != kite.ErrKitesTruncated {
+		t.Errorf("got error %v, want: %v", err, kite.ErrKitesTruncated)
+	}
+}
+
+func TestGetKitesLimit(t *testing.T) {
+	kon.GetKitesLimit = kite.NewRateLimiter(time.Hour, 1)
+	defer func() { kon.GetKitesLimit = nil }()
+
+	query := &protocol.KontrolQuery{
+		Username:    conf.Username,
+		Environment: conf.Environment,
+		Name:        "mathworker-limit",
+	}
+
+	exp := kite.New("exp-limit", "0.0.1")
+	exp.Config = conf.Copy()
+
+	if _, err := exp.GetKites(query); err != kite.ErrNoKitesAvailable {
+		t.Fatalf("got error %v, want: %v", err, kite.ErrNoKitesAvailable)
+	}
+
+	_, err := exp.GetKites(query)
+	if kErr, ok := err.(*kite.Error); !ok || kErr.Type != "rateLimited" {
+		t.Fatalf("got error %v, want: rateLimited", err)
+	}
+}
+
 func TestGetToken(t *testing.T) {
 	t.Log("Setting up mathworker5")
 	testName := "mathworker5"
@@ -417,3 +924,493 @@ func TestGetQueryKey(t *testing.T) {
 		t.Errorf("Key is not expected: %s", key)
 	}
 }
+
+func TestGetKitesHTTP(t *testing.T) {
+	testName := "mathworker-rest"
+	m := kite.New(testName, "1.0.0")
+	m.Config = conf.Copy()
+
+	kiteURL := &url.URL{Scheme: "http", Host: "localhost:4445", Path: "/kite"}
+	if _, err := m.Register(kiteURL); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	u := "http://localhost:5555/kites?tokens=true&username=" + conf.Username +
+		"&environment=" + conf.Environment + "&name=" + testName
+
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got status %d without kite key, want: %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+conf.KiteKey)
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want: %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var result protocol.GetKitesResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Kites) != 1 {
+		t.Fatalf("got %d kites, want: 1", len(result.Kites))
+	}
+
+	if result.Kites[0].Kite.Name != testName {
+		t.Errorf("got kite %q, want: %q", result.Kites[0].Kite.Name, testName)
+	}
+
+	if result.Kites[0].Token == "" {
+		t.Error("token is not attached")
+	}
+
+	if origin := resp.Header.Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("cross-origin requests are allowed without CORSOrigins: %q", origin)
+	}
+
+	// the queries are limited and audited like the getKites calls
+	var (
+		entries []*AuditEntry
+		mu      sync.Mutex
+	)
+
+	kon.AuditLog = AuditSinkFunc(func(e *AuditEntry) error {
+		mu.Lock()
+		entries = append(entries, e)
+		mu.Unlock()
+		return nil
+	})
+	kon.GetKitesLimit = kite.NewRateLimiter(time.Hour, 1)
+	kon.CORSOrigins = []string{"https://dashboard.example.com"}
+	defer func() {
+		kon.AuditLog = nil
+		kon.GetKitesLimit = nil
+		kon.CORSOrigins = nil
+	}()
+
+	req.Header.Set("Origin", "https://dashboard.example.com")
+
+	for _, status := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != status {
+			t.Fatalf("got status %d, want: %d", resp.StatusCode, status)
+		}
+
+		if origin := resp.Header.Get("Access-Control-Allow-Origin"); origin != "https://dashboard.example.com" {
+			t.Errorf("got allowed origin %q, want: https://dashboard.example.com", origin)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(entries) != 1 || entries[0].Action != "getKites" || entries[0].Username != conf.Username {
+		t.Errorf("got audit entries %+v, want: 1 getKites of %s", entries, conf.Username)
+	}
+}
+
+func TestStatelessHeartbeat(t *testing.T) {
+	m := kite.New("statelesskite", "1.0.0")
+	m.Config = conf.Copy()
+	defer m.Close()
+
+	// not registered via HTTP, like a kite registered to another instance
+	kiteURL := &url.URL{Scheme: "http", Host: "localhost:4453", Path: "/kite"}
+	if _, err := m.Register(kiteURL); err != nil {
+		t.Fatal(err)
+	}
+
+	heartbeat := func(key string) string {
+		req, err := http.NewRequest("GET", "http://localhost:5555/heartbeat?id="+m.Id, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(body)
+	}
+
+	if got := heartbeat(conf.KiteKey); got != "registeragain" {
+		t.Errorf("got %q, want: registeragain", got)
+	}
+
+	kon.Stateless = true
+	defer func() { kon.Stateless = false }()
+
+	// the storage is not touched for anyone knowing the kite id
+	if got := heartbeat(""); got != "registeragain" {
+		t.Errorf("got %q without kite key in stateless mode, want: registeragain", got)
+	}
+
+	if got := heartbeat(conf.KiteKey); got != "pong" {
+		t.Errorf("got %q in stateless mode, want: pong", got)
+	}
+}
+
+func TestSharedState(t *testing.T) {
+	storage := NewMemoryStorage()
+	defer storage.Close()
+
+	instances := make([]*Kontrol, 2)
+	for i := range instances {
+		instances[i] = New(conf.Copy(), "0.0.1", testkeys.Public, testkeys.Private)
+		instances[i].SetStorage(storage)
+		instances[i].Stateless = true
+	}
+	k1, k2 := instances[0], instances[1]
+
+	if err := k1.revokeToken("shared-jti"); err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, privateKey := newTestKeyPair(t)
+	kid := k1.RotateKey(publicKey, privateKey)
+
+	if err := k2.loadState(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !k2.Kite.IsTokenRevoked("shared-jti") {
+		t.Error("revoked token is not shared")
+	}
+
+	if current, _, _ := k2.signingKey(); current != kid {
+		t.Errorf("got signing key %q, want: %q", current, kid)
+	}
+
+	if _, ok := k2.Kite.KontrolKeys()[kite.KeyID(testkeys.Public)]; !ok {
+		t.Error("previous key is not accepted after the rotation")
+	}
+
+	// the changes of the instances don't overwrite each other
+	if err := k2.revokeToken("other-jti"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k2.RetireKey(kite.KeyID(testkeys.Public)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k1.loadState(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !k1.Kite.IsTokenRevoked("shared-jti") || !k1.Kite.IsTokenRevoked("other-jti") {
+		t.Errorf("got revoked tokens %v, want: shared-jti and other-jti", k1.revokedTokens())
+	}
+
+	if _, ok := k1.Kite.KontrolKeys()[kite.KeyID(testkeys.Public)]; ok {
+		t.Error("retired key is still accepted")
+	}
+}
+
+func TestAdminMethods(t *testing.T) {
+	m := kite.New("mathworker-admin", "1.0.0")
+	m.Config = conf.Copy()
+
+	kiteURL := &url.URL{Scheme: "http", Host: "localhost:4446", Path: "/kite"}
+	if _, err := m.Register(kiteURL); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	c := m.NewClient(conf.KontrolURL)
+	c.Auth = &kite.Auth{Type: "kiteKey", Key: conf.KiteKey}
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	result, err := c.TellWithTimeout("listAllKites", 4*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var kites []*AdminKite
+	if err := result.Unmarshal(&kites); err != nil {
+		t.Fatal(err)
+	}
+
+	var found *AdminKite
+	for _, k := range kites {
+		if k.Kite.ID == m.Kite().ID {
+			found = k
+		}
+	}
+
+	if found == nil {
+		t.Fatal("registered kite is not listed")
+	}
+
+	if found.LastSeen.IsZero() {
+		t.Error("last seen time is not set")
+	}
+
+	if _, err := c.TellWithTimeout("forceDeregister", 4*time.Second, m.Kite().ID); err != nil {
+		t.Fatal(err)
+	}
+
+	kites, err = kon.AllKites()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range kites {
+		if k.Kite.ID == m.Kite().ID {
+			t.Error("kite is not deregistered")
+		}
+	}
+}
+
+func TestDeregister(t *testing.T) {
+	m := kite.New("deregisterkite", "1.0.0")
+	m.Config = conf.Copy()
+	defer m.Close()
+
+	kiteURL := &url.URL{Scheme: "http", Host: "localhost:4450", Path: "/kite"}
+	if _, err := m.Register(kiteURL); err != nil {
+		t.Fatal(err)
+	}
+
+	query := &protocol.KontrolQuery{
+		Username:    conf.Username,
+		Environment: conf.Environment,
+		Name:        "deregisterkite",
+	}
+
+	exp := kite.New("exp-deregister", "0.0.1")
+	exp.Config = conf.Copy()
+
+	if _, err := exp.GetKites(query); err != nil {
+		t.Fatal(err)
+	}
+
+	// the kites of the other users can't be deregistered
+	os.Setenv("TESTKEY_USERNAME", "otheruser")
+	defer os.Unsetenv("TESTKEY_USERNAME")
+
+	impostor := kite.New("deregisterkite", "1.0.0")
+	impostor.Config = conf.Copy()
+	impostor.Config.Username = "otheruser"
+	impostor.Config.KiteKey = testutil.NewKiteKey().Raw
+	impostor.Id = m.Id
+	defer impostor.Close()
+
+	if err := impostor.DeregisterFromKontrol(); err == nil {
+		t.Error("kite is deregistered by another user")
+	}
+
+	if _, err := exp.GetKites(query); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.DeregisterFromKontrol(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := exp.GetKites(query); err != kite.ErrNoKitesAvailable {
+		t.Errorf("got error %v, want: %v", err, kite.ErrNoKitesAvailable)
+	}
+}
+
+func TestReregister(t *testing.T) {
+	defer func(interval, delay time.Duration) {
+		HeartbeatInterval, HeartbeatDelay = interval, delay
+	}(HeartbeatInterval, HeartbeatDelay)
+
+	HeartbeatInterval = time.Second
+	HeartbeatDelay = time.Second
+
+	kiteURL := &url.URL{Scheme: "http", Host: "localhost:4464", Path: "/kite"}
+
+	m := kite.New("reregisterkite", "1.0.0")
+	m.Config = conf.Copy()
+
+	if _, err := m.Register(kiteURL); err != nil {
+		t.Fatal(err)
+	}
+
+	// the kite reconnects before the updater of the first connection
+	// expires it
+	m.Close()
+
+	m2 := kite.New("reregisterkite", "1.0.0")
+	m2.Config = conf.Copy()
+	m2.Id = m.Id
+	defer m2.Close()
+
+	if _, err := m2.Register(kiteURL); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(2 * (HeartbeatInterval + HeartbeatDelay))
+
+	query := &protocol.KontrolQuery{
+		Username:    conf.Username,
+		Environment: conf.Environment,
+		Name:        "reregisterkite",
+	}
+
+	exp := kite.New("exp-reregister", "0.0.1")
+	exp.Config = conf.Copy()
+
+	kites, err := exp.GetKites(query)
+	if err != nil {
+		t.Fatalf("kite is expired after registering again: %s", err)
+	}
+
+	if len(kites) != 1 || kites[0].ID != m.Id {
+		t.Errorf("got %d kites, want: %s", len(kites), m.Id)
+	}
+}
+
+func TestRegisterToMultiple(t *testing.T) {
+	conf2 := conf.Copy()
+	conf2.Port = 5557
+	conf2.KontrolURL = "http://localhost:5557/kite"
+
+	kon2 := New(conf2.Copy(), "0.0.1", testkeys.Public, testkeys.Private)
+	kon2.SetStorage(NewMemoryStorage())
+
+	go kon2.Run()
+	<-kon2.Kite.ServerReadyNotify()
+	defer kon2.Close()
+
+	m := kite.New("multiplekite", "1.0.0")
+	m.Config = conf.Copy()
+	defer m.Close()
+
+	kontrols := []*url.URL{
+		{Scheme: "http", Host: "localhost:5555", Path: "/kite"},
+		{Scheme: "http", Host: "localhost:5557", Path: "/kite"},
+	}
+
+	kiteURL := &url.URL{Scheme: "http", Host: "localhost:4457", Path: "/kite"}
+	if err := m.RegisterToMultiple(kontrols, kiteURL); err != nil {
+		t.Fatal(err)
+	}
+
+	query := &protocol.KontrolQuery{
+		Username:    conf.Username,
+		Environment: conf.Environment,
+		Name:        "multiplekite",
+	}
+
+	for _, c := range []*config.Config{conf, conf2} {
+		exp := kite.New("exp-multiple", "0.0.1")
+		exp.Config = c.Copy()
+
+		kites, err := exp.GetKites(query)
+		if err != nil {
+			t.Fatalf("%s: %s", c.KontrolURL, err)
+		}
+
+		if len(kites) != 1 || kites[0].ID != m.Id {
+			t.Errorf("%s: got %d kites, want: %s", c.KontrolURL, len(kites), m.Id)
+		}
+
+		exp.Close()
+	}
+
+	if err := m.DeregisterFromKontrol(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []*config.Config{conf, conf2} {
+		exp := kite.New("exp-multiple", "0.0.1")
+		exp.Config = c.Copy()
+
+		if _, err := exp.GetKites(query); err != kite.ErrNoKitesAvailable {
+			t.Errorf("%s: got error %v, want: %v", c.KontrolURL, err, kite.ErrNoKitesAvailable)
+		}
+
+		exp.Close()
+	}
+}
+
+func TestEnrollMachine(t *testing.T) {
+	kon.EnrollAuthURL = func(username, id string) string {
+		return "http://localhost/approve?id=" + id
+	}
+	defer func() { kon.EnrollAuthURL = nil }()
+
+	m := kite.New("machine", "0.0.1")
+	m.Config = conf.Copy()
+	defer m.Close()
+
+	c := m.NewClient(conf.KontrolURL)
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	authURL := dnode.Callback(func(args *dnode.Partial) {
+		u, err := url.Parse(args.One().MustString())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if err := kon.ApproveEnrollment(u.Query().Get("id"), "approved"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	result, err := c.TellWithTimeout("enrollMachine", 4*time.Second, map[string]interface{}{
+		"username": "claimed",
+		"authURL":  authURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := jwt.Parse(result.MustString(), kitekey.GetKontrolKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if username := token.Claims["sub"].(string); username != "approved" {
+		t.Errorf("got username %q, want: approved", username)
+	}
+
+	if err := kon.ApproveEnrollment("unknown", "approved"); err != ErrEnrollmentNotFound {
+		t.Errorf("got %v, want: %v", err, ErrEnrollmentNotFound)
+	}
+}
@@ -0,0 +1,19 @@
+package kontrol
+
+import (
+	"github.com/koding/kite"
+)
+
+// allow takes a token from the bucket of the user in the limiter. It returns
+// the same error as the rate limited kite methods if the user has exceeded
+// the limit. Nil limiter allows all.
+func allow(l *kite.RateLimiter, username string) error {
+	if l == nil || l.Allow(username) {
+		return nil
+	}
+
+	return &kite.Error{
+		Type:    "rateLimited",
+		Message: "Rate limit is exceeded, try again later.",
+	}
+}
@@ -0,0 +1,63 @@
+package kontrol
+
+import (
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/koding/kite/protocol"
+)
+
+// setLoad records the load reported by the kite with the id.
+func (k *Kontrol) setLoad(id string, load *protocol.Load) {
+	k.loadsMu.Lock()
+	k.loads[id] = load
+	k.loadsMu.Unlock()
+}
+
+// attachLoads sets the loads of the kites that have reported them to this
+// kontrol. The loads of the kites registered to other kontrols are not known.
+func (k *Kontrol) attachLoads(kites Kites) {
+	k.loadsMu.Lock()
+	defer k.loadsMu.Unlock()
+
+	for _, kite := range kites {
+		if load, ok := k.loads[kite.Kite.ID]; ok {
+			l := *load
+			kite.Load = &l
+		}
+	}
+}
+
+// OrderByLoad orders the kites by their loads, the least loaded one being the
+// first. The kites without a load are moved to the end, keeping their order.
+func (k Kites) OrderByLoad() {
+	sort.SliceStable(k, func(i, j int) bool {
+		a, b := k[i].Load, k[j].Load
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return a.Less(b)
+	})
+}
+
+// loadFromQuery parses the load sent with the HTTP heartbeats as the
+// "inflight" and "cpu" query parameters.
+func loadFromQuery(q url.Values) (*protocol.Load, bool) {
+	if q.Get("inflight") == "" {
+		return nil, false
+	}
+
+	inflight, err := strconv.Atoi(q.Get("inflight"))
+	if err != nil {
+		return nil, false
+	}
+
+	cpu, _ := strconv.ParseFloat(q.Get("cpu"), 64)
+	if inflight < 0 || cpu < 0 || math.IsNaN(cpu) || math.IsInf(cpu, 0) {
+		return nil, false
+	}
+
+	return &protocol.Load{InFlight: inflight, CPU: cpu}, true
+}
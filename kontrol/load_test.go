@@ -0,0 +1,82 @@
+package kontrol
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/koding/kite/protocol"
+	"github.com/koding/kite/testkeys"
+)
+
+func TestOrderByLoad(t *testing.T) {
+	kite := func(id string, load *protocol.Load) *protocol.KiteWithToken {
+		return &protocol.KiteWithToken{Kite: protocol.Kite{ID: id}, Load: load}
+	}
+
+	kites := Kites{
+		kite("unknown", nil),
+		kite("busy", &protocol.Load{InFlight: 10}),
+		kite("hot", &protocol.Load{InFlight: 1, CPU: 0.9}),
+		kite("idle", &protocol.Load{InFlight: 1, CPU: 0.1}),
+	}
+
+	kites.OrderByLoad()
+
+	want := []string{"idle", "hot", "busy", "unknown"}
+	for i, id := range want {
+		if kites[i].Kite.ID != id {
+			t.Fatalf("got kite %q at %d, want: %q", kites[i].Kite.ID, i, id)
+		}
+	}
+}
+
+func TestLoadFromQuery(t *testing.T) {
+	load, ok := loadFromQuery(url.Values{"inflight": {"3"}, "cpu": {"0.25"}})
+	if !ok || load.InFlight != 3 || load.CPU != 0.25 {
+		t.Errorf("wrong load: %+v", load)
+	}
+
+	if _, ok := loadFromQuery(url.Values{"id": {"foo"}}); ok {
+		t.Error("load is parsed from a heartbeat without load")
+	}
+
+	for _, cpu := range []string{"NaN", "+Inf", "-1"} {
+		if _, ok := loadFromQuery(url.Values{"inflight": {"1"}, "cpu": {cpu}}); ok {
+			t.Errorf("invalid cpu %s is accepted", cpu)
+		}
+	}
+}
+
+func TestHeartbeatLoad(t *testing.T) {
+	kon := New(conf.Copy(), "0.0.1", testkeys.Public, testkeys.Private)
+
+	kon.heartbeats["kite-id"] = time.AfterFunc(time.Hour, func() {})
+	defer kon.heartbeats["kite-id"].Stop()
+
+	heartbeat := func(username, authorization string) *protocol.Load {
+		kon.heartbeatUsers["kite-id"] = username
+
+		req := httptest.NewRequest("GET", "/heartbeat?id=kite-id&inflight=3&cpu=0.5", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		kon.loads = make(map[string]*protocol.Load)
+		kon.handleHeartbeat(httptest.NewRecorder(), req)
+		return kon.loads["kite-id"]
+	}
+
+	if load := heartbeat(conf.Username, ""); load != nil {
+		t.Errorf("unauthenticated load is accepted: %+v", load)
+	}
+
+	if load := heartbeat("otheruser", "Bearer "+conf.KiteKey); load != nil {
+		t.Errorf("load of another user's kite is accepted: %+v", load)
+	}
+
+	if load := heartbeat(conf.Username, "Bearer "+conf.KiteKey); load == nil || load.InFlight != 3 {
+		t.Errorf("got load %+v, want: 3 in flight", load)
+	}
+}
@@ -0,0 +1,399 @@
+package kontrol
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	kontrolprotocol "github.com/koding/kite/kontrol/protocol"
+	"github.com/koding/kite/protocol"
+)
+
+// MemoryStorage implements the Storage, Watcher and StateStorage interfaces
+// by keeping the kites in memory. It's useful for tests and single node
+// setups, where running an external storage is not needed. Kites are lost
+// when kontrol is restarted.
+type MemoryStorage struct {
+	kites map[string]*memoryKite // keys are kite.String()
+	state map[string][]byte
+	mu    sync.Mutex // protects kites, state and watchers
+
+	watchers map[*memoryWatcher]struct{}
+
+	done      chan struct{} // closed by Close to stop the cleaner
+	closeOnce sync.Once
+}
+
+// memoryWatchQueue is the number of events buffered for each watcher. A
+// watcher that falls behind more than this is stopped, so the registrations
+// are never blocked by a slow watcher.
+const memoryWatchQueue = 256
+
+// errWatcherTooSlow is returned from Watch when the events are not consumed
+// fast enough and some of them are dropped.
+var errWatcherTooSlow = errors.New("watcher is too slow, events are dropped")
+
+type memoryKite struct {
+	kite    protocol.Kite
+	value   kontrolprotocol.RegisterValue
+	expires time.Time
+}
+
+type memoryWatcher struct {
+	match func(*protocol.Kite) bool
+	queue chan *protocol.KiteEvent
+
+	// overflow is closed when the queue is full, protected by the lock of
+	// MemoryStorage
+	overflow   chan struct{}
+	overflowed bool
+}
+
+// NewMemoryStorage returns a new MemoryStorage. Expired kites are cleaned up
+// in the background every KeyTTL until the storage is closed.
+func NewMemoryStorage() *MemoryStorage {
+	m := &MemoryStorage{
+		kites:    make(map[string]*memoryKite),
+		state:    make(map[string][]byte),
+		watchers: make(map[*memoryWatcher]struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go m.RunCleaner(KeyTTL)
+
+	return m
+}
+
+// RunCleaner deletes the expired kites every interval. It returns when the
+// storage is closed.
+func (m *MemoryStorage) RunCleaner(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.CleanExpired()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// Close stops the cleaner. The storage can still be used after it's closed
+// but the expired kites are not cleaned up in the background.
+func (m *MemoryStorage) Close() error {
+	m.closeOnce.Do(func() { close(m.done) })
+	return nil
+}
+
+// CleanExpired deletes the kites that are not updated in KeyTTL.
+func (m *MemoryStorage) CleanExpired() {
+	now := time.Now()
+
+	m.mu.Lock()
+	var expired []*memoryKite
+	for key, k := range m.kites {
+		if now.After(k.expires) {
+			expired = append(expired, k)
+			delete(m.kites, key)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, k := range expired {
+		m.notify(&protocol.KiteEvent{Action: protocol.Deregister, Kite: k.kite})
+	}
+}
+
+// Get implements the Storage interface.
+func (m *MemoryStorage) Get(query *protocol.KontrolQuery) (Kites, error) {
+	match, err := queryMatcher(query)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	kites := make(Kites, 0)
+
+	m.mu.Lock()
+	for _, k := range m.kites {
+		if now.After(k.expires) || !match(&k.kite) {
+			continue
+		}
+
+		kites = append(kites, &protocol.KiteWithToken{
+			Kite: k.kite,
+			URL:  k.value.URL,
+			URLs: k.value.URLs,
+		})
+	}
+	m.mu.Unlock()
+
+	kites.Shuffle()
+
+	return kites, nil
+}
+
+// List implements the Lister interface.
+func (m *MemoryStorage) List() (Kites, error) {
+	now := time.Now()
+	kites := make(Kites, 0)
+
+	m.mu.Lock()
+	for _, k := range m.kites {
+		if now.After(k.expires) {
+			continue
+		}
+
+		kites = append(kites, &protocol.KiteWithToken{
+			Kite: k.kite,
+			URL:  k.value.URL,
+			URLs: k.value.URLs,
+		})
+	}
+	m.mu.Unlock()
+
+	return kites, nil
+}
+
+// Add implements the Storage interface.
+func (m *MemoryStorage) Add(kite *protocol.Kite, value *kontrolprotocol.RegisterValue) error {
+	key := kite.String()
+
+	m.mu.Lock()
+	old, exists := m.kites[key]
+	m.kites[key] = &memoryKite{
+		kite:    *kite,
+		value:   *value,
+		expires: time.Now().Add(KeyTTL),
+	}
+	m.mu.Unlock()
+
+	action := protocol.Register
+
+	// Updates of a registered kite are not registrations.
+	if exists && time.Now().Before(old.expires) {
+		if old.value.URL == value.URL && sameURLs(old.value.URLs, value.URLs) {
+			return nil
+		}
+
+		action = protocol.Update
+	}
+
+	m.notify(&protocol.KiteEvent{
+		Action: action,
+		Kite:   *kite,
+		URL:    value.URL,
+		URLs:   value.URLs,
+	})
+
+	return nil
+}
+
+// Update implements the Storage interface.
+func (m *MemoryStorage) Update(kite *protocol.Kite, value *kontrolprotocol.RegisterValue) error {
+	key := kite.String()
+
+	m.mu.Lock()
+	k, ok := m.kites[key]
+	if !ok || time.Now().After(k.expires) {
+		m.mu.Unlock()
+		return ErrKiteNotFound
+	}
+
+	changed := k.value.URL != value.URL || !sameURLs(k.value.URLs, value.URLs)
+	k.value = *value
+	k.expires = time.Now().Add(KeyTTL)
+	m.mu.Unlock()
+
+	if changed {
+		m.notify(&protocol.KiteEvent{
+			Action: protocol.Update,
+			Kite:   *kite,
+			URL:    value.URL,
+			URLs:   value.URLs,
+		})
+	}
+
+	return nil
+}
+
+// Delete implements the Storage interface.
+func (m *MemoryStorage) Delete(kite *protocol.Kite) error {
+	key := kite.String()
+
+	m.mu.Lock()
+	_, ok := m.kites[key]
+	delete(m.kites, key)
+	m.mu.Unlock()
+
+	if ok {
+		m.notify(&protocol.KiteEvent{Action: protocol.Deregister, Kite: *kite})
+	}
+
+	return nil
+}
+
+// Upsert implements the Storage interface.
+func (m *MemoryStorage) Upsert(kite *protocol.Kite, value *kontrolprotocol.RegisterValue) error {
+	return m.Add(kite, value)
+}
+
+// Watch implements the Watcher interface. Watch returns an error if the
+// events are not consumed fast enough.
+func (m *MemoryStorage) Watch(query *protocol.KontrolQuery, events chan<- *protocol.KiteEvent, stop <-chan struct{}) error {
+	match, err := queryMatcher(query)
+	if err != nil {
+		return err
+	}
+
+	w := &memoryWatcher{
+		match:    match,
+		queue:    make(chan *protocol.KiteEvent, memoryWatchQueue),
+		overflow: make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	m.watchers[w] = struct{}{}
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.watchers, w)
+		m.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case event := <-w.queue:
+			select {
+			case events <- event:
+			case <-w.overflow:
+				return errWatcherTooSlow
+			case <-stop:
+				return nil
+			}
+		case <-w.overflow:
+			return errWatcherTooSlow
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// notify queues the event for the watchers that are interested in the kite.
+// It never blocks, the watchers that can't keep up are stopped.
+func (m *MemoryStorage) notify(event *protocol.KiteEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for w := range m.watchers {
+		if w.overflowed || !w.match(&event.Kite) {
+			continue
+		}
+
+		select {
+		case w.queue <- event:
+		default:
+			w.overflowed = true
+			close(w.overflow)
+		}
+	}
+}
+
+// sameURLs returns true if the network URLs are the same.
+func sameURLs(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for network, u := range a {
+		if b[network] != u {
+			return false
+		}
+	}
+
+	return true
+}
+
+// queryMatcher returns a function that reports whether a kite matches the
+// given query. The query is validated with the same rules as other storages.
+func queryMatcher(query *protocol.KontrolQuery) (func(*protocol.Kite) bool, error) {
+	match, err := keyMatcher(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(query.Labels) == 0 {
+		return match, nil
+	}
+
+	return func(k *protocol.Kite) bool {
+		return k.HasLabels(query.Labels) && match(k)
+	}, nil
+}
+
+// keyMatcher is like queryMatcher but ignores the labels of the query.
+func keyMatcher(query *protocol.KontrolQuery) (func(*protocol.Kite) bool, error) {
+	if onlyIDQuery(query) {
+		return func(k *protocol.Kite) bool { return k.ID == query.ID }, nil
+	}
+
+	queryKey, err := GetQueryKey(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isVersionConstraint(query.Version) {
+		return func(k *protocol.Kite) bool {
+			return hasKeyPrefix(k.String(), queryKey)
+		}, nil
+	}
+
+	constraint, err := parseVersionConstraint(query.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	nameKey, _ := GetQueryKey(&protocol.KontrolQuery{
+		Username:    query.Username,
+		Environment: query.Environment,
+		Name:        query.Name,
+	})
+
+	keyRest := "/" + strings.TrimRight(query.Region+"/"+query.Hostname+"/"+query.ID, "/")
+
+	return func(k *protocol.Kite) bool {
+		return hasKeyPrefix(k.String(), nameKey) && isValid(k, constraint, keyRest)
+	}, nil
+}
+
+// hasKeyPrefix returns true if the prefix is a parent path of the key or the
+// key itself.
+func hasKeyPrefix(key, prefix string) bool {
+	return key == prefix || strings.HasPrefix(key, prefix+"/")
+}
+
+// GetState implements the StateStorage interface.
+func (m *MemoryStorage) GetState(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.state[key], nil
+}
+
+// UpdateState implements the StateStorage interface.
+func (m *MemoryStorage) UpdateState(key string, update func(value []byte) ([]byte, error)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value, err := update(m.state[key])
+	if err != nil {
+		return err
+	}
+
+	m.state[key] = value
+	return nil
+}
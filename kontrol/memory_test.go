@@ -0,0 +1,222 @@
+package kontrol
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	kontrolprotocol "github.com/koding/kite/kontrol/protocol"
+	"github.com/koding/kite/protocol"
+)
+
+func newMemoryKite(name, version, id string) *protocol.Kite {
+	return &protocol.Kite{
+		Username:    "testuser",
+		Environment: "testing",
+		Name:        name,
+		Version:     version,
+		Region:      "local",
+		Hostname:    "localhost",
+		ID:          id,
+	}
+}
+
+func TestMemoryStorage(t *testing.T) {
+	m := NewMemoryStorage()
+
+	kites := []*protocol.Kite{
+		newMemoryKite("math", "1.0.0", "1"),
+		newMemoryKite("math", "1.1.0", "2"),
+		newMemoryKite("math", "2.0.0", "3"),
+		newMemoryKite("fs", "1.0.0", "4"),
+	}
+
+	kites[1].Labels = map[string]string{"tier": "canary", "gpu": "true"}
+	kites[2].Labels = map[string]string{"tier": "canary"}
+
+	for _, k := range kites {
+		if err := m.Add(k, &kontrolprotocol.RegisterValue{URL: "http://" + k.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	queries := []struct {
+		query *protocol.KontrolQuery
+		count int
+	}{
+		{&protocol.KontrolQuery{Username: "testuser"}, 4},
+		{&protocol.KontrolQuery{Username: "testuser", Environment: "testing", Name: "math"}, 3},
+		{&protocol.KontrolQuery{Username: "testuser", Environment: "testing", Name: "math", Version: "1.0.0"}, 1},
+		{&protocol.KontrolQuery{Username: "testuser", Environment: "testing", Name: "math", Version: "< 2.0"}, 2},
+		{&protocol.KontrolQuery{Username: "testuser", Environment: "testing", Name: "math", Version: ">=1.1.0 <2.0.0"}, 1},
+		{&protocol.KontrolQuery{Username: "testuser", Environment: "testing", Name: "math", Version: ">= 1.0.0 < 3"}, 3},
+		{&protocol.KontrolQuery{Username: "testuser", Labels: map[string]string{"tier": "canary"}}, 2},
+		{&protocol.KontrolQuery{Username: "testuser", Labels: map[string]string{"tier": "canary", "gpu": "true"}}, 1},
+		{&protocol.KontrolQuery{Username: "testuser", Labels: map[string]string{"tier": "stable"}}, 0},
+		{&protocol.KontrolQuery{ID: "4"}, 1},
+		{&protocol.KontrolQuery{Username: "otheruser"}, 0},
+	}
+
+	for _, q := range queries {
+		result, err := m.Get(q.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result) != q.count {
+			t.Errorf("query %+v: got %d kites, want: %d", q.query, len(result), q.count)
+		}
+	}
+
+	if _, err := m.Get(&protocol.KontrolQuery{Username: "testuser", Name: "math"}); err == nil {
+		t.Error("query with a missing field should return an error")
+	}
+
+	if err := m.Delete(kites[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Update(kites[0], &kontrolprotocol.RegisterValue{}); err != ErrKiteNotFound {
+		t.Errorf("updating a deleted kite, got: %v want: %v", err, ErrKiteNotFound)
+	}
+}
+
+func TestMemoryStorageWatch(t *testing.T) {
+	m := NewMemoryStorage()
+
+	events := make(chan *protocol.KiteEvent)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	query := &protocol.KontrolQuery{Username: "testuser", Environment: "testing", Name: "math"}
+	go m.Watch(query, events, stop)
+
+	// let the watcher start
+	time.Sleep(100 * time.Millisecond)
+
+	k := newMemoryKite("math", "1.0.0", "1")
+	go m.Add(k, &kontrolprotocol.RegisterValue{URL: "http://1"})
+
+	select {
+	case e := <-events:
+		if e.Action != protocol.Register || e.URL != "http://1" {
+			t.Errorf("unexpected event: %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("register event is not received")
+	}
+
+	go m.Update(k, &kontrolprotocol.RegisterValue{URL: "http://1-new"})
+
+	select {
+	case e := <-events:
+		if e.Action != protocol.Update || e.URL != "http://1-new" {
+			t.Errorf("unexpected event: %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("update event is not received")
+	}
+
+	// not matching the query
+	go m.Add(newMemoryKite("fs", "1.0.0", "2"), &kontrolprotocol.RegisterValue{})
+	go m.Delete(k)
+
+	select {
+	case e := <-events:
+		if e.Action != protocol.Deregister || e.Kite.ID != "1" {
+			t.Errorf("unexpected event: %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("deregister event is not received")
+	}
+}
+
+func TestMemoryStorageSlowWatcher(t *testing.T) {
+	m := NewMemoryStorage()
+	defer m.Close()
+
+	// the events are never read
+	events := make(chan *protocol.KiteEvent)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- m.Watch(&protocol.KontrolQuery{Username: "testuser"}, events, stop)
+	}()
+
+	// let the watcher start
+	time.Sleep(100 * time.Millisecond)
+
+	added := make(chan struct{})
+	go func() {
+		for i := 0; i <= memoryWatchQueue+1; i++ {
+			k := newMemoryKite("math", "1.0.0", strconv.Itoa(i))
+			m.Add(k, &kontrolprotocol.RegisterValue{URL: "http://" + k.ID})
+		}
+		close(added)
+	}()
+
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("registrations are blocked by the watcher")
+	}
+
+	select {
+	case err := <-watchErr:
+		if err != errWatcherTooSlow {
+			t.Errorf("got error %v, want: %s", err, errWatcherTooSlow)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("slow watcher is not stopped")
+	}
+}
+
+func TestMemoryStorageClose(t *testing.T) {
+	m := NewMemoryStorage()
+
+	done := make(chan struct{})
+	go func() {
+		m.RunCleaner(time.Millisecond)
+		close(done)
+	}()
+
+	m.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("cleaner is not stopped")
+	}
+}
+
+func TestGetKitesNetwork(t *testing.T) {
+	k := &Kontrol{storage: NewMemoryStorage()}
+
+	value := &kontrolprotocol.RegisterValue{
+		URL:  "http://public",
+		URLs: map[string]string{"private": "http://private"},
+	}
+
+	if err := k.storage.Add(newMemoryKite("math", "1.0.0", "1"), value); err != nil {
+		t.Fatal(err)
+	}
+
+	networks := map[string]string{
+		"":        "http://public",
+		"private": "http://private",
+		"other":   "http://public",
+	}
+
+	for network, want := range networks {
+		kites, err := k.getKites(&protocol.KontrolQuery{Username: "testuser", Network: network})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(kites) != 1 || kites[0].URL != want {
+			t.Errorf("network %q: got %+v, want url: %s", network, kites, want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package kontrol
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	kontrolprotocol "github.com/koding/kite/kontrol/protocol"
+	"github.com/koding/kite/protocol"
+)
+
+// Metrics is a snapshot of the metrics of kontrol. The counters are the
+// totals since kontrol is created, the rates can be calculated from their
+// changes by the monitoring systems.
+type Metrics struct {
+	Kites           int   `json:"kites"` // kites registered to this kontrol
+	Watchers        int   `json:"watchers"`
+	Registrations   int64 `json:"registrations"`
+	Deregistrations int64 `json:"deregistrations"`
+	Expirations     int64 `json:"expirations"`
+	Queries         int64 `json:"queries"` // getKites calls
+	Tokens          int64 `json:"tokens"`  // issued tokens
+
+	// Storage contains the metrics of the storage calls by operations: get,
+	// add, update, delete and upsert.
+	Storage map[string]*StorageMetrics `json:"storage"`
+}
+
+// StorageMetrics are the metrics of the calls of a storage operation.
+type StorageMetrics struct {
+	Calls    int64         `json:"calls"`
+	Errors   int64         `json:"errors"`
+	Duration time.Duration `json:"duration"` // total duration of the calls
+}
+
+// metrics contains the counters of kontrol, updated atomically.
+type metrics struct {
+	registrations   int64
+	deregistrations int64
+	expirations     int64
+	queries         int64
+	tokens          int64
+	storage         map[string]*storageMetrics // read only
+}
+
+type storageMetrics struct {
+	calls    int64
+	errors   int64
+	duration int64 // nanoseconds
+}
+
+func newMetrics() *metrics {
+	m := &metrics{storage: make(map[string]*storageMetrics)}
+	for _, op := range []string{"get", "add", "update", "delete", "upsert"} {
+		m.storage[op] = new(storageMetrics)
+	}
+
+	return m
+}
+
+// count increments the counter.
+func count(counter *int64) {
+	atomic.AddInt64(counter, 1)
+}
+
+// observe records the call of the storage operation started at start.
+func (m *metrics) observe(op string, start time.Time, err error) {
+	s := m.storage[op]
+	atomic.AddInt64(&s.calls, 1)
+	atomic.AddInt64(&s.duration, int64(time.Since(start)))
+	if err != nil && err != ErrKiteNotFound {
+		atomic.AddInt64(&s.errors, 1)
+	}
+}
+
+// Metrics returns the current metrics of kontrol.
+func (k *Kontrol) Metrics() *Metrics {
+	m := &Metrics{
+		Registrations:   atomic.LoadInt64(&k.metrics.registrations),
+		Deregistrations: atomic.LoadInt64(&k.metrics.deregistrations),
+		Expirations:     atomic.LoadInt64(&k.metrics.expirations),
+		Queries:         atomic.LoadInt64(&k.metrics.queries),
+		Tokens:          atomic.LoadInt64(&k.metrics.tokens),
+		Storage:         make(map[string]*StorageMetrics),
+	}
+
+	for op, s := range k.metrics.storage {
+		m.Storage[op] = &StorageMetrics{
+			Calls:    atomic.LoadInt64(&s.calls),
+			Errors:   atomic.LoadInt64(&s.errors),
+			Duration: time.Duration(atomic.LoadInt64(&s.duration)),
+		}
+	}
+
+	k.registrationsMu.Lock()
+	m.Kites = len(k.registrations)
+	k.registrationsMu.Unlock()
+
+	k.watchersMu.Lock()
+	m.Watchers = len(k.watchers)
+	k.watchersMu.Unlock()
+
+	return m
+}
+
+// PublishMetrics publishes the metrics of kontrol with expvar with the name.
+// Each kontrol in the process must be published with a different name,
+// expvar panics if the name is already used.
+func (k *Kontrol) PublishMetrics(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return k.Metrics()
+	}))
+}
+
+// HandleMetrics serves the metrics in the Prometheus text format at
+// "/metrics". The scrapers are authenticated with the kite key of an admin
+// in the Authorization header as "Bearer <kite key>".
+func (k *Kontrol) HandleMetrics() {
+	k.Kite.HandleHTTPFunc("/metrics", k.handleMetrics)
+}
+
+// handleMetrics serves the metrics in the Prometheus text format.
+func (k *Kontrol) handleMetrics(rw http.ResponseWriter, req *http.Request) {
+	key := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if key == "" {
+		http.Error(rw, "missing kite key", http.StatusUnauthorized)
+		return
+	}
+
+	username, err := k.Kite.AuthenticateSimpleKiteKey(key)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if !k.isAdmin(username) {
+		http.Error(rw, fmt.Sprintf("user %q is not an admin", username), http.StatusForbidden)
+		return
+	}
+
+	m := k.Metrics()
+
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	metric := func(name, typ, help string, value interface{}) {
+		fmt.Fprintf(rw, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, value)
+	}
+
+	metric("kontrol_kites", "gauge", "Number of the kites registered to this kontrol.", m.Kites)
+	metric("kontrol_watchers", "gauge", "Number of the running watchers.", m.Watchers)
+	metric("kontrol_registrations_total", "counter", "Total number of the registrations.", m.Registrations)
+	metric("kontrol_deregistrations_total", "counter", "Total number of the deregistrations.", m.Deregistrations)
+	metric("kontrol_expirations_total", "counter", "Total number of the kites expired without heartbeats.", m.Expirations)
+	metric("kontrol_queries_total", "counter", "Total number of the getKites calls.", m.Queries)
+	metric("kontrol_tokens_total", "counter", "Total number of the issued tokens.", m.Tokens)
+
+	ops := make([]string, 0, len(m.Storage))
+	for op := range m.Storage {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	fmt.Fprintf(rw, "# HELP kontrol_storage_duration_seconds Duration of the storage calls.\n")
+	fmt.Fprintf(rw, "# TYPE kontrol_storage_duration_seconds summary\n")
+	for _, op := range ops {
+		s := m.Storage[op]
+		fmt.Fprintf(rw, "kontrol_storage_duration_seconds_sum{op=%q} %v\n", op, s.Duration.Seconds())
+		fmt.Fprintf(rw, "kontrol_storage_duration_seconds_count{op=%q} %d\n", op, s.Calls)
+	}
+
+	fmt.Fprintf(rw, "# HELP kontrol_storage_errors_total Total number of the failed storage calls.\n")
+	fmt.Fprintf(rw, "# TYPE kontrol_storage_errors_total counter\n")
+	for _, op := range ops {
+		fmt.Fprintf(rw, "kontrol_storage_errors_total{op=%q} %d\n", op, m.Storage[op].Errors)
+	}
+}
+
+// meteredStorage records the durations and the errors of the calls to the
+// storage.
+type meteredStorage struct {
+	Storage
+	metrics *metrics
+}
+
+func (s *meteredStorage) Get(query *protocol.KontrolQuery) (Kites, error) {
+	start := time.Now()
+	kites, err := s.Storage.Get(query)
+	s.metrics.observe("get", start, err)
+	return kites, err
+}
+
+func (s *meteredStorage) Add(kite *protocol.Kite, value *kontrolprotocol.RegisterValue) error {
+	start := time.Now()
+	err := s.Storage.Add(kite, value)
+	s.metrics.observe("add", start, err)
+	return err
+}
+
+func (s *meteredStorage) Update(kite *protocol.Kite, value *kontrolprotocol.RegisterValue) error {
+	start := time.Now()
+	err := s.Storage.Update(kite, value)
+	s.metrics.observe("update", start, err)
+	return err
+}
+
+func (s *meteredStorage) Delete(kite *protocol.Kite) error {
+	start := time.Now()
+	err := s.Storage.Delete(kite)
+	s.metrics.observe("delete", start, err)
+	return err
+}
+
+func (s *meteredStorage) Upsert(kite *protocol.Kite, value *kontrolprotocol.RegisterValue) error {
+	start := time.Now()
+	err := s.Storage.Upsert(kite, value)
+	s.metrics.observe("upsert", start, err)
+	return err
+}
+
+// backend returns the storage set with SetStorage, for checking the optional
+// interfaces it implements.
+func (k *Kontrol) backend() Storage {
+	if s, ok := k.storage.(*meteredStorage); ok {
+		return s.Storage
+	}
+
+	return k.storage
+}
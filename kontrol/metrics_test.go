@@ -0,0 +1,94 @@
+package kontrol
+
+import (
+	"expvar"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/protocol"
+)
+
+func TestMetrics(t *testing.T) {
+	before := kon.Metrics()
+
+	query := &protocol.KontrolQuery{
+		Username:    conf.Username,
+		Environment: conf.Environment,
+		Name:        "mathworker-metrics",
+	}
+
+	exp := kite.New("exp-metrics", "0.0.1")
+	exp.Config = conf.Copy()
+
+	if _, err := exp.GetKites(query); err != kite.ErrNoKitesAvailable {
+		t.Fatalf("got error %v, want: %v", err, kite.ErrNoKitesAvailable)
+	}
+
+	after := kon.Metrics()
+
+	if after.Queries != before.Queries+1 {
+		t.Errorf("got %d queries, want: %d", after.Queries, before.Queries+1)
+	}
+
+	if after.Tokens != before.Tokens+1 {
+		t.Errorf("got %d tokens, want: %d", after.Tokens, before.Tokens+1)
+	}
+
+	if after.Storage["get"].Calls != before.Storage["get"].Calls+1 {
+		t.Errorf("got %d storage get calls, want: %d", after.Storage["get"].Calls, before.Storage["get"].Calls+1)
+	}
+
+	// the endpoint is opt-in and only for the admins
+	metrics := func(key string) *http.Response {
+		req, err := http.NewRequest("GET", "http://localhost:5555/metrics", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := metrics(conf.KiteKey)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d without HandleMetrics, want: %d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	kon.HandleMetrics()
+
+	resp = metrics("")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got status %d without kite key, want: %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+
+	resp = metrics(conf.KiteKey)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"kontrol_queries_total", `kontrol_storage_duration_seconds_count{op="get"}`} {
+		if !strings.Contains(string(body), "\n"+name+" ") {
+			t.Errorf("%s is missing in the metrics:\n%s", name, body)
+		}
+	}
+
+	kon.PublishMetrics("kontrol-test")
+	if v := expvar.Get("kontrol-test"); v == nil || !strings.Contains(v.String(), `"queries":`) {
+		t.Errorf("metrics are not published: %v", v)
+	}
+}
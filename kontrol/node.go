@@ -52,14 +52,17 @@ func (n *Node) Kite() (*protocol.KiteWithToken, error) {
 		return nil, err
 	}
 
-	url, err := n.Value()
-	if err != nil {
+	var rv kontrolprotocol.RegisterValue
+	if err := json.Unmarshal([]byte(n.Node.Value), &rv); err != nil {
 		return nil, err
 	}
 
+	kite.Labels = rv.Labels
+
 	return &protocol.KiteWithToken{
 		Kite: *kite,
-		URL:  url,
+		URL:  rv.URL,
+		URLs: rv.URLs,
 	}, nil
 }
 
@@ -2,6 +2,7 @@ package kontrol
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/url"
@@ -27,6 +28,21 @@ type PostgresConfig struct {
 	DBName   string `required:"true" `
 }
 
+// kiteColumns are the columns of the kite table in the order they are
+// scanned by scanKites.
+var kiteColumns = []string{
+	"username",
+	"environment",
+	"kitename",
+	"version",
+	"region",
+	"hostname",
+	"id",
+	"url",
+	"labels",
+	"urls",
+}
+
 type Postgres struct {
 	DB  *sql.DB
 	Log kite.Logger
@@ -128,7 +144,7 @@ func (p *Postgres) Get(query *protocol.KontrolQuery) (Kites, error) {
 	_, err = version.NewVersion(query.Version)
 	if err != nil && query.Version != "" {
 		// now parse our constraint
-		versionConstraint, err = version.NewConstraint(query.Version)
+		versionConstraint, err = parseVersionConstraint(query.Version)
 		if err != nil {
 			// version is a malformed, just return the error
 			return nil, err
@@ -159,6 +175,41 @@ func (p *Postgres) Get(query *protocol.KontrolQuery) (Kites, error) {
 	}
 	defer rows.Close()
 
+	kites, err := scanKites(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// if it's just single result there is no need to shuffle or filter
+	// according to the version constraint
+	if len(kites) == 1 {
+		return kites, nil
+	}
+
+	// Filter kites by version constraint
+	if hasVersionConstraint {
+		kites.Filter(versionConstraint, keyRest)
+	}
+
+	// randomize the result
+	kites.Shuffle()
+
+	return kites, nil
+}
+
+// List implements the Lister interface.
+func (p *Postgres) List() (Kites, error) {
+	rows, err := p.DB.Query("SELECT " + strings.Join(kiteColumns, ", ") + " FROM kite.kite")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanKites(rows)
+}
+
+// scanKites returns the kites in the rows of the kite table.
+func scanKites(rows *sql.Rows) (Kites, error) {
 	var (
 		username    string
 		environment string
@@ -168,8 +219,8 @@ func (p *Postgres) Get(query *protocol.KontrolQuery) (Kites, error) {
 		hostname    string
 		id          string
 		url         string
-		updated_at  time.Time
-		created_at  time.Time
+		labels      []byte
+		urls        []byte
 	)
 
 	kites := make(Kites, 0)
@@ -184,13 +235,22 @@ func (p *Postgres) Get(query *protocol.KontrolQuery) (Kites, error) {
 			&hostname,
 			&id,
 			&url,
-			&updated_at,
-			&created_at,
+			&labels,
+			&urls,
 		)
 		if err != nil {
 			return nil, err
 		}
 
+		var kiteLabels, kiteURLs map[string]string
+		if err := json.Unmarshal(labels, &kiteLabels); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(urls, &kiteURLs); err != nil {
+			return nil, err
+		}
+
 		kites = append(kites, &protocol.KiteWithToken{
 			Kite: protocol.Kite{
 				Username:    username,
@@ -200,8 +260,10 @@ func (p *Postgres) Get(query *protocol.KontrolQuery) (Kites, error) {
 				Region:      region,
 				Hostname:    hostname,
 				ID:          id,
+				Labels:      kiteLabels,
 			},
-			URL: url,
+			URL:  url,
+			URLs: kiteURLs,
 		})
 	}
 
@@ -209,20 +271,6 @@ func (p *Postgres) Get(query *protocol.KontrolQuery) (Kites, error) {
 		return nil, err
 	}
 
-	// if it's just single result there is no need to shuffle or filter
-	// according to the version constraint
-	if len(kites) == 1 {
-		return kites, nil
-	}
-
-	// Filter kites by version constraint
-	if hasVersionConstraint {
-		kites.Filter(versionConstraint, keyRest)
-	}
-
-	// randomize the result
-	kites.Shuffle()
-
 	return kites, nil
 }
 
@@ -249,8 +297,18 @@ func (p *Postgres) Upsert(kiteProt *protocol.Kite, value *kontrolprotocol.Regist
 		}
 	}()
 
-	res, err := tx.Exec(`UPDATE kite.kite SET url = $1, updated_at = (now() at time zone 'utc') 
-	WHERE id = $2`, value.URL, kiteProt.ID)
+	labels, err := mapValue(value.Labels)
+	if err != nil {
+		return err
+	}
+
+	urls, err := mapValue(value.URLs)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`UPDATE kite.kite SET url = $1, labels = $2, urls = $3, updated_at = (now() at time zone 'utc') 
+	WHERE id = $4`, value.URL, labels, urls, kiteProt.ID)
 	if err != nil {
 		return err
 	}
@@ -265,7 +323,7 @@ func (p *Postgres) Upsert(kiteProt *protocol.Kite, value *kontrolprotocol.Regist
 		return nil
 	}
 
-	insertSQL, args, err := insertQuery(kiteProt, value.URL)
+	insertSQL, args, err := insertQuery(kiteProt, value)
 	if err != nil {
 		return err
 	}
@@ -281,7 +339,7 @@ func (p *Postgres) Add(kiteProt *protocol.Kite, value *kontrolprotocol.RegisterV
 		return err
 	}
 
-	sqlQuery, args, err := insertQuery(kiteProt, value.URL)
+	sqlQuery, args, err := insertQuery(kiteProt, value)
 	if err != nil {
 		return err
 	}
@@ -299,9 +357,19 @@ func (p *Postgres) Update(kiteProt *protocol.Kite, value *kontrolprotocol.Regist
 
 	// TODO: also consider just using WHERE id = kiteProt.ID, see how it's
 	// performs out
-	_, err = p.DB.Exec(`UPDATE kite.kite SET url = $1, updated_at = (now() at time zone 'utc') 
-	WHERE id = $2`,
-		value.URL, kiteProt.ID)
+	labels, err := mapValue(value.Labels)
+	if err != nil {
+		return err
+	}
+
+	urls, err := mapValue(value.URLs)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.DB.Exec(`UPDATE kite.kite SET url = $1, labels = $2, urls = $3, updated_at = (now() at time zone 'utc') 
+	WHERE id = $4`,
+		value.URL, labels, urls, kiteProt.ID)
 
 	return err
 }
@@ -316,7 +384,7 @@ func (p *Postgres) Delete(kiteProt *protocol.Kite) error {
 func selectQuery(query *protocol.KontrolQuery) (string, []interface{}, error) {
 	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
 
-	kites := psql.Select("*").From("kite.kite")
+	kites := psql.Select(kiteColumns...).From("kite.kite")
 	fields := query.Fields()
 	andQuery := sq.And{}
 
@@ -339,11 +407,20 @@ func selectQuery(query *protocol.KontrolQuery) (string, []interface{}, error) {
 		return "", nil, errors.New("all query fields are empty")
 	}
 
+	if len(query.Labels) != 0 {
+		labels, err := mapValue(query.Labels)
+		if err != nil {
+			return "", nil, err
+		}
+
+		andQuery = append(andQuery, sq.Expr("labels @> ?::jsonb", labels))
+	}
+
 	return kites.Where(andQuery).ToSql()
 }
 
-// inseryQuery
-func insertQuery(kiteProt *protocol.Kite, url string) (string, []interface{}, error) {
+// insertQuery returns a SQL query for inserting the kite with the value
+func insertQuery(kiteProt *protocol.Kite, value *kontrolprotocol.RegisterValue) (string, []interface{}, error) {
 	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
 
 	kiteValues := kiteProt.Values()
@@ -353,16 +430,80 @@ func insertQuery(kiteProt *protocol.Kite, url string) (string, []interface{}, er
 		values[i] = kiteVal
 	}
 
-	values = append(values, url)
+	labels, err := mapValue(value.Labels)
+	if err != nil {
+		return "", nil, err
+	}
 
-	return psql.Insert("kite.kite").Columns(
-		"username",
-		"environment",
-		"kitename",
-		"version",
-		"region",
-		"hostname",
-		"id",
-		"url",
-	).Values(values...).ToSql()
+	urls, err := mapValue(value.URLs)
+	if err != nil {
+		return "", nil, err
+	}
+
+	values = append(values, value.URL, labels, urls)
+
+	return psql.Insert("kite.kite").Columns(kiteColumns...).Values(values...).ToSql()
+}
+
+// mapValue returns m encoded for the jsonb columns, like labels.
+func mapValue(m map[string]string) (string, error) {
+	if m == nil {
+		return "{}", nil
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// GetState implements the StateStorage interface. The state is kept in the
+// state table, see 005-state.sql.
+func (p *Postgres) GetState(key string) ([]byte, error) {
+	var value []byte
+	err := p.DB.QueryRow(`SELECT value FROM kite.state WHERE key = $1`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return value, err
+}
+
+// UpdateState implements the StateStorage interface. The row of the key is
+// locked until the update is done, so the concurrent updates wait for each
+// other.
+func (p *Postgres) UpdateState(key string, update func(value []byte) ([]byte, error)) (err error) {
+	tx, err := p.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	_, err = tx.Exec(`INSERT INTO kite.state (key, value) VALUES ($1, '') ON CONFLICT (key) DO NOTHING`, key)
+	if err != nil {
+		return err
+	}
+
+	var current []byte
+	err = tx.QueryRow(`SELECT value FROM kite.state WHERE key = $1 FOR UPDATE`, key).Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	value, err := update(current)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE kite.state SET value = $1 WHERE key = $2`, value, key)
+	return err
 }
@@ -2,5 +2,7 @@ package protocol
 
 // RegisterValue is the type of the value that is saved to etcd.
 type RegisterValue struct {
-	URL string `json:"url"`
+	URL    string            `json:"url"`
+	Labels map[string]string `json:"labels,omitempty"`
+	URLs   map[string]string `json:"urls,omitempty"`
 }
@@ -0,0 +1,142 @@
+package kontrol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/protocol"
+)
+
+// handleKitesHTTP serves the "GET /kites" endpoint. It's the same as the
+// "getKites" method for the clients that don't speak dnode, like dashboards,
+// scripts and browsers. The query is given with the URL parameters:
+//
+//	GET /kites?username=devrim&environment=production&name=mathworker
+//
+// The labels are given with the repeated "label" parameter, like
+// "label=gpu=true&label=tier=canary", the network of the returned URLs
+// with the "network" parameter and the page with the "offset" and "limit"
+// parameters.
+//
+// The caller is authenticated with its kite key in the Authorization header
+// as "Bearer <kite key>". The tokens for the kites are attached only if the
+// "tokens" parameter is true. The query is limited and audited like the
+// getKites calls. Browsers can query it from the Kontrol.CORSOrigins.
+func (k *Kontrol) handleKitesHTTP(rw http.ResponseWriter, req *http.Request) {
+	// allow the browsers to send the Authorization header
+	if origin := req.Header.Get("Origin"); origin != "" && k.allowCORS(origin) {
+		rw.Header().Set("Access-Control-Allow-Origin", origin)
+		rw.Header().Set("Access-Control-Allow-Headers", "Authorization")
+		rw.Header().Set("Access-Control-Allow-Methods", "GET")
+	}
+	rw.Header().Add("Vary", "Origin")
+
+	switch req.Method {
+	case "GET", "HEAD":
+	case "OPTIONS":
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		rw.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		http.Error(rw, jsonError(errors.New("method not allowed")), http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if key == "" {
+		http.Error(rw, jsonError(errors.New("missing kite key")), http.StatusUnauthorized)
+		return
+	}
+
+	username, keyID, err := k.Kite.AuthenticateKiteKey(key)
+	if err != nil {
+		http.Error(rw, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+
+	if k.Kite.IsKeyBlocked(keyID) || k.Kite.IsBlocked("", username) {
+		http.Error(rw, jsonError(kite.ErrBlocked), http.StatusForbidden)
+		return
+	}
+
+	if err := allow(k.GetKitesLimit, username); err != nil {
+		http.Error(rw, jsonError(err), http.StatusTooManyRequests)
+		return
+	}
+
+	count(&k.metrics.queries)
+
+	params := req.URL.Query()
+
+	var offset, limit int
+	for name, n := range map[string]*int{"offset": &offset, "limit": &limit} {
+		if s := params.Get(name); s != "" {
+			if *n, err = strconv.Atoi(s); err != nil {
+				http.Error(rw, jsonError(fmt.Errorf("invalid %s %q", name, s)), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	query := &protocol.KontrolQuery{
+		Username:    params.Get("username"),
+		Environment: params.Get("environment"),
+		Name:        params.Get("name"),
+		Version:     params.Get("version"),
+		Region:      params.Get("region"),
+		Hostname:    params.Get("hostname"),
+		ID:          params.Get("id"),
+		Network:     params.Get("network"),
+	}
+
+	for _, label := range params["label"] {
+		kv := strings.SplitN(label, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			http.Error(rw, jsonError(fmt.Errorf("invalid label %q", label)), http.StatusBadRequest)
+			return
+		}
+
+		if query.Labels == nil {
+			query.Labels = make(map[string]string)
+		}
+
+		query.Labels[kv[0]] = kv[1]
+	}
+
+	e := &AuditEntry{
+		Action:     "getKites",
+		Username:   username,
+		KiteKeyID:  keyID,
+		RemoteAddr: req.RemoteAddr,
+	}
+
+	withTokens, _ := strconv.ParseBool(params.Get("tokens"))
+
+	result, err := k.queryKites(e, query, offset, limit, withTokens)
+	if err != nil {
+		http.Error(rw, jsonError(err), http.StatusBadRequest)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(rw).Encode(result); err != nil {
+		k.log.Error("could not encode response: %s", err)
+	}
+}
+
+// allowCORS returns true if the browsers of the origin can query the kites.
+func (k *Kontrol) allowCORS(origin string) bool {
+	for _, allowed := range k.CORSOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,76 @@
+package kontrol
+
+import (
+	"errors"
+	"time"
+
+	"github.com/koding/kite"
+)
+
+// revokeToken adds the token id to the revocation list. The id is kept in
+// the list until all tokens that could be issued with it are expired. The
+// revocation is shared with the other instances in stateless mode.
+func (k *Kontrol) revokeToken(jti string) error {
+	expires := time.Now().UTC().Add(k.revocationTTL())
+
+	k.revokedMu.Lock()
+	k.revoked[jti] = expires
+	k.revokedMu.Unlock()
+
+	// do not give the revoked token to anyone from the cache
+	invalidateToken(jti)
+
+	k.Kite.SetRevokedTokens(k.revokedTokens())
+
+	return k.updateState(func(s *sharedState) {
+		s.Revoked[jti] = expires
+	})
+}
+
+// revokedTokens returns the ids of the revoked tokens that are not expired
+// yet. Expired ones are removed from the list.
+func (k *Kontrol) revokedTokens() []string {
+	k.revokedMu.Lock()
+	defer k.revokedMu.Unlock()
+
+	now := time.Now().UTC()
+	jtis := make([]string, 0, len(k.revoked))
+
+	for jti, expires := range k.revoked {
+		if now.After(expires) {
+			delete(k.revoked, jti)
+			continue
+		}
+
+		jtis = append(jtis, jti)
+	}
+
+	return jtis
+}
+
+// handleRevokeToken revokes the token with the id given as the only argument.
+// It can only be called by the owner of kontrol.
+func (k *Kontrol) handleRevokeToken(r *kite.Request) (interface{}, error) {
+	if r.Username != k.Kite.Kite().Username {
+		return nil, errors.New("not allowed to revoke tokens")
+	}
+
+	jti, err := r.Args.One().String()
+	if err != nil || jti == "" {
+		return nil, errors.New("Invalid token id")
+	}
+
+	if err := k.revokeToken(jti); err != nil {
+		k.log.Error("cannot share the revocation of %s: %s", jti, err)
+		return nil, errors.New("internal error - revokeToken")
+	}
+
+	k.log.Info("Token is revoked: %s", jti)
+
+	return nil, nil
+}
+
+// handleGetRevokedTokens returns the ids of the revoked tokens.
+func (k *Kontrol) handleGetRevokedTokens(r *kite.Request) (interface{}, error) {
+	return k.revokedTokens(), nil
+}
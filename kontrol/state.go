@@ -0,0 +1,217 @@
+package kontrol
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/koding/kite/protocol"
+)
+
+// StateStorage is implemented by the storages that can share the state of
+// kontrol between the instances in stateless mode: the revoked tokens, the
+// blocklist and the signing keys. It's optional, the state is kept in memory
+// by each instance if the storage doesn't implement it.
+type StateStorage interface {
+	// GetState returns the value of the key. It returns nil if the key is
+	// not set.
+	GetState(key string) ([]byte, error)
+
+	// UpdateState sets the key to the value returned from update, which is
+	// called with the current value of the key. The updates of the other
+	// instances must not be lost, update is called again with the new value
+	// if the key is changed meanwhile.
+	UpdateState(key string, update func(value []byte) ([]byte, error)) error
+}
+
+// StateSyncInterval is the interval of loading the state shared by the
+// instances in stateless mode from the StateStorage.
+var StateSyncInterval = 10 * time.Second
+
+// stateKey is the key of the shared state in the StateStorage.
+const stateKey = "kontrol"
+
+// sharedState is the state of kontrol shared by the instances in stateless
+// mode.
+type sharedState struct {
+	// Revoked contains the ids of the revoked tokens and the time they can
+	// be forgotten.
+	Revoked map[string]time.Time `json:"revoked"`
+
+	Blocklist protocol.Blocklist `json:"blocklist"`
+
+	// Keys are the accepted public keys by their ids. They are set after
+	// the signing key is rotated for the first time, the instances keep
+	// their own keys until then.
+	Keys       map[string]string `json:"keys,omitempty"`
+	SigningKey *signingKey       `json:"signingKey,omitempty"`
+}
+
+type signingKey struct {
+	ID         string `json:"id"`
+	PublicKey  string `json:"publicKey"`
+	PrivateKey string `json:"privateKey"`
+}
+
+// stateStorage returns the storage of the shared state. It returns false if
+// kontrol is not stateless or the storage can't keep the state.
+func (k *Kontrol) stateStorage() (StateStorage, bool) {
+	if !k.Stateless || k.storage == nil {
+		return nil, false
+	}
+
+	s, ok := k.backend().(StateStorage)
+	return s, ok
+}
+
+// updateState changes the shared state with update and applies the new state
+// to this instance. It does nothing if the state is not shared.
+func (k *Kontrol) updateState(update func(s *sharedState)) error {
+	storage, ok := k.stateStorage()
+	if !ok {
+		return nil
+	}
+
+	var state *sharedState
+	err := storage.UpdateState(stateKey, func(value []byte) ([]byte, error) {
+		s, err := decodeState(value)
+		if err != nil {
+			return nil, err
+		}
+
+		update(s)
+
+		// the expired revocations are not needed by anyone
+		now := time.Now().UTC()
+		for jti, expires := range s.Revoked {
+			if now.After(expires) {
+				delete(s.Revoked, jti)
+			}
+		}
+
+		state = s
+		return json.Marshal(s)
+	})
+	if err != nil {
+		return err
+	}
+
+	k.applyState(state)
+	return nil
+}
+
+// loadState applies the shared state in the storage to this instance.
+func (k *Kontrol) loadState() error {
+	storage, ok := k.stateStorage()
+	if !ok {
+		return nil
+	}
+
+	value, err := storage.GetState(stateKey)
+	if err != nil {
+		return err
+	}
+
+	state, err := decodeState(value)
+	if err != nil {
+		return err
+	}
+
+	k.applyState(state)
+	return nil
+}
+
+// syncState loads the shared state every StateSyncInterval until kontrol is
+// closed.
+func (k *Kontrol) syncState() {
+	ticker := time.NewTicker(StateSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := k.loadState(); err != nil {
+			k.log.Error("cannot load the shared state: %s", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-k.done:
+			return
+		}
+	}
+}
+
+func decodeState(value []byte) (*sharedState, error) {
+	s := &sharedState{Revoked: make(map[string]time.Time)}
+	if len(value) == 0 {
+		return s, nil
+	}
+
+	if err := json.Unmarshal(value, s); err != nil {
+		return nil, err
+	}
+
+	if s.Revoked == nil {
+		s.Revoked = make(map[string]time.Time)
+	}
+
+	return s, nil
+}
+
+// applyState replaces the revoked tokens, the blocklist and the keys of this
+// instance with the ones in the shared state.
+func (k *Kontrol) applyState(s *sharedState) {
+	k.revokedMu.Lock()
+	for jti := range s.Revoked {
+		if _, ok := k.revoked[jti]; !ok {
+			// do not give the revoked token to anyone from the cache
+			invalidateToken(jti)
+		}
+	}
+
+	k.revoked = make(map[string]time.Time, len(s.Revoked))
+	for jti, expires := range s.Revoked {
+		k.revoked[jti] = expires
+	}
+	k.revokedMu.Unlock()
+
+	k.Kite.SetRevokedTokens(k.revokedTokens())
+
+	k.blocklistMu.Lock()
+	blocklist := &s.Blocklist
+	changed := !sameBlocklist(k.Kite.Blocklist(), blocklist)
+	if changed {
+		k.Kite.SetBlocklist(blocklist)
+	}
+	k.blocklistMu.Unlock()
+
+	if changed {
+		k.Kite.Broadcast("kite.setBlocklist", blocklist)
+	}
+
+	if s.SigningKey == nil {
+		return
+	}
+
+	for _, publicKey := range s.Keys {
+		k.Kite.AddKontrolKey(publicKey)
+	}
+
+	for kid := range k.Kite.KontrolKeys() {
+		if _, ok := s.Keys[kid]; !ok {
+			k.Kite.RemoveKontrolKey(kid)
+		}
+	}
+
+	k.keysMu.Lock()
+	k.keyID = s.SigningKey.ID
+	k.publicKey = s.SigningKey.PublicKey
+	k.privateKey = s.SigningKey.PrivateKey
+	k.keysMu.Unlock()
+}
+
+// sameBlocklist returns true if the blocklists contain the same kites, kite
+// keys and users, in any order.
+func sameBlocklist(a, b *protocol.Blocklist) bool {
+	return len(without(a.Kites, b.Kites)) == 0 && len(without(b.Kites, a.Kites)) == 0 &&
+		len(without(a.Keys, b.Keys)) == 0 && len(without(b.Keys, a.Keys)) == 0 &&
+		len(without(a.Users, b.Users)) == 0 && len(without(b.Users, a.Users)) == 0
+}
@@ -1,12 +1,20 @@
 package kontrol
 
 import (
+	"errors"
+
 	kontrolprotocol "github.com/koding/kite/kontrol/protocol"
 	"github.com/koding/kite/protocol"
 )
 
+// ErrKiteNotFound is returned from Storage methods when the kite is not
+// stored or has expired.
+var ErrKiteNotFound = errors.New("kite not found")
+
 // Storage is an interface to a kite storage. A storage should be safe to
-// concurrent access.
+// concurrent access. Kites that are added or updated must expire after KeyTTL
+// if they are not updated again, either with a native TTL mechanism or with a
+// background cleaner.
 type Storage interface {
 	// Get retrieves the Kites with the given query
 	Get(query *protocol.KontrolQuery) (Kites, error)
@@ -23,3 +31,22 @@ type Storage interface {
 	// Upsert inserts or updates the value for the given kite
 	Upsert(kite *protocol.Kite, value *kontrolprotocol.RegisterValue) error
 }
+
+// Watcher is implemented by storages that can notify about the changes of the
+// stored kites. It's optional, kontrol checks whether the storage implements
+// it before using it.
+type Watcher interface {
+	// Watch sends an event to the events channel whenever a kite matching
+	// the query is registered or deregistered (deleted or expired). It
+	// blocks until stop is closed or an error occurs. URL and Token fields
+	// of the events are only set for Register events.
+	Watch(query *protocol.KontrolQuery, events chan<- *protocol.KiteEvent, stop <-chan struct{}) error
+}
+
+// Lister is implemented by storages that can list all stored kites,
+// regardless of their usernames. It's optional, it's used by the admin
+// methods of kontrol.
+type Lister interface {
+	// List returns all kites in the storage.
+	List() (Kites, error)
+}
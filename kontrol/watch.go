@@ -0,0 +1,120 @@
+package kontrol
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/protocol"
+	"github.com/nu7hatch/gouuid"
+)
+
+// handleWatchKites starts sending the events of the kites matching the query
+// to the watch callback. It returns the ID of the watcher, which can be used
+// to stop it with the "cancelWatcher" method. The watcher is stopped when the
+// caller disconnects.
+func (k *Kontrol) handleWatchKites(r *kite.Request) (interface{}, error) {
+	var args protocol.GetKitesArgs
+	r.Args.One().MustUnmarshal(&args)
+
+	if args.Query == nil {
+		return nil, errors.New("query is missing")
+	}
+
+	if !args.WatchCallback.IsValid() {
+		return nil, errors.New("watch callback is missing")
+	}
+
+	w, ok := k.backend().(Watcher)
+	if !ok {
+		return nil, errors.New("storage does not support watching kites")
+	}
+
+	if !onlyIDQuery(args.Query) {
+		if _, err := GetQueryKey(args.Query); err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, errors.New("cannot generate a watcher id")
+	}
+	watcherID := id.String()
+
+	events := make(chan *protocol.KiteEvent)
+	stop := make(chan struct{})
+
+	var once sync.Once
+	k.watchersMu.Lock()
+	k.watchers[watcherID] = func() { once.Do(func() { close(stop) }) }
+	k.watchersMu.Unlock()
+
+	r.Client.OnDisconnect(func() { k.cancelWatcher(watcherID) })
+
+	go func() {
+		if err := w.Watch(args.Query, events, stop); err != nil {
+			k.log.Error("watch '%s' error: %s", args.Query, err)
+		}
+
+		k.cancelWatcher(watcherID)
+	}()
+
+	go func() {
+		for {
+			select {
+			case event := <-events:
+				if event.Action != protocol.Deregister {
+					// the deregistered kites may not carry their labels
+					if !event.Kite.HasLabels(args.Query.Labels) {
+						continue
+					}
+
+					// Same as getKites, the token is attached to the events
+					// of the kites that can be connected. It's issued for
+					// each event, the watcher may run longer than its TTL.
+					token, err := k.newToken(getAudience(args.Query), r.Username, r.KiteKeyID)
+					if err != nil {
+						k.log.Error("cannot issue token for kite event: %s", err)
+						continue
+					}
+
+					event.Token = token
+
+					if u, ok := event.URLs[args.Query.Network]; ok && args.Query.Network != "" {
+						event.URL = u
+					}
+				}
+
+				if err := args.WatchCallback.Call(event); err != nil {
+					k.log.Error("cannot send kite event: %s", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return watcherID, nil
+}
+
+// handleCancelWatcher stops the watcher with the ID returned from the
+// "watchKites" method.
+func (k *Kontrol) handleCancelWatcher(r *kite.Request) (interface{}, error) {
+	id := r.Args.One().MustString()
+	return nil, k.cancelWatcher(id)
+}
+
+func (k *Kontrol) cancelWatcher(watcherID string) error {
+	k.watchersMu.Lock()
+	stop, ok := k.watchers[watcherID]
+	delete(k.watchers, watcherID)
+	k.watchersMu.Unlock()
+
+	if !ok {
+		return errors.New("watcher not found")
+	}
+
+	stop()
+	return nil
+}
@@ -0,0 +1,147 @@
+package kontrol
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/protocol"
+	"github.com/koding/kite/testkeys"
+)
+
+func TestWatchKitesToken(t *testing.T) {
+	conf2 := conf.Copy()
+	conf2.Port = 5558
+	conf2.KontrolURL = "http://localhost:5558/kite"
+
+	kon2 := New(conf2.Copy(), "0.0.1", testkeys.Public, testkeys.Private)
+	kon2.SetStorage(NewMemoryStorage())
+	kon2.TokenTTL = time.Second
+	kon2.TokenLeeway = time.Millisecond
+
+	go kon2.Run()
+	<-kon2.Kite.ServerReadyNotify()
+	defer kon2.Close()
+
+	exp := kite.New("exp-watch", "0.0.1")
+	exp.Config = conf2.Copy()
+	defer exp.Close()
+
+	w, err := exp.WatchKites(&protocol.KontrolQuery{
+		Username:    conf.Username,
+		Environment: conf.Environment,
+		Name:        "watchedkite",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	register := func(port string) string {
+		m := kite.New("watchedkite", "1.0.0")
+		m.Config = conf2.Copy()
+		defer m.Close()
+
+		kiteURL := &url.URL{Scheme: "http", Host: "localhost:" + port, Path: "/kite"}
+		if _, err := m.Register(kiteURL); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case event := <-w.Events():
+			if event.Type != kite.KiteAdded || event.Kite.ID != m.Id {
+				t.Fatalf("got %s event of %s, want: KiteAdded of %s", event.Type, event.Kite.ID, m.Id)
+			}
+			return event.Token
+		case <-time.After(4 * time.Second):
+			t.Fatal("event is not received")
+		}
+
+		return ""
+	}
+
+	first := register("4458")
+
+	// the token of the first event has expired
+	time.Sleep(1500 * time.Millisecond)
+
+	second := register("4459")
+	if second == "" || second == first {
+		t.Errorf("got the expired token of the first event")
+	}
+}
+
+func TestWatchEventTokenRenew(t *testing.T) {
+	conf2 := conf.Copy()
+	conf2.Port = 5559
+	conf2.KontrolURL = "http://localhost:5559/kite"
+
+	// the tokens are renewed 30 seconds before they expire, after a second
+	kon2 := New(conf2.Copy(), "0.0.1", testkeys.Public, testkeys.Private)
+	kon2.SetStorage(NewMemoryStorage())
+	kon2.TokenTTL = 15 * time.Second
+	kon2.TokenLeeway = 16 * time.Second
+
+	go kon2.Run()
+	<-kon2.Kite.ServerReadyNotify()
+	defer kon2.Close()
+
+	exp := kite.New("exp-renew", "0.0.1")
+	exp.Config = conf2.Copy()
+	defer exp.Close()
+
+	w, err := exp.WatchKites(&protocol.KontrolQuery{
+		Username:    conf.Username,
+		Environment: conf.Environment,
+		Name:        "renewedkite",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	m := kite.New("renewedkite", "1.0.0")
+	m.Config = conf2.Copy()
+	m.Config.Port = 4460
+	m.HandleFunc("token", func(r *kite.Request) (interface{}, error) {
+		return r.Auth.Key, nil
+	})
+
+	go m.Run()
+	defer m.Close()
+	<-m.ServerReadyNotify()
+
+	if _, err := m.Register(&url.URL{Scheme: "http", Host: "localhost:4460", Path: "/kite"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var event *kite.WatchEvent
+	select {
+	case event = <-w.Events():
+	case <-time.After(4 * time.Second):
+		t.Fatal("event is not received")
+	}
+
+	c := event.Client(exp)
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	first, err := c.Tell("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	second, err := c.Tell("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.MustString() == second.MustString() {
+		t.Error("token of the event client is not renewed")
+	}
+}
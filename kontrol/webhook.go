@@ -0,0 +1,158 @@
+package kontrol
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/koding/kite/protocol"
+)
+
+// Expire is the action of the webhook events sent when a kite is deleted
+// because it has stopped sending heartbeats.
+const Expire protocol.KiteAction = "EXPIRE"
+
+// WebhookTimeout is the timeout of the requests sent to the webhooks.
+var WebhookTimeout = 10 * time.Second
+
+// WebhookSignatureHeader is the header of the webhook requests that carries
+// the signature of the body when Kontrol.WebhookSecret is set. It's
+// "sha256=" followed by the hex encoded HMAC-SHA256 of the body. The Time of
+// the signed event can be checked to reject the replayed requests.
+const WebhookSignatureHeader = "X-Kite-Signature"
+
+// webhookQueueSize is the number of the events waiting to be sent to the
+// webhook. New events are dropped when the queue is full.
+const webhookQueueSize = 1024
+
+// WebhookEvent is sent to the webhooks as the JSON body of a POST request
+// when a kite is registered, deregistered or expired.
+type WebhookEvent struct {
+	// Action is protocol.Register, protocol.Deregister or Expire.
+	Action protocol.KiteAction `json:"action"`
+	Kite   protocol.Kite       `json:"kite"`
+	URL    string              `json:"url,omitempty"` // only for protocol.Register
+	Time   time.Time           `json:"time"`
+}
+
+// notifyWebhooks queues the event of the kite to be sent to the webhooks. It
+// doesn't block, the events are sent in order by a goroutine for each
+// webhook.
+func (k *Kontrol) notifyWebhooks(action protocol.KiteAction, kite *protocol.Kite, url string) {
+	if len(k.Webhooks) == 0 {
+		return
+	}
+
+	event := &WebhookEvent{
+		Action: action,
+		Kite:   *kite,
+		URL:    url,
+		Time:   time.Now().UTC(),
+	}
+
+	k.webhooksMu.Lock()
+	defer k.webhooksMu.Unlock()
+
+	if k.webhooksClosed {
+		return
+	}
+
+	if k.webhookQueues == nil {
+		k.webhookQueues = make(map[string]chan *WebhookEvent)
+		k.webhooksCtx, k.webhooksCancel = context.WithCancel(context.Background())
+	}
+
+	for _, u := range k.Webhooks {
+		queue, ok := k.webhookQueues[u]
+		if !ok {
+			queue = make(chan *WebhookEvent, webhookQueueSize)
+			k.webhookQueues[u] = queue
+			go k.sendWebhooks(k.webhooksCtx, u, queue)
+		}
+
+		select {
+		case queue <- event:
+		default:
+			k.log.Warning("Webhook queue of '%s' is full, dropping %s event of %s", u, action, kite)
+		}
+	}
+}
+
+// closeWebhooks stops sending the events to the webhooks, the requests that
+// are being sent are canceled.
+func (k *Kontrol) closeWebhooks() {
+	k.webhooksMu.Lock()
+	defer k.webhooksMu.Unlock()
+
+	k.webhooksClosed = true
+	if k.webhooksCancel != nil {
+		k.webhooksCancel()
+	}
+}
+
+// sendWebhooks sends the events in the queue to the webhook until ctx is
+// canceled.
+func (k *Kontrol) sendWebhooks(ctx context.Context, url string, queue <-chan *WebhookEvent) {
+	client := &http.Client{Timeout: WebhookTimeout}
+
+	for {
+		select {
+		case event := <-queue:
+			body, err := json.Marshal(event)
+			if err != nil {
+				k.log.Error("cannot marshal webhook event: %s", err)
+				continue
+			}
+
+			if err := postWebhook(ctx, client, url, body, k.WebhookSecret); err != nil {
+				k.log.Error("webhook '%s' error: %s", url, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func postWebhook(ctx context.Context, client *http.Client, url string, body []byte, secret string) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(WebhookSignatureHeader, WebhookSignature(secret, body))
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// read the body so the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// WebhookSignature returns the signature of the webhook request body with
+// the secret, which is sent in the WebhookSignatureHeader. The webhooks
+// should compare it with hmac.Equal.
+func WebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,141 @@
+package kontrol
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/protocol"
+	"github.com/koding/kite/testkeys"
+)
+
+func TestWebhooks(t *testing.T) {
+	events := make(chan *WebhookEvent, 16)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		signature := r.Header.Get(WebhookSignatureHeader)
+		if !hmac.Equal([]byte(signature), []byte(WebhookSignature("webhook-secret", body))) {
+			t.Errorf("invalid signature: %q", signature)
+		}
+
+		var event WebhookEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Error(err)
+			return
+		}
+
+		events <- &event
+	}))
+	defer ts.Close()
+
+	kon.Webhooks = []string{ts.URL}
+	kon.WebhookSecret = "webhook-secret"
+	defer func() {
+		kon.Webhooks = nil
+		kon.WebhookSecret = ""
+	}()
+
+	m := kite.New("webhookkite", "1.0.0")
+	m.Config = conf.Copy()
+	defer m.Close()
+
+	kiteURL := &url.URL{Scheme: "http", Host: "localhost:4451", Path: "/kite"}
+	if _, err := m.Register(kiteURL); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.DeregisterFromKontrol(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, action := range []protocol.KiteAction{protocol.Register, protocol.Deregister} {
+		event := nextWebhookEvent(t, events, m.Id)
+
+		if event.Action != action {
+			t.Fatalf("got %s event, want: %s", event.Action, action)
+		}
+
+		if action == protocol.Register && event.URL != kiteURL.String() {
+			t.Errorf("got url %q, want: %q", event.URL, kiteURL)
+		}
+	}
+}
+
+func TestWebhooksClose(t *testing.T) {
+	blocked := make(chan struct{})
+	canceled := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the closed connection is noticed after reading the body
+		ioutil.ReadAll(r.Body)
+
+		close(blocked)
+		<-r.Context().Done()
+		close(canceled)
+	}))
+	defer slow.Close()
+
+	events := make(chan *WebhookEvent, 16)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event WebhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+			return
+		}
+
+		events <- &event
+	}))
+	defer fast.Close()
+
+	k := New(conf.Copy(), "0.0.1", testkeys.Public, testkeys.Private)
+	k.Webhooks = []string{slow.URL, fast.URL}
+
+	k.notifyWebhooks(protocol.Register, &protocol.Kite{ID: "webhook-kite"}, "")
+
+	// the slow webhook doesn't delay the others
+	<-blocked
+	nextWebhookEvent(t, events, "webhook-kite")
+
+	// called by Close, the kite of k is not running
+	k.closeWebhooks()
+
+	select {
+	case <-canceled:
+	case <-time.After(4 * time.Second):
+		t.Fatal("webhook request is not canceled after closing kontrol")
+	}
+
+	k.notifyWebhooks(protocol.Deregister, &protocol.Kite{ID: "webhook-kite"}, "")
+
+	select {
+	case event := <-events:
+		t.Errorf("got %s event after closing kontrol", event.Action)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// nextWebhookEvent returns the next event of the kite with the id, skipping
+// the events of the other kites.
+func nextWebhookEvent(t *testing.T, events <-chan *WebhookEvent, id string) *WebhookEvent {
+	timeout := time.After(4 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Kite.ID == id {
+				return event
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for the webhook event")
+		}
+	}
+}
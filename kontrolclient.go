@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/koding/kite/jwtkeys"
 	"github.com/koding/kite/protocol"
 )
 
@@ -21,6 +22,11 @@ const (
 // Returned from GetKites when query matches no kites.
 var ErrNoKitesAvailable = errors.New("no kites availabile")
 
+// Returned from GetKites when kontrol limits the number of kites returned for
+// a weighted or least loaded query. These orders change on every call, so the
+// rest of the kites can't be got with the next pages.
+var ErrKitesTruncated = errors.New("kontrol has returned part of the kites")
+
 // kontrolClient is a kite for registering and querying Kites from Kontrol.
 type kontrolClient struct {
 	*Client
@@ -39,6 +45,14 @@ type kontrolClient struct {
 
 	// registerChan registers the url's it receives from the channel to Kontrol
 	registerChan chan *url.URL
+
+	// registered is true after a succesful registiration until the kite
+	// deregisters. Protected by the mutex.
+	registered bool
+
+	// multiple are the connections to the kontrols of RegisterToMultiple.
+	// Protected by the mutex.
+	multiple []*Client
 }
 
 type registerResult struct {
@@ -73,7 +87,7 @@ func (k *Kite) SetupKontrolClient() error {
 		k.Log.Info("Connected to Kontrol ")
 
 		// try to re-register on connect
-		if k.kontrol.lastRegisteredURL != nil {
+		if k.kontrol.lastRegisteredURL != nil && k.kontrol.isRegistered() {
 			select {
 			case k.kontrol.registerChan <- k.kontrol.lastRegisteredURL:
 			default:
@@ -100,17 +114,39 @@ func (k *Kite) SetupKontrolClient() error {
 // GetKites returns the list of Kites matching the query. The returned list
 // contains Ready to connect Client instances. The caller must connect
 // with Client.Dial() before using each Kite. An error is returned when no
-// kites are available.
+// kites are available. If kontrol limits the number of kites it returns, the
+// rest of the kites are got with the next pages.
 func (k *Kite) GetKites(query *protocol.KontrolQuery) ([]*Client, error) {
 	if err := k.SetupKontrolClient(); err != nil {
 		return nil, err
 	}
 
-	clients, err := k.getKites(protocol.GetKitesArgs{Query: query})
+	clients, total, err := k.getKites(protocol.GetKitesArgs{Query: query})
 	if err != nil {
 		return nil, err
 	}
 
+	for len(clients) < total {
+		if query != nil && (query.Weighted || query.LeastLoaded) {
+			return nil, ErrKitesTruncated
+		}
+
+		page, _, err := k.getKites(protocol.GetKitesArgs{
+			Query:  query,
+			Offset: len(clients),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// the kites after the offset have deregistered meanwhile
+		if len(page) == 0 {
+			break
+		}
+
+		clients = append(clients, page...)
+	}
+
 	if len(clients) == 0 {
 		return nil, ErrNoKitesAvailable
 	}
@@ -118,26 +154,51 @@ func (k *Kite) GetKites(query *protocol.KontrolQuery) ([]*Client, error) {
 	return clients, nil
 }
 
+// GetKitesPage is like GetKites but returns at most limit kites after the
+// offset, with the total number of the kites matching the query. Kontrol may
+// return less kites than the limit if it limits the size of the results. Zero
+// limit returns all kites after the offset. No error is returned for an empty
+// page.
+func (k *Kite) GetKitesPage(query *protocol.KontrolQuery, offset, limit int) ([]*Client, int, error) {
+	if err := k.SetupKontrolClient(); err != nil {
+		return nil, 0, err
+	}
+
+	return k.getKites(protocol.GetKitesArgs{
+		Query:  query,
+		Offset: offset,
+		Limit:  limit,
+	})
+}
+
 // used internally for GetKites() and WatchKites()
-func (k *Kite) getKites(args protocol.GetKitesArgs) ([]*Client, error) {
+func (k *Kite) getKites(args protocol.GetKitesArgs) ([]*Client, int, error) {
 	<-k.kontrol.readyConnected
 
 	response, err := k.kontrol.TellWithTimeout("getKites", 4*time.Second, args)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	var result = new(protocol.GetKitesResult)
 	err = response.Unmarshal(&result)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	clients := make([]*Client, len(result.Kites))
+	renew := make([]*Client, 0, len(result.Kites))
 	for i, currentKite := range result.Kites {
-		_, err := jwt.Parse(currentKite.Token, k.RSAKey)
+		// The tokens of the kites of the other clusters are signed by
+		// their kontrols, they can't be renewed by ours.
+		keyFunc := k.RSAKey
+		if currentKite.KontrolKey != "" {
+			keyFunc = peerKontrolKey(currentKite.KontrolKey)
+		}
+
+		_, err := jwt.Parse(currentKite.Token, keyFunc)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 
 		// exp := time.Unix(int64(token.Claims["exp"].(float64)), 0).UTC()
@@ -147,12 +208,17 @@ func (k *Kite) getKites(args protocol.GetKitesArgs) ([]*Client, error) {
 		}
 
 		clients[i] = k.NewClient(currentKite.URL)
+		clients[i].FallbackURLs = fallbackURLs(currentKite)
 		clients[i].Kite = currentKite.Kite
 		clients[i].Auth = auth
+
+		if currentKite.KontrolKey == "" {
+			renew = append(renew, clients[i])
+		}
 	}
 
 	// Renew tokens
-	for _, r := range clients {
+	for _, r := range renew {
 		token, err := NewTokenRenewer(r, k)
 		if err != nil {
 			k.Log.Error("Error in token. Token will not be renewed when it expires: %s", err.Error())
@@ -161,7 +227,21 @@ func (k *Kite) getKites(args protocol.GetKitesArgs) ([]*Client, error) {
 		token.RenewWhenExpires()
 	}
 
-	return clients, nil
+	// older kontrols don't send the total
+	total := result.Total
+	if total == 0 {
+		total = len(clients)
+	}
+
+	return clients, total, nil
+}
+
+// peerKontrolKey returns a jwt.Keyfunc for the tokens signed by the kontrol
+// of another cluster with the public key.
+func peerKontrolKey(publicKey string) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		return jwtkeys.VerificationKey(token.Method, publicKey)
+	}
 }
 
 // GetToken is used to get a new token for a single Kite.
@@ -186,6 +266,62 @@ func (k *Kite) GetToken(kite *protocol.Kite) (string, error) {
 	return tkn, nil
 }
 
+// RenewToken is used to get a new token in place of the given token before it
+// expires. The token must be still valid and issued to this kite.
+func (k *Kite) RenewToken(token string) (string, error) {
+	if err := k.SetupKontrolClient(); err != nil {
+		return "", err
+	}
+
+	<-k.kontrol.readyConnected
+
+	result, err := k.kontrol.TellWithTimeout("renewToken", 4*time.Second, token)
+	if err != nil {
+		return "", err
+	}
+
+	var tkn string
+	err = result.Unmarshal(&tkn)
+	if err != nil {
+		return "", err
+	}
+
+	return tkn, nil
+}
+
+// UpdateKontrolKeys gets the public keys accepted by kontrol and makes them
+// the keys added with AddKontrolKey. It should be called after kontrol
+// rotates its key, so the tokens signed with the new key are accepted.
+func (k *Kite) UpdateKontrolKeys() error {
+	if err := k.SetupKontrolClient(); err != nil {
+		return err
+	}
+
+	<-k.kontrol.readyConnected
+
+	result, err := k.kontrol.TellWithTimeout("getKeys", 4*time.Second)
+	if err != nil {
+		return err
+	}
+
+	var keys map[string]string
+	if err := result.Unmarshal(&keys); err != nil {
+		return err
+	}
+
+	for kid := range k.KontrolKeys() {
+		if _, ok := keys[kid]; !ok {
+			k.RemoveKontrolKey(kid)
+		}
+	}
+
+	for _, key := range keys {
+		k.AddKontrolKey(key)
+	}
+
+	return nil
+}
+
 // KontrolReadyNotify returns a channel that is closed when a successful
 // registiration to kontrol is done.
 func (k *Kite) KontrolReadyNotify() chan struct{} {
@@ -257,13 +393,83 @@ func (k *Kite) Register(kiteURL *url.URL) (*registerResult, error) {
 
 	<-k.kontrol.readyConnected
 
+	rr, err := k.registerTo(k.kontrol.Client, kiteURL)
+	if err != nil {
+		return nil, err
+	}
+
+	k.kontrol.setRegistered(true)
+
+	return rr, nil
+}
+
+// DeregisterFromKontrol deletes current Kite from Kontrol, so it's not found
+// by the other kites anymore, without waiting for its registiration to expire.
+// The kite doesn't register again after reconnecting to kontrol or with its
+// heartbeats until one of the register methods is called. Shutdown() calls it
+// for the registered kites.
+func (k *Kite) DeregisterFromKontrol() error {
+	k.kontrol.setRegistered(false)
+
+	deregister := func(c *Client) error {
+		_, err := c.TellWithTimeout("deregister", 4*time.Second)
+		return err
+	}
+
+	k.kontrol.Lock()
+	c := k.kontrol.Client
+	multiple := k.kontrol.multiple
+	k.kontrol.Unlock()
+
+	var firstErr error
+	for _, c := range multiple {
+		if err := deregister(c); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	// registered only with RegisterToMultiple
+	if c == nil && len(multiple) != 0 {
+		return firstErr
+	}
+
+	var err error
+	if c == nil {
+		// kites registered via HTTP don't have a kontrol connection
+		err = k.kontrolFunc(deregister)
+	} else {
+		err = deregister(c)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return firstErr
+}
+
+func (k *kontrolClient) setRegistered(registered bool) {
+	k.Lock()
+	k.registered = registered
+	k.Unlock()
+}
+
+func (k *kontrolClient) isRegistered() bool {
+	k.Lock()
+	defer k.Unlock()
+	return k.registered
+}
+
+// registerTo registers current Kite to the kontrol that c is connected to.
+func (k *Kite) registerTo(c *Client, kiteURL *url.URL) (*registerResult, error) {
 	args := protocol.RegisterArgs{
-		URL: kiteURL.String(),
+		URL:  kiteURL.String(),
+		URLs: k.Config.URLs,
 	}
 
 	k.Log.Info("Registering to kontrol with URL: %s", kiteURL.String())
 
-	response, err := k.kontrol.TellWithTimeout("register", 4*time.Second, args)
+	response, err := c.TellWithTimeout("register", 4*time.Second, args)
 	if err != nil {
 		return nil, err
 	}
@@ -285,6 +491,82 @@ func (k *Kite) Register(kiteURL *url.URL) (*registerResult, error) {
 	return &registerResult{parsed}, nil
 }
 
+// RegisterToMultiple registers current Kite to each of the kontrols at the
+// given URLs, so it can be found in more than one kontrol cluster. Each
+// kontrol has its own connection and it's re-registered independently after
+// disconnections and failures, like RegisterForever(). The returned error is
+// the first error of the initial register attempts. DeregisterFromKontrol()
+// deregisters the kite from each of the kontrols.
+func (k *Kite) RegisterToMultiple(kontrolURLs []*url.URL, kiteURL *url.URL) error {
+	k.kontrol.setRegistered(true)
+
+	errs := make(chan error, len(kontrolURLs))
+	for _, u := range kontrolURLs {
+		go k.registerToKontrolForever(u, kiteURL, errs)
+	}
+
+	var firstErr error
+	for range kontrolURLs {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// registerToKontrolForever connects to the kontrol at kontrolURL and keeps
+// the kite registered. The result of the first register attempt is sent to
+// firstResult.
+func (k *Kite) registerToKontrolForever(kontrolURL, kiteURL *url.URL, firstResult chan<- error) {
+	c := k.NewClient(kontrolURL.String())
+	c.Kite = protocol.Kite{Name: "kontrol"} // for logging purposes
+	c.Auth = &Auth{
+		Type: "kiteKey",
+		Key:  k.Config.KiteKey,
+	}
+
+	register := make(chan struct{}, 1)
+	signal := func() {
+		select {
+		case register <- struct{}{}:
+		default:
+		}
+	}
+
+	// register again on every reconnect
+	c.OnConnect(signal)
+
+	if _, err := c.DialForever(); err != nil {
+		firstResult <- err
+		return
+	}
+
+	k.kontrol.Lock()
+	k.kontrol.multiple = append(k.kontrol.multiple, c)
+	k.kontrol.Unlock()
+
+	for range register {
+		// not registered again after deregistering
+		if firstResult == nil && !k.kontrol.isRegistered() {
+			continue
+		}
+
+		_, err := k.registerTo(c, kiteURL)
+
+		if firstResult != nil {
+			firstResult <- err
+			firstResult = nil
+		}
+
+		if err != nil {
+			k.Log.Error("Cannot register to Kontrol %s: %s Will retry after %d seconds",
+				kontrolURL, err, kontrolRetryDuration/time.Second)
+			time.AfterFunc(kontrolRetryDuration, signal)
+		}
+	}
+}
+
 // RegisterToTunnel finds a tunnel proxy kite by asking kontrol then registers
 // itselfs on proxy. On error, retries forever. On every successfull
 // registration, it sends the proxied URL to the registerChan channel. There is
@@ -0,0 +1,369 @@
+// Package ldapauth authenticates the kites with the passwords of the users in
+// an LDAP directory, like Active Directory, for the "password"
+// authentication type.
+//
+//	ldapauth.Use(k, &ldapauth.Authenticator{
+//		URL:    "ldaps://ldap.example.com",
+//		BaseDN: "dc=example,dc=com",
+//	})
+//
+// The kites connect with the password of their user:
+//
+//	c.Auth = &kite.Auth{Type: "password", Key: password}
+package ldapauth
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/koding/kite"
+	"gopkg.in/ldap.v2"
+)
+
+// ErrTooManyFailures is returned when the password of the user is not
+// checked because of the failed attempts.
+var ErrTooManyFailures = errors.New("too many failed attempts, try again later")
+
+// Authenticator authenticates the kites with the passwords of the users in
+// an LDAP directory. The key is the password of the user, the username is
+// the username of the remote kite. The entry of the user is searched with
+// Filter and the password is verified by binding as the entry.
+type Authenticator struct {
+	// URL of the directory server, like "ldaps://ldap.example.com" or
+	// "ldap://ldap.example.com:389".
+	URL string
+
+	// StartTLS upgrades the "ldap://" connections to TLS.
+	StartTLS bool
+
+	// TLSConfig is used for the "ldaps://" URLs and StartTLS.
+	TLSConfig *tls.Config
+
+	// BindDN and BindPassword are the credentials of the service account
+	// the users are searched with. The search is anonymous if BindDN is
+	// empty.
+	BindDN       string
+	BindPassword string
+
+	// BaseDN is where the users are searched, like "dc=example,dc=com".
+	BaseDN string
+
+	// Filter finds the entry of the user, %s is replaced with the escaped
+	// username. Default is "(uid=%s)", use "(sAMAccountName=%s)" for
+	// Active Directory. Group membership can be required with the filter
+	// too, like "(&(uid=%s)(memberOf=cn=kites,ou=groups,dc=example,dc=com))".
+	Filter string
+
+	// SessionTTL enables the session tokens if it's not zero. The kites
+	// authenticated with their passwords get a session token with the
+	// "kite.login" method, see Login, which is valid for SessionTTL with
+	// the "ldapSession" authentication type. The directory is not queried
+	// for the calls with the session tokens.
+	SessionTTL time.Duration
+
+	// MaxFailures is the number of the failed attempts of a user in
+	// FailureWindow after which the password of the user is not checked
+	// with the directory until the window ends, so the passwords can't be
+	// guessed and the directory doesn't lock the account. Default is 5,
+	// negative disables the limit.
+	MaxFailures int
+
+	// FailureWindow is the period the failed attempts are counted in.
+	// Default is 15 minutes.
+	FailureWindow time.Duration
+
+	// dial connects to the directory, it's replaced in tests
+	dial func() (ldap.Client, error)
+
+	secret     string // signs the session tokens
+	secretOnce sync.Once
+
+	failures   map[string]*failures // keys are usernames
+	failuresMu sync.Mutex
+}
+
+// failures are the failed attempts of a user.
+type failures struct {
+	count int
+	since time.Time // the first failure in the window
+}
+
+// Use authenticates the "password" authentication type of the kite with the
+// directory. It adds the "kite.login" method and the "ldapSession"
+// authentication type too if a.SessionTTL is set.
+func Use(k *kite.Kite, a *Authenticator) {
+	k.Authenticators["password"] = a.Authenticate
+
+	if a.SessionTTL > 0 {
+		k.Authenticators["ldapSession"] = a.AuthenticateSession
+		k.HandleFunc("kite.login", a.handleLogin)
+	}
+}
+
+// Authenticate verifies the password in r.Auth.Key with the directory.
+func (a *Authenticator) Authenticate(r *kite.Request) error {
+	username := r.Client.Kite.Username
+	if username == "" {
+		return errors.New("Username is empty")
+	}
+
+	// the directories accept binds without passwords as anonymous binds
+	if r.Auth.Key == "" {
+		return errors.New("Password is empty")
+	}
+
+	if !a.allow(username) {
+		return ErrTooManyFailures
+	}
+
+	if err := a.verify(username, r.Auth.Key); err != nil {
+		return err
+	}
+
+	r.Username = username
+	return nil
+}
+
+// errInvalidCredentials is returned for the unknown users and the wrong
+// passwords alike.
+var errInvalidCredentials = errors.New("Invalid username or password")
+
+// verify binds as the user to check the password.
+func (a *Authenticator) verify(username, password string) error {
+	conn, err := a.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if a.BindDN != "" {
+		if err := conn.Bind(a.BindDN, a.BindPassword); err != nil {
+			return fmt.Errorf("ldap bind error: %s", err)
+		}
+	}
+
+	filter := a.Filter
+	if filter == "" {
+		filter = "(uid=%s)"
+	}
+
+	req := ldap.NewSearchRequest(
+		a.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(filter, ldap.EscapeFilter(username)),
+		[]string{"dn"},
+		nil,
+	)
+
+	result, err := conn.Search(req)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return fmt.Errorf("ldap search error: %s", err)
+	}
+
+	if result == nil || len(result.Entries) != 1 {
+		a.fail(username)
+		return errInvalidCredentials
+	}
+
+	if err := conn.Bind(result.Entries[0].DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			a.fail(username)
+			return errInvalidCredentials
+		}
+
+		return fmt.Errorf("ldap bind error: %s", err)
+	}
+
+	a.failuresMu.Lock()
+	delete(a.failures, username)
+	a.failuresMu.Unlock()
+
+	return nil
+}
+
+// allow returns false if the user has failed too many times in the failure
+// window.
+func (a *Authenticator) allow(username string) bool {
+	max := a.maxFailures()
+	if max < 0 {
+		return true
+	}
+
+	a.failuresMu.Lock()
+	defer a.failuresMu.Unlock()
+
+	f, ok := a.failures[username]
+	if !ok {
+		return true
+	}
+
+	if time.Since(f.since) >= a.failureWindow() {
+		delete(a.failures, username)
+		return true
+	}
+
+	return f.count < max
+}
+
+// fail records a failed attempt of the user.
+func (a *Authenticator) fail(username string) {
+	if a.maxFailures() < 0 {
+		return
+	}
+
+	a.failuresMu.Lock()
+	defer a.failuresMu.Unlock()
+
+	if a.failures == nil {
+		a.failures = make(map[string]*failures)
+	}
+
+	// forget the windows that have ended, the usernames are chosen by the
+	// callers
+	window := a.failureWindow()
+	for u, f := range a.failures {
+		if time.Since(f.since) >= window {
+			delete(a.failures, u)
+		}
+	}
+
+	f, ok := a.failures[username]
+	if !ok {
+		f = &failures{since: time.Now()}
+		a.failures[username] = f
+	}
+
+	f.count++
+}
+
+func (a *Authenticator) maxFailures() int {
+	if a.MaxFailures == 0 {
+		return 5
+	}
+
+	return a.MaxFailures
+}
+
+func (a *Authenticator) failureWindow() time.Duration {
+	if a.FailureWindow == 0 {
+		return 15 * time.Minute
+	}
+
+	return a.FailureWindow
+}
+
+// connect connects to the directory server.
+func (a *Authenticator) connect() (ldap.Client, error) {
+	if a.dial != nil {
+		return a.dial()
+	}
+
+	u, err := url.Parse(a.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	host, port := u.Host, u.Port()
+
+	switch u.Scheme {
+	case "ldaps":
+		if port == "" {
+			host = net.JoinHostPort(host, "636")
+		}
+
+		return ldap.DialTLS("tcp", host, a.tlsConfig(u.Hostname()))
+	case "ldap":
+		if port == "" {
+			host = net.JoinHostPort(host, "389")
+		}
+
+		conn, err := ldap.Dial("tcp", host)
+		if err != nil {
+			return nil, err
+		}
+
+		if a.StartTLS {
+			if err := conn.StartTLS(a.tlsConfig(u.Hostname())); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+
+		return conn, nil
+	}
+
+	return nil, fmt.Errorf("unsupported ldap url: %s", a.URL)
+}
+
+func (a *Authenticator) tlsConfig(serverName string) *tls.Config {
+	if a.TLSConfig != nil {
+		return a.TLSConfig
+	}
+
+	return &tls.Config{ServerName: serverName}
+}
+
+// AuthenticateSession authenticates the session tokens created by the
+// "kite.login" method.
+func (a *Authenticator) AuthenticateSession(r *kite.Request) error {
+	username, err := kite.ParseHMACToken(r.Auth.Key, a.sessionSecret())
+	if err != nil {
+		return err
+	}
+
+	r.Username = username
+	return nil
+}
+
+// sessionSecret returns the random secret that the session tokens are
+// signed with. The tokens are not valid after the kite restarts.
+func (a *Authenticator) sessionSecret() string {
+	a.secretOnce.Do(func() {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+
+		a.secret = base64.StdEncoding.EncodeToString(b)
+	})
+
+	return a.secret
+}
+
+// handleLogin returns a session token for the kite authenticated with its
+// password.
+func (a *Authenticator) handleLogin(r *kite.Request) (interface{}, error) {
+	// the sessions can't be extended without the password
+	if r.Auth == nil || r.Auth.Type != "password" {
+		return nil, errors.New("login requires password authentication")
+	}
+
+	return kite.NewHMACToken(a.sessionSecret(), r.Username, a.SessionTTL)
+}
+
+// Login gets a session token from a kite that authenticates the passwords
+// with an Authenticator and replaces the password in c.Auth with it, so the
+// password is not sent with the next calls. c.Auth must be the password.
+// The token expires after the SessionTTL of the remote kite, login again
+// with the password to get a new one.
+func Login(c *kite.Client) error {
+	result, err := c.TellWithTimeout("kite.login", 4*time.Second)
+	if err != nil {
+		return err
+	}
+
+	token, err := result.String()
+	if err != nil {
+		return err
+	}
+
+	c.Auth = &kite.Auth{Type: "ldapSession", Key: token}
+	return nil
+}
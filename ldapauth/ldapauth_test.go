@@ -0,0 +1,204 @@
+package ldapauth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/protocol"
+	"gopkg.in/ldap.v2"
+)
+
+// fakeDirectory is an ldap.Client with the users in memory. The methods
+// that are not used by Authenticator panic.
+type fakeDirectory struct {
+	ldap.Client
+
+	passwords map[string]string // dn -> password
+	dns       map[string]string // uid -> dn
+	binds     []string          // bound dns
+	closed    bool
+}
+
+func newFakeDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		passwords: map[string]string{
+			"cn=service,dc=example,dc=com": "servicepass",
+			"uid=alice,dc=example,dc=com":  "alicepass",
+		},
+		dns: map[string]string{
+			"alice": "uid=alice,dc=example,dc=com",
+		},
+	}
+}
+
+func (d *fakeDirectory) Bind(dn, password string) error {
+	if p, ok := d.passwords[dn]; !ok || p != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+
+	d.binds = append(d.binds, dn)
+	return nil
+}
+
+func (d *fakeDirectory) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	result := &ldap.SearchResult{}
+	for uid, dn := range d.dns {
+		if req.Filter == "(uid="+uid+")" {
+			result.Entries = append(result.Entries, ldap.NewEntry(dn, nil))
+		}
+	}
+
+	return result, nil
+}
+
+func (d *fakeDirectory) Close() { d.closed = true }
+
+func newAuthenticator(d *fakeDirectory, dials *int) *Authenticator {
+	return &Authenticator{
+		BindDN:       "cn=service,dc=example,dc=com",
+		BindPassword: "servicepass",
+		BaseDN:       "dc=example,dc=com",
+		dial: func() (ldap.Client, error) {
+			*dials++
+			return d, nil
+		},
+	}
+}
+
+func newRequest(username, password string) *kite.Request {
+	return &kite.Request{
+		Client: &kite.Client{Kite: protocol.Kite{Username: username}},
+		Auth:   &kite.Auth{Type: "password", Key: password},
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	d := newFakeDirectory()
+	var dials int
+	a := newAuthenticator(d, &dials)
+
+	r := newRequest("alice", "alicepass")
+	if err := a.Authenticate(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Username != "alice" {
+		t.Errorf("got username %q, want: alice", r.Username)
+	}
+
+	want := []string{"cn=service,dc=example,dc=com", "uid=alice,dc=example,dc=com"}
+	if len(d.binds) != 2 || d.binds[0] != want[0] || d.binds[1] != want[1] {
+		t.Errorf("got binds %v, want: %v", d.binds, want)
+	}
+
+	if !d.closed {
+		t.Error("connection is not closed")
+	}
+
+	for _, r := range []*kite.Request{
+		newRequest("alice", "wrong"),
+		newRequest("bob", "alicepass"),
+	} {
+		if err := a.Authenticate(r); err != errInvalidCredentials {
+			t.Errorf("%s: got error %v, want: %v", r.Client.Kite.Username, err, errInvalidCredentials)
+		}
+	}
+
+	// the directories bind anonymously without a password
+	if err := a.Authenticate(newRequest("alice", "")); err == nil {
+		t.Error("empty password is accepted")
+	}
+}
+
+func TestAuthenticateFailures(t *testing.T) {
+	d := newFakeDirectory()
+	var dials int
+	a := newAuthenticator(d, &dials)
+	a.MaxFailures = 3
+	a.FailureWindow = 100 * time.Millisecond
+
+	for i := 0; i < a.MaxFailures; i++ {
+		if err := a.Authenticate(newRequest("alice", "wrong")); err != errInvalidCredentials {
+			t.Fatalf("got error %v, want: %v", err, errInvalidCredentials)
+		}
+	}
+
+	dials = 0
+
+	// the right password is not checked either until the window ends
+	if err := a.Authenticate(newRequest("alice", "alicepass")); err != ErrTooManyFailures {
+		t.Fatalf("got error %v, want: %v", err, ErrTooManyFailures)
+	}
+
+	if dials != 0 {
+		t.Errorf("directory is queried %d times after the failures", dials)
+	}
+
+	// the other users are not affected
+	if err := a.Authenticate(newRequest("bob", "wrong")); err != errInvalidCredentials {
+		t.Errorf("got error %v for another user, want: %v", err, errInvalidCredentials)
+	}
+
+	time.Sleep(a.FailureWindow)
+
+	if err := a.Authenticate(newRequest("alice", "alicepass")); err != nil {
+		t.Fatalf("got error %v after the failure window", err)
+	}
+
+	// the failures are forgotten after a success
+	for i := 0; i < a.MaxFailures-1; i++ {
+		a.Authenticate(newRequest("alice", "wrong"))
+	}
+
+	if err := a.Authenticate(newRequest("alice", "alicepass")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Authenticate(newRequest("alice", "wrong")); err != errInvalidCredentials {
+		t.Errorf("got error %v, want: %v", err, errInvalidCredentials)
+	}
+}
+
+func TestSession(t *testing.T) {
+	d := newFakeDirectory()
+	var dials int
+	a := newAuthenticator(d, &dials)
+	a.SessionTTL = time.Minute
+
+	r := newRequest("alice", "alicepass")
+	if err := a.Authenticate(r); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := a.handleLogin(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dials = 0
+
+	session := &kite.Request{Auth: &kite.Auth{Type: "ldapSession", Key: result.(string)}}
+	if err := a.AuthenticateSession(session); err != nil {
+		t.Fatal(err)
+	}
+
+	if session.Username != "alice" {
+		t.Errorf("got username %q, want: alice", session.Username)
+	}
+
+	if dials != 0 {
+		t.Error("directory is queried for the session")
+	}
+
+	// the sessions can't be extended with the session tokens
+	if _, err := a.handleLogin(session); err == nil {
+		t.Error("login is accepted with a session token")
+	}
+
+	other := newAuthenticator(d, &dials)
+	if err := other.AuthenticateSession(session); err == nil {
+		t.Error("session token of another authenticator is accepted")
+	}
+}
@@ -0,0 +1,57 @@
+package kite
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/koding/kite/protocol"
+)
+
+// loadMeter measures the CPU usage of the process between the load reports.
+type loadMeter struct {
+	mu       sync.Mutex
+	lastWall time.Time
+	lastCPU  time.Duration
+}
+
+// Load returns the current load of the kite, which is reported to kontrol
+// with the heartbeats. The CPU usage is measured since the previous call.
+func (k *Kite) Load() *protocol.Load {
+	return &protocol.Load{
+		InFlight: int(atomic.LoadInt64(&k.inflightCount)),
+		CPU:      k.load.cpu(),
+	}
+}
+
+// cpu returns the CPU usage of the process since the previous call, as a
+// fraction of all CPUs. The first call measures since the process has
+// started.
+func (m *loadMeter) cpu() float64 {
+	now, cpu := time.Now(), processCPUTime()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.lastWall.IsZero() {
+		m.lastWall = processStart
+	}
+
+	wall := now.Sub(m.lastWall)
+	used := cpu - m.lastCPU
+	m.lastWall, m.lastCPU = now, cpu
+
+	if wall <= 0 || used <= 0 {
+		return 0
+	}
+
+	usage := float64(used) / float64(wall) / float64(runtime.NumCPU())
+	if usage > 1 {
+		usage = 1
+	}
+	return usage
+}
+
+// processStart is the approximate start time of the process.
+var processStart = time.Now()
@@ -0,0 +1,9 @@
+package kite
+
+import "time"
+
+// processCPUTime is not implemented on windows, the CPU usage is reported as
+// zero.
+func processCPUTime() time.Duration {
+	return 0
+}
@@ -0,0 +1,118 @@
+package kite
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogFunc receives the messages of a Logger created with NewFuncLogger,
+// with the fields attached to the logger.
+type LogFunc func(level Level, msg string, fields Fields)
+
+// NewFuncLogger returns a FieldLogger that passes every message to fn with
+// its fields. It's the adapter to route the logs of a kite into a structured
+// logging package, like logrus:
+//
+//	k.Log = kite.NewFuncLogger(func(l kite.Level, msg string, f kite.Fields) {
+//		e := logrus.WithFields(logrus.Fields(f))
+//		switch l {
+//		case kite.ERROR:
+//			e.Error(msg)
+//		...
+//		}
+//	})
+//
+// Fatal calls fn with the FATAL level and then calls os.Exit(1).
+func NewFuncLogger(fn LogFunc) FieldLogger {
+	return &funcLogger{fn: fn}
+}
+
+type funcLogger struct {
+	fn     LogFunc
+	fields Fields
+}
+
+func (l *funcLogger) WithFields(fields Fields) Logger {
+	return &funcLogger{fn: l.fn, fields: mergeFields(l.fields, fields)}
+}
+
+func (l *funcLogger) log(level Level, format string, args []interface{}) {
+	l.fn(level, fmt.Sprintf(format, args...), l.fields)
+}
+
+func (l *funcLogger) Fatal(format string, args ...interface{}) {
+	l.log(FATAL, format, args)
+	os.Exit(1)
+}
+
+func (l *funcLogger) Error(format string, args ...interface{}) {
+	l.log(ERROR, format, args)
+}
+
+func (l *funcLogger) Warning(format string, args ...interface{}) {
+	l.log(WARNING, format, args)
+}
+
+func (l *funcLogger) Info(format string, args ...interface{}) {
+	l.log(INFO, format, args)
+}
+
+func (l *funcLogger) Debug(format string, args ...interface{}) {
+	l.log(DEBUG, format, args)
+}
+
+// NewJSONLogger returns a FieldLogger that writes the messages up to the
+// level to w as JSON objects, one per line:
+//
+//	{"time":"2016-01-02T15:04:05Z","level":"INFO","msg":"hello","method":"square"}
+//
+// The fields are written next to the "time", "level" and "msg" keys and
+// can't override them.
+func NewJSONLogger(w io.Writer, level Level) FieldLogger {
+	jw := &jsonWriter{w: w}
+
+	return NewFuncLogger(func(l Level, msg string, fields Fields) {
+		if l > level {
+			return
+		}
+
+		jw.write(l, msg, fields)
+	})
+}
+
+type jsonWriter struct {
+	w  io.Writer
+	mu sync.Mutex // serializes writes
+}
+
+func (jw *jsonWriter) write(level Level, msg string, fields Fields) {
+	obj := make(map[string]interface{}, len(fields)+3)
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		obj[k] = v
+	}
+
+	obj["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	obj["level"] = level.String()
+	obj["msg"] = msg
+
+	p, err := json.Marshal(obj)
+	if err != nil {
+		p, _ = json.Marshal(map[string]interface{}{
+			"time":  obj["time"],
+			"level": obj["level"],
+			"msg":   msg,
+			"error": "cannot marshal fields: " + err.Error(),
+		})
+	}
+
+	jw.mu.Lock()
+	jw.w.Write(append(p, '\n'))
+	jw.mu.Unlock()
+}
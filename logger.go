@@ -1,12 +1,15 @@
 package kite
 
 import (
+	"fmt"
+	"log"
 	"os"
 	"os/signal"
+	"runtime"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"syscall"
-
-	"github.com/koding/logging"
 )
 
 type Level int
@@ -22,6 +25,22 @@ const (
 	DEBUG
 )
 
+var levelNames = [...]string{
+	FATAL:   "FATAL",
+	ERROR:   "ERROR",
+	WARNING: "WARNING",
+	INFO:    "INFO",
+	DEBUG:   "DEBUG",
+}
+
+// String returns the name of the level, like "INFO".
+func (l Level) String() string {
+	if l < FATAL || l > DEBUG {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
 // Logger is the interface used to log messages in different levels.
 type Logger interface {
 	// Fatal logs to the FATAL, ERROR, WARNING, INFO and DEBUG levels,
@@ -42,6 +61,91 @@ type Logger interface {
 	Debug(format string, args ...interface{})
 }
 
+// Fields are key-value pairs attached to log messages to give them context.
+type Fields map[string]interface{}
+
+// FieldLogger is implemented by loggers that support structured logging
+// natively. Kite.Log can be set to any Logger; if it implements FieldLogger,
+// the fields attached by kite are passed to it as they are.
+type FieldLogger interface {
+	Logger
+
+	// WithFields returns a new Logger that attaches the fields to every
+	// message it logs.
+	WithFields(fields Fields) Logger
+}
+
+// WithFields returns a Logger that attaches the fields to every message it
+// logs. If l is a FieldLogger its WithFields method is used, otherwise the
+// fields are appended to the messages in "key=value" form.
+func WithFields(l Logger, fields Fields) Logger {
+	if fl, ok := l.(FieldLogger); ok {
+		return fl.WithFields(fields)
+	}
+
+	return &fieldLogger{Logger: l, fields: fields}
+}
+
+// mergeFields returns a new Fields with the fields of a and b, b overriding
+// the keys of a.
+func mergeFields(a, b Fields) Fields {
+	merged := make(Fields, len(a)+len(b))
+	for k, v := range a {
+		merged[k] = v
+	}
+	for k, v := range b {
+		merged[k] = v
+	}
+	return merged
+}
+
+// fieldLogger appends the fields to the messages of a Logger that doesn't
+// support structured logging.
+type fieldLogger struct {
+	Logger
+	fields Fields
+}
+
+func (l *fieldLogger) WithFields(fields Fields) Logger {
+	return &fieldLogger{Logger: l.Logger, fields: mergeFields(l.fields, fields)}
+}
+
+// format formats the message and appends the fields sorted by key.
+func (l *fieldLogger) format(format string, args []interface{}) string {
+	keys := make([]string, 0, len(l.fields))
+	for k := range l.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	msg := fmt.Sprintf(format, args...)
+	for _, k := range keys {
+		msg += fmt.Sprintf(" %s=%v", k, l.fields[k])
+	}
+
+	return msg
+}
+
+func (l *fieldLogger) Fatal(format string, args ...interface{}) {
+	l.Logger.Fatal("%s", l.format(format, args))
+}
+
+func (l *fieldLogger) Error(format string, args ...interface{}) {
+	l.Logger.Error("%s", l.format(format, args))
+}
+
+func (l *fieldLogger) Warning(format string, args ...interface{}) {
+	l.Logger.Warning("%s", l.format(format, args))
+}
+
+func (l *fieldLogger) Info(format string, args ...interface{}) {
+	l.Logger.Info("%s", l.format(format, args))
+}
+
+func (l *fieldLogger) Debug(format string, args ...interface{}) {
+	l.Logger.Debug("%s", l.format(format, args))
+}
+
 // getLogLevel returns the logging level defined via the KITE_LOG_LEVEL
 // environment. It returns Info by default if no environment variable
 // is set.
@@ -60,40 +164,77 @@ func getLogLevel() Level {
 	}
 }
 
-// convertLevel converst a kite level into logging level
-func convertLevel(l Level) logging.Level {
-	switch l {
-	case DEBUG:
-		return logging.DEBUG
-	case WARNING:
-		return logging.WARNING
-	case ERROR:
-		return logging.ERROR
-	case FATAL:
-		return logging.CRITICAL
-	default:
-		return logging.INFO
-	}
+// defaultLogger is the Logger of the kites unless Kite.Log is replaced. It
+// writes through the standard log package, so its output can be redirected
+// with log.SetOutput.
+type defaultLogger struct {
+	name    string
+	level   int32 // Level, accessed atomically
+	colored bool
 }
 
-// newLogger returns a new kite logger based on koding/logging package and a
-// SetLogLvel function. The current logLevel is INFO by default, which can be
-// changed with KITE_LOG_LEVEL environment variable.
+// colors are the ANSI color codes of the levels.
+var colors = [...]string{
+	FATAL:   "\033[35m", // magenta
+	ERROR:   "\033[31m", // red
+	WARNING: "\033[33m", // yellow
+	INFO:    "\033[32m", // green
+	DEBUG:   "\033[37m", // gray
+}
+
+// newLogger returns a new kite logger and a SetLogLevel function. The
+// current logLevel is INFO by default, which can be changed with
+// KITE_LOG_LEVEL environment variable. Messages are colored unless
+// KITE_LOG_NOCOLOR is set.
 func newLogger(name string) (Logger, func(Level)) {
-	logger := logging.NewLogger(name)
-	logger.SetLevel(convertLevel(getLogLevel()))
+	l := &defaultLogger{
+		name:    name,
+		level:   int32(getLogLevel()),
+		colored: os.Getenv("KITE_LOG_NOCOLOR") == "",
+	}
 
-	if os.Getenv("KITE_LOG_NOCOLOR") != "" {
-		logging.StdoutHandler.Colorize = false
-		logging.StderrHandler.Colorize = false
+	setLevel := func(level Level) {
+		atomic.StoreInt32(&l.level, int32(level))
 	}
 
-	setLevel := func(l Level) {
-		logger.SetLevel(convertLevel(l))
-		logging.DefaultHandler.SetLevel(convertLevel(l))
+	return l, setLevel
+}
+
+func (l *defaultLogger) log(level Level, format string, args []interface{}) {
+	if level > Level(atomic.LoadInt32(&l.level)) {
+		return
+	}
+
+	msg := fmt.Sprintf("[%s] %-7s %s", l.name, level, fmt.Sprintf(format, args...))
+	if l.colored {
+		msg = colors[level] + msg + "\033[0m"
 	}
 
-	return logger, setLevel
+	log.Print(msg)
+}
+
+func (l *defaultLogger) Fatal(format string, args ...interface{}) {
+	buf := make([]byte, 1<<16)
+	buf = buf[:runtime.Stack(buf, true)]
+
+	l.log(FATAL, "%s\n%s", []interface{}{fmt.Sprintf(format, args...), buf})
+	os.Exit(1)
+}
+
+func (l *defaultLogger) Error(format string, args ...interface{}) {
+	l.log(ERROR, format, args)
+}
+
+func (l *defaultLogger) Warning(format string, args ...interface{}) {
+	l.log(WARNING, format, args)
+}
+
+func (l *defaultLogger) Info(format string, args ...interface{}) {
+	l.log(INFO, format, args)
+}
+
+func (l *defaultLogger) Debug(format string, args ...interface{}) {
+	l.log(DEBUG, format, args)
 }
 
 // SetupSignalHandler listens to signals and toggles the log level to DEBUG
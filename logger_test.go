@@ -0,0 +1,82 @@
+package kite
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// recordLogger records the messages of the INFO level.
+type recordLogger struct {
+	Logger
+	messages []string
+}
+
+func (l *recordLogger) Info(format string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+func TestWithFields(t *testing.T) {
+	rec := &recordLogger{}
+
+	l := WithFields(rec, Fields{"a": 1, "b": 2})
+	if _, ok := l.(*fieldLogger); !ok {
+		t.Fatalf("got %T, want: *fieldLogger", l)
+	}
+
+	// WithFields of a fieldLogger must merge the fields.
+	WithFields(l, Fields{"b": 3, "c": 4}).Info("hello %s", "world")
+	l.(FieldLogger).WithFields(Fields{"d": 5}).Info("bye")
+
+	want := []string{"hello world a=1 b=3 c=4", "bye a=1 b=2 d=5"}
+	if !reflect.DeepEqual(rec.messages, want) {
+		t.Errorf("got messages %q, want: %q", rec.messages, want)
+	}
+}
+
+func TestFuncLogger(t *testing.T) {
+	type entry struct {
+		level  Level
+		msg    string
+		fields Fields
+	}
+
+	var entries []entry
+	l := NewFuncLogger(func(level Level, msg string, fields Fields) {
+		entries = append(entries, entry{level, msg, fields})
+	})
+
+	WithFields(l, Fields{"method": "square"}).Warning("took %d ms", 42)
+	l.Error("failed")
+
+	want := []entry{
+		{WARNING, "took 42 ms", Fields{"method": "square"}},
+		{ERROR, "failed", nil},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got entries %+v, want: %+v", entries, want)
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewJSONLogger(&buf, INFO)
+
+	l.WithFields(Fields{"method": "square", "msg": "ignored"}).Info("called %d times", 2)
+	l.Debug("not logged")
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &obj); err != nil {
+		t.Fatalf("invalid JSON %q: %s", buf.String(), err)
+	}
+
+	if obj["level"] != "INFO" || obj["msg"] != "called 2 times" || obj["method"] != "square" {
+		t.Errorf("got %v", obj)
+	}
+
+	if _, ok := obj["time"].(string); !ok {
+		t.Errorf("time is missing: %v", obj)
+	}
+}
@@ -1,6 +1,9 @@
 package kite
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
 
@@ -40,6 +43,15 @@ func (h HandlerFunc) ServeKite(r *Request) (interface{}, error) {
 	return h(r)
 }
 
+// Middleware wraps the execution of every method handler of a Kite. It must
+// call next to continue the chain, or return without calling it to stop the
+// request. Middlewares are useful for cross-cutting concerns like logging,
+// authorization checks, metrics and rate limiting.
+type Middleware func(r *Request, next HandlerFunc) (result interface{}, err error)
+
+// MethodOption sets an option of a method when it's registered, like Version.
+type MethodOption func(*Method)
+
 // Method defines a method and the Handler it is bind to. By default
 // "ReturnMethod" handling is used.
 type Method struct {
@@ -65,11 +77,41 @@ type Method struct {
 	// bucket is used for throttling the method by certain rule
 	bucket *ratelimit.Bucket
 
+	// rateLimiter limits the rate of the requests of each caller, set with
+	// RateLimit.
+	rateLimiter *RateLimiter
+
+	// authTypes restricts the authentication types accepted by this method.
+	// Empty means any type registered in Kite.Authenticators.
+	authTypes []string
+
+	// authorizers are called after the request is authenticated. A non-nil
+	// error rejects the request.
+	authorizers []func(*Request) error
+
+	// timeout is the time the handlers have to return, set with Timeout.
+	// Zero means Config.HandlerTimeout.
+	timeout time.Duration
+
+	// argsType and resultType are the types of the argument and the result
+	// of typed handlers, used to describe the method.
+	argsType, resultType reflect.Type
+
+	// version is the version of the method set with Version, zero if the
+	// method is not versioned.
+	version int
+
+	// deprecated is the warning sent to the callers of a deprecated method.
+	deprecated string
+
+	// namespace is the group the method is registered with, if any.
+	namespace *Namespace
+
 	mu sync.Mutex // protects handler slices
 }
 
 // addHandle is an internal method to add a handler
-func (k *Kite) addHandle(method string, handler Handler) *Method {
+func (k *Kite) addHandle(method string, handler Handler, opts ...MethodOption) *Method {
 	authenticate := true
 	if k.Config.DisableAuthentication {
 		authenticate = false
@@ -84,7 +126,15 @@ func (k *Kite) addHandle(method string, handler Handler) *Method {
 		handling:     k.MethodHandling,
 	}
 
-	k.handlers[method] = m
+	if h, ok := handler.(*typedHandler); ok {
+		m.argsType, m.resultType = h.argsType, h.resultType
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	k.addVersion(m)
 	return m
 }
 
@@ -94,6 +144,75 @@ func (m *Method) DisableAuthentication() *Method {
 	return m
 }
 
+// AuthTypes restricts the authentication types (options.auth.type) that are
+// accepted for this method, like "kiteKey" or "token". Requests with other
+// authentication types are rejected before the authenticator is called.
+func (m *Method) AuthTypes(types ...string) *Method {
+	m.authTypes = append(m.authTypes, types...)
+	return m
+}
+
+// Authorize adds a function that decides whether the authenticated request is
+// allowed to call this method. It's called after authentication, so
+// Request.Username is the authenticated username. Returning an error rejects
+// the request with an "authorizationError". Note that if authentication is
+// disabled for the method, Request.Username is the one that the caller claims.
+func (m *Method) Authorize(fn func(*Request) error) *Method {
+	m.authorizers = append(m.authorizers, fn)
+	return m
+}
+
+// AllowUsers allows only the given usernames to call this method.
+func (m *Method) AllowUsers(usernames ...string) *Method {
+	allowed := make(map[string]bool, len(usernames))
+	for _, u := range usernames {
+		allowed[u] = true
+	}
+
+	return m.Authorize(func(r *Request) error {
+		if !allowed[r.Username] {
+			return fmt.Errorf("user %q is not allowed to call %q", r.Username, r.Method)
+		}
+		return nil
+	})
+}
+
+// checkAuthType checks whether the authentication type of the request is
+// accepted by the method.
+func (m *Method) checkAuthType(r *Request) *Error {
+	// requests of the connections that are initiated by us are not
+	// authenticated, they may not have any auth type.
+	if len(m.authTypes) == 0 || r.Auth == nil {
+		return nil
+	}
+
+	for _, t := range m.authTypes {
+		if r.Auth.Type == t {
+			return nil
+		}
+	}
+
+	return &Error{
+		Type:    "authenticationError",
+		Message: fmt.Sprintf("Authentication type %q is not accepted for method %q", r.Auth.Type, r.Method),
+	}
+}
+
+// authorize checks the authenticated request against the method's
+// authorizers.
+func (m *Method) authorize(r *Request) *Error {
+	for _, fn := range m.authorizers {
+		if err := fn(r); err != nil {
+			return &Error{
+				Type:    "authorizationError",
+				Message: err.Error(),
+			}
+		}
+	}
+
+	return nil
+}
+
 // Throttle throttles the method for each incoming request. The throttle
 // algorithm is based on token bucket implementation:
 // http://en.wikipedia.org/wiki/Token_bucket. Rate determines the number of
@@ -144,15 +263,86 @@ func (m *Method) PostHandleFunc(handler HandlerFunc) *Method {
 
 // Handle registers the handler for the given method. The handler is called
 // when a method call is received from a Kite.
-func (k *Kite) Handle(method string, handler Handler) *Method {
-	return k.addHandle(method, handler)
+func (k *Kite) Handle(method string, handler Handler, opts ...MethodOption) *Method {
+	return k.addHandle(method, handler, opts...)
 }
 
 // HandleFunc registers a handler to run when a method call is received from a
 // Kite. It returns a *Method option to further modify certain options on a
 // method call
-func (k *Kite) HandleFunc(method string, handler HandlerFunc) *Method {
-	return k.addHandle(method, handler)
+func (k *Kite) HandleFunc(method string, handler HandlerFunc, opts ...MethodOption) *Method {
+	return k.addHandle(method, handler, opts...)
+}
+
+// Use registers a middleware that wraps every method of the Kite, including
+// their Pre and Post handlers. Middlewares are called in the order they are
+// registered, the first one being the outermost.
+func (k *Kite) Use(m Middleware) {
+	k.middlewares = append(k.middlewares, m)
+}
+
+// serveMethod calls the method's handlers wrapped with the registered
+// middlewares and the middlewares of the method's namespace.
+func (k *Kite) serveMethod(m *Method, r *Request) (interface{}, error) {
+	middlewares := k.middlewares
+	if m.namespace != nil {
+		middlewares = append(middlewares[:len(middlewares):len(middlewares)], m.namespace.middlewares()...)
+	}
+
+	// the calls made by the handler get the remaining time of the request
+	if !r.Deadline.IsZero() {
+		defer k.handlerDeadlines.enter(r.Deadline)()
+	}
+
+	next := HandlerFunc(m.ServeKite)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		middleware, inner := middlewares[i], next
+		next = func(r *Request) (interface{}, error) {
+			return middleware(r, inner)
+		}
+	}
+
+	return next(r)
+}
+
+// RegisterObject registers the exported methods of obj which have the
+// signature of a HandlerFunc, func(*Request) (interface{}, error), or of a
+// typed handler, func(*Request, T) (R, error), see TypedHandler. Method names
+// are converted to lower camel case and prefixed with "name." if name is not
+// empty. For example the method Add of an object registered with the name
+// "math" is registered as "math.add". Methods with other signatures are
+// skipped with a warning. It returns the registered methods keyed by their
+// names, so their options can be modified further.
+func (k *Kite) RegisterObject(name string, obj interface{}) map[string]*Method {
+	methods := make(map[string]*Method)
+
+	value := reflect.ValueOf(obj)
+	handlerType := reflect.TypeOf(HandlerFunc(nil))
+
+	for i := 0; i < value.NumMethod(); i++ {
+		m := value.Type().Method(i)
+		if m.PkgPath != "" { // unexported
+			continue
+		}
+
+		method := strings.ToLower(m.Name[0:1]) + m.Name[1:]
+		if name != "" {
+			method = name + "." + method
+		}
+
+		fn := value.Method(i)
+		switch {
+		case fn.Type().ConvertibleTo(handlerType):
+			handler := fn.Convert(handlerType).Interface().(HandlerFunc)
+			methods[method] = k.HandleFunc(method, handler)
+		case isTypedHandler(fn.Type()):
+			methods[method] = k.HandleTyped(method, fn.Interface())
+		default:
+			k.Log.Warning("Method %s of %T is not registered, it's not a handler: %s", m.Name, obj, fn.Type())
+		}
+	}
+
+	return methods
 }
 
 // PreHandle registers an handler which is executed before a kite.Handler
@@ -2,6 +2,8 @@ package kite
 
 import (
 	"errors"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -244,3 +246,412 @@ func TestMethod_Base(t *testing.T) {
 	}
 
 }
+
+func TestMethod_Middleware(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10002
+
+	var order []string
+
+	k.Use(func(r *Request, next HandlerFunc) (interface{}, error) {
+		order = append(order, "outer")
+		return next(r)
+	})
+
+	k.Use(func(r *Request, next HandlerFunc) (interface{}, error) {
+		order = append(order, "inner")
+		if r.Method == "forbidden" {
+			return nil, errors.New("not allowed")
+		}
+
+		result, err := next(r)
+		return result.(string) + "-wrapped", err
+	})
+
+	k.HandleFunc("foo", func(r *Request) (interface{}, error) {
+		order = append(order, "handler")
+		return "handle", nil
+	})
+
+	k.HandleFunc("forbidden", func(r *Request) (interface{}, error) {
+		t.Error("handler shouldn't be called if a middleware stops the chain")
+		return nil, nil
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	c := New("exp", "0.0.1").NewClient("http://127.0.0.1:10002/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := c.TellWithTimeout("foo", 4*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.MustString() != "handle-wrapped" {
+		t.Errorf("Response should be handle-wrapped, got %s", result.MustString())
+	}
+
+	if strings.Join(order, ",") != "outer,inner,handler" {
+		t.Errorf("Middlewares are called in wrong order: %v", order)
+	}
+
+	if _, err := c.TellWithTimeout("forbidden", 4*time.Second); err == nil {
+		t.Error("Middleware should return an error")
+	}
+}
+
+type mathHandlers struct {
+	factor float64
+}
+
+func (m *mathHandlers) Multiply(r *Request) (interface{}, error) {
+	return r.Args.One().MustFloat64() * m.factor, nil
+}
+
+type squareArgs struct {
+	X float64 `json:"x"`
+}
+
+func (m *mathHandlers) Square(r *Request, args squareArgs) (float64, error) {
+	return args.X * args.X * m.factor, nil
+}
+
+func (m *mathHandlers) NotAHandler(a, b int) int { return a + b }
+
+func TestMethod_RegisterObject(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10049
+
+	methods := k.RegisterObject("math", &mathHandlers{factor: 2})
+	if len(methods) != 2 {
+		t.Fatalf("Want 2 registered methods, got: %d", len(methods))
+	}
+
+	for _, name := range []string{"math.multiply", "math.square"} {
+		m, ok := k.handlers[name]
+		if !ok || methods[name] != m {
+			t.Fatalf("%s is not registered", name)
+		}
+	}
+
+	if _, ok := k.handlers["math.notAHandler"]; ok {
+		t.Fatal("methods with other signatures shouldn't be registered")
+	}
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	c := New("exp", "0.0.1").NewClient("http://127.0.0.1:10049/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	result, err := c.TellWithTimeout("math.multiply", 4*time.Second, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := result.MustFloat64(); n != 6 {
+		t.Errorf("math.multiply: got %v, want: 6", n)
+	}
+
+	result, err = c.TellWithTimeout("math.square", 4*time.Second, squareArgs{X: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := result.MustFloat64(); n != 18 {
+		t.Errorf("math.square: got %v, want: 18", n)
+	}
+
+	// the typed methods decode their arguments
+	_, err = c.TellWithTimeout("math.square", 4*time.Second, "three")
+	if kiteErr, ok := err.(*Error); !ok || kiteErr.Type != "argumentError" {
+		t.Errorf("Want argumentError, got: %v", err)
+	}
+}
+
+func TestMethod_Authorize(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10003
+
+	k.HandleFunc("foo", func(r *Request) (interface{}, error) {
+		return "handle", nil
+	}).AllowUsers("alice")
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := New("exp", "0.0.1")
+	e.Config.Username = "bob"
+
+	c := e.NewClient("http://127.0.0.1:10003/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := c.TellWithTimeout("foo", 4*time.Second)
+	if kiteErr, ok := err.(*Error); !ok || kiteErr.Type != "authorizationError" {
+		t.Fatalf("Want authorizationError, got: %v", err)
+	}
+}
+
+func TestMethod_RequireScope(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	m := k.HandleFunc("fs.readFile", func(r *Request) (interface{}, error) {
+		return nil, nil
+	}, RequireScope("fs.read"))
+
+	tests := []struct {
+		scopes  []string
+		allowed bool
+	}{
+		{nil, false},
+		{[]string{"fs.write"}, false},
+		{[]string{"fs.read"}, true},
+		{[]string{"fs.*"}, true},
+		{[]string{"fsx.*"}, false},
+		{[]string{"*"}, true},
+	}
+
+	for _, test := range tests {
+		r := &Request{Method: "fs.readFile", Scopes: test.scopes}
+		var err error
+		for _, authorize := range m.authorizers {
+			if err = authorize(r); err != nil {
+				break
+			}
+		}
+
+		if allowed := err == nil; allowed != test.allowed {
+			t.Errorf("scopes %v: got allowed %t, want: %t", test.scopes, allowed, test.allowed)
+		}
+	}
+}
+
+func TestMethod_RequireRole(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	m := k.HandleFunc("user.delete", func(r *Request) (interface{}, error) {
+		return nil, nil
+	}, RequireRole("admin", "owner"))
+
+	tests := []struct {
+		roles   []string
+		allowed bool
+	}{
+		{nil, false},
+		{[]string{"user"}, false},
+		{[]string{"user", "admin"}, true},
+		{[]string{"owner"}, true},
+	}
+
+	for _, test := range tests {
+		r := &Request{Method: "user.delete", Roles: test.roles}
+		var err error
+		for _, authorize := range m.authorizers {
+			if err = authorize(r); err != nil {
+				break
+			}
+		}
+
+		if allowed := err == nil; allowed != test.allowed {
+			t.Errorf("roles %v: got allowed %t, want: %t", test.roles, allowed, test.allowed)
+		}
+	}
+}
+
+func TestMethod_RequireAuth(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	handler := func(r *Request) (interface{}, error) { return nil, nil }
+
+	if m := k.HandleFunc("private", handler); !m.authenticate {
+		t.Error("private: authentication is disabled by default")
+	}
+
+	if m := k.HandleFunc("public", handler, RequireAuth(false)); m.authenticate {
+		t.Error("public: authentication is enabled with RequireAuth(false)")
+	}
+
+	k.Config.DisableAuthentication = true
+
+	if m := k.HandleFunc("forced", handler, RequireAuth(true)); !m.authenticate {
+		t.Error("forced: authentication is disabled with RequireAuth(true)")
+	}
+}
+
+func TestMethod_RateLimit(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10010
+
+	k.HandleFunc("foo", func(r *Request) (interface{}, error) {
+		return "handle", nil
+	}).RateLimit(time.Hour, 2)
+
+	k.HandleFunc("bar", func(r *Request) (interface{}, error) {
+		return "handle", nil
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	c := New("exp", "0.0.1").NewClient("http://127.0.0.1:10010/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.TellWithTimeout("foo", 4*time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := c.TellWithTimeout("foo", 4*time.Second)
+	if kErr, ok := err.(*Error); !ok || kErr.Type != "rateLimited" {
+		t.Fatalf("got error %v, want: rateLimited", err)
+	}
+
+	// other methods are not limited
+	if _, err := c.TellWithTimeout("bar", 4*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(time.Hour, 1)
+
+	if !l.Allow("user:alice") {
+		t.Fatal("first request of alice is not allowed")
+	}
+
+	if l.Allow("user:alice") {
+		t.Error("second request of alice is allowed")
+	}
+
+	if !l.Allow("user:bob") {
+		t.Error("first request of bob is not allowed")
+	}
+
+	// a new caller evicts a bucket when the limit of the keys is reached
+	l.maxKeys = 2
+
+	if !l.Allow("user:carol") {
+		t.Error("first request of carol is not allowed")
+	}
+
+	if n := len(l.buckets); n != 2 {
+		t.Errorf("got %d buckets, want: 2", n)
+	}
+}
+
+func TestRateLimitBeforeAuthentication(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.Port = 10044
+	k.RateLimit(time.Hour, 2)
+
+	var authenticated int32
+	k.Authenticators["token"] = func(r *Request) error {
+		atomic.AddInt32(&authenticated, 1)
+		return errors.New("invalid token")
+	}
+
+	k.HandleFunc("foo", func(r *Request) (interface{}, error) {
+		return "handle", nil
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	c := New("exp", "0.0.1").NewClient("http://127.0.0.1:10044/kite")
+	c.Auth = &Auth{Type: "token", Key: "invalid"}
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		_, err := c.TellWithTimeout("foo", 4*time.Second)
+		if kErr, ok := err.(*Error); !ok || kErr.Type != "authenticationError" {
+			t.Fatalf("got error %v, want: authenticationError", err)
+		}
+	}
+
+	_, err := c.TellWithTimeout("foo", 4*time.Second)
+	if kErr, ok := err.(*Error); !ok || kErr.Type != "rateLimited" {
+		t.Fatalf("got error %v, want: rateLimited", err)
+	}
+
+	if n := atomic.LoadInt32(&authenticated); n != 2 {
+		t.Errorf("authenticator is called %d times, want: 2", n)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10011
+
+	entries := make(chan *AccessLogEntry, 2)
+	k.Use(AccessLog(AccessLogSinkFunc(func(e *AccessLogEntry) {
+		entries <- e
+	})))
+
+	k.HandleFunc("foo", func(r *Request) (interface{}, error) {
+		return "handle", nil
+	})
+
+	k.HandleFunc("fail", func(r *Request) (interface{}, error) {
+		return nil, errors.New("failed")
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	c := New("exp", "0.0.1").NewClient("http://127.0.0.1:10011/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.TellWithTimeout("foo", 4*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.TellWithTimeout("fail", 4*time.Second); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	tests := []struct {
+		method, status string
+	}{
+		{"foo", "ok"},
+		{"fail", "genericError"},
+	}
+
+	for _, test := range tests {
+		e := <-entries
+
+		if e.Method != test.method || e.Status != test.status {
+			t.Errorf("got method %q status %q, want: %q %q", e.Method, e.Status, test.method, test.status)
+		}
+
+		if e.Username == "" {
+			t.Errorf("username is not set for %q", e.Method)
+		}
+	}
+}
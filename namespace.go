@@ -0,0 +1,129 @@
+package kite
+
+import "sync"
+
+// Namespace is a group of methods whose names share a common prefix, like
+// "fs.readFile" and "fs.writeFile" of the "fs" namespace. Middlewares and
+// authentication policies set on a namespace apply only to its methods and to
+// the methods of its nested namespaces.
+type Namespace struct {
+	kite   *Kite
+	parent *Namespace
+	name   string // full name, including the names of the parents
+
+	mu      sync.Mutex // protects the fields below
+	uses    []Middleware
+	options []func(*Method)
+	methods []*Method
+}
+
+// Namespace returns a new method group whose methods are registered with the
+// name prefixed with "name.".
+func (k *Kite) Namespace(name string) *Namespace {
+	return &Namespace{kite: k, name: name}
+}
+
+// Namespace returns a nested group whose methods are registered with the name
+// prefixed with the name of n and "name.", like "fs.local.readFile". The
+// nested group inherits the middlewares and policies of n.
+func (n *Namespace) Namespace(name string) *Namespace {
+	return &Namespace{kite: n.kite, parent: n, name: n.name + "." + name}
+}
+
+// Name returns the full name of the namespace.
+func (n *Namespace) Name() string {
+	return n.name
+}
+
+// Handle registers the handler for the method "<namespace>.<method>". The
+// policies of the namespace are applied to the returned *Method, which can be
+// modified further.
+func (n *Namespace) Handle(method string, handler Handler, opts ...MethodOption) *Method {
+	m := n.kite.addHandle(n.name+"."+method, handler, opts...)
+	m.namespace = n
+	n.add(m)
+	return m
+}
+
+// HandleFunc registers the handler for the method "<namespace>.<method>". See
+// Handle for details.
+func (n *Namespace) HandleFunc(method string, handler HandlerFunc, opts ...MethodOption) *Method {
+	return n.Handle(method, handler, opts...)
+}
+
+// Use registers a middleware that wraps the methods of the namespace. They
+// run inside the middlewares registered with Kite.Use, the ones of the parent
+// namespaces being the outermost.
+func (n *Namespace) Use(m Middleware) {
+	n.mu.Lock()
+	n.uses = append(n.uses, m)
+	n.mu.Unlock()
+}
+
+// DisableAuthentication disables authentication check for the methods of the
+// namespace.
+func (n *Namespace) DisableAuthentication() *Namespace {
+	return n.apply(func(m *Method) { m.DisableAuthentication() })
+}
+
+// AuthTypes restricts the authentication types accepted for the methods of
+// the namespace. See Method.AuthTypes.
+func (n *Namespace) AuthTypes(types ...string) *Namespace {
+	return n.apply(func(m *Method) { m.AuthTypes(types...) })
+}
+
+// Authorize adds a function that decides whether the authenticated request is
+// allowed to call the methods of the namespace. See Method.Authorize.
+func (n *Namespace) Authorize(fn func(*Request) error) *Namespace {
+	return n.apply(func(m *Method) { m.Authorize(fn) })
+}
+
+// AllowUsers allows only the given usernames to call the methods of the
+// namespace.
+func (n *Namespace) AllowUsers(usernames ...string) *Namespace {
+	return n.apply(func(m *Method) { m.AllowUsers(usernames...) })
+}
+
+// apply calls fn for the methods that are already registered and saves it for
+// the ones that are registered later.
+func (n *Namespace) apply(fn func(*Method)) *Namespace {
+	n.mu.Lock()
+	n.options = append(n.options, fn)
+	methods := n.methods[:len(n.methods):len(n.methods)]
+	n.mu.Unlock()
+
+	for _, m := range methods {
+		fn(m)
+	}
+
+	return n
+}
+
+// add applies the policies of the namespace and its parents to m.
+func (n *Namespace) add(m *Method) {
+	for ; n != nil; n = n.parent {
+		n.mu.Lock()
+		n.methods = append(n.methods, m)
+		options := n.options[:len(n.options):len(n.options)]
+		n.mu.Unlock()
+
+		for _, fn := range options {
+			fn(m)
+		}
+	}
+}
+
+// middlewares returns the middlewares of the namespace and its parents, the
+// outermost being the first.
+func (n *Namespace) middlewares() []Middleware {
+	var ms []Middleware
+	if n.parent != nil {
+		ms = n.parent.middlewares()
+	}
+
+	n.mu.Lock()
+	ms = append(ms, n.uses...)
+	n.mu.Unlock()
+
+	return ms
+}
@@ -0,0 +1,68 @@
+package kite
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNamespace(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10027
+
+	var wrapped []string
+	handler := func(r *Request) (interface{}, error) {
+		return r.Method, nil
+	}
+
+	fs := k.Namespace("fs")
+	fs.Use(func(r *Request, next HandlerFunc) (interface{}, error) {
+		wrapped = append(wrapped, r.Method)
+		return next(r)
+	})
+	fs.HandleFunc("readFile", handler)
+
+	admin := fs.Namespace("admin")
+	admin.HandleFunc("format", handler)
+	admin.AllowUsers("alice")
+
+	k.HandleFunc("readFile", handler)
+
+	if _, ok := k.handlers["fs.admin.format"]; !ok {
+		t.Fatal("fs.admin.format is not registered")
+	}
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := New("exp", "0.0.1")
+	e.Config.Username = "bob"
+
+	c := e.NewClient("http://127.0.0.1:10027/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, method := range []string{"fs.readFile", "readFile"} {
+		result, err := c.TellWithTimeout(method, 4*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s := result.MustString(); s != method {
+			t.Errorf("got %q, want: %q", s, method)
+		}
+	}
+
+	_, err := c.TellWithTimeout("fs.admin.format", 4*time.Second)
+	if kiteErr, ok := err.(*Error); !ok || kiteErr.Type != "authorizationError" {
+		t.Fatalf("Want authorizationError, got: %v", err)
+	}
+
+	// unauthorized requests are rejected before the middlewares are called
+	if len(wrapped) != 1 || wrapped[0] != "fs.readFile" {
+		t.Errorf("namespace middleware wrapped wrong methods: %v", wrapped)
+	}
+}
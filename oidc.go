@@ -0,0 +1,355 @@
+package kite
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// OIDCKeysTTL is the duration the keys of an OpenID Connect provider
+	// are cached for.
+	OIDCKeysTTL = time.Hour
+
+	// oidcMinRefresh limits how often the keys are fetched again for the
+	// tokens signed with unknown keys.
+	oidcMinRefresh = time.Minute
+)
+
+// OIDCProvider authenticates the kites with the bearer tokens issued by an
+// OpenID Connect provider, like the ID tokens or the JWT access tokens of
+// the identity provider of the organization. The tokens are verified with
+// the keys published by the provider. It's used as the "oidc" authentication
+// type like:
+//
+//	p := &kite.OIDCProvider{
+//		Issuer:   "https://accounts.example.com",
+//		Audience: "my-client-id",
+//	}
+//	k.Authenticators["oidc"] = p.Authenticate
+//
+// The callers set Auth to &kite.Auth{Type: "oidc", Key: token}.
+type OIDCProvider struct {
+	// Issuer is the URL of the provider, which must be the "iss" claim of
+	// the tokens. The keys are found via its discovery document at
+	// Issuer + "/.well-known/openid-configuration".
+	Issuer string
+
+	// Audience must be one of the audiences in the "aud" claim of the
+	// tokens, usually the client id of the application.
+	Audience string
+
+	// JWKSURL is the URL of the keys of the provider. It's used instead of
+	// the discovery document if it's set.
+	JWKSURL string
+
+	// UsernameClaim is the claim that is used as the username, like "email"
+	// or "preferred_username". Default is "sub".
+	UsernameClaim string
+
+	// Client fetches the discovery document and the keys. Default is a
+	// client with a 10 second timeout.
+	Client *http.Client
+
+	// Log logs the published keys that can't be used, like the EC keys on
+	// unsupported curves. Default is a logger named "oidc".
+	Log Logger
+
+	keys    map[string]interface{} // keys are key ids
+	fetched time.Time
+	mu      sync.Mutex
+}
+
+// Authenticate validates the token in r.Auth.Key and sets the username to
+// the UsernameClaim of the token.
+func (p *OIDCProvider) Authenticate(r *Request) error {
+	token, err := jwt.Parse(r.Auth.Key, p.key)
+	if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors&jwt.ValidationErrorExpired != 0 {
+		return ErrTokenExpired
+	}
+	if err != nil {
+		return err
+	}
+
+	if !token.Valid {
+		return errors.New("Invalid signature in token")
+	}
+
+	if issuer, _ := token.Claims["iss"].(string); issuer != p.Issuer {
+		return fmt.Errorf("issuer is not trusted: %s", issuer)
+	}
+
+	if !hasAudience(token.Claims["aud"], p.Audience) {
+		return errors.New("Invalid audience in token")
+	}
+
+	// jwt-go checks the expiration only if the claim is present
+	if _, ok := token.Claims["exp"]; !ok {
+		return errors.New("Expiration is not present in token")
+	}
+
+	claim := p.UsernameClaim
+	if claim == "" {
+		claim = "sub"
+	}
+
+	username, ok := token.Claims[claim].(string)
+	if !ok || username == "" {
+		return fmt.Errorf("Username claim %q is not present in token", claim)
+	}
+
+	r.Username = username
+	r.Scopes = scopesFromClaims(token.Claims)
+	r.Roles = rolesFromClaims(token.Claims)
+
+	// OAuth2 access tokens list the scopes separated with spaces
+	if scope, ok := token.Claims["scope"].(string); ok {
+		r.Scopes = append(r.Scopes, strings.Fields(scope)...)
+	}
+
+	return nil
+}
+
+// hasAudience returns true if the "aud" claim, which is either a string or a
+// list of strings, contains the audience.
+func hasAudience(claim interface{}, audience string) bool {
+	switch aud := claim.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// key returns the key of the provider that the token is signed with. The keys
+// are fetched again if the key is not known, so the rotated keys are found.
+func (p *OIDCProvider) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys == nil || time.Since(p.fetched) > OIDCKeysTTL || (p.lookup(kid) == nil && time.Since(p.fetched) > oidcMinRefresh) {
+		// the cached keys are used while the provider is unreachable
+		if err := p.fetchKeys(); err != nil && p.lookup(kid) == nil {
+			return nil, err
+		}
+	}
+
+	key := p.lookup(kid)
+	if key == nil {
+		return nil, fmt.Errorf("unknown key: %q", kid)
+	}
+
+	// do not let the tokens signed with other algorithms to be verified
+	// with the key, like HS256 with the public key as the secret
+	switch key.(type) {
+	case []byte:
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %s", token.Method.Alg())
+		}
+	case *ecdsa.PublicKey:
+		if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %s", token.Method.Alg())
+		}
+	}
+
+	return key, nil
+}
+
+// lookup returns the key with the id, or the only key if the id is empty.
+func (p *OIDCProvider) lookup(kid string) interface{} {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+
+	return p.keys[kid]
+}
+
+// fetchKeys replaces the keys with the ones published by the provider.
+func (p *OIDCProvider) fetchKeys() error {
+	p.fetched = time.Now()
+
+	jwksURL := p.JWKSURL
+	if jwksURL == "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+
+		u := strings.TrimSuffix(p.Issuer, "/") + "/.well-known/openid-configuration"
+		if err := p.get(u, &discovery); err != nil {
+			return err
+		}
+
+		if discovery.Issuer != p.Issuer {
+			return fmt.Errorf("discovery document is of another issuer: %s", discovery.Issuer)
+		}
+
+		jwksURL = discovery.JWKSURI
+	}
+
+	var jwks struct {
+		Keys []*jsonWebKey `json:"keys"`
+	}
+
+	if err := p.get(jwksURL, &jwks); err != nil {
+		return err
+	}
+
+	keys := make(map[string]interface{}, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		// the other keys can still be used
+		key, err := jwk.publicKey()
+		if err != nil {
+			p.log().Warning("Skipping the invalid key %q of %s: %s", jwk.Kid, p.Issuer, err)
+			continue
+		}
+
+		if key != nil {
+			keys[jwk.Kid] = key
+		}
+	}
+
+	p.keys = keys
+	return nil
+}
+
+// log returns the logger of the provider. It must be called with p.mu held.
+func (p *OIDCProvider) log() Logger {
+	if p.Log == nil {
+		p.Log, _ = newLogger("oidc")
+	}
+
+	return p.Log
+}
+
+// get fetches the JSON document at the url into v.
+func (p *OIDCProvider) get(url string, v interface{}) error {
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// jsonWebKey is a public key in a JWK Set, as defined in RFC 7517.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+
+	// RSA keys
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC keys
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the RSA key as a PEM block, which is how the RSA keys
+// are given to jwt-go in this package, or the EC key as *ecdsa.PublicKey. It
+// returns nil for the other key types.
+func (jwk *jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		der, err := x509.MarshalPKIXPublicKey(&rsa.PublicKey{N: n, E: int(e.Int64())})
+		if err != nil {
+			return nil, err
+		}
+
+		return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+		}
+
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, nil
+}
+
+// decodeBigInt decodes the unpadded base64url encoded big-endian integer.
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) == 0 {
+		return nil, errors.New("empty integer")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,93 @@
+package kite
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/koding/kite/testkeys"
+)
+
+func TestOIDCProvider(t *testing.T) {
+	key, err := jwt.ParseRSAPublicKeyFromPEM([]byte(testkeys.Public))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/.well-known/openid-configuration" {
+			json.NewEncoder(rw).Encode(map[string]string{
+				"issuer":   srv.URL,
+				"jwks_uri": srv.URL + "/keys",
+			})
+			return
+		}
+
+		// the keys that can't be used are skipped
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{"kty": "EC", "kid": "unsupported", "crv": "secp256k1", "x": "AQ", "y": "AQ"},
+				{"kty": "RSA", "kid": "invalid", "n": "!", "e": "AQAB"},
+				jwkOf("test", key),
+			},
+		})
+	}))
+	defer srv.Close()
+
+	p := &OIDCProvider{
+		Issuer:        srv.URL,
+		Audience:      "testapp",
+		UsernameClaim: "email",
+	}
+
+	newToken := func(audience interface{}) string {
+		token := jwt.New(jwt.GetSigningMethod("RS256"))
+		token.Header["kid"] = "test"
+		token.Claims["iss"] = srv.URL
+		token.Claims["aud"] = audience
+		token.Claims["email"] = "alice@example.com"
+		token.Claims["exp"] = time.Now().Add(time.Hour).Unix()
+		token.Claims["scope"] = "openid email"
+
+		s, err := token.SignedString([]byte(testkeys.Private))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	r := &Request{Auth: &Auth{Type: "oidc", Key: newToken([]string{"other", "testapp"})}}
+	if err := p.Authenticate(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Username != "alice@example.com" {
+		t.Errorf("got username %q, want: %q", r.Username, "alice@example.com")
+	}
+
+	if !hasScope(r.Scopes, "email") {
+		t.Errorf("got scopes %v, want: email", r.Scopes)
+	}
+
+	r = &Request{Auth: &Auth{Type: "oidc", Key: newToken("other")}}
+	if err := p.Authenticate(r); err == nil {
+		t.Error("token of another audience is accepted")
+	}
+}
+
+// jwkOf returns the RSA public key as a JSON Web Key.
+func jwkOf(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
@@ -0,0 +1,47 @@
+package kite
+
+import (
+	"crypto/tls"
+
+	"github.com/koding/kite/config"
+)
+
+// Option configures a Kite in New. Options are applied in the given order,
+// so WithConfig should come before the options that change the config.
+type Option func(*Kite)
+
+// WithConfig sets the config of the kite. The default config is kept if c is
+// nil.
+func WithConfig(c *config.Config) Option {
+	return func(k *Kite) {
+		if c != nil {
+			k.Config = c
+		}
+	}
+}
+
+// WithPort sets the port the kite server listens on.
+func WithPort(port int) Option {
+	return func(k *Kite) { k.Config.Port = port }
+}
+
+// WithLogger sets the logger of the kite. SetLogLevel has no effect on the
+// given logger.
+func WithLogger(l Logger) Option {
+	return func(k *Kite) {
+		k.Log = l
+		k.SetLogLevel = func(Level) {}
+	}
+}
+
+// WithTLS makes the kite server accept TLS connections with the given
+// config.
+func WithTLS(c *tls.Config) Option {
+	return func(k *Kite) { k.TLSConfig = c }
+}
+
+// WithTransport sets the transport that the clients of the kite use for
+// connecting to other kites.
+func WithTransport(t config.Transport) Option {
+	return func(k *Kite) { k.Config.Transport = t }
+}
@@ -0,0 +1,72 @@
+package kite
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// checkRequest validates the Origin and Host headers of an incoming HTTP
+// request against Config.AllowedOrigins and Config.AllowedHosts. Browsers
+// send the Origin header with websocket handshakes, checking it prevents
+// other sites from connecting to the kite with the cookies of the user.
+func (k *Kite) checkRequest(req *http.Request) bool {
+	if len(k.Config.AllowedHosts) != 0 && !matchHost(k.Config.AllowedHosts, req.Host) {
+		return false
+	}
+
+	origin := req.Header.Get("Origin")
+	if origin == "" || len(k.Config.AllowedOrigins) == 0 {
+		return true // not a browser or not restricted
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	// kite clients send the URL they connect to as the origin
+	if u.Host == req.Host {
+		return true
+	}
+
+	for _, allowed := range k.Config.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+
+		// "https://*.example.com" matches the subdomains of example.com on
+		// any port, a port in the pattern must match too
+		a, err := url.Parse(allowed)
+		if err != nil || a.Scheme != u.Scheme {
+			continue
+		}
+
+		if a.Port() != "" && a.Port() != u.Port() {
+			continue
+		}
+
+		if pattern := a.Hostname(); strings.HasPrefix(pattern, "*.") && strings.HasSuffix(u.Hostname(), pattern[1:]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchHost returns true if host, with or without its port, is in allowed.
+func matchHost(allowed []string, host string) bool {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	for _, a := range allowed {
+		if strings.EqualFold(a, host) || strings.EqualFold(a, hostname) {
+			return true
+		}
+	}
+
+	return false
+}
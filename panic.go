@@ -0,0 +1,28 @@
+package kite
+
+import (
+	"os"
+)
+
+// handlerPanic carries a panic of a handler that is run in another goroutine
+// to the goroutine of the request, together with the stack of the panic.
+type handlerPanic struct {
+	value interface{}
+	stack []byte
+}
+
+// recoverPanic returns the error sent to the caller for a panic recovered
+// while serving the request. The request is nil if the panic has happened
+// before the request is created.
+func (k *Kite) recoverPanic(r *Request, recovered interface{}, stack []byte) *Error {
+	if k.PanicHandler == nil {
+		os.Stderr.Write(stack)
+		return createError(recovered)
+	}
+
+	if err := k.PanicHandler(r, recovered, stack); err != nil {
+		return err
+	}
+
+	return createError(recovered)
+}
@@ -0,0 +1,408 @@
+// Package pool provides a client side load balancer for the kites that match
+// a kontrol query. A Pool keeps a connection to every matching kite, follows
+// the kites registering to and leaving from kontrol, checks their health and
+// distributes the calls between the healthy ones.
+package pool
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/dnode"
+	"github.com/koding/kite/protocol"
+)
+
+const (
+	// DefaultHealthCheckInterval is the interval of pings sent to the kites
+	// in the pool.
+	DefaultHealthCheckInterval = 10 * time.Second
+
+	// DefaultHealthCheckTimeout is the duration a kite has to answer a ping
+	// before it's marked as unhealthy.
+	DefaultHealthCheckTimeout = 4 * time.Second
+
+	// DefaultRefreshInterval is the interval of the queries sent to kontrol
+	// when kontrol does not support watching kites.
+	DefaultRefreshInterval = 30 * time.Second
+)
+
+// ErrNoKites is returned from Pool.Tell when there is no healthy kite in the
+// pool.
+var ErrNoKites = errors.New("pool: no healthy kites available")
+
+// ErrClosed is returned from Pool.Tell after the pool is closed.
+var ErrClosed = errors.New("pool: closed")
+
+// Strategy decides which kite is called next.
+type Strategy int
+
+const (
+	// RoundRobin calls the healthy kites in turn.
+	RoundRobin Strategy = iota
+
+	// LeastPending calls the kite with the least number of unanswered
+	// calls made through the pool.
+	LeastPending
+)
+
+// Pool is a set of connected clients to the kites matching a query.
+type Pool struct {
+	// Strategy is used for choosing the kite to call. It must be set before
+	// the first call.
+	Strategy Strategy
+
+	// MaxAttempts is the number of kites tried for a single call when the
+	// chosen kite is not reachable. Zero means every healthy kite is tried
+	// once.
+	MaxAttempts int
+
+	k     *kite.Kite
+	query *protocol.KontrolQuery
+
+	healthCheckInterval time.Duration
+	healthCheckTimeout  time.Duration
+
+	mu      sync.Mutex // protects fields below
+	members map[string]*member
+	next    int
+	closed  bool
+
+	watcher *kite.Watcher
+	done    chan struct{}
+}
+
+// member is a kite in the pool.
+type member struct {
+	client  *kite.Client
+	pending int64 // number of calls waiting for a response, accessed atomically
+	healthy int32 // 1 if healthy, accessed atomically
+}
+
+func (m *member) isHealthy() bool { return atomic.LoadInt32(&m.healthy) == 1 }
+
+func (m *member) setHealthy(healthy bool) {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	atomic.StoreInt32(&m.healthy, v)
+}
+
+// New returns a new Pool of the kites matching the query. The kontrol client
+// of k is used for finding the kites. Changes are followed with a kontrol
+// watch if the kontrol storage supports it, otherwise kontrol is queried
+// every DefaultRefreshInterval.
+func New(k *kite.Kite, query *protocol.KontrolQuery) (*Pool, error) {
+	p := &Pool{
+		k:                   k,
+		query:               query,
+		healthCheckInterval: DefaultHealthCheckInterval,
+		healthCheckTimeout:  DefaultHealthCheckTimeout,
+		members:             make(map[string]*member),
+		done:                make(chan struct{}),
+	}
+
+	// start watching before the query so no kite is missed in between
+	watcher, err := k.WatchKites(query)
+	if err != nil {
+		k.Log.Warning("pool: cannot watch kites, will poll kontrol instead: %s", err)
+	}
+
+	clients, err := k.GetKites(query)
+	if err != nil && err != kite.ErrNoKitesAvailable {
+		if watcher != nil {
+			watcher.Stop()
+		}
+		return nil, err
+	}
+
+	for _, c := range clients {
+		p.add(c)
+	}
+
+	if watcher != nil {
+		p.watcher = watcher
+		go p.watch()
+	} else {
+		go p.poll(DefaultRefreshInterval)
+	}
+
+	go p.healthCheck()
+
+	return p, nil
+}
+
+// SetHealthCheck changes the interval and the timeout of the pings sent to
+// the kites in the pool.
+func (p *Pool) SetHealthCheck(interval, timeout time.Duration) {
+	p.mu.Lock()
+	p.healthCheckInterval = interval
+	p.healthCheckTimeout = timeout
+	p.mu.Unlock()
+}
+
+// Len returns the number of kites in the pool, including the unhealthy ones.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.members)
+}
+
+// Clients returns the clients of the healthy kites in the pool.
+func (p *Pool) Clients() []*kite.Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	clients := make([]*kite.Client, 0, len(p.members))
+	for _, m := range p.members {
+		if m.isHealthy() {
+			clients = append(clients, m.client)
+		}
+	}
+
+	return clients
+}
+
+// Tell calls the method on one of the healthy kites in the pool. If the
+// chosen kite is not reachable, it's marked as unhealthy and the call is
+// sent to the next kite, up to MaxAttempts kites. Errors returned from the
+// remote method are not retried.
+func (p *Pool) Tell(method string, args ...interface{}) (*dnode.Partial, error) {
+	tried := make(map[*member]bool)
+
+	for {
+		m, err := p.pick(tried)
+		if err != nil {
+			return nil, err
+		}
+
+		tried[m] = true
+
+		atomic.AddInt64(&m.pending, 1)
+		result, err := m.client.Tell(method, args...)
+		atomic.AddInt64(&m.pending, -1)
+
+		if !kite.IsDeadPeer(err) {
+			return result, err
+		}
+
+		p.k.Log.Warning("pool: kite %s is not reachable: %s", m.client.Kite, err)
+		m.setHealthy(false)
+
+		if p.MaxAttempts > 0 && len(tried) >= p.MaxAttempts {
+			return nil, err
+		}
+	}
+}
+
+// pick chooses a healthy member that is not tried yet.
+func (p *Pool) pick(tried map[*member]bool) (*member, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, ErrClosed
+	}
+
+	candidates := make([]*member, 0, len(p.members))
+	for _, m := range p.members {
+		if m.isHealthy() && !tried[m] {
+			candidates = append(candidates, m)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, ErrNoKites
+	}
+
+	if p.Strategy == LeastPending {
+		best := candidates[0]
+		for _, m := range candidates[1:] {
+			if atomic.LoadInt64(&m.pending) < atomic.LoadInt64(&best.pending) {
+				best = m
+			}
+		}
+		return best, nil
+	}
+
+	// map iteration order is random, sort by id to have a stable rotation
+	sort.Sort(byID(candidates))
+
+	p.next++
+	return candidates[p.next%len(candidates)], nil
+}
+
+// byID sorts members by their kite ids.
+type byID []*member
+
+func (b byID) Len() int           { return len(b) }
+func (b byID) Less(i, j int) bool { return b[i].client.Kite.ID < b[j].client.Kite.ID }
+func (b byID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// Close stops following the kites and closes all connections in the pool.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.done)
+
+	members := p.members
+	p.members = make(map[string]*member)
+	p.mu.Unlock()
+
+	if p.watcher != nil {
+		p.watcher.Stop()
+	}
+
+	for _, m := range members {
+		m.client.Close()
+	}
+}
+
+// add connects to the kite of c and adds it to the pool. An existing kite
+// with the same id is replaced.
+func (p *Pool) add(c *kite.Client) {
+	m := &member{client: c}
+
+	c.Reconnect = true
+	c.OnConnect(func() { m.setHealthy(true) })
+	c.OnDisconnect(func() { m.setHealthy(false) })
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	old := p.members[c.Kite.ID]
+	p.members[c.Kite.ID] = m
+	p.mu.Unlock()
+
+	if old != nil {
+		old.client.Close()
+	}
+
+	if _, err := c.DialForever(); err != nil {
+		p.k.Log.Error("pool: cannot connect to kite %s: %s", c.Kite, err)
+	}
+}
+
+// remove closes the connection to the kite with the id and removes it from
+// the pool.
+func (p *Pool) remove(id string) {
+	p.mu.Lock()
+	m := p.members[id]
+	delete(p.members, id)
+	p.mu.Unlock()
+
+	if m != nil {
+		m.client.Close()
+	}
+}
+
+// watch applies the events of the kontrol watch to the pool.
+func (p *Pool) watch() {
+	for {
+		select {
+		case e := <-p.watcher.Events():
+			switch e.Type {
+			case kite.KiteAdded, kite.KiteUpdated:
+				p.add(e.Client(p.k))
+			case kite.KiteRemoved:
+				p.remove(e.Kite.ID)
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// poll queries kontrol periodically and syncs the pool with the result.
+func (p *Pool) poll(interval time.Duration) {
+	for {
+		select {
+		case <-time.After(interval):
+		case <-p.done:
+			return
+		}
+
+		clients, err := p.k.GetKites(p.query)
+		if err != nil && err != kite.ErrNoKitesAvailable {
+			p.k.Log.Error("pool: cannot query kites: %s", err)
+			continue
+		}
+
+		p.sync(clients)
+	}
+}
+
+// sync adds the new kites in clients to the pool and removes the ones that
+// are not in clients anymore.
+func (p *Pool) sync(clients []*kite.Client) {
+	found := make(map[string]bool, len(clients))
+
+	for _, c := range clients {
+		found[c.Kite.ID] = true
+
+		p.mu.Lock()
+		m, ok := p.members[c.Kite.ID]
+		p.mu.Unlock()
+
+		if !ok || m.client.URL != c.URL {
+			p.add(c)
+		}
+	}
+
+	p.mu.Lock()
+	var removed []string
+	for id := range p.members {
+		if !found[id] {
+			removed = append(removed, id)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, id := range removed {
+		p.remove(id)
+	}
+}
+
+// healthCheck pings every kite in the pool periodically. Kites that do not
+// answer are marked as unhealthy until they answer again.
+func (p *Pool) healthCheck() {
+	for {
+		p.mu.Lock()
+		interval, timeout := p.healthCheckInterval, p.healthCheckTimeout
+		p.mu.Unlock()
+
+		select {
+		case <-time.After(interval):
+		case <-p.done:
+			return
+		}
+
+		p.mu.Lock()
+		members := make([]*member, 0, len(p.members))
+		for _, m := range p.members {
+			members = append(members, m)
+		}
+		p.mu.Unlock()
+
+		var wg sync.WaitGroup
+		for _, m := range members {
+			wg.Add(1)
+			go func(m *member) {
+				defer wg.Done()
+				_, err := m.client.TellWithTimeout("kite.ping", timeout)
+				m.setHealthy(!kite.IsDeadPeer(err))
+			}(m)
+		}
+		wg.Wait()
+	}
+}
@@ -0,0 +1,65 @@
+package pool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/kitetest"
+	"github.com/koding/kite/protocol"
+)
+
+func TestPool(t *testing.T) {
+	kon, err := kitetest.NewKontrol()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kon.Kite.Close()
+
+	for _, id := range []string{"a", "b"} {
+		id := id
+		k := kon.NewKite("pooltest", "0.0.1")
+		k.HandleFunc("id", func(r *kite.Request) (interface{}, error) {
+			return id, nil
+		})
+
+		if err := kon.Register(k); err != nil {
+			t.Fatal(err)
+		}
+		defer k.Close()
+	}
+
+	local := kon.NewKite("local", "0.0.1")
+	defer local.Close()
+
+	p, err := New(local, &protocol.KontrolQuery{
+		Username:    local.Kite().Username,
+		Environment: local.Kite().Environment,
+		Name:        "pooltest",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	for start := time.Now(); len(p.Clients()) != 2; {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("got %d healthy kites, want: 2", len(p.Clients()))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	seen := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		result, err := p.Tell("id")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		seen[result.MustString()]++
+	}
+
+	if seen["a"] != 2 || seen["b"] != 2 {
+		t.Errorf("calls are not balanced: %v", seen)
+	}
+}
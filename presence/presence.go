@@ -0,0 +1,217 @@
+// Package presence tells which users are online by following their kites in
+// kontrol. A user is online while at least one of the user's kites matching
+// the query is registered to kontrol. Kontrol removes the kites that stop
+// sending heartbeats, so a user whose kites die goes offline after the
+// heartbeat timeout of kontrol.
+//
+//	w, err := presence.Watch(k, &protocol.KontrolQuery{Name: "chat"})
+//	for e := range w.Events() {
+//		fmt.Println(e.Username, e.Online)
+//	}
+package presence
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/protocol"
+)
+
+// RefreshInterval is the interval the kites are queried again to catch up
+// with the events missed while kontrol is not connected.
+var RefreshInterval = 30 * time.Second
+
+// PresenceEvent is sent when a user goes online or offline.
+type PresenceEvent struct {
+	// Username is the user whose presence has changed.
+	Username string
+
+	// Online is true if the user has come online, false if the user has
+	// gone offline.
+	Online bool
+
+	// Kite is the kite that has caused the change, the first registered
+	// kite of the user or the last removed one.
+	Kite protocol.Kite
+}
+
+// Watcher follows the presence of the users. It's returned from Watch.
+type Watcher struct {
+	k       *kite.Kite
+	query   *protocol.KontrolQuery
+	watcher *kite.Watcher
+	events  chan PresenceEvent
+
+	mu    sync.Mutex                          // protects users
+	users map[string]map[string]protocol.Kite // username -> kite id -> kite
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// Watch starts following the presence of the owners of the kites matching
+// the query. The users that are online when Watch is called are reported
+// with the first events.
+func Watch(k *kite.Kite, query *protocol.KontrolQuery) (*Watcher, error) {
+	// start watching before the query so no kite is missed in between
+	watcher, err := k.WatchKites(query)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{
+		k:       k,
+		query:   query,
+		watcher: watcher,
+		events:  make(chan PresenceEvent, 16),
+		users:   make(map[string]map[string]protocol.Kite),
+		done:    make(chan struct{}),
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+// Events returns the channel the presence events are delivered from. The
+// channel is closed when the watcher is stopped.
+func (w *Watcher) Events() <-chan PresenceEvent {
+	return w.events
+}
+
+// Online returns true if the user is online.
+func (w *Watcher) Online(username string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.users[username]) > 0
+}
+
+// Users returns the online users sorted by their names.
+func (w *Watcher) Users() []string {
+	w.mu.Lock()
+	users := make([]string, 0, len(w.users))
+	for username := range w.users {
+		users = append(users, username)
+	}
+	w.mu.Unlock()
+
+	sort.Strings(users)
+	return users
+}
+
+// Stop stops the watcher and closes the events channel.
+func (w *Watcher) Stop() error {
+	var err error
+	w.stopOnce.Do(func() {
+		close(w.done)
+		err = w.watcher.Stop()
+	})
+	return err
+}
+
+func (w *Watcher) run() {
+	defer close(w.events)
+
+	w.refresh()
+
+	ticker := time.NewTicker(RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e := <-w.watcher.Events():
+			switch e.Type {
+			case kite.KiteAdded, kite.KiteUpdated:
+				w.add(e.Kite)
+			case kite.KiteRemoved:
+				w.remove(e.Kite)
+			}
+		case <-ticker.C:
+			w.refresh()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// refresh queries the kites and brings the users up to date with them.
+func (w *Watcher) refresh() {
+	clients, err := w.k.GetKites(w.query)
+	if err != nil && err != kite.ErrNoKitesAvailable {
+		w.k.Log.Error("presence: cannot query kites: %s", err)
+		return
+	}
+
+	found := make(map[string]bool, len(clients))
+	for _, c := range clients {
+		found[c.Kite.ID] = true
+		w.add(c.Kite)
+	}
+
+	var removed []protocol.Kite
+	w.mu.Lock()
+	for _, kites := range w.users {
+		for id, k := range kites {
+			if !found[id] {
+				removed = append(removed, k)
+			}
+		}
+	}
+	w.mu.Unlock()
+
+	for _, k := range removed {
+		w.remove(k)
+	}
+}
+
+// add adds the kite to its user, the user comes online with its first kite.
+func (w *Watcher) add(k protocol.Kite) {
+	w.mu.Lock()
+	kites, ok := w.users[k.Username]
+	if !ok {
+		kites = make(map[string]protocol.Kite)
+		w.users[k.Username] = kites
+	}
+	kites[k.ID] = k
+	w.mu.Unlock()
+
+	if !ok {
+		w.send(PresenceEvent{Username: k.Username, Online: true, Kite: k})
+	}
+}
+
+// remove removes the kite from its user, the user goes offline with its
+// last kite.
+func (w *Watcher) remove(k protocol.Kite) {
+	w.mu.Lock()
+	kites, ok := w.users[k.Username]
+	if !ok {
+		w.mu.Unlock()
+		return
+	}
+
+	if _, ok := kites[k.ID]; !ok {
+		w.mu.Unlock()
+		return
+	}
+
+	delete(kites, k.ID)
+	offline := len(kites) == 0
+	if offline {
+		delete(w.users, k.Username)
+	}
+	w.mu.Unlock()
+
+	if offline {
+		w.send(PresenceEvent{Username: k.Username, Online: false, Kite: k})
+	}
+}
+
+func (w *Watcher) send(e PresenceEvent) {
+	select {
+	case w.events <- e:
+	case <-w.done:
+	}
+}
@@ -0,0 +1,80 @@
+package presence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/koding/kite/kitetest"
+	"github.com/koding/kite/protocol"
+)
+
+func TestWatch(t *testing.T) {
+	kon, err := kitetest.NewKontrol()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kon.Kite.Close()
+
+	observer := kon.NewKite("observer", "0.0.1")
+	defer observer.Close()
+
+	w, err := Watch(observer, &protocol.KontrolQuery{
+		Username:    observer.Kite().Username,
+		Environment: observer.Kite().Environment,
+		Name:        "chat",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	chat := kon.NewKite("chat", "0.0.1")
+	if err := kon.Register(chat); err != nil {
+		t.Fatal(err)
+	}
+	defer chat.Close()
+
+	select {
+	case e := <-w.Events():
+		if e.Username != chat.Kite().Username || !e.Online {
+			t.Errorf("got event %+v, want %s online", e, chat.Kite().Username)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the presence event")
+	}
+
+	if !w.Online(chat.Kite().Username) {
+		t.Errorf("%s is not online", chat.Kite().Username)
+	}
+}
+
+func TestOffline(t *testing.T) {
+	w := &Watcher{
+		events: make(chan PresenceEvent, 16),
+		users:  make(map[string]map[string]protocol.Kite),
+		done:   make(chan struct{}),
+	}
+
+	a := protocol.Kite{Username: "alice", ID: "1"}
+	b := protocol.Kite{Username: "alice", ID: "2"}
+
+	w.add(a)
+	w.add(b)
+	w.remove(a)
+
+	if got := w.Users(); len(got) != 1 || got[0] != "alice" {
+		t.Fatalf("got users %v, want [alice]", got)
+	}
+
+	w.remove(b)
+	w.remove(b) // removing twice is a no-op
+
+	var events []PresenceEvent
+	for len(w.events) > 0 {
+		events = append(events, <-w.events)
+	}
+
+	if len(events) != 2 || !events[0].Online || events[1].Online || events[1].Kite.ID != "2" {
+		t.Errorf("got events %+v, want alice online then offline", events)
+	}
+}
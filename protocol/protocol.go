@@ -6,6 +6,8 @@ package protocol
 import (
 	"encoding/json"
 	"errors"
+	"math"
+	"strconv"
 	"strings"
 
 	"github.com/koding/kite/dnode"
@@ -41,6 +43,11 @@ type Kite struct {
 
 	// os.Hostname() of the Kite.
 	Hostname string `json:"hostname"`
+
+	// Labels are free-form key/value pairs describing the kite, like
+	// "gpu=true" or "tier=canary". They are not part of the kite's key,
+	// kontrol queries can filter kites by them.
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 func (k Kite) String() string {
@@ -66,6 +73,34 @@ func (k *Kite) Query() *KontrolQuery {
 	}
 }
 
+// HasLabels returns true if the kite has all of the labels with the same
+// values.
+func (k *Kite) HasLabels(labels map[string]string) bool {
+	for key, value := range labels {
+		if v, ok := k.Labels[key]; !ok || v != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// WeightLabel is the label that sets the weight of a kite for the weighted
+// kontrol queries, like "kite.weight=3". Kites without the label have the
+// weight 1.
+const WeightLabel = "kite.weight"
+
+// Weight returns the weight of the kite set with the WeightLabel. It returns
+// 1 if the label is not set or it's not a valid weight.
+func (k *Kite) Weight() float64 {
+	w, err := strconv.ParseFloat(k.Labels[WeightLabel], 64)
+	if err != nil || w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+		return 1
+	}
+
+	return w
+}
+
 // Values returns the values of each field in order
 func (k *Kite) Values() []string {
 	return []string{
@@ -127,6 +162,10 @@ type RegisterArgs struct {
 	URL  string `json:"url"`
 	Kite *Kite  `json:"kite,omitempty"`
 	Auth *Auth  `json:"auth,omitempty"`
+
+	// URLs are the additional URLs of the kite by network names, like
+	// {"private": "http://10.0.0.5:3000/kite"}. URL is the default one.
+	URLs map[string]string `json:"urls,omitempty"`
 }
 
 type Auth struct {
@@ -146,6 +185,11 @@ type GetKitesArgs struct {
 	Query         *KontrolQuery   `json:"query"`
 	WatchCallback dnode.Function  `json:"watchCallback"`
 	Who           json.RawMessage `json:"who"`
+
+	// Offset and Limit select a page of the matching kites. Zero Limit
+	// returns all kites after the Offset, unless kontrol limits it.
+	Offset int `json:"offset,omitempty"`
+	Limit  int `json:"limit,omitempty"`
 }
 
 type WhoResult struct {
@@ -154,12 +198,73 @@ type WhoResult struct {
 
 type GetKitesResult struct {
 	Kites []*KiteWithToken `json:"kites"`
+
+	// Total is the number of the kites matching the query, including the
+	// ones that are not in the returned page.
+	Total int `json:"total"`
 }
 
 type KiteWithToken struct {
 	Kite  Kite   `json:"kite"`
 	URL   string `json:"url"`
 	Token string `json:"token"`
+
+	// URLs are the additional URLs of the kite by network names.
+	URLs map[string]string `json:"urls,omitempty"`
+
+	// Load is the last load reported by the kite, nil if it's not known.
+	Load *Load `json:"load,omitempty"`
+
+	// Cluster is the name of the cluster of the kontrol the kite is
+	// registered to. It's only set in the results of the queries with
+	// Clusters.
+	Cluster string `json:"cluster,omitempty"`
+
+	// KontrolKey is the public key of the kontrol that signed the token. It's
+	// only set for the kites of the other clusters, their tokens are signed
+	// by the kontrols of their clusters.
+	KontrolKey string `json:"kontrolKey,omitempty"`
+}
+
+// Blocklist contains the kites and the users blocked in kontrol, which are
+// refused by kontrol and disconnected by the kites. The kite ids are chosen
+// by the kites, kontrol only refuses to register them. The kites are kept
+// out by blocking the ids of their kite keys (the "jti" claim).
+type Blocklist struct {
+	Kites []string `json:"kites"`          // kite ids
+	Keys  []string `json:"keys,omitempty"` // kite key ids
+	Users []string `json:"users"`          // usernames
+}
+
+// Load is the load of a kite, reported to kontrol with the heartbeats.
+type Load struct {
+	// InFlight is the number of the requests that are being handled.
+	InFlight int `json:"inFlight"`
+
+	// CPU is the CPU usage of the kite process since the previous report,
+	// as a fraction of all CPUs of the host, between 0 and 1.
+	CPU float64 `json:"cpu"`
+}
+
+// Less reports whether l is less loaded than o. The kites are compared by
+// their in-flight requests first, and by their CPU usage if they have the
+// same number of requests.
+func (l *Load) Less(o *Load) bool {
+	if l.InFlight != o.InFlight {
+		return l.InFlight < o.InFlight
+	}
+
+	return l.CPU < o.CPU
+}
+
+// URLFor returns the URL of the kite for the given network. It returns the
+// default URL if the network is empty or the kite has no URL for it.
+func (k *KiteWithToken) URLFor(network string) string {
+	if u, ok := k.URLs[network]; ok && network != "" {
+		return u
+	}
+
+	return k.URL
 }
 
 // KiteEvent is the struct that is sent as an argument in watchCallback of
@@ -171,6 +276,9 @@ type KiteEvent struct {
 	// Required to connect when Action == Register
 	URL   string `json:"url,omitempty"`
 	Token string `json:"token,omitempty"`
+
+	// URLs are the additional URLs of the kite by network names.
+	URLs map[string]string `json:"urls,omitempty"`
 }
 
 type KiteAction string
@@ -178,6 +286,9 @@ type KiteAction string
 const (
 	Register   KiteAction = "REGISTER"
 	Deregister KiteAction = "DEREGISTER"
+
+	// Update is sent when a registered kite changes its URL.
+	Update KiteAction = "UPDATE"
 )
 
 // KontrolQuery is a structure of message sent to Kontrol. It is used for
@@ -193,6 +304,36 @@ type KontrolQuery struct {
 	Region      string `json:"region"`
 	Hostname    string `json:"hostname"`
 	ID          string `json:"id"`
+
+	// Labels matches the kites having all of the labels with the same
+	// values. It's applied in addition to the fields above.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Network selects the URLs of the kites registered for the network, like
+	// "private". The kites without a URL for the network are returned with
+	// their default URLs.
+	Network string `json:"network,omitempty"`
+
+	// LeastLoaded orders the kites by their reported loads, the least
+	// loaded one being the first. The kites whose loads are not known are
+	// returned after the others.
+	LeastLoaded bool `json:"leastLoaded,omitempty"`
+
+	// Weighted orders the kites randomly in proportion to their weights set
+	// with the WeightLabel, so the clients picking the first kites spread
+	// the load by the weights.
+	Weighted bool `json:"weighted,omitempty"`
+
+	// PreferRegion returns the kites in the region before the others, like
+	// the region of the caller to keep the latency low. The other orders
+	// are applied within the kites of the region and the others.
+	PreferRegion string `json:"preferRegion,omitempty"`
+
+	// Clusters are the names of the clusters to search the kites in, when
+	// kontrol is federated with the kontrols of other clusters. "*" means
+	// all clusters, including the local one. Only the local cluster is
+	// searched if it's empty.
+	Clusters []string `json:"clusters,omitempty"`
 }
 
 func (k KontrolQuery) Fields() map[string]string {
@@ -0,0 +1,423 @@
+// Package pubsub routes messages between kites by topics. A Broker is added
+// to a kite, which can be any kite including kontrol, and the other kites
+// connect to it with a Client:
+//
+//	pubsub.NewBroker(broker)
+//
+//	c := pubsub.NewClient(k.NewClient(brokerURL))
+//	c.Subscribe("news", func(m *pubsub.Message) {
+//		fmt.Println(m.Payload.MustString())
+//	})
+//	c.Publish("news", "hello")
+//
+// Messages are delivered at most once. They are not stored, the subscribers
+// that are disconnected while a message is published don't receive it. A
+// Client subscribes to its topics again when it reconnects to the broker.
+package pubsub
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/koding/kite"
+	"github.com/koding/kite/dnode"
+)
+
+// Methods of the broker and subscriber kites.
+const (
+	SubscribeMethod   = "pubsub.subscribe"
+	UnsubscribeMethod = "pubsub.unsubscribe"
+	PublishMethod     = "pubsub.publish"
+
+	// MessageMethod is called by the broker to deliver the messages to the
+	// subscribers.
+	MessageMethod = "pubsub.message"
+)
+
+// ErrInvalidTopic is returned when the topic is empty.
+var ErrInvalidTopic = errors.New("pubsub: invalid topic")
+
+// Message is a message published to a topic.
+type Message struct {
+	Topic   string         `json:"topic"`
+	Payload *dnode.Partial `json:"payload"`
+}
+
+// publishArgs is the argument of the publish method.
+type publishArgs struct {
+	Topic   string      `json:"topic"`
+	Payload interface{} `json:"payload"`
+}
+
+// topicArgs is the argument of the subscribe and unsubscribe methods.
+type topicArgs struct {
+	Topic string `json:"topic"`
+}
+
+// Broker routes the published messages to the subscribers of their topics.
+type Broker struct {
+	k *kite.Kite
+
+	// AuthorizeSubscribe, if set, is called before a kite subscribes to a
+	// topic. The subscription is rejected with the returned error. It must
+	// be set before the kite is run.
+	AuthorizeSubscribe func(r *kite.Request, topic string) error
+
+	// AuthorizePublish, if set, is called before a kite publishes a message
+	// to a topic. The message is rejected with the returned error. It must
+	// be set before the kite is run.
+	AuthorizePublish func(r *kite.Request, topic string) error
+
+	mu      sync.Mutex // protects the fields below
+	topics  map[string]map[*kite.Client]struct{}
+	clients map[*kite.Client]map[string]struct{}
+}
+
+// NewBroker returns a new Broker that serves the pubsub methods on k.
+func NewBroker(k *kite.Kite) *Broker {
+	b := &Broker{
+		k:       k,
+		topics:  make(map[string]map[*kite.Client]struct{}),
+		clients: make(map[*kite.Client]map[string]struct{}),
+	}
+
+	k.HandleFunc(SubscribeMethod, b.handleSubscribe)
+	k.HandleFunc(UnsubscribeMethod, b.handleUnsubscribe)
+	k.HandleFunc(PublishMethod, b.handlePublish)
+
+	// the subscriptions of the disconnected kites are made again when they
+	// reconnect
+	k.OnDisconnect(b.removeClient)
+
+	return b
+}
+
+// Publish sends the payload to the subscribers of the topic and returns the
+// number of the subscribers it's sent to.
+func (b *Broker) Publish(topic string, payload interface{}) int {
+	b.mu.Lock()
+	subscribers := make([]*kite.Client, 0, len(b.topics[topic]))
+	for c := range b.topics[topic] {
+		subscribers = append(subscribers, c)
+	}
+	b.mu.Unlock()
+
+	msg := &publishArgs{Topic: topic, Payload: payload}
+
+	var n int
+	for _, c := range subscribers {
+		if err := c.Notify(MessageMethod, msg); err != nil {
+			b.k.Log.Debug("pubsub: cannot deliver message to %s: %s", c.Kite, err)
+			continue
+		}
+		n++
+	}
+
+	return n
+}
+
+// Subscribers returns the number of the subscribers of the topic.
+func (b *Broker) Subscribers(topic string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.topics[topic])
+}
+
+func (b *Broker) handleSubscribe(r *kite.Request) (interface{}, error) {
+	var args topicArgs
+	if err := r.Args.One().Unmarshal(&args); err != nil {
+		return nil, err
+	}
+
+	if args.Topic == "" {
+		return nil, ErrInvalidTopic
+	}
+
+	if b.AuthorizeSubscribe != nil {
+		if err := b.AuthorizeSubscribe(r, args.Topic); err != nil {
+			return nil, err
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.topics[args.Topic] == nil {
+		b.topics[args.Topic] = make(map[*kite.Client]struct{})
+	}
+	b.topics[args.Topic][r.Client] = struct{}{}
+
+	if b.clients[r.Client] == nil {
+		b.clients[r.Client] = make(map[string]struct{})
+	}
+	b.clients[r.Client][args.Topic] = struct{}{}
+
+	return nil, nil
+}
+
+func (b *Broker) handleUnsubscribe(r *kite.Request) (interface{}, error) {
+	var args topicArgs
+	if err := r.Args.One().Unmarshal(&args); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	b.unsubscribe(r.Client, args.Topic)
+	b.mu.Unlock()
+
+	return nil, nil
+}
+
+func (b *Broker) handlePublish(r *kite.Request) (interface{}, error) {
+	var args struct {
+		Topic   string         `json:"topic"`
+		Payload *dnode.Partial `json:"payload"`
+	}
+	if err := r.Args.One().Unmarshal(&args); err != nil {
+		return nil, err
+	}
+
+	if args.Topic == "" {
+		return nil, ErrInvalidTopic
+	}
+
+	if b.AuthorizePublish != nil {
+		if err := b.AuthorizePublish(r, args.Topic); err != nil {
+			return nil, err
+		}
+	}
+
+	return b.Publish(args.Topic, args.Payload), nil
+}
+
+// removeClient removes the subscriptions of the disconnected client.
+func (b *Broker) removeClient(c *kite.Client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for topic := range b.clients[c] {
+		b.unsubscribe(c, topic)
+	}
+}
+
+// unsubscribe removes the subscription of c to the topic. b.mu must be held.
+func (b *Broker) unsubscribe(c *kite.Client, topic string) {
+	delete(b.topics[topic], c)
+	if len(b.topics[topic]) == 0 {
+		delete(b.topics, topic)
+	}
+
+	delete(b.clients[c], topic)
+	if len(b.clients[c]) == 0 {
+		delete(b.clients, c)
+	}
+}
+
+// Client subscribes to and publishes the topics of a broker.
+type Client struct {
+	c *kite.Client
+
+	mu       sync.RWMutex // protects handlers
+	handlers map[string]func(*Message)
+}
+
+// NewClient returns a new Client that uses c to connect to the broker. c
+// can be dialed before or after NewClient is called. The broker delivers
+// the messages by calling MessageMethod on the local kite of c, which is
+// registered by NewClient.
+func NewClient(c *kite.Client) *Client {
+	client := &Client{
+		c:        c,
+		handlers: make(map[string]func(*Message)),
+	}
+
+	addClient(client)
+
+	c.OnConnect(client.resubscribe)
+
+	return client
+}
+
+// Subscribe calls handler with the messages published to the topic. The
+// handler replaces the previous handler of the topic. Handlers may be called
+// concurrently.
+func (c *Client) Subscribe(topic string, handler func(*Message)) error {
+	if topic == "" {
+		return ErrInvalidTopic
+	}
+
+	c.mu.Lock()
+	c.handlers[topic] = handler
+	c.mu.Unlock()
+
+	if _, err := c.c.Tell(SubscribeMethod, &topicArgs{Topic: topic}); err != nil {
+		// don't subscribe to a rejected topic again on reconnect
+		if !kite.IsDeadPeer(err) {
+			c.mu.Lock()
+			delete(c.handlers, topic)
+			c.mu.Unlock()
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Unsubscribe stops receiving the messages of the topic.
+func (c *Client) Unsubscribe(topic string) error {
+	c.mu.Lock()
+	delete(c.handlers, topic)
+	c.mu.Unlock()
+
+	_, err := c.c.Tell(UnsubscribeMethod, &topicArgs{Topic: topic})
+	return err
+}
+
+// Publish sends the payload to the subscribers of the topic. It returns the
+// number of the subscribers it's sent to.
+func (c *Client) Publish(topic string, payload interface{}) (int, error) {
+	if topic == "" {
+		return 0, ErrInvalidTopic
+	}
+
+	result, err := c.c.Tell(PublishMethod, &publishArgs{Topic: topic, Payload: payload})
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	if err := result.Unmarshal(&n); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// Close stops receiving messages. It doesn't close the underlying client.
+// The MessageMethod handler of the local kite is released with its last
+// Client.
+func (c *Client) Close() {
+	c.mu.Lock()
+	c.handlers = make(map[string]func(*Message))
+	c.mu.Unlock()
+
+	removeClient(c)
+}
+
+// resubscribe subscribes to the topics again after a reconnect.
+func (c *Client) resubscribe() {
+	c.mu.RLock()
+	topics := make([]string, 0, len(c.handlers))
+	for topic := range c.handlers {
+		topics = append(topics, topic)
+	}
+	c.mu.RUnlock()
+
+	for _, topic := range topics {
+		if _, err := c.c.Tell(SubscribeMethod, &topicArgs{Topic: topic}); err != nil {
+			c.c.LocalKite.Log.Warning("pubsub: cannot subscribe to %q: %s", topic, err)
+		}
+	}
+}
+
+// deliver calls the handler of the message's topic.
+func (c *Client) deliver(msg *Message) {
+	c.mu.RLock()
+	handler, ok := c.handlers[msg.Topic]
+	c.mu.RUnlock()
+
+	if ok {
+		handler(msg)
+	}
+}
+
+// dispatcher delivers the messages received by a local kite to the Clients
+// the broker has sent them to.
+type dispatcher struct {
+	mu      sync.RWMutex
+	clients map[*kite.Client]*Client
+}
+
+// dispatchers are the dispatchers of the local kites that have Clients. A
+// dispatcher is removed with the last Client of its kite.
+var dispatchers = struct {
+	m map[*kite.Kite]*dispatcher
+	sync.Mutex
+}{m: make(map[*kite.Kite]*dispatcher)}
+
+// addClient adds c to the dispatcher of its local kite. MessageMethod is
+// registered on the kite with a new dispatcher if the kite has none.
+func addClient(c *Client) {
+	k := c.c.LocalKite
+
+	dispatchers.Lock()
+	defer dispatchers.Unlock()
+
+	d, ok := dispatchers.m[k]
+	if !ok {
+		d = &dispatcher{clients: make(map[*kite.Client]*Client)}
+		dispatchers.m[k] = d
+
+		// The broker doesn't authenticate to the subscribers, but only the
+		// brokers the kite is connected with a Client can deliver messages.
+		k.HandleFunc(MessageMethod, d.handleMessage).
+			DisableAuthentication().
+			Authorize(d.authorize)
+	}
+
+	d.mu.Lock()
+	d.clients[c.c] = c
+	d.mu.Unlock()
+}
+
+// removeClient removes c from the dispatcher of its local kite, and the
+// dispatcher if c is its last Client.
+func removeClient(c *Client) {
+	k := c.c.LocalKite
+
+	dispatchers.Lock()
+	defer dispatchers.Unlock()
+
+	d, ok := dispatchers.m[k]
+	if !ok {
+		return
+	}
+
+	d.mu.Lock()
+	delete(d.clients, c.c)
+	empty := len(d.clients) == 0
+	d.mu.Unlock()
+
+	if empty {
+		delete(dispatchers.m, k)
+	}
+}
+
+func (d *dispatcher) authorize(r *kite.Request) error {
+	d.mu.RLock()
+	_, ok := d.clients[r.Client]
+	d.mu.RUnlock()
+
+	if !ok {
+		return errors.New("pubsub: messages are accepted from brokers only")
+	}
+
+	return nil
+}
+
+func (d *dispatcher) handleMessage(r *kite.Request) (interface{}, error) {
+	var msg Message
+	if err := r.Args.One().Unmarshal(&msg); err != nil {
+		return nil, err
+	}
+
+	d.mu.RLock()
+	c, ok := d.clients[r.Client]
+	d.mu.RUnlock()
+
+	if ok {
+		c.deliver(&msg)
+	}
+
+	return nil, nil
+}
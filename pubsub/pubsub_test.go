@@ -0,0 +1,157 @@
+package pubsub
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koding/kite"
+)
+
+func TestPubSub(t *testing.T) {
+	k := kite.New("broker", "0.0.1")
+	k.Config.Port = 10024
+	k.Config.DisableAuthentication = true
+
+	b := NewBroker(k)
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	received := make(chan string, 2)
+
+	var clients []*Client
+	for _, name := range []string{"sub1", "sub2"} {
+		e := kite.New(name, "0.0.1")
+		c := e.NewClient("http://127.0.0.1:10024/kite")
+		if err := c.Dial(); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		name := name
+		client := NewClient(c)
+		err := client.Subscribe("news", func(m *Message) {
+			received <- name + ":" + m.Payload.MustString()
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clients = append(clients, client)
+	}
+
+	if n := b.Subscribers("news"); n != 2 {
+		t.Fatalf("got %d subscribers, want 2", n)
+	}
+
+	n, err := clients[0].Publish("news", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 2 {
+		t.Errorf("message is sent to %d subscribers, want 2", n)
+	}
+
+	got := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case s := <-received:
+			got[s] = true
+		case <-time.After(4 * time.Second):
+			t.Fatal("timeout waiting for the message")
+		}
+	}
+
+	if !got["sub1:hello"] || !got["sub2:hello"] {
+		t.Errorf("got messages %v", got)
+	}
+
+	if err := clients[1].Unsubscribe("news"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := clients[0].Publish("news", "bye"); err != nil || n != 1 {
+		t.Errorf("got %d, %v; want 1 subscriber", n, err)
+	}
+
+	select {
+	case s := <-received:
+		if s != "sub1:bye" {
+			t.Errorf("got message %q, want sub1:bye", s)
+		}
+	case <-time.After(4 * time.Second):
+		t.Fatal("timeout waiting for the message")
+	}
+}
+
+func TestBrokerAuthorize(t *testing.T) {
+	k := kite.New("broker", "0.0.1")
+	k.Config.Port = 10046
+	k.Config.DisableAuthentication = true
+
+	b := NewBroker(k)
+	b.AuthorizeSubscribe = func(r *kite.Request, topic string) error {
+		if strings.HasPrefix(topic, "private.") && r.Username != "admin" {
+			return errors.New("not allowed")
+		}
+		return nil
+	}
+	b.AuthorizePublish = func(r *kite.Request, topic string) error {
+		if topic == "announcements" {
+			return errors.New("not allowed")
+		}
+		return nil
+	}
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := kite.New("sub", "0.0.1")
+	c := e.NewClient("http://127.0.0.1:10046/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	client := NewClient(c)
+
+	if err := client.Subscribe("private.alice", func(*Message) {}); err == nil {
+		t.Error("subscribed to a private topic")
+	}
+
+	if n := b.Subscribers("private.alice"); n != 0 {
+		t.Errorf("got %d subscribers of the private topic, want 0", n)
+	}
+
+	client.mu.RLock()
+	_, ok := client.handlers["private.alice"]
+	client.mu.RUnlock()
+
+	if ok {
+		t.Error("handler of the rejected topic is kept")
+	}
+
+	if err := client.Subscribe("announcements", func(*Message) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Publish("announcements", "hello"); err == nil {
+		t.Error("published to a read-only topic")
+	}
+
+	// the dispatcher of the local kite is removed with its last client
+	client.Close()
+
+	dispatchers.Lock()
+	_, ok = dispatchers.m[e]
+	dispatchers.Unlock()
+
+	if ok {
+		t.Error("dispatcher is not removed after the last client is closed")
+	}
+}
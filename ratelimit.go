@@ -0,0 +1,172 @@
+package kite
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/juju/ratelimit"
+)
+
+// maxRateLimiterKeys is the number of callers a RateLimiter keeps buckets
+// for. Buckets are evicted to make room for the new callers after that.
+const maxRateLimiterKeys = 10000
+
+// RateLimiter limits the rate of the requests of each caller with a token
+// bucket per key. Requests are limited by the remote IP addresses of the
+// callers before they are authenticated, and by the usernames of the
+// authenticated callers too. See Throttle for the meaning of fillInterval
+// and capacity.
+type RateLimiter struct {
+	fillInterval time.Duration
+	capacity     int64
+	maxKeys      int
+
+	buckets   map[string]*ratelimit.Bucket
+	lastSweep time.Time
+	mu        sync.Mutex // protects buckets and lastSweep
+}
+
+// NewRateLimiter returns a new RateLimiter that allows capacity requests at
+// once for each caller and adds a new token to their buckets every
+// fillInterval.
+func NewRateLimiter(fillInterval time.Duration, capacity int64) *RateLimiter {
+	return &RateLimiter{
+		fillInterval: fillInterval,
+		capacity:     capacity,
+		maxKeys:      maxRateLimiterKeys,
+		buckets:      make(map[string]*ratelimit.Bucket),
+		lastSweep:    time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of the key. It returns false if there
+// is no token available.
+func (l *RateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.maxKeys {
+			l.evict()
+		}
+
+		b = ratelimit.NewBucket(l.fillInterval, l.capacity)
+		l.buckets[key] = b
+	}
+
+	return b.TakeAvailable(1) != 0
+}
+
+// sweep removes the buckets that are full, they are the same as a new one.
+// It's run at most once per the time it takes to fill an empty bucket.
+func (l *RateLimiter) sweep() {
+	if time.Since(l.lastSweep) < l.fillInterval*time.Duration(l.capacity) {
+		return
+	}
+
+	l.removeFull()
+	l.lastSweep = time.Now()
+}
+
+func (l *RateLimiter) removeFull() {
+	for key, b := range l.buckets {
+		if b.Available() >= l.capacity {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// evict makes room for a new bucket when there are maxKeys buckets. Full
+// buckets are removed first, an arbitrary one is removed if there is none.
+func (l *RateLimiter) evict() {
+	l.removeFull()
+
+	for key := range l.buckets {
+		if len(l.buckets) < l.maxKeys {
+			return
+		}
+		delete(l.buckets, key)
+	}
+}
+
+// allowAddr checks the request against the bucket of the remote IP address
+// of the caller.
+func (l *RateLimiter) allowAddr(r *Request) *Error {
+	return l.allow("ip:" + r.Client.remoteIP())
+}
+
+// allowUser checks the authenticated request against the bucket of the
+// username of the caller.
+func (l *RateLimiter) allowUser(r *Request) *Error {
+	if r.Username == "" {
+		return nil
+	}
+
+	return l.allow("user:" + r.Username)
+}
+
+func (l *RateLimiter) allow(key string) *Error {
+	if l.Allow(key) {
+		return nil
+	}
+
+	return &Error{
+		Type:    "rateLimited",
+		Message: "Rate limit is exceeded, try again later.",
+	}
+}
+
+// RateLimit limits the rate of the requests to all methods of the kite for
+// each caller. Methods can have their own limits set with Method.RateLimit,
+// a request must be allowed by both.
+func (k *Kite) RateLimit(fillInterval time.Duration, capacity int64) {
+	k.rateLimiter = NewRateLimiter(fillInterval, capacity)
+}
+
+// RateLimit limits the rate of the requests to the method for each caller.
+// Unlike Throttle, which limits the total rate of the method, every caller
+// gets a bucket of its own.
+func (m *Method) RateLimit(fillInterval time.Duration, capacity int64) *Method {
+	m.rateLimiter = NewRateLimiter(fillInterval, capacity)
+	return m
+}
+
+// rateLimit checks the request with the rate limiters of the kite and the
+// method.
+func (c *Client) rateLimit(method *Method, allow func(*RateLimiter, *Request) *Error, r *Request) *Error {
+	for _, limiter := range []*RateLimiter{c.LocalKite.rateLimiter, method.rateLimiter} {
+		if limiter == nil {
+			continue
+		}
+
+		if err := allow(limiter, r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remoteIP returns the IP address of the remote kite, without the port.
+func (c *Client) remoteIP() string {
+	addr := c.RemoteAddr()
+
+	if addr == "" {
+		if r, ok := c.session.(interface {
+			Request() *http.Request
+		}); ok && r.Request() != nil {
+			addr = r.Request().RemoteAddr
+		}
+	}
+
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return addr
+}
@@ -0,0 +1,131 @@
+package kite
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"net/http"
+)
+
+// frameLimit returns the maximum size in bytes of a transport frame that can
+// carry a message of the given size. Messages are JSON encoded again inside
+// the frames, escaping may grow a message up to six times in the worst case.
+func frameLimit(maxMessageSize int) int64 {
+	return int64(maxMessageSize)*6 + 64
+}
+
+// limitHijacker limits the size of the websocket messages read from the
+// hijacked connection, so a message larger than the limit is rejected while
+// it's being received instead of after it's read into memory.
+type limitHijacker struct {
+	http.ResponseWriter
+	limit int64
+}
+
+func (h *limitHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := h.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the websocket handshake is rejected if the client has sent data
+	// before it, there is nothing to limit then
+	if brw.Reader.Buffered() > 0 {
+		return conn, brw, nil
+	}
+
+	limited := &limitConn{Conn: conn, limit: h.limit}
+	return limited, bufio.NewReadWriter(bufio.NewReader(limited), brw.Writer), nil
+}
+
+// limitConn follows the websocket frames read from the connection and fails
+// the read when the payload of a message exceeds the limit. Control frames
+// are not counted.
+type limitConn struct {
+	net.Conn
+	limit int64
+
+	header    []byte // header of the next frame, while it's incomplete
+	remaining int64  // payload bytes left in the current frame
+	message   int64  // payload bytes of the current message
+}
+
+func (c *limitConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+
+	for b := p[:n]; len(b) > 0; {
+		if c.remaining > 0 {
+			skip := int64(len(b))
+			if skip > c.remaining {
+				skip = c.remaining
+			}
+			c.remaining -= skip
+			b = b[skip:]
+			continue
+		}
+
+		c.header = append(c.header, b[0])
+		b = b[1:]
+
+		if !c.parseHeader() {
+			continue
+		}
+
+		if c.limit > 0 && c.message > c.limit {
+			c.Conn.Close()
+			return 0, ErrMessageTooLarge
+		}
+	}
+
+	return n, err
+}
+
+// parseHeader parses the frame header when it's complete and returns true.
+func (c *limitConn) parseHeader() bool {
+	h := c.header
+	if len(h) < 2 {
+		return false
+	}
+
+	size := 2
+	switch h[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+
+	if h[1]&0x80 != 0 { // masking key
+		size += 4
+	}
+
+	if len(h) < size {
+		return false
+	}
+
+	var length int64
+	switch h[1] & 0x7f {
+	case 126:
+		length = int64(binary.BigEndian.Uint16(h[2:]))
+	case 127:
+		length = int64(binary.BigEndian.Uint64(h[2:]) & (1<<63 - 1))
+	default:
+		length = int64(h[1] & 0x7f)
+	}
+
+	switch opcode := h[0] & 0x0f; {
+	case opcode == 0: // continuation of the message
+		c.message += length
+	case opcode < 8: // new message
+		c.message = length
+	}
+
+	c.remaining = length
+	c.header = c.header[:0]
+	return true
+}
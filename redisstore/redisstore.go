@@ -0,0 +1,168 @@
+// Package redisstore provides a kite.SessionStore that keeps the sessions in
+// Redis, so the sessions created by a web application can be used by the
+// kites on other hosts. A session is a key with the prefix and the session
+// id, whose value is the username. The expiry of the key is the expiry of the
+// session. The sessions with an Expires time are not extended beyond it with
+// IdleTimeout, the time is kept as the expiry of another key with the
+// ":expires" suffix.
+//
+//	store := redisstore.New(pool)
+//	k.UseSessionStore(store)
+package redisstore
+
+import (
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/koding/kite"
+)
+
+// DefaultPrefix is the default prefix of the session keys.
+const DefaultPrefix = "kite:session:"
+
+// Store is a kite.SessionStore backed by Redis.
+type Store struct {
+	Pool *redis.Pool
+
+	// Prefix is prepended to the session ids for the keys.
+	Prefix string
+
+	// IdleTimeout sets the expiry of the sessions to the duration every
+	// time they are used, up to the Expires time they are set with. Zero
+	// leaves the expiry of the keys as it is.
+	IdleTimeout time.Duration
+}
+
+var _ kite.SessionStore = (*Store)(nil)
+
+// New returns a new Store that uses the connections from the pool.
+func New(pool *redis.Pool) *Store {
+	return &Store{
+		Pool:   pool,
+		Prefix: DefaultPrefix,
+	}
+}
+
+func (s *Store) key(id string) string {
+	return s.Prefix + id
+}
+
+// deadlineKey is the key that expires at the Expires time the session is set
+// with.
+func (s *Store) deadlineKey(id string) string {
+	return s.key(id) + ":expires"
+}
+
+// Set adds or replaces the session. The key is expired at s.Expires if it's
+// set, or after IdleTimeout if it's sooner.
+func (s *Store) Set(session *kite.Session) error {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	key, deadlineKey := s.key(session.ID), s.deadlineKey(session.ID)
+
+	deadline := time.Until(session.Expires)
+	if !session.Expires.IsZero() && deadline <= 0 {
+		return kite.ErrSessionExpired
+	}
+
+	conn.Send("MULTI")
+
+	switch {
+	case !session.Expires.IsZero():
+		ttl := deadline
+		if s.IdleTimeout > 0 && s.IdleTimeout < ttl {
+			ttl = s.IdleTimeout
+		}
+
+		conn.Send("SET", key, session.Username, "PX", int64(ttl/time.Millisecond))
+		conn.Send("SET", deadlineKey, "", "PX", int64(deadline/time.Millisecond))
+	case s.IdleTimeout > 0:
+		conn.Send("SET", key, session.Username, "PX", int64(s.IdleTimeout/time.Millisecond))
+		conn.Send("DEL", deadlineKey)
+	default:
+		conn.Send("SET", key, session.Username)
+		conn.Send("DEL", deadlineKey)
+	}
+
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// Delete deletes the session with the id, like when the user signs out.
+func (s *Store) Delete(id string) error {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", s.key(id), s.deadlineKey(id))
+	return err
+}
+
+// Get implements kite.SessionStore.
+func (s *Store) Get(id string) (*kite.Session, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("GET", s.key(id))
+	conn.Send("PTTL", s.key(id))
+
+	values, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+
+	username, err := redis.String(values[0], nil)
+	if err == redis.ErrNil {
+		return nil, kite.ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	session := &kite.Session{
+		ID:       id,
+		Username: username,
+	}
+
+	// negative if the key doesn't expire
+	if ttl, err := redis.Int64(values[1], nil); err == nil && ttl > 0 {
+		session.Expires = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+	}
+
+	return session, nil
+}
+
+// Validate implements kite.SessionStore. Redis deletes the expired sessions,
+// so the sessions found by Get are valid.
+func (s *Store) Validate(session *kite.Session) error {
+	return nil
+}
+
+// touchScript sets the expiry of the session key KEYS[1] to ARGV[1]
+// milliseconds, or to the expiry of the deadline key KEYS[2] if it's sooner.
+var touchScript = redis.NewScript(2, `
+local ttl = tonumber(ARGV[1])
+local deadline = redis.call("PTTL", KEYS[2])
+if deadline >= 0 and deadline < ttl then
+	ttl = deadline
+end
+if ttl <= 0 then
+	return redis.call("DEL", KEYS[1])
+end
+return redis.call("PEXPIRE", KEYS[1], ttl)
+`)
+
+// Touch implements kite.SessionStore. It sets the expiry of the session to
+// IdleTimeout, up to the Expires time the session is set with.
+func (s *Store) Touch(session *kite.Session) error {
+	if s.IdleTimeout == 0 {
+		return nil
+	}
+
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	_, err := touchScript.Do(conn, s.key(session.ID), s.deadlineKey(session.ID), int64(s.IdleTimeout/time.Millisecond))
+	return err
+}
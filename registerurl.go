@@ -16,11 +16,25 @@ const publicEcho = "http://echoip.com"
 // method to get a Registration URL that can be passed to Kontrol (via the
 // methods Register(), RegisterToProxy(), etc.) It needs to be called after all
 // configurations are done (like TLS, Port,etc.). If local is true a local IP
-// is used, otherwise a public IP is being used.
+// is used, otherwise a public IP is being used. The hostname given to
+// UseAutocert is used instead of the public IP if it's set.
 func (k *Kite) RegisterURL(local bool) *url.URL {
 	var ip net.IP
 	var err error
 
+	scheme := "http"
+	if k.TLSConfig != nil {
+		scheme = "https"
+	}
+
+	if !local && k.publicHostname != "" {
+		return &url.URL{
+			Scheme: scheme,
+			Host:   net.JoinHostPort(k.publicHostname, strconv.Itoa(k.Config.Port)),
+			Path:   "/" + k.name + "-" + k.version + "/kite",
+		}
+	}
+
 	if local {
 		ip, err = localIP()
 		if err != nil {
@@ -33,11 +47,6 @@ func (k *Kite) RegisterURL(local bool) *url.URL {
 		}
 	}
 
-	scheme := "http"
-	if k.TLSConfig != nil {
-		scheme = "https"
-	}
-
 	return &url.URL{
 		Scheme: scheme,
 		Host:   ip.String() + ":" + strconv.Itoa(k.Config.Port),
@@ -1,10 +1,12 @@
 package kite
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"runtime/debug"
 	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/koding/cache"
@@ -32,6 +34,19 @@ type Request struct {
 	// This is authenticated and validated if authentication is enabled.
 	Username string
 
+	// Scopes are the scopes granted to the caller in the token it's
+	// authenticated with. It's empty for other authentication types.
+	Scopes []string
+
+	// Roles are the roles granted to the caller in the token it's
+	// authenticated with. It's empty for other authentication types.
+	Roles []string
+
+	// KiteKeyID is the id of the kite key the caller is authenticated with,
+	// directly or with a token issued by kontrol for it. It's empty for
+	// other authentication types.
+	KiteKeyID string
+
 	// Auth stores the authentication information for the incoming request and
 	// the type of authentication. This is not used when authentication is disabled
 	Auth *Auth
@@ -41,6 +56,41 @@ type Request struct {
 	// chain. This is useful with PreHandle and PostHandle handlers to pass
 	// data between handlers.
 	Context cache.Cache
+
+	// Stream is used to send incremental results to the caller before the
+	// handler returns. It's only usable if the method is called with
+	// Client.Stream, otherwise Stream.Send returns ErrNoStream.
+	Stream *Stream
+
+	// Binary is the binary payload sent with the call by Client.TellBinary.
+	// It's nil for the other calls.
+	Binary []byte
+
+	// Deadline is the time the caller stops waiting for the response. It's
+	// zero if the caller has no timeout.
+	Deadline time.Time
+
+	// Ctx is done when the Deadline is exceeded or the response is sent.
+	// The calls made in the handler goroutine with the clients of the kite
+	// get the remaining time of the request automatically, so chained calls
+	// don't wait longer than the original caller. Passing it to
+	// Client.TellWithContext or GoWithContext does the same in the other
+	// goroutines.
+	Ctx context.Context
+
+	// version is the version of the method requested by the caller. warning
+	// is sent to the caller with the response if the method is deprecated.
+	version int
+	warning string
+
+	// idempotencyKey is sent by the clients that resume their calls after
+	// a reconnect.
+	idempotencyKey string
+
+	// binaryID is the id of the uploaded binary payload, acceptBinary is
+	// set if the caller can download a Binary result.
+	binaryID     string
+	acceptBinary bool
 }
 
 // Response is the type of the object that is returned from request handlers
@@ -48,6 +98,9 @@ type Request struct {
 type Response struct {
 	Error  *Error      `json:"error" dnode:"-"`
 	Result interface{} `json:"result"`
+
+	// Warning is sent to the callers of deprecated methods.
+	Warning string `json:"warning,omitempty"`
 }
 
 // runMethod is called when a method is received from remote Kite.
@@ -61,16 +114,58 @@ func (c *Client) runMethod(method *Method, args *dnode.Partial) {
 	// functions like MustString(), MustSlice()... without the fear of panic.
 	defer func() {
 		if r := recover(); r != nil {
-			debug.PrintStack()
-			kiteErr := createError(r)
-			c.LocalKite.Log.Error(kiteErr.Error()) // let's log it too :)
+			stack := debug.Stack()
+			if p, ok := r.(*handlerPanic); ok {
+				r, stack = p.value, p.stack
+			}
+
+			kiteErr := c.LocalKite.recoverPanic(request, r, stack)
+			if request != nil {
+				request.Log().Error(kiteErr.Error()) // let's log it too :)
+			} else {
+				c.LocalKite.Log.Error(kiteErr.Error())
+			}
 			callFunc(nil, kiteErr)
 		}
 	}()
 
 	// The request that will be constructed from incoming dnode message.
 	request, callFunc = c.newRequest(method.name, args)
+
+	// The method is dispatched to the version requested by the caller.
+	if request.version != 0 {
+		m, err := c.LocalKite.methodVersion(method.name, request.version)
+		if err != nil {
+			callFunc(nil, err)
+			return
+		}
+		method = m
+	}
+	request.warning = method.deprecated
+
+	// Do not start new requests when the kite is shutting down.
+	if !c.LocalKite.trackRequest() {
+		callFunc(nil, &Error{
+			Type:    "shutdown",
+			Message: "Kite is shutting down",
+		})
+		return
+	}
+	defer c.LocalKite.doneRequest()
+
+	// Limit the rate of the requests of each remote address before the
+	// authentication, which may be expensive.
+	if err := c.rateLimit(method, (*RateLimiter).allowAddr, request); err != nil {
+		callFunc(nil, err)
+		return
+	}
+
 	if method.authenticate {
+		if err := method.checkAuthType(request); err != nil {
+			callFunc(nil, err)
+			return
+		}
+
 		if err := request.authenticate(); err != nil {
 			callFunc(nil, err)
 			return
@@ -81,6 +176,39 @@ func (c *Client) runMethod(method *Method, args *dnode.Partial) {
 		request.Username = request.Client.Kite.Username
 	}
 
+	if err := method.authorize(request); err != nil {
+		callFunc(nil, err)
+		return
+	}
+
+	// A call that is sent again after a reconnect is run only once. The
+	// duplicates get the result of the first one. It's done after the
+	// authentication, so the keys are scoped to the authenticated user and
+	// the rejected calls can't take the keys of the others.
+	if request.idempotencyKey != "" {
+		key := request.Username + "/" + request.Client.Kite.ID + "/" + request.idempotencyKey
+
+		call, first := c.LocalKite.idempotent.start(key)
+		if !first {
+			<-call.done
+			callFunc(call.result, call.err)
+			return
+		}
+
+		respond := callFunc
+		callFunc = func(result interface{}, err *Error) {
+			c.LocalKite.idempotent.finish(key, call, result, err)
+			respond(result, err)
+		}
+	}
+
+	// The payload is claimed after the authentication, so a call that is
+	// sent again with a new token can still claim it.
+	if err := request.claimBinary(); err != nil {
+		callFunc(nil, err)
+		return
+	}
+
 	method.mu.Lock()
 	if !method.initialized {
 		method.preHandlers = append(method.preHandlers, c.LocalKite.preHandlers...)
@@ -102,10 +230,28 @@ func (c *Client) runMethod(method *Method, args *dnode.Partial) {
 		return
 	}
 
+	// Limit the rate of the requests of each authenticated user too.
+	if method.authenticate {
+		if err := c.rateLimit(method, (*RateLimiter).allowUser, request); err != nil {
+			callFunc(nil, err)
+			return
+		}
+	}
+
 	// Call the handler functions.
-	result, err := method.ServeKite(request)
+	result, err := c.LocalKite.serveMethodTimeout(method, request)
+
+	callFunc(request.binaryResult(result), createError(err))
+}
 
-	callFunc(result, createError(err))
+// Log returns the logger of the local kite with the request's method, caller
+// kite and username attached as fields.
+func (r *Request) Log() Logger {
+	return WithFields(r.LocalKite.Log, Fields{
+		"method":   r.Method,
+		"kite":     r.Client.Kite.String(),
+		"username": r.Username,
+	})
 }
 
 // runCallback is called when a callback method call is received from remote Kite.
@@ -117,6 +263,12 @@ func (c *Client) runCallback(callback func(*dnode.Partial), args *dnode.Partial)
 		}
 	}()
 
+	// Callbacks are still run when the kite is shutting down because they
+	// may be the responses the running handlers are waiting for.
+	if c.LocalKite.trackRequest() {
+		defer c.LocalKite.doneRequest()
+	}
+
 	// Call the callback function.
 	callback(args)
 }
@@ -130,7 +282,9 @@ func (c *Client) newRequest(method string, args *dnode.Partial) (*Request, func(
 	// Notify the handlers registered with Kite.OnFirstRequest().
 	if _, ok := c.session.(*sockjsclient.WebsocketSession); !ok {
 		c.firstRequestHandlersNotified.Do(func() {
+			c.muProt.Lock()
 			c.Kite = options.Kite
+			c.muProt.Unlock()
 			c.LocalKite.callOnFirstRequestHandlers(c)
 		})
 	}
@@ -142,18 +296,41 @@ func (c *Client) newRequest(method string, args *dnode.Partial) (*Request, func(
 		Client:    c,
 		Auth:      options.Auth,
 		Context:   cache.NewMemory(),
+		Stream:    &Stream{fn: options.StreamCallback},
+
+		idempotencyKey: options.IdempotencyKey,
+		binaryID:       options.BinaryID,
+		acceptBinary:   options.AcceptBinary,
+		version:        options.Version,
+	}
+
+	var cancel context.CancelFunc
+	if options.Timeout > 0 {
+		request.Deadline = time.Now().Add(time.Duration(options.Timeout) * time.Millisecond)
+		request.Ctx, cancel = context.WithDeadline(context.Background(), request.Deadline)
+	} else {
+		request.Ctx, cancel = context.WithCancel(context.Background())
 	}
 
 	// Call response callback function, send back our response
 	callFunc := func(result interface{}, err *Error) {
+		cancel()
+
+		// The end of the stream must be sent before the response, no chunks
+		// can be sent after the handler has returned.
+		if err := request.Stream.close(); err != nil {
+			c.LocalKite.Log.Error(err.Error())
+		}
+
 		if options.ResponseCallback.Caller == nil {
 			return
 		}
 
 		// Only argument to the callback.
 		response := Response{
-			Result: result,
-			Error:  err,
+			Result:  result,
+			Error:   err,
+			Warning: request.warning,
 		}
 
 		if err := options.ResponseCallback.Call(response); err != nil {
@@ -205,12 +382,27 @@ func (r *Request) authenticate() *Error {
 	// Replace username of the remote Kite with the username that client send
 	// us. This prevents a Kite to impersonate someone else's Kite.
 	r.Client.SetUsername(r.Username)
+
+	r.Client.muProt.Lock()
+	r.Client.kiteKeyID = r.KiteKeyID
+	r.Client.muProt.Unlock()
+
+	if r.LocalKite.isClientBlocked(r.Client) {
+		return &Error{
+			Type:    "authenticationError",
+			Message: ErrBlocked.Error(),
+		}
+	}
+
 	return nil
 }
 
 // AuthenticateFromToken is the default Authenticator for Kite.
 func (k *Kite) AuthenticateFromToken(r *Request) error {
 	token, err := jwt.Parse(r.Auth.Key, r.LocalKite.RSAKey)
+	if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors&jwt.ValidationErrorExpired != 0 {
+		return ErrTokenExpired
+	}
 	if err != nil {
 		return err
 	}
@@ -227,6 +419,10 @@ func (k *Kite) AuthenticateFromToken(r *Request) error {
 		}
 	}
 
+	if jti, ok := token.Claims["jti"].(string); ok && k.IsTokenRevoked(jti) {
+		return ErrTokenRevoked
+	}
+
 	// We don't check for exp and nbf claims here because jwt-go package
 	// already checks them.
 	username, ok := token.Claims["sub"].(string)
@@ -236,6 +432,9 @@ func (k *Kite) AuthenticateFromToken(r *Request) error {
 
 	// replace the requester username so we reflect the validated
 	r.Username = username
+	r.Scopes = scopesFromClaims(token.Claims)
+	r.Roles = rolesFromClaims(token.Claims)
+	r.KiteKeyID, _ = token.Claims["kiteKeyID"].(string)
 
 	return nil
 }
@@ -280,6 +479,8 @@ func (k *Kite) AuthenticateFromKiteKey(r *Request) error {
 		r.Username = username
 	}
 
+	r.KiteKeyID, _ = token.Claims["jti"].(string)
+
 	return nil
 }
 
@@ -287,20 +488,29 @@ func (k *Kite) AuthenticateFromKiteKey(r *Request) error {
 // returns the authenticated username. It's the same as AuthenticateFromKiteKey
 // but can be used without the need for a *kite.Request.
 func (k *Kite) AuthenticateSimpleKiteKey(key string) (string, error) {
+	username, _, err := k.AuthenticateKiteKey(key)
+	return username, err
+}
+
+// AuthenticateKiteKey is like AuthenticateSimpleKiteKey, but it returns the
+// id of the kite key too.
+func (k *Kite) AuthenticateKiteKey(key string) (username, keyID string, err error) {
 	token, err := jwt.Parse(key, kitekey.GetKontrolKey)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	if !token.Valid {
-		return "", errors.New("Invalid signature in token")
+		return "", "", errors.New("Invalid signature in token")
 	}
 
 	username, ok := token.Claims["sub"].(string)
 	if !ok {
-		return "", errors.New("Username is not present in token")
+		return "", "", errors.New("Username is not present in token")
 	}
 
+	keyID, _ = token.Claims["jti"].(string)
+
 	// return authenticated username
-	return username, nil
+	return username, keyID, nil
 }
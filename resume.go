@@ -0,0 +1,99 @@
+package kite
+
+import (
+	"sync"
+	"time"
+)
+
+// idempotencyTTL is the duration the results of the calls with an idempotency
+// key are kept after they have finished. A call that is sent again by a
+// resuming client within this duration is answered from the kept result.
+const idempotencyTTL = 5 * time.Minute
+
+// idempotentCall is a method call with an idempotency key. The calls with the
+// same key wait for the first one to finish and get the same result.
+type idempotentCall struct {
+	done   chan struct{}
+	result interface{}
+	err    *Error
+}
+
+// idempotentCalls keeps the calls with idempotency keys that are running or
+// recently finished.
+type idempotentCalls struct {
+	calls map[string]*idempotentCall
+	mu    sync.Mutex
+}
+
+// start returns the call for the key. If a call with the same key is running
+// or recently finished, it returns that call and false.
+func (i *idempotentCalls) start(key string) (*idempotentCall, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.calls == nil {
+		i.calls = make(map[string]*idempotentCall)
+	}
+
+	if call, ok := i.calls[key]; ok {
+		return call, false
+	}
+
+	call := &idempotentCall{done: make(chan struct{})}
+	i.calls[key] = call
+	return call, true
+}
+
+// finish saves the result of the call and forgets it after idempotencyTTL.
+func (i *idempotentCalls) finish(key string, call *idempotentCall, result interface{}, err *Error) {
+	call.result = result
+	call.err = err
+	close(call.done)
+
+	time.AfterFunc(idempotencyTTL, func() {
+		i.mu.Lock()
+		delete(i.calls, key)
+		i.mu.Unlock()
+	})
+}
+
+// connectNotify returns a channel that is closed when the client connects
+// the next time.
+func (c *Client) connectNotify() <-chan struct{} {
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+	return c.nextConnect
+}
+
+// disconnectNotify returns the channel that receives a value when the client
+// disconnects.
+func (c *Client) disconnectNotify() <-chan struct{} {
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+	return c.disconnect
+}
+
+// notifyDisconnect notifies a waiter of disconnectNotify. If renew is true,
+// the channel is replaced, so the waiters that are selecting it later are
+// not notified.
+func (c *Client) notifyDisconnect(renew bool) {
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+
+	select {
+	case c.disconnect <- struct{}{}:
+	default:
+	}
+
+	if renew {
+		c.disconnect = make(chan struct{}, 1)
+	}
+}
+
+// notifyConnect notifies the waiters of connectNotify.
+func (c *Client) notifyConnect() {
+	c.connectMu.Lock()
+	close(c.nextConnect)
+	c.nextConnect = make(chan struct{})
+	c.connectMu.Unlock()
+}
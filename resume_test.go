@@ -0,0 +1,150 @@
+package kite
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/koding/kite/dnode"
+)
+
+func TestResumeCalls(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10038
+
+	var calls int32
+	started := make(chan *Client, 1)
+	release := make(chan struct{})
+	k.HandleFunc("slow", func(r *Request) (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			started <- r.Client
+		}
+		<-release
+		return "done", nil
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	c := New("exp", "0.0.1").NewClient("http://127.0.0.1:10038/kite")
+	c.ResumeCalls = true
+	connected, err := c.DialForever()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-connected
+	defer c.Close()
+
+	reconnected := make(chan struct{}, 1)
+	c.OnConnect(func() { reconnected <- struct{}{} })
+
+	call := c.GoWithTimeout("slow", 10*time.Second)
+
+	// drop the connection while the call is running
+	(<-started).Close()
+
+	select {
+	case <-reconnected:
+	case <-time.After(4 * time.Second):
+		t.Fatal("client is not reconnected")
+	}
+
+	close(release)
+
+	select {
+	case resp := <-call:
+		if resp.Err != nil {
+			t.Fatalf("resumed call failed: %s", resp.Err)
+		}
+
+		if s := resp.Result.MustString(); s != "done" {
+			t.Errorf("got result %q, want: done", s)
+		}
+	case <-time.After(4 * time.Second):
+		t.Fatal("resumed call is not answered")
+	}
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("handler is called %d times, want: 1", n)
+	}
+}
+
+// tellWithKey calls the method with the idempotency key and returns the
+// response.
+func tellWithKey(c *Client, method, key string) *response {
+	done := make(chan *response, 1)
+	remove := make(chan uint64, 1)
+
+	cb := c.makeResponseCallback(done, remove, method, nil)
+	args := c.wrapMethodArgs(nil, cb, dnode.Function{}, key)
+
+	callbacks, err := c.marshalAndSend(method, args)
+	if err != nil {
+		return &response{Err: err}
+	}
+	sendCallbackID(callbacks, remove)
+
+	select {
+	case resp := <-done:
+		return resp
+	case <-time.After(4 * time.Second):
+		return &response{Err: &Error{Type: "timeout"}}
+	}
+}
+
+func TestIdempotencyKeyAuthorization(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10039
+
+	var calls int32
+	k.HandleFunc("foo", func(r *Request) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return "handle", nil
+	}).AllowUsers("alice")
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	client := func(username string) *Client {
+		e := New("exp", "0.0.1")
+		e.Config.Username = username
+		e.Id = "same-id" // the kite id is chosen by the caller
+
+		c := e.NewClient("http://127.0.0.1:10039/kite")
+		if err := c.Dial(); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	bob := client("bob")
+	defer bob.Close()
+
+	alice := client("alice")
+	defer alice.Close()
+
+	// the rejected call must not take the key of alice
+	resp := tellWithKey(bob, "foo", "key")
+	if err, ok := resp.Err.(*Error); !ok || err.Type != "authorizationError" {
+		t.Fatalf("got error %v, want: authorizationError", resp.Err)
+	}
+
+	for i := 0; i < 2; i++ {
+		resp = tellWithKey(alice, "foo", "key")
+		if resp.Err != nil {
+			t.Fatalf("call %d: %s", i, resp.Err)
+		}
+
+		if s := resp.Result.MustString(); s != "handle" {
+			t.Errorf("call %d: got %q, want: handle", i, s)
+		}
+	}
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("handler is called %d times, want: 1", n)
+	}
+}
@@ -0,0 +1,129 @@
+package reverseproxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultHealthCheckTimeout is used when Proxy.HealthCheckTimeout is zero.
+const DefaultHealthCheckTimeout = 5 * time.Second
+
+// runHealthChecks checks the backend kites every interval until the proxy is
+// closed. Backends that don't respond are not proxied to until they respond
+// again.
+func (p *Proxy) runHealthChecks(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.checkBackends()
+		case <-p.closeC:
+			return
+		}
+	}
+}
+
+// checkBackends sends a request to the SockJS endpoint of every registered
+// backend and marks the ones that fail as unhealthy.
+func (p *Proxy) checkBackends() {
+	timeout := p.HealthCheckTimeout
+	if timeout == 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+
+	client := &http.Client{Timeout: timeout}
+
+	p.kitesMu.Lock()
+	backends := make(map[string]string, len(p.kites))
+	for id, u := range p.kites {
+		backends[id] = u.String()
+	}
+	p.kitesMu.Unlock()
+
+	for id, u := range backends {
+		healthy := true
+
+		resp, err := client.Get(u)
+		if err != nil {
+			healthy = false
+		} else {
+			resp.Body.Close()
+			healthy = resp.StatusCode < 500
+		}
+
+		p.kitesMu.Lock()
+		if _, ok := p.kites[id]; ok {
+			if !healthy && !p.unhealthy[id] {
+				p.Kite.Log.Warning("[%s] Backend kite is unhealthy: %s", id, u)
+			}
+			if healthy && p.unhealthy[id] {
+				p.Kite.Log.Info("[%s] Backend kite is healthy again: %s", id, u)
+			}
+
+			if healthy {
+				delete(p.unhealthy, id)
+			} else {
+				p.unhealthy[id] = true
+			}
+		}
+		p.kitesMu.Unlock()
+	}
+}
+
+// available returns true if the request is for a registered and healthy
+// backend kite.
+func (p *Proxy) available(req *http.Request) bool {
+	paths := strings.Split(strings.TrimPrefix(req.URL.Path, "/proxy/"), "/")
+	kiteID := paths[0]
+
+	p.kitesMu.Lock()
+	defer p.kitesMu.Unlock()
+
+	_, ok := p.kites[kiteID]
+	return ok && !p.unhealthy[kiteID]
+}
+
+// trackConn marks the start of a proxied connection. It returns false if the
+// proxy is shutting down, in which case the caller must not call
+// conns.Done().
+func (p *Proxy) trackConn() bool {
+	p.drainMu.RLock()
+	defer p.drainMu.RUnlock()
+
+	if p.draining {
+		return false
+	}
+
+	p.conns.Add(1)
+	return true
+}
+
+// Shutdown stops accepting new connections and waits for the proxied
+// connections to finish. If ctx is done before that, ctx.Err() is returned
+// and the remaining connections are left to the backends.
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.drainMu.Lock()
+	p.draining = true
+	p.drainMu.Unlock()
+
+	if p.listener != nil {
+		p.listener.Close()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		p.conns.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
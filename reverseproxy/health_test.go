@@ -0,0 +1,219 @@
+package reverseproxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/koding/kite/config"
+)
+
+// addBackend registers the backend URL for the kite ID like the "register"
+// method does.
+func addBackend(p *Proxy, id, backendURL string) {
+	u, err := url.Parse(backendURL)
+	if err != nil {
+		panic(err)
+	}
+
+	p.kitesMu.Lock()
+	p.kites[id] = *u
+	p.kitesMu.Unlock()
+}
+
+func proxyRequest(id string) *http.Request {
+	return httptest.NewRequest("GET", "/proxy/"+id+"/kite/info", nil)
+}
+
+func TestHealthCheck(t *testing.T) {
+	p := New(config.New())
+
+	var status int32 = http.StatusInternalServerError
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer flaky.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+
+	gone := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	gone.Close()
+
+	addBackend(p, "healthy", healthy.URL+"/kite")
+	addBackend(p, "flaky", flaky.URL+"/kite")
+	addBackend(p, "gone", gone.URL+"/kite")
+
+	p.checkBackends()
+
+	for id, want := range map[string]bool{
+		"healthy":    true,
+		"flaky":      false,
+		"gone":       false,
+		"unknownkey": false,
+	} {
+		if got := p.available(proxyRequest(id)); got != want {
+			t.Errorf("%s: got available %t, want: %t", id, got, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, proxyRequest("flaky"))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d for an unhealthy kite, want: %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	// the backends are proxied to again after they recover
+	atomic.StoreInt32(&status, http.StatusOK)
+	p.checkBackends()
+
+	if !p.available(proxyRequest("flaky")) {
+		t.Error("recovered kite is not available")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	p := New(config.New())
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	defer backend.Close()
+
+	addBackend(p, "slow", backend.URL+"/kite")
+
+	proxied := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, proxyRequest("slow"))
+		proxied <- rec.Code
+	}()
+
+	<-started
+
+	// the proxied connection is waited for
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := p.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v while a connection is proxied, want: %v", err, context.DeadlineExceeded)
+	}
+
+	// the new connections are refused while draining
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, proxyRequest("slow"))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d while shutting down, want: %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	close(release)
+
+	if code := <-proxied; code != http.StatusOK {
+		t.Errorf("got status %d for the proxied connection, want: %d", code, http.StatusOK)
+	}
+
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestCert writes a self-signed certificate for localhost and its key to
+// the directory.
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string, pool *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pool = x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	return certFile, keyFile, pool
+}
+
+func TestListenAndServeTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reverseproxy-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile, pool := writeTestCert(t, dir)
+
+	conf := config.New()
+	conf.IP = "127.0.0.1"
+	conf.Port = 4998
+
+	p := New(conf)
+	go p.ListenAndServeTLS(certFile, keyFile)
+	<-p.ReadyNotify()
+	defer p.Shutdown(context.Background())
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		Timeout:   4 * time.Second,
+	}
+
+	// the TLS is terminated by the proxy, the kite is unknown
+	resp, err := client.Get("https://localhost:4998/proxy/unknown/kite/info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want: %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+}
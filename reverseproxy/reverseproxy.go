@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/koding/kite"
@@ -32,8 +33,22 @@ type Proxy struct {
 	closeC chan bool // To signal when kite is closed with Close()
 
 	// Holds registered kites. Keys are kite IDs.
-	kites   map[string]url.URL
-	kitesMu sync.Mutex
+	kites     map[string]url.URL
+	unhealthy map[string]bool // kites failing the health check
+	kitesMu   sync.Mutex      // protects kites and unhealthy
+
+	// HealthCheckInterval is the interval the backend kites are checked.
+	// Unhealthy kites are not proxied to. Zero value disables health checks.
+	HealthCheckInterval time.Duration
+
+	// HealthCheckTimeout is the timeout of a single health check. If zero,
+	// DefaultHealthCheckTimeout is used.
+	HealthCheckTimeout time.Duration
+
+	// For draining the proxied connections on Shutdown.
+	conns    sync.WaitGroup
+	draining bool
+	drainMu  sync.RWMutex // protects draining
 
 	// muxer for proxy
 	mux            *http.ServeMux
@@ -51,11 +66,12 @@ func New(conf *config.Config) *Proxy {
 	k.Config = conf
 
 	p := &Proxy{
-		Kite:   k,
-		kites:  make(map[string]url.URL),
-		readyC: make(chan bool),
-		closeC: make(chan bool),
-		mux:    http.NewServeMux(),
+		Kite:      k,
+		kites:     make(map[string]url.URL),
+		unhealthy: make(map[string]bool),
+		readyC:    make(chan bool),
+		closeC:    make(chan bool),
+		mux:       http.NewServeMux(),
 	}
 
 	// third part kites are going to use this to register themself to
@@ -86,7 +102,10 @@ func New(conf *config.Config) *Proxy {
 	// OnDisconnect is called whenever a kite is disconnected from us.
 	k.OnDisconnect(func(r *kite.Client) {
 		k.Log.Info("Removing kite Id '%s' from proxy. It's disconnected", r.Kite.ID)
+		p.kitesMu.Lock()
 		delete(p.kites, r.Kite.ID)
+		delete(p.unhealthy, r.Kite.ID)
+		p.kitesMu.Unlock()
 	})
 
 	return p
@@ -94,6 +113,17 @@ func New(conf *config.Config) *Proxy {
 
 // ServeHTTP implements the http.Handler interface.
 func (p *Proxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if !p.trackConn() {
+		http.Error(rw, "proxy is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer p.conns.Done()
+
+	if !p.available(req) {
+		http.Error(rw, "kite is not available", http.StatusServiceUnavailable)
+		return
+	}
+
 	if isWebsocket(req) {
 		// we don't use https explicitly, ssl termination is done here
 		req.URL.Scheme = "ws"
@@ -128,7 +158,10 @@ func (p *Proxy) handleRegister(r *kite.Request) (interface{}, error) {
 		return nil, err
 	}
 
+	p.kitesMu.Lock()
 	p.kites[r.Client.ID] = *kiteUrl
+	delete(p.unhealthy, r.Client.ID)
+	p.kitesMu.Unlock()
 
 	proxyURL := url.URL{
 		Scheme: p.Scheme,
@@ -203,6 +236,10 @@ func (p *Proxy) ListenAndServe() error {
 
 	close(p.readyC)
 
+	if p.HealthCheckInterval > 0 {
+		go p.runHealthChecks(p.HealthCheckInterval)
+	}
+
 	server := http.Server{
 		Handler: p.mux,
 	}
@@ -228,10 +265,14 @@ func (p *Proxy) ListenAndServeTLS(certFile, keyFile string) error {
 	}
 	p.Kite.Log.Info("Listening on: %s", p.listener.Addr().String())
 
+	p.listener = tls.NewListener(p.listener, tlsConfig)
+
 	// now we are ready
 	close(p.readyC)
 
-	p.listener = tls.NewListener(p.listener, tlsConfig)
+	if p.HealthCheckInterval > 0 {
+		go p.runHealthChecks(p.HealthCheckInterval)
+	}
 
 	server := &http.Server{
 		Handler:   p.mux,
@@ -0,0 +1,96 @@
+package kite
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrTokenRevoked is returned from Kite.AuthenticateFromToken when the token
+// is revoked in kontrol.
+var ErrTokenRevoked = errors.New("token is revoked")
+
+// IsTokenRevoked returns true if the token with the id (the "jti" claim) is
+// in the revocation list of the kite.
+func (k *Kite) IsTokenRevoked(jti string) bool {
+	k.revokedMu.RLock()
+	defer k.revokedMu.RUnlock()
+
+	_, ok := k.revoked[jti]
+	return ok
+}
+
+// SetRevokedTokens replaces the revocation list of the kite with the token
+// ids. Tokens with these ids are rejected by AuthenticateFromToken.
+func (k *Kite) SetRevokedTokens(jtis []string) {
+	revoked := make(map[string]struct{}, len(jtis))
+	for _, jti := range jtis {
+		revoked[jti] = struct{}{}
+	}
+
+	k.revokedMu.Lock()
+	k.revoked = revoked
+	k.revokedMu.Unlock()
+}
+
+// RevokeToken revokes the token with the id in kontrol. Only the owner of
+// kontrol can revoke tokens. Kites reject the token after they fetch the
+// revocation list again.
+func (k *Kite) RevokeToken(jti string) error {
+	if err := k.SetupKontrolClient(); err != nil {
+		return err
+	}
+
+	<-k.kontrol.readyConnected
+
+	_, err := k.kontrol.TellWithTimeout("revokeToken", 4*time.Second, jti)
+	return err
+}
+
+// UpdateRevokedTokens fetches the revocation list from kontrol and replaces
+// the revocation list of the kite with it.
+func (k *Kite) UpdateRevokedTokens() error {
+	if err := k.SetupKontrolClient(); err != nil {
+		return err
+	}
+
+	<-k.kontrol.readyConnected
+
+	result, err := k.kontrol.TellWithTimeout("getRevokedTokens", 4*time.Second)
+	if err != nil {
+		return err
+	}
+
+	var jtis []string
+	if err := result.Unmarshal(&jtis); err != nil {
+		return err
+	}
+
+	k.SetRevokedTokens(jtis)
+	return nil
+}
+
+// SyncRevokedTokens fetches the revocation list and the blocklist from
+// kontrol every interval until the kite is closed. It does not block. The
+// blocklist is pushed by kontrol too, fetching it covers the changes missed
+// while the kite is disconnected.
+func (k *Kite) SyncRevokedTokens(interval time.Duration) {
+	go func() {
+		for {
+			if err := k.UpdateRevokedTokens(); err != nil {
+				k.Log.Error("Cannot update revoked tokens: %s", err)
+			}
+
+			// older kontrols don't have the blocklist
+			err := k.UpdateBlocklist()
+			if kerr, ok := err.(*Error); err != nil && (!ok || kerr.Type != "methodNotFound") {
+				k.Log.Error("Cannot update blocklist: %s", err)
+			}
+
+			select {
+			case <-time.After(interval):
+			case <-k.closeC:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,71 @@
+package kite
+
+import "fmt"
+
+// RequireAuth sets whether the requests to the method are authenticated,
+// overriding Config.DisableAuthentication for the method. It's useful for
+// methods that must be public, like health checks:
+//
+//	k.HandleFunc("health", health, kite.RequireAuth(false))
+func RequireAuth(required bool) MethodOption {
+	return func(m *Method) {
+		m.authenticate = required
+	}
+}
+
+// RequireRole allows only the callers having at least one of the roles to
+// call the method. Roles are granted by kontrol in the "roles" claim of the
+// tokens, requests that are not authenticated with a token have no roles.
+//
+//	k.HandleFunc("user.delete", deleteUser, kite.RequireRole("admin"))
+func RequireRole(roles ...string) MethodOption {
+	return func(m *Method) {
+		m.RequireRole(roles...)
+	}
+}
+
+// RequireRole allows only the callers having at least one of the roles to
+// call this method. See the package level RequireRole for details.
+func (m *Method) RequireRole(roles ...string) *Method {
+	return m.Authorize(func(r *Request) error {
+		for _, role := range roles {
+			if hasRole(r.Roles, role) {
+				return nil
+			}
+		}
+
+		if len(roles) == 1 {
+			return fmt.Errorf("role %q is required to call %q", roles[0], r.Method)
+		}
+
+		return fmt.Errorf("one of the roles %q is required to call %q", roles, r.Method)
+	})
+}
+
+// hasRole returns true if role is one of the granted roles.
+func hasRole(granted []string, role string) bool {
+	for _, g := range granted {
+		if g == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rolesFromClaims returns the roles in the "roles" claim of a token.
+func rolesFromClaims(claims map[string]interface{}) []string {
+	list, ok := claims["roles"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	roles := make([]string, 0, len(list))
+	for _, r := range list {
+		if role, ok := r.(string); ok {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles
+}
@@ -0,0 +1,65 @@
+package kite
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RequireScope allows only the requests having all of the scopes to call the
+// method. Scopes are granted by kontrol in the "scopes" claim of the tokens.
+// A scope ending with ".*" grants all scopes with that prefix, "fs.*" grants
+// "fs.read" for example, and "*" grants all scopes. Requests that are not
+// authenticated with a token have no scopes.
+//
+//	k.HandleFunc("fs.readFile", readFile, kite.RequireScope("fs.read"))
+func RequireScope(scopes ...string) MethodOption {
+	return func(m *Method) {
+		m.RequireScope(scopes...)
+	}
+}
+
+// RequireScope allows only the requests having all of the scopes to call
+// this method. See the package level RequireScope for details.
+func (m *Method) RequireScope(scopes ...string) *Method {
+	return m.Authorize(func(r *Request) error {
+		for _, scope := range scopes {
+			if !hasScope(r.Scopes, scope) {
+				return fmt.Errorf("scope %q is required to call %q", scope, r.Method)
+			}
+		}
+
+		return nil
+	})
+}
+
+// hasScope returns true if the scope is granted by one of the granted scopes.
+func hasScope(granted []string, scope string) bool {
+	for _, g := range granted {
+		if g == scope || g == "*" {
+			return true
+		}
+
+		if strings.HasSuffix(g, ".*") && strings.HasPrefix(scope, strings.TrimSuffix(g, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scopesFromClaims returns the scopes in the "scopes" claim of a token.
+func scopesFromClaims(claims map[string]interface{}) []string {
+	list, ok := claims["scopes"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	scopes := make([]string, 0, len(list))
+	for _, s := range list {
+		if scope, ok := s.(string); ok {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes
+}
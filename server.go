@@ -2,6 +2,7 @@
 package kite
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io/ioutil"
@@ -10,10 +11,12 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 // Run is a blocking method. It runs the kite server and then accepts requests
-// asynchronously. It supports graceful restart via SIGUSR2.
+// asynchronously. It supports graceful restart via SIGUSR2 if
+// SetupUpgradeHandler is called.
 func (k *Kite) Run() {
 	if os.Getenv("KITE_VERSION") != "" {
 		fmt.Println(k.Kite().Version)
@@ -52,23 +55,149 @@ func (k *Kite) Close() {
 
 }
 
+// Shutdown gracefully shuts down the kite. It stops accepting new connections
+// and requests, waits for the running handlers and callbacks to finish and
+// then closes the connected clients. If ctx is done before the handlers have
+// finished, the clients are closed anyway and ctx.Err() is returned. A kite
+// registered to kontrol is deregistered first, so the other kites stop
+// connecting to it.
+func (k *Kite) Shutdown(ctx context.Context) error {
+	return k.shutdown(ctx, true)
+}
+
+// shutdown is the implementation of Shutdown. The kite is not deregistered
+// if deregister is false.
+func (k *Kite) shutdown(ctx context.Context, deregister bool) error {
+	k.Log.Info("Shutting down kite...")
+
+	// before rejecting the requests, the response is needed
+	if deregister && k.kontrol.isRegistered() {
+		if err := k.DeregisterFromKontrol(); err != nil {
+			k.Log.Warning("Cannot deregister from kontrol: %s", err)
+		}
+	}
+
+	k.shutdownMu.Lock()
+	k.shuttingDown = true
+	k.shutdownMu.Unlock()
+
+	// stop accepting new connections
+	k.Close()
+
+	drained := make(chan struct{})
+	go func() {
+		k.inflight.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		k.Log.Warning("Kite is shut down before draining the requests: %s", err)
+	}
+
+	// the responses of the drained handlers may still be queued
+	for _, c := range k.Clients() {
+		c.flush(ctx)
+		c.Close()
+	}
+
+	return err
+}
+
+// trackRequest marks the start of a handler or callback. It returns false if
+// the kite is shutting down, in which case the caller must not call
+// inflight.Done().
+func (k *Kite) trackRequest() bool {
+	k.shutdownMu.RLock()
+	defer k.shutdownMu.RUnlock()
+
+	if k.shuttingDown {
+		return false
+	}
+
+	k.inflight.Add(1)
+	atomic.AddInt64(&k.inflightCount, 1)
+	return true
+}
+
+// doneRequest marks the end of a handler or callback started with
+// trackRequest.
+func (k *Kite) doneRequest() {
+	atomic.AddInt64(&k.inflightCount, -1)
+	k.inflight.Done()
+}
+
+// addClient adds a connected client to the list of clients served by this
+// kite. It returns false if the kite is shutting down.
+func (k *Kite) addClient(c *Client) bool {
+	k.shutdownMu.RLock()
+	defer k.shutdownMu.RUnlock()
+
+	if k.shuttingDown {
+		return false
+	}
+
+	k.clientsMu.Lock()
+	k.clients[c] = struct{}{}
+	k.clientsMu.Unlock()
+	return true
+}
+
+// removeClient removes a disconnected client.
+func (k *Kite) removeClient(c *Client) {
+	k.clientsMu.Lock()
+	delete(k.clients, c)
+	k.clientsMu.Unlock()
+}
+
 func (k *Kite) Addr() string {
 	return net.JoinHostPort(k.Config.IP, strconv.Itoa(k.Config.Port))
 }
 
 // listenAndServe listens on the TCP network address k.URL.Host and then
-// calls Serve to handle requests on incoming connectionk.
+// calls Serve to handle requests on incoming connectionk. The socket passed by
+// systemd is used instead if the kite is socket activated, and the listener
+// of the old process if the kite is started by Upgrade.
 func (k *Kite) listenAndServe() error {
-	var err error
-
-	// create a new one if there doesn't exist
-	k.listener, err = net.Listen("tcp4", k.Addr())
+	l, err := upgradeListener()
 	if err != nil {
 		return err
 	}
 
-	k.Log.Info("New listening: %s", k.listener.Addr().String())
+	if l != nil {
+		k.Log.Info("Using the listener of the old process: %s", l.Addr().String())
+	} else if l, err = activationListener(); err != nil {
+		return err
+	} else if l != nil {
+		k.Log.Info("Using the socket passed by systemd: %s", l.Addr().String())
+	} else {
+		// create a new one if there doesn't exist
+		l, err = net.Listen("tcp4", k.Addr())
+		if err != nil {
+			return err
+		}
+
+		k.Log.Info("New listening: %s", l.Addr().String())
+	}
+
+	return k.Serve(l)
+}
+
+// Serve accepts the connections on the listener l, like Run does on the
+// listener it creates. It's useful for serving on a listener created by
+// another process, like a supervisor. The connections are wrapped with TLS if
+// the kite has a TLSConfig. If Config.Port is zero, it's set to the port of l.
+// Serve blocks until l is closed by Close or Shutdown.
+func (k *Kite) Serve(l net.Listener) error {
+	if addr, ok := l.Addr().(*net.TCPAddr); ok && k.Config.Port == 0 {
+		k.Config.Port = addr.Port
+	}
 
+	k.rawListener = l
+	k.listener = l
 	if k.TLSConfig != nil {
 		if k.TLSConfig.NextProtos == nil {
 			k.TLSConfig.NextProtos = []string{"http/1.1"}
@@ -78,6 +207,7 @@ func (k *Kite) listenAndServe() error {
 
 	// listener is ready, notify waiters.
 	close(k.readyC)
+	notifyUpgradeReady()
 
 	defer close(k.closeC) // serving is finished, notify waiters.
 	k.Log.Info("Serving...")
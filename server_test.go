@@ -0,0 +1,113 @@
+package kite
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10033
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	k.HandleFunc("slow", func(r *Request) (interface{}, error) {
+		close(started)
+		<-release
+		return "done", nil
+	})
+	k.HandleFunc("ping", func(r *Request) (interface{}, error) {
+		return "pong", nil
+	})
+
+	go k.Run()
+	<-k.ServerReadyNotify()
+
+	c := New("exp", "0.0.1").NewClient("http://127.0.0.1:10033/kite")
+	c.Concurrent = false // the response is processed before the disconnect
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	slow := c.GoWithTimeout("slow", 4*time.Second)
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+		defer cancel()
+		shutdown <- k.Shutdown(ctx)
+	}()
+
+	// wait until the kite stops accepting new requests
+	for {
+		_, err := c.TellWithTimeout("ping", 4*time.Second)
+		if err == nil {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+
+		if e, ok := err.(*Error); !ok || e.Type != "shutdown" {
+			t.Fatalf("got error %v, want: shutdown", err)
+		}
+		break
+	}
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown has returned before the handler finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+
+	resp := <-slow
+	if resp.Err != nil {
+		t.Fatalf("running handler is not drained: %s", resp.Err)
+	}
+
+	if s := resp.Result.MustString(); s != "done" {
+		t.Errorf("got result %q, want: done", s)
+	}
+
+	if err := <-shutdown; err != nil {
+		t.Errorf("shutdown returned error: %s", err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10034
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	k.HandleFunc("slow", func(r *Request) (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+
+	go k.Run()
+	<-k.ServerReadyNotify()
+
+	c := New("exp", "0.0.1").NewClient("http://127.0.0.1:10034/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.GoWithTimeout("slow", 4*time.Second)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := k.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got error %v, want: %s", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,214 @@
+package kite
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrSessionNotFound is returned from SessionStore.Get when there is no
+	// session with the id.
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrSessionExpired is returned from SessionStore.Validate when the
+	// session is expired.
+	ErrSessionExpired = errors.New("session is expired")
+)
+
+// Session is a login session of a user, created by the application, like a
+// web application after the user signs in. The kites of the browsers
+// authenticate with the session id as the "sessionID" authentication type.
+type Session struct {
+	ID       string    `json:"id"`
+	Username string    `json:"username"`
+	Expires  time.Time `json:"expires,omitempty"` // zero if it doesn't expire
+}
+
+// SessionStore looks up the sessions for the "sessionID" authentication type.
+// See Kite.UseSessionStore.
+type SessionStore interface {
+	// Get returns the session with the id, or ErrSessionNotFound.
+	Get(id string) (*Session, error)
+
+	// Validate returns an error if the session can't be used anymore, like
+	// ErrSessionExpired.
+	Validate(s *Session) error
+
+	// Touch is called after the session is used to authenticate a call,
+	// the stores with idle timeouts extend the session.
+	Touch(s *Session) error
+}
+
+// UseSessionStore authenticates the "sessionID" authentication type with the
+// sessions in the store.
+func (k *Kite) UseSessionStore(store SessionStore) {
+	k.Authenticators["sessionID"] = func(r *Request) error {
+		s, err := store.Get(r.Auth.Key)
+		if err != nil {
+			return err
+		}
+
+		if err := store.Validate(s); err != nil {
+			return err
+		}
+
+		// the call is authenticated even if the session can't be extended
+		if err := store.Touch(s); err != nil {
+			k.Log.Warning("Cannot touch session of %s: %s", s.Username, err)
+		}
+
+		r.Username = s.Username
+		return nil
+	}
+}
+
+// SessionSweepInterval is the minimum interval between the sweeps of the
+// expired sessions of MemorySessionStore.
+var SessionSweepInterval = time.Minute
+
+// MemorySessionStore is a SessionStore that keeps the sessions in memory.
+// It's useful when the application creating the sessions and the kite run
+// in the same process, and for testing. The expired sessions are deleted
+// when they are looked up, and swept with Set at most once in every
+// SessionSweepInterval.
+type MemorySessionStore struct {
+	// IdleTimeout expires the sessions that are not used for the duration.
+	// Zero keeps the sessions until their Expires time. The sessions are
+	// never extended beyond the Expires time they are set with.
+	IdleTimeout time.Duration
+
+	sessions  map[string]*memorySession
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// memorySession is a session in MemorySessionStore.
+type memorySession struct {
+	session *Session
+
+	// deadline is the Expires time the session is set with, zero if the
+	// session is extended forever
+	deadline time.Time
+}
+
+// NewMemorySessionStore returns a new, empty MemorySessionStore.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]*memorySession),
+	}
+}
+
+// Set adds or replaces the session. Expires is set to IdleTimeout later if
+// the store has an IdleTimeout, unless it's sooner.
+func (m *MemorySessionStore) Set(s *Session) {
+	s = copySession(s)
+	deadline := s.Expires
+
+	if m.IdleTimeout > 0 {
+		s.Expires = m.idleExpires(deadline)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if time.Since(m.lastSweep) >= SessionSweepInterval {
+		m.sweep()
+	}
+
+	m.sessions[s.ID] = &memorySession{
+		session:  s,
+		deadline: deadline,
+	}
+}
+
+// Delete deletes the session with the id, like when the user signs out.
+func (m *MemorySessionStore) Delete(id string) {
+	m.mu.Lock()
+	delete(m.sessions, id)
+	m.mu.Unlock()
+}
+
+// Sweep deletes the expired sessions.
+func (m *MemorySessionStore) Sweep() {
+	m.mu.Lock()
+	m.sweep()
+	m.mu.Unlock()
+}
+
+func (m *MemorySessionStore) sweep() {
+	for id, s := range m.sessions {
+		if isExpired(s.session) {
+			delete(m.sessions, id)
+		}
+	}
+
+	m.lastSweep = time.Now()
+}
+
+// Get implements SessionStore. The expired sessions are deleted when they
+// are looked up.
+func (m *MemorySessionStore) Get(id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	if isExpired(s.session) {
+		delete(m.sessions, id)
+		return nil, ErrSessionNotFound
+	}
+
+	return copySession(s.session), nil
+}
+
+// Validate implements SessionStore.
+func (m *MemorySessionStore) Validate(s *Session) error {
+	if isExpired(s) {
+		return ErrSessionExpired
+	}
+
+	return nil
+}
+
+// Touch implements SessionStore. It extends the session for IdleTimeout,
+// up to the Expires time the session is set with.
+func (m *MemorySessionStore) Touch(s *Session) error {
+	if m.IdleTimeout == 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.sessions[s.ID]
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	stored.session.Expires = m.idleExpires(stored.deadline)
+	return nil
+}
+
+// idleExpires returns the expiry of a session used now, which is not later
+// than the deadline unless it's zero.
+func (m *MemorySessionStore) idleExpires(deadline time.Time) time.Time {
+	expires := time.Now().Add(m.IdleTimeout)
+	if !deadline.IsZero() && deadline.Before(expires) {
+		return deadline
+	}
+
+	return expires
+}
+
+func isExpired(s *Session) bool {
+	return !s.Expires.IsZero() && time.Now().After(s.Expires)
+}
+
+func copySession(s *Session) *Session {
+	c := *s
+	return &c
+}
@@ -0,0 +1,94 @@
+package kite
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemorySessionStore(t *testing.T) {
+	store := NewMemorySessionStore()
+	store.IdleTimeout = time.Hour
+	store.Set(&Session{ID: "valid", Username: "alice"})
+	store.Set(&Session{ID: "expired", Username: "bob", Expires: time.Now().Add(-time.Minute)})
+
+	k := New("testkite", "0.0.1")
+	k.UseSessionStore(store)
+	authenticate := k.Authenticators["sessionID"]
+
+	r := &Request{Auth: &Auth{Type: "sessionID", Key: "valid"}}
+	if err := authenticate(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Username != "alice" {
+		t.Errorf("got username %q, want: %q", r.Username, "alice")
+	}
+
+	for _, id := range []string{"expired", "unknown"} {
+		r := &Request{Auth: &Auth{Type: "sessionID", Key: id}}
+		if err := authenticate(r); err != ErrSessionNotFound {
+			t.Errorf("%s: got error %v, want: %v", id, err, ErrSessionNotFound)
+		}
+	}
+
+	store.Delete("valid")
+
+	if _, err := store.Get("valid"); err != ErrSessionNotFound {
+		t.Errorf("got error %v for deleted session, want: %v", err, ErrSessionNotFound)
+	}
+}
+
+func TestMemorySessionStoreTouch(t *testing.T) {
+	store := NewMemorySessionStore()
+	store.IdleTimeout = time.Hour
+
+	deadline := time.Now().Add(time.Minute)
+	store.Set(&Session{ID: "limited", Username: "alice", Expires: deadline})
+	store.Set(&Session{ID: "unlimited", Username: "bob"})
+
+	for id, want := range map[string]time.Time{
+		"limited":   deadline,
+		"unlimited": time.Now().Add(store.IdleTimeout),
+	} {
+		s, err := store.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Touch(s); err != nil {
+			t.Fatal(err)
+		}
+
+		if s, err = store.Get(id); err != nil {
+			t.Fatal(err)
+		}
+
+		if d := s.Expires.Sub(want); d < -time.Second || d > time.Second {
+			t.Errorf("%s: got expiry %s after touch, want: %s", id, s.Expires, want)
+		}
+	}
+}
+
+func TestMemorySessionStoreSweep(t *testing.T) {
+	defer func(interval time.Duration) { SessionSweepInterval = interval }(SessionSweepInterval)
+	SessionSweepInterval = time.Hour
+
+	store := NewMemorySessionStore()
+	store.Set(&Session{ID: "expired", Username: "alice", Expires: time.Now().Add(50 * time.Millisecond)})
+	store.Set(&Session{ID: "valid", Username: "bob", Expires: time.Now().Add(time.Hour)})
+
+	time.Sleep(50 * time.Millisecond)
+
+	// not swept before the interval
+	store.Set(&Session{ID: "other", Username: "carol"})
+	if len(store.sessions) != 3 {
+		t.Fatalf("got %d sessions, want: 3", len(store.sessions))
+	}
+
+	SessionSweepInterval = 0
+	store.Set(&Session{ID: "other", Username: "carol"})
+
+	if _, ok := store.sessions["expired"]; ok || len(store.sessions) != 2 {
+		t.Errorf("got %d sessions after the sweep, want: 2", len(store.sessions))
+	}
+}
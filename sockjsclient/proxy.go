@@ -0,0 +1,260 @@
+package sockjsclient
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// proxyURL returns the proxy to connect to the websocket URL through, nil
+// means a direct connection.
+func (opts *DialOptions) proxyURL(dialURL *url.URL) (*url.URL, error) {
+	if opts.Proxy == nil {
+		return nil, nil
+	}
+
+	// proxy functions know about the http schemes only
+	scheme := "http"
+	if dialURL.Scheme == "wss" {
+		scheme = "https"
+	}
+
+	return opts.Proxy(&http.Request{
+		URL:  &url.URL{Scheme: scheme, Host: dialURL.Host},
+		Host: dialURL.Host,
+	})
+}
+
+// proxyAddr returns the host:port of the proxy, adding the default port of
+// the proxy scheme if it's missing.
+func proxyAddr(proxy *url.URL) string {
+	if proxy.Port() != "" {
+		return proxy.Host
+	}
+
+	port := "1080"
+	switch proxy.Scheme {
+	case "http":
+		port = "80"
+	case "https":
+		port = "443"
+	}
+
+	return net.JoinHostPort(proxy.Hostname(), port)
+}
+
+// proxyConnect asks the proxy on conn to connect to addr. The handshake must
+// finish before deadline, zero deadline means no limit.
+func proxyConnect(conn net.Conn, proxy *url.URL, addr string, deadline time.Time) (net.Conn, error) {
+	if !deadline.IsZero() {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	var err error
+	switch proxy.Scheme {
+	case "socks5", "socks5h":
+		err = socks5Connect(conn, proxy.User, addr)
+	case "http":
+		err = httpConnect(conn, proxy.User, addr)
+	case "https":
+		conn = tls.Client(conn, &tls.Config{ServerName: proxy.Hostname()})
+		err = httpConnect(conn, proxy.User, addr)
+	default:
+		err = fmt.Errorf("proxy scheme is not known '%s'", proxy.Scheme)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// httpConnect opens a tunnel to addr with an HTTP CONNECT request.
+func httpConnect(conn net.Conn, user *url.Userinfo, addr string) error {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+
+	if user != nil {
+		password, _ := user.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+
+	// The server doesn't send anything before the websocket handshake, so
+	// nothing is lost in the buffer of the reader.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy connection failed: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// SOCKS5 constants, see RFC 1928 and RFC 1929.
+const (
+	socks5Version      = 0x05
+	socks5NoAuth       = 0x00
+	socks5PasswordAuth = 0x02
+	socks5NoAcceptable = 0xff
+	socks5CmdConnect   = 0x01
+	socks5IPv4         = 0x01
+	socks5Domain       = 0x03
+	socks5IPv6         = 0x04
+)
+
+// socks5Errors are the messages of the SOCKS5 reply codes.
+var socks5Errors = []string{
+	"",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+// socks5Connect opens a tunnel to addr with a SOCKS5 CONNECT request. The
+// host name is resolved by the proxy.
+func socks5Connect(conn net.Conn, user *url.Userinfo, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 0xffff {
+		return fmt.Errorf("invalid port '%s'", portStr)
+	}
+
+	methods := []byte{socks5NoAuth}
+	if user != nil {
+		methods = append(methods, socks5PasswordAuth)
+	}
+
+	greeting := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+
+	if buf[0] != socks5Version {
+		return fmt.Errorf("unexpected SOCKS version %d", buf[0])
+	}
+
+	switch buf[1] {
+	case socks5NoAuth:
+	case socks5PasswordAuth:
+		if user == nil {
+			return errors.New("SOCKS proxy requires authentication")
+		}
+
+		password, _ := user.Password()
+		username := user.Username()
+		if len(username) > 255 || len(password) > 255 {
+			return errors.New("SOCKS username or password is too long")
+		}
+
+		auth := []byte{0x01, byte(len(username))}
+		auth = append(auth, username...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return err
+		}
+
+		if buf[1] != 0x00 {
+			return errors.New("SOCKS authentication failed")
+		}
+	case socks5NoAcceptable:
+		return errors.New("SOCKS proxy accepts none of the authentication methods")
+	default:
+		return fmt.Errorf("unexpected SOCKS authentication method %d", buf[1])
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, socks5IPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, socks5IPv6)
+			req = append(req, ip...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name is too long '%s'", host)
+		}
+		req = append(req, socks5Domain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+
+	if reply[1] != 0x00 {
+		msg := "unknown SOCKS error"
+		if int(reply[1]) < len(socks5Errors) {
+			msg = socks5Errors[reply[1]]
+		}
+		return fmt.Errorf("proxy connection failed: %s", msg)
+	}
+
+	// skip the bound address and port
+	var skip int
+	switch reply[3] {
+	case socks5IPv4:
+		skip = net.IPv4len + 2
+	case socks5IPv6:
+		skip = net.IPv6len + 2
+	case socks5Domain:
+		if _, err := io.ReadFull(conn, reply[:1]); err != nil {
+			return err
+		}
+		skip = int(reply[0]) + 2
+	default:
+		return fmt.Errorf("unexpected SOCKS address type %d", reply[3])
+	}
+
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
@@ -3,7 +3,10 @@ package sockjsclient
 // http://sockjs.github.io/sockjs-protocol/sockjs-protocol-0.3.3.html
 
 import (
+	"bytes"
+	"context"
 	crand "crypto/rand"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -28,16 +31,107 @@ type Rand struct {
 
 var r = Rand{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
 
+// bufferPool keeps the buffers used for encoding the sent frames.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// maxPooledBuffer is the capacity of the largest buffer that is reused.
+const maxPooledBuffer = 64 << 10
+
+// putBuffer puts buf back to the pool unless it's too large.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
+
+// DefaultWriteTimeout is the write deadline used for websocket frames if
+// DialOptions.WriteTimeout is not set.
+var DefaultWriteTimeout = 10 * time.Second
+
 type WebsocketSession struct {
 	conn     *websocket.Conn
 	id       string
 	messages []string
+
+	// readBuf is reused for reading the frames, Recv is not called
+	// concurrently.
+	readBuf bytes.Buffer
+
+	// writeTimeout is the deadline for writing a single frame.
+	writeTimeout time.Duration
+
+	// compressionThreshold is the minimum size of the compressed frames,
+	// zero means the compression is disabled.
+	compressionThreshold int
+
+	// compressed is true if the server has accepted the permessage-deflate
+	// extension in the handshake.
+	compressed bool
+
+	// gorilla/websocket supports only one concurrent writer, mu serializes
+	// Send() and Close() calls.
+	mu     sync.Mutex
+	closed bool
 }
 
 type DialOptions struct {
 	BaseURL                         string
 	ReadBufferSize, WriteBufferSize int
 	Timeout                         time.Duration
+
+	// WriteTimeout is the deadline for writing a single frame. Zero means
+	// DefaultWriteTimeout.
+	WriteTimeout time.Duration
+
+	// TLSClientConfig is used for https and wss URLs. It can hold a client
+	// certificate for authenticating to the server.
+	TLSClientConfig *tls.Config
+
+	// ReadLimit is the maximum size in bytes of a frame read from the
+	// server. The connection is closed if a larger frame is received. Zero
+	// means no limit.
+	ReadLimit int64
+
+	// Context, if set, aborts the connection attempt when it's done. Its
+	// deadline is applied to the TCP and TLS handshakes like Timeout, the
+	// earlier one is used if both are set.
+	Context context.Context
+
+	// Proxy returns the URL of the proxy for the request, like the Proxy
+	// of http.Transport. http, https, socks5 and socks5h proxies are
+	// supported, the user info of the URL is used for authenticating to the
+	// proxy. Nil Proxy or nil URL means no proxy.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// CompressionThreshold enables the permessage-deflate compression of
+	// websocket sessions if it's positive. The frames of at least
+	// CompressionThreshold bytes are compressed if the server supports it.
+	CompressionThreshold int
+}
+
+// context returns the context of the options, never nil.
+func (opts *DialOptions) context() context.Context {
+	if opts.Context != nil {
+		return opts.Context
+	}
+	return context.Background()
+}
+
+// deadline returns the time the connection attempt must finish, the zero
+// time means no deadline.
+func (opts *DialOptions) deadline() time.Time {
+	var deadline time.Time
+	if opts.Timeout != 0 {
+		deadline = time.Now().Add(opts.Timeout)
+	}
+
+	if d, ok := opts.context().Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+
+	return deadline
 }
 
 func ConnectWebsocketSession(opts *DialOptions) (*WebsocketSession, error) {
@@ -69,30 +163,96 @@ func ConnectWebsocketSession(opts *DialOptions) (*WebsocketSession, error) {
 	ws := websocket.Dialer{
 		ReadBufferSize:  opts.ReadBufferSize,
 		WriteBufferSize: opts.WriteBufferSize,
+		TLSClientConfig: opts.TLSClientConfig,
+		// the server ignores it if it doesn't support the compression
+		EnableCompression: opts.CompressionThreshold > 0,
 	}
 
-	// if the user passed a timeout, us a dial with a timeout
-	if opts.Timeout != 0 {
-		ws.NetDial = func(network, addr string) (net.Conn, error) {
-			return net.DialTimeout(network, addr, opts.Timeout)
+	ctx := opts.context()
+	deadline := opts.deadline()
+
+	// stop is closed when the handshake is finished, the connection is
+	// closed if the context is done before that.
+	stop := make(chan struct{})
+	defer close(stop)
+
+	proxy, err := opts.proxyURL(dialURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ws.NetDial = func(network, addr string) (net.Conn, error) {
+		d := net.Dialer{Deadline: deadline}
+
+		dialAddr := addr
+		if proxy != nil {
+			dialAddr = proxyAddr(proxy)
+		}
+
+		conn, err := d.DialContext(ctx, network, dialAddr)
+		if err != nil {
+			return nil, err
+		}
+
+		go func() {
+			select {
+			case <-ctx.Done():
+				conn.Close()
+			case <-stop:
+			}
+		}()
+
+		if proxy == nil {
+			return conn, nil
+		}
+
+		tunnel, err := proxyConnect(conn, proxy, addr, deadline)
+		if err != nil {
+			conn.Close()
+			return nil, err
 		}
-		// this is used as Deadline inside gorillas dialer method
-		ws.HandshakeTimeout = opts.Timeout
+
+		return tunnel, nil
+	}
+
+	// if the user passed a timeout, it's used as Deadline inside gorillas
+	// dialer method for the TLS and websocket handshakes
+	if !deadline.IsZero() {
+		ws.HandshakeTimeout = deadline.Sub(time.Now())
 	}
 
-	conn, _, err := ws.Dial(dialURL.String(), requestHeader)
+	conn, resp, err := ws.Dial(dialURL.String(), requestHeader)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	// the connection may have been closed after the handshake
+	if err := ctx.Err(); err != nil {
+		conn.Close()
 		return nil, err
 	}
 
+	if opts.ReadLimit > 0 {
+		conn.SetReadLimit(opts.ReadLimit)
+	}
+
 	session := NewWebsocketSession(conn)
 	session.id = sessionID
+	if opts.WriteTimeout != 0 {
+		session.writeTimeout = opts.WriteTimeout
+	}
+	session.compressionThreshold = opts.CompressionThreshold
+	session.compressed = strings.Contains(resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
 	return session, nil
 }
 
 func NewWebsocketSession(conn *websocket.Conn) *WebsocketSession {
 	return &WebsocketSession{
-		conn: conn,
+		conn:         conn,
+		writeTimeout: DefaultWriteTimeout,
 	}
 }
 
@@ -100,6 +260,12 @@ func (w *WebsocketSession) RemoteAddr() string {
 	return w.conn.RemoteAddr().String()
 }
 
+// Compressed returns true if the permessage-deflate compression is negotiated
+// with the server.
+func (w *WebsocketSession) Compressed() bool {
+	return w.compressed
+}
+
 // ID returns a session id
 func (w *WebsocketSession) ID() string {
 	return w.id
@@ -116,7 +282,7 @@ func (w *WebsocketSession) Recv() (string, error) {
 
 read_frame:
 	// Read one SockJS frame.
-	_, buf, err := w.conn.ReadMessage()
+	buf, err := w.readFrame()
 	if err != nil {
 		return "", err
 	}
@@ -164,14 +330,85 @@ read_frame:
 	return msg, nil
 }
 
+// readFrame reads a websocket message into the read buffer of the session.
+// The returned slice is valid until the next call.
+func (w *WebsocketSession) readFrame() ([]byte, error) {
+	_, r, err := w.conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+
+	w.readBuf.Reset()
+	if _, err := w.readBuf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+
+	// do not keep the memory of a huge message for the lifetime of the
+	// session
+	if w.readBuf.Cap() > maxPooledBuffer {
+		buf := w.readBuf.Bytes()
+		w.readBuf = bytes.Buffer{}
+		return buf, nil
+	}
+
+	return w.readBuf.Bytes(), nil
+}
+
 // Send sends one text frame to session
 func (w *WebsocketSession) Send(str string) error {
-	b, _ := json.Marshal([]string{str})
-	return w.conn.WriteMessage(websocket.TextMessage, b)
+	return w.SendBatch([]string{str})
+}
+
+// SendBatch sends the messages in a single frame.
+func (w *WebsocketSession) SendBatch(msgs []string) error {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer putBuffer(buf)
+
+	if err := json.NewEncoder(buf).Encode(msgs); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errors.New("session closed")
+	}
+
+	data := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+
+	// small frames are not worth compressing, it has no effect if the
+	// compression is not negotiated
+	if w.compressionThreshold > 0 {
+		w.conn.EnableWriteCompression(len(data) >= w.compressionThreshold)
+	}
+
+	w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+	return w.conn.WriteMessage(websocket.TextMessage, data)
 }
 
-// Close closes the session with provided code and reason.
+// Close closes the session with provided code and reason. A close frame is
+// sent to the remote side before closing the underlying connection. A zero
+// status means a normal closure.
 func (w *WebsocketSession) Close(status uint32, reason string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if status == 0 {
+		status = websocket.CloseNormalClosure
+	}
+
+	// Errors are ignored because the remote side may have already closed
+	// the connection, the connection is closed in any case.
+	msg := websocket.FormatCloseMessage(int(status), reason)
+	w.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(w.writeTimeout))
+
 	return w.conn.Close()
 }
 
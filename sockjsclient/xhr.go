@@ -39,13 +39,26 @@ func NewXHRSession(opts *DialOptions) (*XHRSession, error) {
 		Jar: cookieJar,
 	}
 
+	if opts.TLSClientConfig != nil || opts.Proxy != nil {
+		client.Transport = &http.Transport{
+			TLSClientConfig: opts.TLSClientConfig,
+			Proxy:           opts.Proxy,
+		}
+	}
+
 	// following /server_id/session_id should always be the same for every session
 	serverID := threeDigits()
 	sessionID := randomStringLength(20)
 	sessionURL := opts.BaseURL + "/" + serverID + "/" + sessionID
 
 	// start the initial session handshake
-	sessionResp, err := client.Post(sessionURL+"/xhr", "text/plain", nil)
+	req, err := http.NewRequest("POST", sessionURL+"/xhr", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+
+	sessionResp, err := client.Do(req.WithContext(opts.context()))
 	if err != nil {
 		return nil, err
 	}
@@ -150,6 +163,11 @@ func (x *XHRSession) Recv() (string, error) {
 }
 
 func (x *XHRSession) Send(frame string) error {
+	return x.SendBatch([]string{frame})
+}
+
+// SendBatch sends the messages in a single request.
+func (x *XHRSession) SendBatch(msgs []string) error {
 	x.mu.Lock()
 	if !x.opened {
 		x.mu.Unlock()
@@ -159,8 +177,7 @@ func (x *XHRSession) Send(frame string) error {
 
 	// Need's to be JSON encoded array of string messages (SockJS protocol
 	// requirement)
-	message := []string{frame}
-	body, err := json.Marshal(&message)
+	body, err := json.Marshal(&msgs)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,31 @@
+package kite
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/koding/kite/protocol"
+)
+
+// Status is the result of the "kite.status" method.
+type Status struct {
+	Kite       protocol.Kite `json:"kite"`
+	StartedAt  time.Time     `json:"startedAt"`
+	Uptime     float64       `json:"uptime"` // in seconds
+	Clients    int           `json:"clients"`
+	Goroutines int           `json:"goroutines"`
+	GoVersion  string        `json:"goVersion"`
+}
+
+// handleStatus returns the identity of the kite with the information about
+// how long it's running and how many clients it has.
+func (k *Kite) handleStatus(r *Request) (interface{}, error) {
+	return &Status{
+		Kite:       *k.Kite(),
+		StartedAt:  k.startedAt,
+		Uptime:     time.Since(k.startedAt).Seconds(),
+		Clients:    len(k.Clients()),
+		Goroutines: runtime.NumGoroutine(),
+		GoVersion:  runtime.Version(),
+	}, nil
+}
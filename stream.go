@@ -0,0 +1,288 @@
+package kite
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/koding/kite/dnode"
+)
+
+// ErrNoStream is returned from Stream.Send when the caller of the method did
+// not ask for a streaming response.
+var ErrNoStream = errors.New("caller does not accept streaming results")
+
+// streamChunk is the single argument of the stream callback. Chunks are
+// numbered because incoming messages may be processed concurrently on the
+// receiving side, so they can be delivered in the order they are sent.
+type streamChunk struct {
+	Seq  int         `json:"seq"`
+	Data interface{} `json:"data,omitempty"`
+	End  bool        `json:"end,omitempty"`
+}
+
+// Stream is used by handlers to push incremental results to the caller of a
+// long running method. The final result of the handler is still sent as the
+// response of the method.
+type Stream struct {
+	fn dnode.Function
+
+	mu     sync.Mutex // protects seq and closed
+	seq    int
+	closed bool
+}
+
+// Send sends a single chunk to the caller. The chunk must be marshalable with
+// json package. It returns ErrNoStream if the method is not called with
+// Client.Stream.
+func (s *Stream) Send(v interface{}) error {
+	if s == nil || !s.fn.IsValid() {
+		return ErrNoStream
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errors.New("stream is closed")
+	}
+
+	err := s.fn.Call(streamChunk{Seq: s.seq, Data: v})
+	s.seq++
+	return err
+}
+
+// close notifies the caller that no more chunks will be sent.
+func (s *Stream) close() error {
+	if s == nil || !s.fn.IsValid() {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+	return s.fn.Call(streamChunk{Seq: s.seq, End: true})
+}
+
+// StreamReader reads the chunks sent by a handler with Request.Stream. It is
+// returned from Client.Stream. Chunks must be consumed with Next or C,
+// otherwise the remote kite's chunks are not delivered.
+type StreamReader struct {
+	c     *Client
+	chunk chan *dnode.Partial
+
+	// final response of the method
+	done     chan struct{}
+	response *response
+
+	mu         sync.Mutex // protects fields below
+	next       int
+	endSeq     int
+	pending    map[int]*dnode.Partial
+	queue      []*dnode.Partial // in order, waiting to be delivered
+	delivering bool             // a goroutine is sending the queue
+	closing    bool             // no more chunks are accepted
+	ended      bool             // chunk channel is closed
+	callbackID *uint64
+}
+
+// Stream calls the method on the remote kite and returns a StreamReader to
+// read the chunks that the handler sends with Request.Stream.Send.
+func (c *Client) Stream(method string, args ...interface{}) *StreamReader {
+	return c.StreamWithContext(context.Background(), method, args...)
+}
+
+// StreamWithContext does the same thing with Stream() method except it takes
+// a context that can be used to cancel the call.
+func (c *Client) StreamWithContext(ctx context.Context, method string, args ...interface{}) *StreamReader {
+	s := &StreamReader{
+		c:       c,
+		chunk:   make(chan *dnode.Partial),
+		done:    make(chan struct{}),
+		endSeq:  -1,
+		pending: make(map[int]*dnode.Partial),
+	}
+
+	responseChan := make(chan *response, 1)
+	callbacks := c.sendMethod(ctx, method, args, 0, responseChan, dnode.PersistentCallback(s.receive))
+
+	if id, ok := callbackID(callbacks, "streamCallback"); ok {
+		s.mu.Lock()
+		s.callbackID = &id
+		if s.closing {
+			c.scrubber.RemoveCallback(id)
+		}
+		s.mu.Unlock()
+	}
+
+	go func() {
+		s.response = <-responseChan
+		close(s.done)
+
+		// The remote kite will not send the end of the stream if the call
+		// has failed.
+		if s.response.Err != nil {
+			s.end()
+		}
+	}()
+
+	return s
+}
+
+// Next blocks until the next chunk is received. It returns false when there
+// are no more chunks. Call Result after that to get the final result.
+func (s *StreamReader) Next() (*dnode.Partial, bool) {
+	chunk, ok := <-s.chunk
+	return chunk, ok
+}
+
+// C returns the channel the chunks are delivered from. The channel is closed
+// when the stream has ended.
+func (s *StreamReader) C() <-chan *dnode.Partial {
+	return s.chunk
+}
+
+// Result waits for the method to return and returns its result.
+func (s *StreamReader) Result() (*dnode.Partial, error) {
+	<-s.done
+	return s.response.Result, s.response.Err
+}
+
+// receive is the stream callback that is sent to the remote kite.
+func (s *StreamReader) receive(args *dnode.Partial) {
+	var chunk struct {
+		Seq  int            `json:"seq"`
+		Data *dnode.Partial `json:"data"`
+		End  bool           `json:"end"`
+	}
+
+	if err := args.One().Unmarshal(&chunk); err != nil {
+		s.c.LocalKite.Log.Warning("invalid stream chunk: %s", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return
+	}
+
+	if chunk.End {
+		s.endSeq = chunk.Seq
+	} else {
+		s.pending[chunk.Seq] = chunk.Data
+	}
+
+	// queue the chunks in order
+	for s.next != s.endSeq {
+		data, ok := s.pending[s.next]
+		if !ok {
+			break
+		}
+
+		delete(s.pending, s.next)
+		s.next++
+		s.queue = append(s.queue, data)
+	}
+
+	if s.next == s.endSeq {
+		s.closing = true
+	}
+
+	// Only one goroutine delivers the queue so the chunks are not reordered.
+	// The others return without waiting for the consumer.
+	if !s.delivering {
+		s.deliverLocked()
+	}
+}
+
+// deliverLocked sends the queued chunks to the consumer. The lock is released
+// while sending so the consumer may call the methods of the reader, and it is
+// held again when the function returns.
+func (s *StreamReader) deliverLocked() {
+	s.delivering = true
+
+	for len(s.queue) > 0 {
+		data := s.queue[0]
+		s.queue = s.queue[1:]
+
+		s.mu.Unlock()
+		s.chunk <- data
+		s.mu.Lock()
+	}
+
+	s.delivering = false
+
+	if s.closing {
+		s.endLocked()
+	}
+}
+
+// end stops accepting chunks. The chunk channel is closed after the queued
+// chunks are delivered.
+func (s *StreamReader) end() {
+	s.mu.Lock()
+	s.closing = true
+	if !s.delivering {
+		s.endLocked()
+	}
+	s.mu.Unlock()
+}
+
+// endLocked closes the chunk channel and removes the stream callback.
+func (s *StreamReader) endLocked() {
+	if s.ended {
+		return
+	}
+
+	s.ended = true
+	close(s.chunk)
+
+	if s.callbackID != nil {
+		s.c.scrubber.RemoveCallback(*s.callbackID)
+	}
+}
+
+// callbackID returns the id of the callback sent as the named field of the
+// call options, which is the first argument of a method call.
+func callbackID(callbacks map[string]dnode.Path, name string) (uint64, bool) {
+	for id, path := range callbacks {
+		if len(path) != 2 {
+			continue
+		}
+
+		// path[0] is the index of the options in the arguments slice
+		switch p0 := path[0].(type) {
+		case int:
+			if p0 != 0 {
+				continue
+			}
+		case string:
+			if p0 != "0" {
+				continue
+			}
+		default:
+			continue
+		}
+
+		if p1, ok := path[1].(string); !ok || p1 != name {
+			continue
+		}
+
+		i, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+
+		return i, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,116 @@
+package kite
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10001
+
+	k.HandleFunc("count", func(r *Request) (interface{}, error) {
+		n := int(r.Args.One().MustFloat64())
+		for i := 0; i < n; i++ {
+			if err := r.Stream.Send(i); err != nil {
+				return nil, err
+			}
+		}
+
+		return "done", nil
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	c := New("exp", "0.0.1").NewClient("http://127.0.0.1:10001/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+
+	stream := c.Stream("count", 10)
+
+	i := 0
+	for chunk, ok := stream.Next(); ok; chunk, ok = stream.Next() {
+		if n := int(chunk.MustFloat64()); n != i {
+			t.Fatalf("chunks are not in order, got: %d want: %d", n, i)
+		}
+		i++
+	}
+
+	if i != 10 {
+		t.Fatalf("got %d chunks, want: 10", i)
+	}
+
+	result, err := stream.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := result.MustString(); s != "done" {
+		t.Fatalf("got result %q, want: done", s)
+	}
+
+	// Tell does not accept streaming results.
+	_, err = c.Tell("count", 1)
+	if err == nil || err.Error() != ErrNoStream.Error() {
+		t.Fatalf("want %q error, got: %v", ErrNoStream, err)
+	}
+}
+
+func TestStreamFailed(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10032
+
+	k.HandleFunc("fail", func(r *Request) (interface{}, error) {
+		for i := 0; i < 3; i++ {
+			if err := r.Stream.Send(i); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, errors.New("failed")
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	c := New("exp", "0.0.1").NewClient("http://127.0.0.1:10032/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	stream := c.Stream("fail")
+
+	// the chunks are not read until the call has failed
+	if _, err := stream.Result(); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	done := make(chan int)
+	go func() {
+		i := 0
+		for chunk, ok := stream.Next(); ok; chunk, ok = stream.Next() {
+			if n := int(chunk.MustFloat64()); n != i {
+				t.Errorf("chunks are not in order, got: %d want: %d", n, i)
+			}
+			i++
+		}
+		done <- i
+	}()
+
+	select {
+	case n := <-done:
+		if n > 3 {
+			t.Errorf("got %d chunks, want at most 3", n)
+		}
+	case <-time.After(4 * time.Second):
+		t.Fatal("stream is not closed after the call has failed")
+	}
+}
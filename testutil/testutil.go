@@ -2,6 +2,9 @@
 package testutil
 
 import (
+	"flag"
+	"io"
+	"log"
 	"os"
 	"testing"
 	"time"
@@ -9,7 +12,6 @@ import (
 	"github.com/dgrijalva/jwt-go"
 	"github.com/koding/kite/config"
 	"github.com/koding/kite/testkeys"
-	"github.com/koding/logging"
 	"github.com/nu7hatch/gouuid"
 )
 
@@ -64,19 +66,21 @@ func NewConfig() *config.Config {
 }
 
 func init() {
-	// Monkey-patch default logging handler that is used by Kite.Logger
-	// in order to hide logs until "-v" flag is given to "test.sh" script.
-	original := logging.DefaultHandler
-	logging.DefaultHandler = &quietHandler{original}
+	// Redirect the standard logger that is used by the default logger of
+	// the kites in order to hide logs until "-v" flag is given to "test.sh"
+	// script.
+	log.SetOutput(&quietWriter{os.Stderr})
 }
 
-// quietHandler does not output any log messages until "-v" flag is given in tests.
-type quietHandler struct {
-	logging.Handler
+// quietWriter does not output any log messages until "-v" flag is given in tests.
+type quietWriter struct {
+	io.Writer
 }
 
-func (h *quietHandler) Handle(rec *logging.Record) {
-	if testing.Verbose() {
-		h.Handler.Handle(rec)
+func (w *quietWriter) Write(p []byte) (int, error) {
+	// testing.Verbose panics before the flags are parsed, in init functions
+	if flag.Parsed() && testing.Verbose() {
+		return w.Writer.Write(p)
 	}
+	return len(p), nil
 }
@@ -0,0 +1,77 @@
+package kite
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// handlerResult is the result of a handler run by serveMethodTimeout.
+type handlerResult struct {
+	result interface{}
+	err    error
+	panic  *handlerPanic // set if the handler has panicked
+}
+
+// Timeout sets the time the handlers of the method have to return,
+// overriding Config.HandlerTimeout. A negative timeout disables the timeout
+// of the method.
+func (m *Method) Timeout(d time.Duration) *Method {
+	m.timeout = d
+	return m
+}
+
+// serveMethodTimeout runs the handlers of the method like serveMethod, but
+// returns a "handlerTimeout" error if they don't return in the timeout of the
+// method. The handler goroutine is abandoned. The error has its own type, so
+// the caller doesn't take it for a "timeout" of an unreachable kite.
+func (k *Kite) serveMethodTimeout(m *Method, r *Request) (interface{}, error) {
+	timeout := m.timeout
+	if timeout == 0 {
+		timeout = k.Config.HandlerTimeout
+	}
+
+	if timeout <= 0 {
+		return k.serveMethod(m, r)
+	}
+
+	done := make(chan handlerResult, 1)
+
+	go func() {
+		// panics are passed to the caller, so they are handled in the same
+		// way as without a timeout
+		defer func() {
+			if rec := recover(); rec != nil {
+				done <- handlerResult{panic: &handlerPanic{value: rec, stack: debug.Stack()}}
+			}
+		}()
+
+		result, err := k.serveMethod(m, r)
+		done <- handlerResult{result: result, err: err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		if res.panic != nil {
+			panic(res.panic)
+		}
+		return res.result, res.err
+	case <-timer.C:
+	}
+
+	start := time.Now()
+	r.Log().Error("Handler has not returned in %s and it's abandoned, its goroutine is leaked", timeout)
+
+	go func() {
+		<-done
+		r.Log().Warning("Abandoned handler has returned after %s", timeout+time.Since(start))
+	}()
+
+	return nil, &Error{
+		Type:    "handlerTimeout",
+		Message: fmt.Sprintf("Method %q has not returned in %s", r.Method, timeout),
+	}
+}
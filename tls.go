@@ -0,0 +1,108 @@
+package kite
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// certReloader serves a certificate that can be read again from its files
+// without restarting the server.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex // protects cert
+	cert *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	return r, r.reload()
+}
+
+// reload reads the certificate files. The previous certificate is kept if
+// the files are not valid.
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+
+	return nil
+}
+
+// getCertificate is used as tls.Config.GetCertificate.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// RunTLS is like Run but it serves TLS connections with the certificate and
+// the private key in the given PEM files, so the kite can be connected with
+// wss:// and https:// URLs directly. The certificate is read again from the
+// files when the process receives SIGHUP or ReloadTLS is called, which lets
+// the certificate to be renewed without restarting the kite. Other settings
+// in Kite.TLSConfig are kept.
+func (k *Kite) RunTLS(certFile, keyFile string) {
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		k.Log.Fatal("Cannot load TLS certificate: %s", err.Error())
+	}
+
+	if k.TLSConfig == nil {
+		k.TLSConfig = &tls.Config{}
+	}
+
+	k.TLSConfig.GetCertificate = reloader.getCertificate
+	k.certReloader = reloader
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+
+		for {
+			select {
+			case <-hup:
+				if err := k.ReloadTLS(); err != nil {
+					k.Log.Error("Cannot reload TLS certificate: %s", err.Error())
+				}
+			case <-k.closeC:
+				return
+			}
+		}
+	}()
+
+	k.Run()
+}
+
+// ReloadTLS reads the certificate files given to RunTLS again. New
+// connections are served with the new certificate, existing ones are not
+// affected. If the files are not valid, an error is returned and the previous
+// certificate is kept.
+func (k *Kite) ReloadTLS() error {
+	if k.certReloader == nil {
+		return errors.New("kite is not run with RunTLS")
+	}
+
+	if err := k.certReloader.reload(); err != nil {
+		return err
+	}
+
+	k.Log.Info("TLS certificate is reloaded from %s", k.certReloader.certFile)
+	return nil
+}
@@ -0,0 +1,126 @@
+package kite
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCert returns a self-signed certificate and its private key in PEM
+// format for the hostname.
+func newTestCert(t *testing.T, hostname string, serial int64) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: hostname},
+		DNSNames:     []string{hostname},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
+
+// servedCertSerial connects to addr and returns the serial number of the
+// certificate it serves.
+func servedCertSerial(t *testing.T, addr, serverName string) int64 {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestRunTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kite-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	writeCert := func(serial int64) {
+		certPEM, keyPEM := newTestCert(t, "localhost", serial)
+		if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeCert(1)
+
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10042
+
+	if err := k.ReloadTLS(); err == nil {
+		t.Error("ReloadTLS succeeded without RunTLS")
+	}
+
+	go k.RunTLS(certFile, keyFile)
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	if serial := servedCertSerial(t, "127.0.0.1:10042", "localhost"); serial != 1 {
+		t.Fatalf("got certificate %d, want: 1", serial)
+	}
+
+	writeCert(2)
+	if err := k.ReloadTLS(); err != nil {
+		t.Fatal(err)
+	}
+
+	if serial := servedCertSerial(t, "127.0.0.1:10042", "localhost"); serial != 2 {
+		t.Fatalf("got certificate %d after reload, want: 2", serial)
+	}
+
+	// invalid files must not replace the certificate
+	if err := ioutil.WriteFile(keyFile, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k.ReloadTLS(); err == nil {
+		t.Error("ReloadTLS succeeded with an invalid key")
+	}
+
+	if serial := servedCertSerial(t, "127.0.0.1:10042", "localhost"); serial != 2 {
+		t.Errorf("got certificate %d after failed reload, want: 2", serial)
+	}
+}
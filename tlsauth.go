@@ -0,0 +1,46 @@
+package kite
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// RequireClientCerts makes the kite server ask the connecting kites for a
+// client certificate signed by one of the CAs in the pool. Kites without a
+// certificate can still connect and use other authentication types, the
+// "tls" authentication type succeeds only with a verified certificate.
+func (k *Kite) RequireClientCerts(cas *x509.CertPool) {
+	if k.TLSConfig == nil {
+		k.TLSConfig = &tls.Config{}
+	}
+
+	k.TLSConfig.ClientCAs = cas
+	k.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+}
+
+// AuthenticateFromTLS authenticates the user from the verified client
+// certificate of the connection. The common name in the subject of the
+// certificate is used as the username. Replace Kite.Authenticators["tls"] for
+// a different mapping.
+func (k *Kite) AuthenticateFromTLS(r *Request) error {
+	certs := r.Client.peerCertificates
+	if len(certs) == 0 {
+		return errors.New("No verified client certificate")
+	}
+
+	username := certs[0].Subject.CommonName
+	if username == "" {
+		return errors.New("Username is not present in certificate")
+	}
+
+	r.Username = username
+	return nil
+}
+
+// PeerCertificates returns the verified certificate chain of the remote kite
+// if it's connected with a client certificate. The first certificate is the
+// certificate of the remote kite.
+func (c *Client) PeerCertificates() []*x509.Certificate {
+	return c.peerCertificates
+}
@@ -0,0 +1,154 @@
+package kite
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// testCA issues the certificates for the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate signed by the CA for the server or the client
+// with the common name.
+func (ca *testCA) issue(t *testing.T, commonName string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	if usage == x509.ExtKeyUsageServerAuth {
+		template.DNSNames = []string{commonName}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestAuthenticateFromTLS(t *testing.T) {
+	ca := newTestCA(t, "kite test CA")
+
+	k := New("mtls", "0.0.1")
+	k.Config.Port = 10048
+	k.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "localhost", x509.ExtKeyUsageServerAuth)},
+	}
+	k.RequireClientCerts(ca.pool)
+	k.HandleFunc("whoami", func(r *Request) (interface{}, error) {
+		return r.Username, nil
+	})
+
+	// the certificates are read from the HTTP request of the session
+	k.OnConnect(func(c *Client) {
+		if _, ok := c.session.(interface {
+			Request() *http.Request
+		}); !ok {
+			t.Errorf("session %T doesn't have the HTTP request", c.session)
+		}
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	// whoami returns the username the client is authenticated with over
+	// mTLS with the certificate, if any.
+	whoami := func(cert *tls.Certificate) (string, error) {
+		config := &tls.Config{RootCAs: ca.pool, ServerName: "localhost"}
+		if cert != nil {
+			config.Certificates = []tls.Certificate{*cert}
+		}
+
+		client := New("client", "0.0.1")
+		c := client.NewClient("https://localhost:10048/kite")
+		c.TLSClientConfig = config
+		c.Auth = &Auth{Type: "tls"}
+
+		if err := c.DialTimeout(4 * time.Second); err != nil {
+			return "", err
+		}
+		defer c.Close()
+
+		result, err := c.TellWithTimeout("whoami", 4*time.Second)
+		if err != nil {
+			return "", err
+		}
+
+		return result.MustString(), nil
+	}
+
+	alice := ca.issue(t, "alice", x509.ExtKeyUsageClientAuth)
+	username, err := whoami(&alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if username != "alice" {
+		t.Errorf("got username %q, want: alice", username)
+	}
+
+	if username, err := whoami(nil); err == nil {
+		t.Errorf("authenticated as %q without a client certificate", username)
+	}
+
+	untrusted := newTestCA(t, "untrusted CA").issue(t, "alice", x509.ExtKeyUsageClientAuth)
+	if username, err := whoami(&untrusted); err == nil {
+		t.Errorf("authenticated as %q with a certificate of an untrusted CA", username)
+	}
+}
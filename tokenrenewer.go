@@ -94,8 +94,20 @@ func (t *TokenRenewer) sendRenewTokenSignal() {
 }
 
 // renewToken gets a new token from a kontrolClient, parses it and sets it as the token.
+// The current token is renewed if it's still valid, otherwise a new token is
+// requested for the remote kite.
 func (t *TokenRenewer) renewToken() error {
-	tokenString, err := t.localKite.GetToken(&t.client.Kite)
+	var tokenString string
+	var err error
+
+	if time.Now().UTC().Before(t.validUntil) {
+		tokenString, err = t.localKite.RenewToken(t.client.auth().Key)
+	}
+
+	if tokenString == "" {
+		tokenString, err = t.localKite.GetToken(&t.client.Kite)
+	}
+
 	if err != nil {
 		return err
 	}
@@ -104,6 +116,67 @@ func (t *TokenRenewer) renewToken() error {
 		return err
 	}
 
-	t.client.Auth.Key = tokenString
+	t.client.setAuthKey(tokenString)
 	return nil
 }
+
+// ErrTokenExpired is returned from Kite.AuthenticateFromToken when the token
+// has expired. Clients refresh their tokens when they receive this error.
+var ErrTokenExpired = errors.New("token is expired")
+
+// tokenRetryKey is the context key that marks a call that is sent again with a
+// refreshed token.
+type tokenRetryKey struct{}
+
+// isTokenExpired returns true if the call is rejected because of an expired
+// token.
+func isTokenExpired(err error) bool {
+	kiteErr, ok := err.(*Error)
+	if !ok {
+		return false
+	}
+
+	return kiteErr.Type == "authenticationError" && kiteErr.Message == ErrTokenExpired.Error()
+}
+
+// refreshToken gets a new token with the handler registered with
+// OnTokenExpire or from kontrol and sets it as the token.
+func (c *Client) refreshToken() error {
+	c.m.RLock()
+	handler := c.tokenExpireHandler
+	c.m.RUnlock()
+
+	if handler == nil {
+		handler = func() (string, error) {
+			return c.LocalKite.GetToken(&c.Kite)
+		}
+	}
+
+	token, err := handler()
+	if err != nil {
+		return err
+	}
+
+	c.setAuthKey(token)
+	return nil
+}
+
+// auth returns the credentials that are sent with the calls.
+func (c *Client) auth() *Auth {
+	c.authMu.RLock()
+	defer c.authMu.RUnlock()
+	return c.Auth
+}
+
+// setAuthKey replaces the key of the credentials. Auth is replaced instead of
+// modified, because the calls being sent may still be reading it.
+func (c *Client) setAuthKey(key string) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	auth := &Auth{Key: key}
+	if c.Auth != nil {
+		auth.Type = c.Auth.Type
+	}
+	c.Auth = auth
+}
@@ -0,0 +1,59 @@
+package kite
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenExpireRetry(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.Port = 10040
+
+	// the "old" token has expired
+	k.Authenticators["token"] = func(r *Request) error {
+		if r.Auth.Key != "new" {
+			return ErrTokenExpired
+		}
+
+		r.Username = "alice"
+		return nil
+	}
+
+	var calls int32
+	k.HandleFunc("echo", func(r *Request) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return r.Args.One().String()
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	c := New("exp", "0.0.1").NewClient("http://127.0.0.1:10040/kite")
+	c.Auth = &Auth{Type: "token", Key: "old"}
+	c.OnTokenExpire(func() (string, error) { return "new", nil })
+
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// the retry must send the arguments of the call, not the wrapped ones
+	result, err := c.TellWithTimeout("echo", 4*time.Second, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := result.MustString(); s != "hello" {
+		t.Errorf("got result %q, want: hello", s)
+	}
+
+	if key := c.auth().Key; key != "new" {
+		t.Errorf("got token %q, want: new", key)
+	}
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("handler is called %d times, want: 1", n)
+	}
+}
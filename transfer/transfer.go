@@ -0,0 +1,491 @@
+// Package transfer sends files between kites. A file is sent in chunks with
+// SendFile and received by a handler with ReceiveFile:
+//
+//	k.HandleFunc(transfer.DefaultMethod, func(r *kite.Request) (interface{}, error) {
+//		return transfer.ReceiveFile(r, &transfer.Options{Dir: "/var/uploads"})
+//	})
+//
+//	file, err := transfer.SendFile(c, "/tmp/photo.jpg", nil)
+//
+// The receiver keeps the partially received file next to the destination,
+// named after the file and its SHA-256 checksum with a ".part" suffix. A
+// transfer that is interrupted resumes from there when the same file is sent
+// again, after the sender has verified the received part. The checksum of the
+// file is verified before the file is moved to its destination.
+package transfer
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/koding/kite"
+)
+
+const (
+	// DefaultMethod is the method called by SendFile if Options.Method is
+	// empty.
+	DefaultMethod = "transfer.receiveFile"
+
+	// DefaultChunkSize is the size of the chunks if Options.ChunkSize is
+	// zero.
+	DefaultChunkSize = 256 << 10
+
+	// DefaultTimeout is the time to wait for each chunk if Options.Timeout
+	// is zero.
+	DefaultTimeout = time.Minute
+)
+
+// partSuffix is the suffix of the partially received files.
+const partSuffix = ".part"
+
+// Options are the options of SendFile and ReceiveFile. A nil *Options uses
+// the defaults.
+type Options struct {
+	// Method is the method of the remote kite that receives the file with
+	// ReceiveFile. Used by SendFile only.
+	Method string
+
+	// Dir is the directory the received files are saved in. Used by
+	// ReceiveFile only, os.TempDir() is used if empty.
+	Dir string
+
+	// ChunkSize is the size of the chunks the file is sent in. Used by
+	// SendFile only.
+	ChunkSize int
+
+	// Timeout is the time to wait for each chunk to be sent or received.
+	Timeout time.Duration
+
+	// Progress, if set, is called after each chunk with the number of bytes
+	// of the file that are transferred so far, including the bytes that
+	// were transferred before a resumption.
+	Progress func(done, total int64)
+}
+
+func (o *Options) method() string {
+	if o == nil || o.Method == "" {
+		return DefaultMethod
+	}
+	return o.Method
+}
+
+func (o *Options) dir() string {
+	if o == nil || o.Dir == "" {
+		return os.TempDir()
+	}
+	return o.Dir
+}
+
+func (o *Options) chunkSize() int {
+	if o == nil || o.ChunkSize <= 0 {
+		return DefaultChunkSize
+	}
+	return o.ChunkSize
+}
+
+func (o *Options) timeout() time.Duration {
+	if o == nil || o.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return o.Timeout
+}
+
+func (o *Options) progress(done, total int64) {
+	if o != nil && o.Progress != nil {
+		o.Progress(done, total)
+	}
+}
+
+// File describes a received file.
+type File struct {
+	// Name is the base name of the file.
+	Name string `json:"name"`
+
+	// Path is the path of the file on the receiver.
+	Path string `json:"path"`
+
+	// Size is the size of the file in bytes.
+	Size int64 `json:"size"`
+
+	// SHA256 is the hex encoded SHA-256 checksum of the file.
+	SHA256 string `json:"sha256"`
+}
+
+// message is the argument of the calls made by SendFile. A transfer is
+// started with a "start" call, which streams the resume point and returns the
+// received File when the transfer is finished. The chunks are sent with
+// "write" calls while the "start" call is running. A "reset" call discards
+// the received part if it's not a part of the sent file.
+type message struct {
+	Op     string `json:"op"`
+	ID     string `json:"id"`
+	Name   string `json:"name,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+	Data   []byte `json:"data,omitempty"`
+}
+
+// resume is the point the transfer resumes from, streamed by the "start"
+// call.
+type resume struct {
+	// Offset is the size of the part the receiver already has.
+	Offset int64 `json:"offset"`
+
+	// SHA256 is the hex encoded SHA-256 checksum of the part.
+	SHA256 string `json:"sha256"`
+}
+
+// receiver is a running transfer on the receiving side.
+type receiver struct {
+	client *kite.Client
+	writes chan *write
+	done   chan struct{}
+}
+
+// write is a chunk or a reset passed from a "write" or "reset" call to the
+// receiver.
+type write struct {
+	msg *message
+	err chan error
+}
+
+// receivers are the running transfers, keyed by their ids.
+var receivers = struct {
+	m map[string]*receiver
+	sync.Mutex
+}{m: make(map[string]*receiver)}
+
+// SendFile sends the file at path to the remote kite, which must receive it
+// with ReceiveFile in the handler of opts.Method. If the remote kite has a
+// part of the file from an interrupted transfer, the rest of the file is
+// sent if the part matches the beginning of the file, otherwise the whole
+// file is sent again. It returns the file as it's saved by the remote kite.
+func SendFile(c *kite.Client, path string, opts *Options) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return nil, fmt.Errorf("transfer: %s is a directory", path)
+	}
+
+	sum, err := checksum(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	size := info.Size()
+	method := opts.method()
+
+	s := c.Stream(method, &message{
+		Op:     "start",
+		ID:     id,
+		Name:   filepath.Base(path),
+		Size:   size,
+		SHA256: sum,
+	})
+
+	chunk, ok := s.Next()
+	if !ok {
+		if _, err := s.Result(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("transfer: receiver did not send the resume point")
+	}
+
+	// no more chunks are expected, but they must be consumed
+	go func() {
+		for range s.C() {
+		}
+	}()
+
+	var from resume
+	if err := chunk.Unmarshal(&from); err != nil {
+		return nil, err
+	}
+
+	// the part of the receiver must be the beginning of this file
+	offset := from.Offset
+	if offset > 0 {
+		prefix := ""
+		if offset <= size {
+			if prefix, err = checksum(f, offset); err != nil {
+				return nil, err
+			}
+		}
+
+		if prefix != from.SHA256 {
+			_, err := c.TellWithTimeout(method, opts.timeout(), &message{Op: "reset", ID: id})
+			if err != nil {
+				return nil, err
+			}
+
+			offset = 0
+		}
+	}
+
+	opts.progress(offset, size)
+
+	buf := make([]byte, opts.chunkSize())
+	for offset < size {
+		n, err := f.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		if n == 0 {
+			return nil, io.ErrUnexpectedEOF // the file is truncated
+		}
+
+		_, err = c.TellWithTimeout(method, opts.timeout(), &message{
+			Op:     "write",
+			ID:     id,
+			Offset: offset,
+			Data:   buf[:n],
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		offset += int64(n)
+		opts.progress(offset, size)
+	}
+
+	result, err := s.Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var file File
+	if err := result.Unmarshal(&file); err != nil {
+		return nil, err
+	}
+
+	return &file, nil
+}
+
+// ReceiveFile receives a file sent with SendFile. It must be called in the
+// handler of the method the file is sent to and its results must be
+// returned from the handler. The file is saved in opts.Dir with its
+// original base name, replacing the existing file.
+func ReceiveFile(r *kite.Request, opts *Options) (*File, error) {
+	var msg message
+	if err := r.Args.One().Unmarshal(&msg); err != nil {
+		return nil, err
+	}
+
+	switch msg.Op {
+	case "start":
+		return receive(r, &msg, opts)
+	case "write", "reset":
+		return nil, deliver(r, &msg)
+	default:
+		return nil, fmt.Errorf("transfer: unknown operation %q", msg.Op)
+	}
+}
+
+// receive runs the transfer started by msg until all chunks are received.
+func receive(r *kite.Request, msg *message, opts *Options) (*File, error) {
+	name := filepath.Base(msg.Name)
+	if msg.Name == "" || name == "." || name == ".." || name == string(filepath.Separator) {
+		return nil, fmt.Errorf("transfer: invalid file name %q", msg.Name)
+	}
+
+	// the checksum is a part of the name of the part file
+	if sum, err := hex.DecodeString(msg.SHA256); msg.ID == "" || msg.Size < 0 || err != nil || len(sum) != sha256.Size {
+		return nil, errors.New("transfer: invalid transfer")
+	}
+
+	path := filepath.Join(opts.dir(), name)
+	part := path + "." + msg.SHA256 + partSuffix
+
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// resume from the end of the partially received file
+	hash := sha256.New()
+	offset, err := io.Copy(hash, f)
+	if err != nil {
+		return nil, err
+	}
+
+	reset := func() error {
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		hash.Reset()
+		offset = 0
+		return nil
+	}
+
+	// a complete part must be the file itself
+	if offset > msg.Size || offset == msg.Size && hex.EncodeToString(hash.Sum(nil)) != msg.SHA256 {
+		if err := reset(); err != nil {
+			return nil, err
+		}
+	}
+
+	t := &receiver{
+		client: r.Client,
+		writes: make(chan *write),
+		done:   make(chan struct{}),
+	}
+	defer close(t.done)
+
+	receivers.Lock()
+	if _, ok := receivers.m[msg.ID]; ok {
+		receivers.Unlock()
+		return nil, errors.New("transfer: transfer is already running")
+	}
+	receivers.m[msg.ID] = t
+	receivers.Unlock()
+
+	defer func() {
+		receivers.Lock()
+		delete(receivers.m, msg.ID)
+		receivers.Unlock()
+	}()
+
+	if err := r.Stream.Send(&resume{Offset: offset, SHA256: hex.EncodeToString(hash.Sum(nil))}); err != nil {
+		return nil, err
+	}
+
+	opts.progress(offset, msg.Size)
+
+	for offset < msg.Size {
+		select {
+		case w := <-t.writes:
+			if w.msg.Op == "reset" {
+				err := reset()
+				w.err <- err
+				if err != nil {
+					return nil, err
+				}
+
+				opts.progress(offset, msg.Size)
+				continue
+			}
+
+			if w.msg.Offset != offset {
+				w.err <- fmt.Errorf("transfer: got chunk at offset %d, want %d", w.msg.Offset, offset)
+				continue
+			}
+
+			if int64(len(w.msg.Data)) > msg.Size-offset {
+				w.err <- errors.New("transfer: chunk exceeds the file size")
+				continue
+			}
+
+			if _, err := f.Write(w.msg.Data); err != nil {
+				w.err <- err
+				return nil, err
+			}
+
+			hash.Write(w.msg.Data)
+			offset += int64(len(w.msg.Data))
+			w.err <- nil
+
+			opts.progress(offset, msg.Size)
+		case <-time.After(opts.timeout()):
+			return nil, &kite.Error{
+				Type:    "timeout",
+				Message: fmt.Sprintf("No chunk of %q is received in %s", name, opts.timeout()),
+			}
+		}
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != msg.SHA256 {
+		f.Close()
+		os.Remove(part)
+
+		return nil, &kite.Error{
+			Type:    "checksumError",
+			Message: fmt.Sprintf("Checksum of %q is %s, want %s", name, sum, msg.SHA256),
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(part, path); err != nil {
+		return nil, err
+	}
+
+	return &File{
+		Name:   name,
+		Path:   path,
+		Size:   msg.Size,
+		SHA256: msg.SHA256,
+	}, nil
+}
+
+// deliver passes the chunk in msg to the running transfer.
+func deliver(r *kite.Request, msg *message) error {
+	receivers.Lock()
+	t, ok := receivers.m[msg.ID]
+	receivers.Unlock()
+
+	// only the kite that has started the transfer can send its chunks
+	if !ok || t.client != r.Client {
+		return errors.New("transfer: transfer is not found")
+	}
+
+	w := &write{msg: msg, err: make(chan error, 1)}
+
+	select {
+	case t.writes <- w:
+	case <-t.done:
+		return errors.New("transfer: transfer is finished")
+	}
+
+	return <-w.err
+}
+
+// checksum returns the hex encoded SHA-256 checksum of the first n bytes of
+// f.
+func checksum(f io.ReaderAt, n int64) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(f, 0, n)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// randomID returns a random transfer id.
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
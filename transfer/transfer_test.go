@@ -0,0 +1,123 @@
+package transfer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/koding/kite"
+)
+
+func TestSendFile(t *testing.T) {
+	src, err := ioutil.TempDir("", "transfer-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+
+	dst, err := ioutil.TempDir("", "transfer-dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	data := make([]byte, 600<<10)
+	rand.Read(data)
+
+	path := filepath.Join(src, "data.bin")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	k := kite.New("testkite", "0.0.1")
+	k.Config.Port = 10023
+	k.Config.DisableAuthentication = true
+	k.HandleFunc(DefaultMethod, func(r *kite.Request) (interface{}, error) {
+		return ReceiveFile(r, &Options{Dir: dst})
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := kite.New("exp", "0.0.1")
+	c := e.NewClient("http://127.0.0.1:10023/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var first int64 = -1
+	opts := &Options{
+		ChunkSize: 64 << 10,
+		Progress: func(done, total int64) {
+			if first == -1 {
+				first = done
+			}
+		},
+	}
+
+	sum := sha256.Sum256(data)
+
+	// an interrupted transfer is resumed
+	part := filepath.Join(dst, "data.bin."+hex.EncodeToString(sum[:])+partSuffix)
+	if err := ioutil.WriteFile(part, data[:100<<10], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := SendFile(c, path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != 100<<10 {
+		t.Errorf("transfer started from %d, want %d", first, 100<<10)
+	}
+
+	got, err := ioutil.ReadFile(file.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Error("received file is different")
+	}
+
+	if _, err := os.Stat(part); !os.IsNotExist(err) {
+		t.Errorf("part file is not removed: %v", err)
+	}
+
+	// a part that is not the beginning of the file is sent again
+	corrupted := make([]byte, 100<<10)
+	if err := ioutil.WriteFile(part, corrupted, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	first = -1
+	file, err = SendFile(c, path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != 0 {
+		t.Errorf("transfer with a corrupted part started from %d, want 0", first)
+	}
+
+	got, err = ioutil.ReadFile(file.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Error("received file is different after the corrupted part")
+	}
+
+	if _, err := os.Stat(part); !os.IsNotExist(err) {
+		t.Errorf("corrupted part file is not removed: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package kite
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+
+	"github.com/koding/kite/config"
+	"github.com/koding/kite/sockjsclient"
+)
+
+// Transport is a message oriented, bidirectional connection between two
+// kites. Each message is a single dnode message. sockjs.Session and the
+// sessions in sockjsclient package satisfy this interface, so they can be used
+// directly.
+type Transport interface {
+	// ID returns the unique id of the underlying session.
+	ID() string
+
+	// Send sends a single message to the remote side.
+	Send(msg string) error
+
+	// Recv blocks until a message is received from the remote side.
+	Recv() (string, error)
+
+	// Close closes the connection with the given status code and reason.
+	Close(status uint32, reason string) error
+}
+
+// BatchSender is implemented by the Transports that can send several messages
+// in a single frame. The messages are sent one by one over the other
+// Transports.
+type BatchSender interface {
+	SendBatch(msgs []string) error
+}
+
+// TransportDialer opens a new Transport to the kite listening on
+// opts.BaseURL. It's used by Client to connect to remote kites.
+type TransportDialer func(opts *sockjsclient.DialOptions) (Transport, error)
+
+// dialWebsocket is the TransportDialer for config.WebSocket.
+func dialWebsocket(opts *sockjsclient.DialOptions) (Transport, error) {
+	return sockjsclient.ConnectWebsocketSession(opts)
+}
+
+// dialXHR is the TransportDialer for config.XHRPolling.
+func dialXHR(opts *sockjsclient.DialOptions) (Transport, error) {
+	return sockjsclient.NewXHRSession(opts)
+}
+
+// transportDialer returns the dialer for the given config transport.
+func transportDialer(t config.Transport) (TransportDialer, error) {
+	switch t {
+	case config.WebSocket:
+		return dialWebsocket, nil
+	case config.XHRPolling:
+		return dialXHR, nil
+	default:
+		return nil, fmt.Errorf("Connection transport is not known '%v'", t)
+	}
+}
+
+// ServeTransport serves the kite over the given connected Transport. It blocks
+// until the transport is closed or the remote side disconnects. It can be
+// used to plug alternative transports (raw TCP, HTTP long-polling, etc.) into
+// the kite server without going through the built-in SockJS handler.
+func (k *Kite) ServeTransport(t Transport) {
+	defer t.Close(0, "")
+
+	// This Client also handles the connected client.
+	// Since both sides can send/receive messages the client code is reused here.
+	c := k.NewClient("")
+	c.session = t
+	c.peerCertificates = peerCertificates(t)
+	c.setState(Connected)
+
+	if !k.addClient(c) {
+		k.Log.Debug("Rejecting session %s, kite is shutting down", t.ID())
+		return
+	}
+	defer k.removeClient(c)
+
+	go c.sendHub()
+	c.wg.Add(1) // with sendHub we added a new listener
+
+	k.callOnConnectHandlers(c)
+
+	// Run after methods are registered and delegate is set
+	c.readLoop()
+
+	c.setState(Disconnected)
+	c.callOnDisconnectHandlers()
+	k.callOnDisconnectHandlers(c)
+}
+
+// peerCertificates returns the verified client certificates of the transport
+// if it's accepted from a TLS connection with a client certificate.
+func peerCertificates(t Transport) []*x509.Certificate {
+	r, ok := t.(interface {
+		Request() *http.Request
+	})
+	if !ok {
+		return nil
+	}
+
+	req := r.Request()
+	if req == nil || req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+
+	return req.TLS.VerifiedChains[0]
+}
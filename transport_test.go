@@ -0,0 +1,125 @@
+package kite
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/koding/kite/sockjsclient"
+)
+
+// pipeTransport is one end of an in-memory Transport pair.
+type pipeTransport struct {
+	id     string
+	in     <-chan string
+	out    chan<- string
+	closed chan struct{}
+	once   *sync.Once
+}
+
+// newPipe returns the two connected ends of an in-memory Transport.
+func newPipe() (a, b *pipeTransport) {
+	ab, ba := make(chan string), make(chan string)
+	closed := make(chan struct{})
+	once := new(sync.Once)
+
+	a = &pipeTransport{id: "a", in: ba, out: ab, closed: closed, once: once}
+	b = &pipeTransport{id: "b", in: ab, out: ba, closed: closed, once: once}
+	return a, b
+}
+
+var errPipeClosed = errors.New("pipe is closed")
+
+func (p *pipeTransport) ID() string { return p.id }
+
+func (p *pipeTransport) Send(msg string) error {
+	select {
+	case p.out <- msg:
+		return nil
+	case <-p.closed:
+		return errPipeClosed
+	}
+}
+
+func (p *pipeTransport) Recv() (string, error) {
+	select {
+	case msg := <-p.in:
+		return msg, nil
+	case <-p.closed:
+		return "", errPipeClosed
+	}
+}
+
+func (p *pipeTransport) Close(status uint32, reason string) error {
+	p.once.Do(func() { close(p.closed) })
+	return nil
+}
+
+func TestServeTransport(t *testing.T) {
+	k := New("pipe", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.HandleFunc("square", func(r *Request) (interface{}, error) {
+		a := r.Args.One().MustFloat64()
+		return a * a, nil
+	})
+
+	served := make(chan struct{})
+
+	c := New("exp", "0.0.1").NewClient("http://pipe/kite")
+	c.Dialer = func(opts *sockjsclient.DialOptions) (Transport, error) {
+		if opts.BaseURL != "http://pipe/kite" {
+			t.Errorf("got URL %q, want: http://pipe/kite", opts.BaseURL)
+		}
+
+		client, server := newPipe()
+		go func() {
+			k.ServeTransport(server)
+			close(served)
+		}()
+
+		return client, nil
+	}
+
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := c.TellWithTimeout("square", 4*time.Second, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := result.MustFloat64(); n != 9 {
+		t.Errorf("got %v, want: 9", n)
+	}
+
+	c.Close()
+
+	select {
+	case <-served:
+	case <-time.After(4 * time.Second):
+		t.Fatal("ServeTransport didn't return after the client is closed")
+	}
+}
+
+// blackholeTransport is a Transport to a dead peer, it accepts the messages
+// but never answers.
+type blackholeTransport struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (b *blackholeTransport) ID() string { return "blackhole" }
+
+func (b *blackholeTransport) Send(msg string) error { return nil }
+
+func (b *blackholeTransport) Recv() (string, error) {
+	<-b.closed
+	return "", errPipeClosed
+}
+
+func (b *blackholeTransport) Close(status uint32, reason string) error {
+	b.once.Do(func() { close(b.closed) })
+	return nil
+}
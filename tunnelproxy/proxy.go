@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -43,7 +44,8 @@ type Proxy struct {
 	privKey string
 
 	// Holds registered kites. Keys are kite IDs.
-	kites map[string]*PrivateKite
+	kites   map[string]*PrivateKite
+	kitesMu sync.Mutex // protects kites
 
 	mux *http.ServeMux
 
@@ -81,7 +83,9 @@ func New(conf *config.Config, version, pubKey, privKey string) *Proxy {
 
 	// Remove URL from the map when PrivateKite disconnects.
 	k.OnDisconnect(func(r *kite.Client) {
+		p.kitesMu.Lock()
 		delete(p.kites, r.Kite.ID)
+		p.kitesMu.Unlock()
 	})
 
 	return p
@@ -111,14 +115,31 @@ func (s *Proxy) ReadyNotify() chan bool {
 
 func (p *Proxy) Close() {
 	p.listener.Close()
+
+	p.kitesMu.Lock()
+	kites := make([]*PrivateKite, 0, len(p.kites))
 	for _, k := range p.kites {
+		kites = append(kites, k)
+	}
+	p.kitesMu.Unlock()
+
+	for _, k := range kites {
 		k.Close()
-		for _, t := range k.tunnels {
+		for _, t := range k.allTunnels() {
 			t.Close()
 		}
 	}
 }
 
+// privateKite returns the registered kite with the ID.
+func (p *Proxy) privateKite(kiteID string) (*PrivateKite, bool) {
+	p.kitesMu.Lock()
+	defer p.kitesMu.Unlock()
+
+	k, ok := p.kites[kiteID]
+	return k, ok
+}
+
 func (p *Proxy) Start() {
 	go p.Run()
 	<-p.readyC
@@ -154,7 +175,9 @@ func (p *Proxy) listenAndServe() error {
 }
 
 func (p *Proxy) handleRegister(r *kite.Request) (interface{}, error) {
+	p.kitesMu.Lock()
 	p.kites[r.Client.ID] = newPrivateKite(r.Client)
+	p.kitesMu.Unlock()
 
 	proxyURL := url.URL{
 		Scheme:   "http",
@@ -170,7 +193,7 @@ func (p *Proxy) handleRegister(r *kite.Request) (interface{}, error) {
 func (p *Proxy) handleProxy(session sockjs.Session, req *http.Request) {
 	kiteID := req.URL.Query().Get("kiteID")
 
-	client, ok := p.kites[kiteID]
+	client, ok := p.privateKite(kiteID)
 	if !ok {
 		p.Kite.Log.Error("Remote kite is not found: %s", req.URL.String())
 		return
@@ -234,15 +257,16 @@ func (p *Proxy) handleTunnel(session sockjs.Session, req *http.Request) {
 	kiteID := token.Claims["sub"].(string)
 	seq := uint64(token.Claims["seq"].(float64))
 
-	client, ok := p.kites[kiteID]
+	client, ok := p.privateKite(kiteID)
 	if !ok {
 		p.Kite.Log.Error("Remote kite is not found: %s", kiteID)
 		return
 	}
 
-	tunnel, ok := client.tunnels[seq]
+	tunnel, ok := client.tunnel(seq)
 	if !ok {
 		p.Kite.Log.Error("Tunnel not found: %d", seq)
+		return
 	}
 
 	go tunnel.Run(session)
@@ -259,7 +283,8 @@ type PrivateKite struct {
 	*kite.Client
 
 	// Connections to kites behind the proxy. Keys are kite IDs.
-	tunnels map[uint64]*Tunnel
+	tunnels   map[uint64]*Tunnel
+	tunnelsMu sync.Mutex // protects tunnels
 
 	// Last tunnel number
 	seq uint64
@@ -281,13 +306,39 @@ func (k *PrivateKite) newTunnel(local sockjs.Session) *Tunnel {
 	}
 
 	// Add to map.
+	k.tunnelsMu.Lock()
 	k.tunnels[t.id] = t
+	k.tunnelsMu.Unlock()
 
 	// Delete from map on close.
 	go func() {
 		<-t.CloseNotify()
+		k.tunnelsMu.Lock()
 		delete(k.tunnels, t.id)
+		k.tunnelsMu.Unlock()
 	}()
 
 	return t
 }
+
+// tunnel returns the tunnel with the sequence number.
+func (k *PrivateKite) tunnel(seq uint64) (*Tunnel, bool) {
+	k.tunnelsMu.Lock()
+	defer k.tunnelsMu.Unlock()
+
+	t, ok := k.tunnels[seq]
+	return t, ok
+}
+
+// allTunnels returns the open tunnels.
+func (k *PrivateKite) allTunnels() []*Tunnel {
+	k.tunnelsMu.Lock()
+	defer k.tunnelsMu.Unlock()
+
+	tunnels := make([]*Tunnel, 0, len(k.tunnels))
+	for _, t := range k.tunnels {
+		tunnels = append(tunnels, t)
+	}
+
+	return tunnels
+}
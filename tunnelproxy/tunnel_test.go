@@ -0,0 +1,80 @@
+package tunnelproxy
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/igm/sockjs-go.v2/sockjs"
+)
+
+// closedSession is a sockjs.Session that can only be closed.
+type closedSession struct {
+	sockjs.Session
+}
+
+func (closedSession) Close(status uint32, reason string) error { return nil }
+
+func TestPrivateKiteTunnels(t *testing.T) {
+	k := newPrivateKite(nil)
+
+	const n = 50
+	tunnels := make([]*Tunnel, n)
+
+	// the tunnels are opened by the proxy handlers concurrently while the
+	// tunnel handlers look them up
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+
+		go func(i int) {
+			defer wg.Done()
+			tunnels[i] = k.newTunnel(closedSession{})
+		}(i)
+
+		go func(i int) {
+			defer wg.Done()
+			k.tunnel(uint64(i))
+			k.allTunnels()
+		}(i)
+	}
+	wg.Wait()
+
+	ids := make(map[uint64]bool)
+	for _, tunnel := range tunnels {
+		if ids[tunnel.id] {
+			t.Fatalf("tunnel id %d is used twice", tunnel.id)
+		}
+		ids[tunnel.id] = true
+
+		if got, ok := k.tunnel(tunnel.id); !ok || got != tunnel {
+			t.Errorf("tunnel %d is not found", tunnel.id)
+		}
+	}
+
+	if _, ok := k.tunnel(n + 1); ok {
+		t.Error("unknown tunnel is found")
+	}
+
+	if got := len(k.allTunnels()); got != n {
+		t.Errorf("got %d tunnels, want: %d", got, n)
+	}
+
+	for _, tunnel := range tunnels {
+		tunnel.Close()
+	}
+
+	// the closed tunnels are removed in the background
+	deadline := time.Now().Add(4 * time.Second)
+	for len(k.allTunnels()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d closed tunnels are not removed", len(k.allTunnels()))
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, ok := k.tunnel(tunnels[0].id); ok {
+		t.Error("closed tunnel is found")
+	}
+}
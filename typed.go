@@ -0,0 +1,97 @@
+package kite
+
+import (
+	"fmt"
+	"reflect"
+)
+
+var (
+	requestType = reflect.TypeOf((*Request)(nil))
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// typedHandler is a Handler that decodes the argument of the request into the
+// type of the argument of a typed handler function.
+type typedHandler struct {
+	fn         reflect.Value
+	argsType   reflect.Type
+	resultType reflect.Type
+}
+
+// TypedHandler returns a Handler that calls fn, which must have the signature:
+//
+//	func(r *kite.Request, args MyArgs) (MyResult, error)
+//
+// The only argument of the request, r.Args.One(), is unmarshaled into MyArgs
+// and validated before fn is called. If it can't be decoded the caller gets an
+// "argumentError", if it's invalid a "validationError", and fn is not called.
+// See Validator for the validation. TypedHandler panics if fn doesn't
+// have the signature above.
+func TypedHandler(fn interface{}) Handler {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+
+	if !isTypedHandler(t) {
+		panic(fmt.Sprintf("kite: invalid typed handler %s, want func(*kite.Request, T) (R, error)", t))
+	}
+
+	h := &typedHandler{
+		fn:       v,
+		argsType: t.In(1),
+	}
+
+	if t.Out(0).Kind() != reflect.Interface {
+		h.resultType = t.Out(0)
+	}
+
+	return h
+}
+
+// isTypedHandler returns true if t is the type of a typed handler function.
+func isTypedHandler(t reflect.Type) bool {
+	return t.Kind() == reflect.Func && t.NumIn() == 2 && t.NumOut() == 2 &&
+		t.In(0) == requestType && t.Out(1) == errorType
+}
+
+// HandleTyped registers the typed handler fn for the given method. See
+// TypedHandler for the signature of fn. The argument and the result types of
+// fn are included in the "kite.describe" output.
+func (k *Kite) HandleTyped(method string, fn interface{}, opts ...MethodOption) *Method {
+	return k.Handle(method, TypedHandler(fn), opts...)
+}
+
+// HandleTyped registers the typed handler fn for the method
+// "<namespace>.<method>". See TypedHandler for the signature of fn.
+func (n *Namespace) HandleTyped(method string, fn interface{}, opts ...MethodOption) *Method {
+	return n.Handle(method, TypedHandler(fn), opts...)
+}
+
+// ServeKite decodes the argument and calls the handler function.
+func (h *typedHandler) ServeKite(r *Request) (interface{}, error) {
+	args, err := r.Args.SliceOfLength(1)
+	if err != nil {
+		return nil, &Error{
+			Type:    "argumentError",
+			Message: err.Error(),
+		}
+	}
+
+	v := reflect.New(h.argsType)
+	if err := args[0].Unmarshal(v.Interface()); err != nil {
+		return nil, &Error{
+			Type:    "argumentError",
+			Message: err.Error(),
+		}
+	}
+
+	if err := r.LocalKite.validateArgs(v.Interface()); err != nil {
+		return nil, err
+	}
+
+	out := h.fn.Call([]reflect.Value{reflect.ValueOf(r), v.Elem()})
+	if err, _ := out[1].Interface().(error); err != nil {
+		return nil, err
+	}
+
+	return out[0].Interface(), nil
+}
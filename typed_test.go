@@ -0,0 +1,60 @@
+package kite
+
+import (
+	"testing"
+	"time"
+)
+
+type addArgs struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func TestHandleTyped(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10028
+
+	m := k.HandleTyped("add", func(r *Request, args addArgs) (int, error) {
+		return args.A + args.B, nil
+	})
+
+	if m.argsType == nil || m.resultType == nil {
+		t.Fatal("types of the typed handler are not saved")
+	}
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := New("exp", "0.0.1")
+	c := e.NewClient("http://127.0.0.1:10028/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	result, err := c.TellWithTimeout("add", 4*time.Second, addArgs{A: 1, B: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := result.MustFloat64(); n != 3 {
+		t.Errorf("got %v, want: 3", n)
+	}
+
+	_, err = c.TellWithTimeout("add", 4*time.Second, "not an object")
+	if kiteErr, ok := err.(*Error); !ok || kiteErr.Type != "argumentError" {
+		t.Fatalf("Want argumentError, got: %v", err)
+	}
+}
+
+func TestTypedHandler_InvalidSignature(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("TypedHandler should panic for invalid signatures")
+		}
+	}()
+
+	TypedHandler(func(r *Request) (interface{}, error) { return nil, nil })
+}
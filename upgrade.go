@@ -0,0 +1,185 @@
+package kite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Environment variables that are passed to the new process of an upgrade.
+const (
+	upgradeFDEnv    = "KITE_UPGRADE_FD"    // fd of the inherited listener
+	upgradeReadyEnv = "KITE_UPGRADE_READY" // fd to close when the new process is serving
+	upgradeIDEnv    = "KITE_UPGRADE_ID"    // id of the old kite, kept by the new one
+)
+
+// UpgradeTimeout is the time the new process has to start serving, and the
+// old one has to finish its running requests during an upgrade.
+var UpgradeTimeout = 30 * time.Second
+
+// SetupUpgradeHandler makes the kite upgrade itself when the process receives
+// SIGUSR2. See Upgrade. It can't be used together with SetupSignalHandler,
+// which uses the same signal.
+func (k *Kite) SetupUpgradeHandler() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR2)
+
+	go func() {
+		defer signal.Stop(c)
+
+		for {
+			select {
+			case <-c:
+				if err := k.Upgrade(); err != nil {
+					k.Log.Error("Cannot upgrade the kite: %s", err)
+					continue
+				}
+				os.Exit(0)
+			case <-k.closeC:
+				return
+			}
+		}
+	}()
+}
+
+// Upgrade replaces the running kite with a new process of the executable,
+// which is started with the same arguments and inherits the listener, so no
+// connection is refused during the upgrade. The new kite keeps the id of the
+// old one, so its kontrol registration continues. After the new process has
+// started serving, the old kite is shut down gracefully: its running requests
+// are finished and the clients are disconnected, which reconnect to the new
+// process. The caller should exit the process when Upgrade returns nil. If the
+// new process can't be started, an error is returned and the old kite keeps
+// serving.
+func (k *Kite) Upgrade() error {
+	l, ok := k.rawListener.(interface {
+		File() (*os.File, error)
+	})
+	if !ok {
+		return errors.New("kite is not serving on a TCP listener")
+	}
+
+	f, err := l.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		readyW.Close()
+		return err
+	}
+
+	// the files are numbered from 3 in the new process
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{f, readyW}
+	cmd.Env = append(os.Environ(),
+		upgradeFDEnv+"=3",
+		upgradeReadyEnv+"=4",
+		upgradeIDEnv+"="+k.Id,
+	)
+
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return err
+	}
+
+	k.Log.Info("Started the new process %d, waiting for it to serve", cmd.Process.Pid)
+
+	// the pipe is closed by the new process when it's serving, or when it
+	// exits
+	served := make(chan bool, 1)
+	go func() {
+		b := make([]byte, 1)
+		n, _ := ready.Read(b)
+		served <- n == 1
+	}()
+
+	select {
+	case ok := <-served:
+		if !ok {
+			cmd.Wait()
+			return errors.New("new process has exited before serving")
+		}
+	case <-time.After(UpgradeTimeout):
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("new process has not served in %s", UpgradeTimeout)
+	}
+
+	// the new process is not waited, it's adopted by init when we exit
+	cmd.Process.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), UpgradeTimeout)
+	defer cancel()
+
+	// the new process keeps the registiration with the same id
+	return k.shutdown(ctx, false)
+}
+
+// upgradeListener returns the listener inherited from the old process of an
+// upgrade, or nil if the process is not started by Upgrade.
+func upgradeListener() (net.Listener, error) {
+	fd, err := strconv.Atoi(os.Getenv(upgradeFDEnv))
+	if err != nil {
+		return nil, nil
+	}
+	os.Unsetenv(upgradeFDEnv)
+
+	return inheritListener(os.NewFile(uintptr(fd), "kite-listener"))
+}
+
+// inheritListener returns a listener that uses the listening socket of f and
+// closes f.
+func inheritListener(f *os.File) (net.Listener, error) {
+	defer f.Close()
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot use the listener of the old process: %s", err)
+	}
+
+	return l, nil
+}
+
+// upgradeID returns the id of the kite that has started this process with
+// Upgrade, empty if there is none.
+func upgradeID() string {
+	id := os.Getenv(upgradeIDEnv)
+	os.Unsetenv(upgradeIDEnv)
+	return id
+}
+
+// notifyUpgradeReady tells the old process of an upgrade that this process is
+// serving.
+func notifyUpgradeReady() {
+	fd, err := strconv.Atoi(os.Getenv(upgradeReadyEnv))
+	if err != nil {
+		return
+	}
+	os.Unsetenv(upgradeReadyEnv)
+
+	notifyReady(os.NewFile(uintptr(fd), "kite-upgrade-ready"))
+}
+
+// notifyReady writes the ready notification to f and closes it.
+func notifyReady(f *os.File) {
+	f.Write([]byte{1})
+	f.Close()
+}
@@ -0,0 +1,142 @@
+package kite
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// upgradeChildEnv makes the test binary run as the new process of
+// TestUpgrade instead of running the tests.
+const upgradeChildEnv = "KITE_TEST_UPGRADE_CHILD"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(upgradeChildEnv) != "" {
+		runUpgradeChild()
+		return
+	}
+
+	os.Exit(m.Run())
+}
+
+// runUpgradeChild serves the kite of TestUpgrade on the inherited listener
+// until it's told to exit.
+func runUpgradeChild() {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+
+	k.HandleFunc("process", func(r *Request) (interface{}, error) {
+		return map[string]interface{}{"pid": os.Getpid(), "id": k.Id}, nil
+	})
+
+	exit := make(chan struct{})
+	k.HandleFunc("exit", func(r *Request) (interface{}, error) {
+		close(exit)
+		return nil, nil
+	})
+
+	go k.Run()
+
+	select {
+	case <-exit:
+		time.Sleep(100 * time.Millisecond) // let the response be sent
+	case <-time.After(30 * time.Second):
+	}
+
+	os.Exit(0)
+}
+
+func TestUpgrade(t *testing.T) {
+	os.Setenv(upgradeChildEnv, "1")
+	defer os.Unsetenv(upgradeChildEnv)
+
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10047
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	if err := k.Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the new process serves on the same listener
+	c := New("exp", "0.0.1").NewClient("http://127.0.0.1:10047/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	result, err := c.TellWithTimeout("process", 4*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var process struct {
+		Pid int    `json:"pid"`
+		ID  string `json:"id"`
+	}
+	if err := result.Unmarshal(&process); err != nil {
+		t.Fatal(err)
+	}
+
+	if process.Pid == os.Getpid() {
+		t.Error("call is served by the old process")
+	}
+
+	if process.ID != k.Id {
+		t.Errorf("got id %q, want: %q", process.ID, k.Id)
+	}
+
+	if _, err := c.TellWithTimeout("exit", 4*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpgradeListener(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the file is closed by inheritListener
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inherited, err := inheritListener(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inherited.Close()
+
+	if inherited.Addr().String() != l.Addr().String() {
+		t.Errorf("got listener on %s, want: %s", inherited.Addr(), l.Addr())
+	}
+
+	// the process is not started by Upgrade
+	if l, err := upgradeListener(); l != nil || err != nil {
+		t.Errorf("listener is inherited without an upgrade: %v, %v", l, err)
+	}
+}
+
+func TestNotifyUpgradeReady(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// the file is closed by notifyReady
+	notifyReady(w)
+
+	b := make([]byte, 1)
+	if n, _ := r.Read(b); n != 1 {
+		t.Error("ready notification is not received")
+	}
+}
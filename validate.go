@@ -0,0 +1,87 @@
+package kite
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Validator is implemented by the arguments of typed handlers that can check
+// themselves. Validate is called after the argument is decoded and before the
+// handler is called. Returning a FieldError or ValidationErrors tells the
+// caller which fields are invalid.
+type Validator interface {
+	Validate() error
+}
+
+// FieldError is the error of an invalid field of an argument.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationErrors is a list of invalid fields of an argument.
+type ValidationErrors []*FieldError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, ", ")
+}
+
+// validateArgs validates the decoded argument of a typed handler with
+// Kite.ValidateArgs and the Validator interface.
+func (k *Kite) validateArgs(args interface{}) *Error {
+	if k.ValidateArgs != nil {
+		if err := k.ValidateArgs(args); err != nil {
+			return validationError(err)
+		}
+	}
+
+	if v, ok := args.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return validationError(err)
+		}
+	}
+
+	return nil
+}
+
+// validationError converts the error of a validation to the error that is
+// sent to the caller. The invalid fields are listed in the "fields" detail
+// with their messages.
+func validationError(err error) *Error {
+	if kiteErr, ok := err.(*Error); ok {
+		return kiteErr
+	}
+
+	kiteErr := &Error{
+		Type:    "validationError",
+		Message: err.Error(),
+	}
+
+	var fields ValidationErrors
+	switch err := err.(type) {
+	case *FieldError:
+		fields = ValidationErrors{err}
+	case ValidationErrors:
+		fields = err
+	}
+
+	if len(fields) != 0 {
+		m := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			m[f.Field] = f.Message
+		}
+
+		kiteErr.Details = map[string]interface{}{"fields": m}
+	}
+
+	return kiteErr
+}
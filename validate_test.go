@@ -0,0 +1,71 @@
+package kite
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type divArgs struct {
+	A float64 `json:"a"`
+	B float64 `json:"b"`
+}
+
+func (a *divArgs) Validate() error {
+	if a.B == 0 {
+		return &FieldError{Field: "b", Message: "must not be zero"}
+	}
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10029
+	k.ValidateArgs = func(args interface{}) error {
+		if a, ok := args.(*divArgs); ok && a.A < 0 {
+			return errors.New("negative dividend")
+		}
+		return nil
+	}
+
+	k.HandleTyped("div", func(r *Request, args divArgs) (float64, error) {
+		return args.A / args.B, nil
+	})
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := New("exp", "0.0.1")
+	c := e.NewClient("http://127.0.0.1:10029/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	result, err := c.TellWithTimeout("div", 4*time.Second, divArgs{A: 6, B: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := result.MustFloat64(); n != 3 {
+		t.Errorf("got %v, want: 3", n)
+	}
+
+	_, err = c.TellWithTimeout("div", 4*time.Second, divArgs{A: 6})
+	kiteErr, ok := err.(*Error)
+	if !ok || kiteErr.Type != "validationError" {
+		t.Fatalf("Want validationError, got: %v", err)
+	}
+
+	fields, _ := kiteErr.Details["fields"].(map[string]interface{})
+	if fields["b"] != "must not be zero" {
+		t.Errorf("wrong details: %v", kiteErr.Details)
+	}
+
+	_, err = c.TellWithTimeout("div", 4*time.Second, divArgs{A: -1, B: 1})
+	if kiteErr, ok := err.(*Error); !ok || kiteErr.Type != "validationError" {
+		t.Fatalf("Want validationError, got: %v", err)
+	}
+}
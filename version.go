@@ -0,0 +1,84 @@
+package kite
+
+import (
+	"context"
+	"fmt"
+)
+
+// versionKey is the context key of the method version set with WithVersion.
+type versionKey struct{}
+
+// Version registers the method as the given version. A kite may register
+// many versions of a method with the same name. Calls are dispatched to the
+// version requested by the caller with WithVersion, and to the latest
+// version if the caller doesn't request one.
+//
+//	k.HandleFunc("getInfo", getInfoV1, kite.Version(1), kite.Deprecated("use version 2"))
+//	k.HandleFunc("getInfo", getInfoV2, kite.Version(2))
+func Version(version int) MethodOption {
+	return func(m *Method) {
+		m.version = version
+	}
+}
+
+// Deprecated marks the method as deprecated. The message is sent to the
+// callers with the responses of the method and logged as a warning by them.
+func Deprecated(message string) MethodOption {
+	return func(m *Method) {
+		m.Deprecate(message)
+	}
+}
+
+// Deprecate marks the method as deprecated. See Deprecated.
+func (m *Method) Deprecate(message string) *Method {
+	if message == "" {
+		message = "deprecated"
+	}
+
+	m.deprecated = message
+	return m
+}
+
+// WithVersion returns a context that requests the given version of the
+// methods called with it, like Client.TellWithContext.
+func WithVersion(ctx context.Context, version int) context.Context {
+	return context.WithValue(ctx, versionKey{}, version)
+}
+
+// addVersion registers m among the versions of the method with its name. The
+// latest version handles the calls that don't request a version.
+func (k *Kite) addVersion(m *Method) {
+	versions, ok := k.versions[m.name]
+	if !ok {
+		versions = make(map[int]*Method)
+		k.versions[m.name] = versions
+	}
+	versions[m.version] = m
+
+	latest := m
+	for _, v := range versions {
+		if v.version > latest.version {
+			latest = v
+		}
+	}
+	k.handlers[m.name] = latest
+}
+
+// methodVersion returns the given version of the method.
+func (k *Kite) methodVersion(name string, version int) (*Method, *Error) {
+	if m, ok := k.versions[name][version]; ok {
+		return m, nil
+	}
+
+	return nil, &Error{
+		Type:    "methodNotFound",
+		Message: fmt.Sprintf("Version %d of method %q is not found", version, name),
+	}
+}
+
+// withVersion sends the requested version of the method to the remote kite.
+func withVersion(args []interface{}, version int) []interface{} {
+	options := args[0].(callOptionsOut)
+	options.Version = version
+	return []interface{}{options}
+}
@@ -0,0 +1,67 @@
+package kite
+
+import (
+	"context"
+	"testing"
+)
+
+func TestVersion(t *testing.T) {
+	k := New("testkite", "0.0.1")
+	k.Config.DisableAuthentication = true
+	k.Config.Port = 10031
+
+	k.HandleFunc("getInfo", func(r *Request) (interface{}, error) {
+		return "v1", nil
+	}, Version(1), Deprecated("use version 2"))
+
+	k.HandleFunc("getInfo", func(r *Request) (interface{}, error) {
+		return "v2", nil
+	}, Version(2))
+
+	var versions []int
+	for _, m := range k.Describe().Methods {
+		if m.Name == "getInfo" {
+			versions = append(versions, m.Version)
+		}
+	}
+
+	if len(versions) != 2 || versions[0] != 1 || versions[1] != 2 {
+		t.Errorf("wrong versions are described: %v", versions)
+	}
+
+	go k.Run()
+	defer k.Close()
+	<-k.ServerReadyNotify()
+
+	e := New("exp", "0.0.1")
+	c := e.NewClient("http://127.0.0.1:10031/kite")
+	if err := c.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	tests := []struct {
+		ctx  context.Context
+		want string
+	}{
+		{context.Background(), "v2"},
+		{WithVersion(context.Background(), 1), "v1"},
+		{WithVersion(context.Background(), 2), "v2"},
+	}
+
+	for _, test := range tests {
+		result, err := c.TellWithContext(test.ctx, "getInfo")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s := result.MustString(); s != test.want {
+			t.Errorf("got %q, want: %q", s, test.want)
+		}
+	}
+
+	_, err := c.TellWithContext(WithVersion(context.Background(), 3), "getInfo")
+	if kiteErr, ok := err.(*Error); !ok || kiteErr.Type != "methodNotFound" {
+		t.Fatalf("Want methodNotFound, got: %v", err)
+	}
+}
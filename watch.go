@@ -0,0 +1,213 @@
+package kite
+
+import (
+	"sync"
+	"time"
+
+	"github.com/koding/kite/dnode"
+	"github.com/koding/kite/protocol"
+)
+
+// EventType is the type of a WatchEvent.
+type EventType int
+
+const (
+	// KiteAdded is sent when a kite matching the query registers to kontrol.
+	KiteAdded EventType = iota
+
+	// KiteRemoved is sent when a kite matching the query deregisters or is
+	// expired in kontrol.
+	KiteRemoved
+
+	// KiteUpdated is sent when a kite matching the query changes its URL.
+	KiteUpdated
+)
+
+func (t EventType) String() string {
+	switch t {
+	case KiteAdded:
+		return "KiteAdded"
+	case KiteRemoved:
+		return "KiteRemoved"
+	case KiteUpdated:
+		return "KiteUpdated"
+	default:
+		return "Unknown"
+	}
+}
+
+// WatchEvent is sent from Watcher.Events for the changes of the kites that
+// match the query of the watcher.
+type WatchEvent struct {
+	Type EventType
+	Kite protocol.Kite
+
+	// URL and Token of the kite, set for KiteAdded and KiteUpdated events.
+	URL   string
+	Token string
+}
+
+// Client returns a Client for the kite of the event. The caller must connect
+// with Client.Dial() before using it. The token is renewed before it expires,
+// same as the clients returned from GetKites.
+func (e *WatchEvent) Client(k *Kite) *Client {
+	c := k.NewClient(e.URL)
+	c.Kite = e.Kite
+	c.Auth = &Auth{
+		Type: "token",
+		Key:  e.Token,
+	}
+
+	renewer, err := NewTokenRenewer(c, k)
+	if err != nil {
+		k.Log.Error("Error in token. Token will not be renewed when it expires: %s", err.Error())
+		return c
+	}
+	renewer.RenewWhenExpires()
+
+	return c
+}
+
+// Watcher delivers the events of the kites matching a query. It's returned
+// from Kite.WatchKites.
+type Watcher struct {
+	k      *Kite
+	query  *protocol.KontrolQuery
+	events chan *WatchEvent
+
+	mu      sync.Mutex // protects fields below
+	id      string     // watcher id in kontrol
+	stopped bool
+	done    chan struct{}
+}
+
+// WatchKites starts watching the kites matching the query in kontrol. The
+// events are delivered from the Events channel of the returned Watcher until
+// Stop is called. The watch is established again when the connection to
+// kontrol is lost and re-established. Events that happen while kontrol is
+// not connected are not delivered.
+func (k *Kite) WatchKites(query *protocol.KontrolQuery) (*Watcher, error) {
+	if err := k.SetupKontrolClient(); err != nil {
+		return nil, err
+	}
+
+	<-k.kontrol.readyConnected
+
+	w := &Watcher{
+		k:      k,
+		query:  query,
+		events: make(chan *WatchEvent, 16),
+		done:   make(chan struct{}),
+	}
+
+	if err := w.watch(); err != nil {
+		return nil, err
+	}
+
+	k.kontrol.OnConnect(func() {
+		w.mu.Lock()
+		stopped := w.stopped
+		w.mu.Unlock()
+
+		if stopped {
+			return
+		}
+
+		// watchers are removed in kontrol when we disconnect
+		for {
+			err := w.watch()
+			if err == nil {
+				return
+			}
+
+			k.Log.Error("Cannot watch kites again: %s Will retry after %d seconds",
+				err, kontrolRetryDuration/time.Second)
+
+			select {
+			case <-time.After(kontrolRetryDuration):
+			case <-w.done:
+				return
+			}
+		}
+	})
+
+	return w, nil
+}
+
+// Events returns the channel the events are delivered from. The channel is
+// never closed, use Stop to stop the watcher.
+func (w *Watcher) Events() <-chan *WatchEvent {
+	return w.events
+}
+
+// Stop stops the watcher. No more events are delivered after it returns.
+func (w *Watcher) Stop() error {
+	w.mu.Lock()
+	if w.stopped {
+		w.mu.Unlock()
+		return nil
+	}
+	w.stopped = true
+	close(w.done)
+	id := w.id
+	w.mu.Unlock()
+
+	_, err := w.k.kontrol.TellWithTimeout("cancelWatcher", 4*time.Second, id)
+	return err
+}
+
+// watch calls the "watchKites" method of kontrol.
+func (w *Watcher) watch() error {
+	args := protocol.GetKitesArgs{
+		Query:         w.query,
+		WatchCallback: dnode.Callback(w.receive),
+	}
+
+	result, err := w.k.kontrol.TellWithTimeout("watchKites", 4*time.Second, args)
+	if err != nil {
+		return err
+	}
+
+	var id string
+	if err := result.Unmarshal(&id); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.id = id
+	w.mu.Unlock()
+
+	return nil
+}
+
+// receive is the watch callback that is sent to kontrol.
+func (w *Watcher) receive(args *dnode.Partial) {
+	var e protocol.KiteEvent
+	if err := args.One().Unmarshal(&e); err != nil {
+		w.k.Log.Warning("invalid kite event: %s", err)
+		return
+	}
+
+	event := &WatchEvent{
+		Kite:  e.Kite,
+		URL:   e.URL,
+		Token: e.Token,
+	}
+
+	switch e.Action {
+	case protocol.Register:
+		event.Type = KiteAdded
+	case protocol.Deregister:
+		event.Type = KiteRemoved
+	case protocol.Update:
+		event.Type = KiteUpdated
+	default:
+		w.k.Log.Warning("unknown kite event action: %s", e.Action)
+		return
+	}
+
+	select {
+	case w.events <- event:
+	case <-w.done:
+	}
+}